@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/banking/api-gateway/internal/buildinfo"
 	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
 	"github.com/banking/api-gateway/internal/infrastructure"
 	"github.com/banking/api-gateway/internal/server"
+	"github.com/banking/api-gateway/internal/tracing"
 	"go.uber.org/zap"
 )
 
@@ -13,6 +22,22 @@ func main() {
 	// 1. Load Configuration
 	cfg, err := config.Load()
 	if err != nil {
+		// Tampered configuration is a change-management event, not just a crash
+		var sigErr *config.SignatureError
+		if errors.As(err, &sigErr) {
+			auditStartup("Configuration signature rejected",
+				zap.String("event", "config_signature_invalid"),
+				zap.String("file", sigErr.File),
+				zap.Error(sigErr.Err),
+			)
+		}
+		var overrideErr *config.UnsignedOverrideError
+		if errors.As(err, &overrideErr) {
+			auditStartup("Environment override of signed configuration rejected",
+				zap.String("event", "config_override_rejected"),
+				zap.Strings("variables", overrideErr.Variables),
+			)
+		}
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
@@ -25,10 +50,32 @@ func main() {
 	}
 	defer logger.Sync()
 
+	// Every log line carries the build it came from
+	info := buildinfo.Get()
+	logger = logger.With(zap.String("version", info.Version), zap.String("commit", info.Commit))
+
 	logger.Info("Initializing Banking API Gateway",
-		zap.String("version", "1.0.0"),
+		zap.String("build_date", info.BuildDate),
+		zap.String("go_version", info.GoVersion),
 		zap.String("environment", cfg.Server.Environment),
 	)
+	if len(cfg.IgnoredEnv) > 0 {
+		logger.Warn("Ignoring environment variables that match no config key", zap.Strings("variables", cfg.IgnoredEnv))
+	}
+
+	// Refuse to start with non-approved crypto when FIPS mode is on
+	if err := fips.Check(cfg); err != nil {
+		logger.Fatal("FIPS mode check failed", zap.Error(err))
+	}
+	if fips.Enabled() {
+		logger.Info("FIPS mode enabled: TLS, JWT and HMAC restricted to approved algorithms")
+	}
+
+	// Spans are exported only when tracing is enabled
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal("Tracing setup failed", zap.Error(err))
+	}
 
 	// 3. Initialize Redis (optional, graceful degradation if unavailable)
 	var redisClient *infrastructure.RedisClient
@@ -38,11 +85,66 @@ func main() {
 		redisClient = nil
 	}
 
+	// Pre-deploy conformance check: `api-gateway selftest [-dry-run] [-json]`
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftest(cfg, logger, redisClient, os.Args[2:]))
+	}
+
+	// Resolved routing table: `api-gateway routes [-url http://gateway] [-json]`
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		os.Exit(runRoutes(cfg, logger, redisClient, os.Args[2:]))
+	}
+
 	// 4. Create Server
 	srv := server.New(cfg, logger, redisClient)
 
 	// 5. Start Server
-	if err := srv.Start(); err != nil {
-		logger.Fatal("Server start failed", zap.Error(err))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Start() }()
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			logger.Fatal("Server start failed", zap.Error(err))
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	// 6. Graceful shutdown: leave load balancing, then drain in-flight requests
+	logger.Info("Shutdown signal received, draining",
+		zap.Duration("delay", cfg.Server.ShutdownDelay),
+		zap.Duration("timeout", cfg.Server.ShutdownTimeout),
+	)
+	srv.Drain()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Stop(shutdownCtx); err != nil {
+		logger.Error("Shutdown did not complete, in-flight requests were cut off", zap.Error(err))
+	} else {
+		logger.Info("Shutdown complete")
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Warn("Failed to flush trace spans", zap.Error(err))
+	}
+	if redisClient != nil {
+		redisClient.Close()
+	}
+}
+
+// auditStartup records an audit event before a logger is configured, for
+// configuration rejected at startup.
+func auditStartup(msg string, fields ...zap.Field) {
+	audit, err := zap.NewProduction()
+	if err != nil {
+		return
 	}
+	audit.Named("audit").Error(msg, append(fields, zap.String("source", "startup"))...)
+	audit.Sync()
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/server"
+	"go.uber.org/zap"
+)
+
+// runRoutes prints the resolved routing table in match order, built from the
+// local configuration or fetched from a running gateway's admin API.
+func runRoutes(cfg *config.Config, logger *zap.Logger, redisClient *infrastructure.RedisClient, args []string) int {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	url := flags.String("url", "", "fetch the table from a running gateway (e.g. http://localhost:8080), including dynamic routes")
+	asJSON := flags.Bool("json", false, "print the table as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var routes []server.ResolvedRoute
+	if *url != "" {
+		var err error
+		if routes, err = fetchRoutes(*url, cfg.Admin.APIKey); err != nil {
+			fmt.Fprintf(os.Stderr, "routes: %v\n", err)
+			return 1
+		}
+	} else {
+		srv := server.New(cfg, logger.WithOptions(zap.IncreaseLevel(zap.DPanicLevel)), redisClient)
+		if _, err := srv.Handler(); err != nil {
+			fmt.Fprintf(os.Stderr, "routes: %v\n", err)
+			return 1
+		}
+		routes = srv.ResolvedRoutes()
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(routes)
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSOURCE\tSERVICE\tAUTH\tRATE LIMIT\tPRIORITY\tSHADOWED BY")
+	for _, r := range routes {
+		auth := "public"
+		if r.Auth {
+			auth = "required"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.Path, r.Source, r.Service, auth, r.RateLimit, r.Priority, r.ShadowedBy)
+	}
+	w.Flush()
+	return 0
+}
+
+func fetchRoutes(base, adminKey string) ([]server.ResolvedRoute, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/admin/routes/resolved", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Admin-Key", adminKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API returned %s", res.Status)
+	}
+
+	var body struct {
+		Routes []server.ResolvedRoute `json:"routes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Routes, nil
+}
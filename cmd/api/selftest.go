@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/selftest"
+	"go.uber.org/zap"
+)
+
+// runSelftest exercises the loaded configuration and returns the process exit
+// code: 0 when every check passed or was skipped, 1 otherwise.
+func runSelftest(cfg *config.Config, logger *zap.Logger, redisClient *infrastructure.RedisClient, args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "probe the real upstreams with safe methods instead of stubs")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Request logs and the deliberate upstream failures would drown the report
+	quiet := logger.WithOptions(zap.IncreaseLevel(zap.DPanicLevel))
+
+	report, err := selftest.Run(cfg, quiet, redisClient, selftest.Options{DryRun: *dryRun})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, r := range report.Results {
+			line := fmt.Sprintf("%-4s  %-28s", r.Status, r.Check)
+			if r.Route != "" {
+				line += "  " + r.Route
+			}
+			if r.Detail != "" {
+				line += "  (" + r.Detail + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if !report.Passed {
+		fmt.Fprintln(os.Stderr, "selftest: FAILED")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,231 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"go.uber.org/zap"
+)
+
+const (
+	endpointKeyPrefix = "usage:endpoints:"
+	scopeKeyPrefix    = "usage:scopes:"
+	dayFormat         = "20060102"
+	fieldSeparator    = "|"
+)
+
+// ClientUsage summarizes what a single client did over the report period.
+type ClientUsage struct {
+	ClientID      string           `json:"client_id"`
+	Endpoints     map[string]int64 `json:"endpoints"`
+	GrantedScopes []string         `json:"granted_scopes"`
+	UsedScopes    []string         `json:"used_scopes"`
+	UnusedScopes  []string         `json:"unused_scopes"`
+}
+
+// DeprecatedUsage lists callers of a deprecated route.
+type DeprecatedUsage struct {
+	Route   string           `json:"route"`
+	Total   int64            `json:"total"`
+	Clients map[string]int64 `json:"clients"`
+}
+
+// UsageReport is the admin view of client/endpoint/scope usage.
+type UsageReport struct {
+	From                time.Time         `json:"from"`
+	To                  time.Time         `json:"to"`
+	Clients             []ClientUsage     `json:"clients"`
+	DeprecatedEndpoints []DeprecatedUsage `json:"deprecated_endpoints"`
+}
+
+// UsageTracker aggregates client usage in memory and periodically flushes the
+// counters into per-day Redis hashes.
+type UsageTracker struct {
+	cfg    *config.Config
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	endpoints map[string]int64
+	scopes    map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewUsageTracker(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *UsageTracker {
+	return &UsageTracker{
+		cfg:       cfg,
+		redis:     redis,
+		logger:    logger,
+		endpoints: make(map[string]int64),
+		scopes:    make(map[string]int64),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Record counts one call by clientID to route carrying the given token scopes.
+func (u *UsageTracker) Record(clientID, route string, scopes []string) {
+	if clientID == "" {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.endpoints[clientID+fieldSeparator+route]++
+	for _, scope := range scopes {
+		u.scopes[clientID+fieldSeparator+scope]++
+	}
+}
+
+// Start runs the periodic flush loop until Stop is called.
+func (u *UsageTracker) Start() {
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(u.cfg.Analytics.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-u.stop:
+				u.flush()
+				return
+			case <-ticker.C:
+				u.flush()
+			}
+		}
+	}()
+}
+
+// Stop flushes pending counters and stops the background loop.
+func (u *UsageTracker) Stop() {
+	close(u.stop)
+	<-u.done
+}
+
+func (u *UsageTracker) flush() {
+	u.mu.Lock()
+	endpoints, scopes := u.endpoints, u.scopes
+	u.endpoints = make(map[string]int64)
+	u.scopes = make(map[string]int64)
+	u.mu.Unlock()
+
+	if len(endpoints) == 0 && len(scopes) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	day := time.Now().UTC().Format(dayFormat)
+	ttl := u.cfg.Analytics.Retention
+	if err := u.redis.IncrementHashFields(ctx, endpointKeyPrefix+day, endpoints, ttl); err != nil {
+		u.logger.Error("Failed to flush endpoint usage", zap.Error(err))
+	}
+	if err := u.redis.IncrementHashFields(ctx, scopeKeyPrefix+day, scopes, ttl); err != nil {
+		u.logger.Error("Failed to flush scope usage", zap.Error(err))
+	}
+}
+
+// Report builds a usage report over the last days days (including today).
+func (u *UsageTracker) Report(ctx context.Context, days int) (*UsageReport, error) {
+	now := time.Now().UTC()
+	clients := make(map[string]*ClientUsage)
+	granted := make(map[string]map[string]bool)
+	client := func(id string) *ClientUsage {
+		cu, ok := clients[id]
+		if !ok {
+			cu = &ClientUsage{ClientID: id, Endpoints: make(map[string]int64)}
+			clients[id] = cu
+			granted[id] = make(map[string]bool)
+		}
+		return cu
+	}
+
+	for i := 0; i < days; i++ {
+		day := now.AddDate(0, 0, -i).Format(dayFormat)
+
+		endpoints, err := u.redis.GetHashCounts(ctx, endpointKeyPrefix+day)
+		if err != nil {
+			return nil, err
+		}
+		for field, count := range endpoints {
+			id, route, ok := strings.Cut(field, fieldSeparator)
+			if ok {
+				client(id).Endpoints[route] += count
+			}
+		}
+
+		scopes, err := u.redis.GetHashCounts(ctx, scopeKeyPrefix+day)
+		if err != nil {
+			return nil, err
+		}
+		for field := range scopes {
+			id, scope, ok := strings.Cut(field, fieldSeparator)
+			if ok {
+				client(id)
+				granted[id][scope] = true
+			}
+		}
+	}
+
+	report := &UsageReport{
+		From: now.AddDate(0, 0, -(days - 1)).Truncate(24 * time.Hour),
+		To:   now,
+	}
+
+	deprecated := make(map[string]*DeprecatedUsage)
+	for id, cu := range clients {
+		used := make(map[string]bool)
+		for route, count := range cu.Endpoints {
+			rc := u.cfg.Route(route)
+			if rc == nil {
+				continue
+			}
+			for _, scope := range rc.Scopes {
+				if granted[id][scope] {
+					used[scope] = true
+				}
+			}
+			if rc.Deprecation != nil {
+				du, ok := deprecated[route]
+				if !ok {
+					du = &DeprecatedUsage{Route: route, Clients: make(map[string]int64)}
+					deprecated[route] = du
+				}
+				du.Total += count
+				du.Clients[id] += count
+			}
+		}
+
+		for scope := range granted[id] {
+			cu.GrantedScopes = append(cu.GrantedScopes, scope)
+			if used[scope] {
+				cu.UsedScopes = append(cu.UsedScopes, scope)
+			} else {
+				cu.UnusedScopes = append(cu.UnusedScopes, scope)
+			}
+		}
+		sort.Strings(cu.GrantedScopes)
+		sort.Strings(cu.UsedScopes)
+		sort.Strings(cu.UnusedScopes)
+		report.Clients = append(report.Clients, *cu)
+	}
+	sort.Slice(report.Clients, func(i, j int) bool { return report.Clients[i].ClientID < report.Clients[j].ClientID })
+
+	for _, du := range deprecated {
+		report.DeprecatedEndpoints = append(report.DeprecatedEndpoints, *du)
+	}
+	sort.Slice(report.DeprecatedEndpoints, func(i, j int) bool {
+		return report.DeprecatedEndpoints[i].Total > report.DeprecatedEndpoints[j].Total
+	})
+
+	return report, nil
+}
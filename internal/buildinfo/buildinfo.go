@@ -0,0 +1,61 @@
+// Package buildinfo exposes the gateway version and build metadata, set at
+// link time:
+//
+//	go build -ldflags "-X github.com/banking/api-gateway/internal/buildinfo.Version=1.4.0 \
+//	  -X github.com/banking/api-gateway/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/banking/api-gateway/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via -ldflags -X; Commit and Date fall back to the VCS stamp embedded by
+// the Go toolchain when left empty.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Features  []string `json:"features,omitempty"`
+}
+
+// Get returns the build metadata of the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
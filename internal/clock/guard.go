@@ -0,0 +1,180 @@
+package clock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+)
+
+// Status is the outcome of the most recent drift check.
+type Status struct {
+	Server          string    `json:"server,omitempty"`
+	OffsetMs        float64   `json:"offset_ms"`
+	RTTMs           float64   `json:"rtt_ms"`
+	CheckedAt       time.Time `json:"checked_at,omitempty"`
+	WithinThreshold bool      `json:"within_threshold"`
+	MaxDriftMs      float64   `json:"max_drift_ms"`
+	FailMode        string    `json:"fail_mode"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// Guard periodically measures the local clock against NTP and tells
+// clock-sensitive policies whether they can trust time.Now. Until a check
+// succeeds the clock is trusted, so an unreachable NTP server alone never
+// blocks traffic.
+type Guard struct {
+	cfg    config.ClockConfig
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	drift  time.Duration
+	status Status
+}
+
+func NewGuard(cfg config.ClockConfig, logger *zap.Logger) (*Guard, error) {
+	switch cfg.FailMode {
+	case "":
+		cfg.FailMode = FailOpen
+	case FailOpen, FailClosed:
+	default:
+		return nil, fmt.Errorf("clock.fail_mode must be %q or %q, got %q", FailOpen, FailClosed, cfg.FailMode)
+	}
+	if len(cfg.NTPServers) == 0 {
+		return nil, fmt.Errorf("clock.ntp_servers is empty")
+	}
+
+	metrics.ClockWithinThreshold.Set(1)
+	return &Guard{
+		cfg:    cfg,
+		logger: logger,
+		status: Status{
+			WithinThreshold: true,
+			MaxDriftMs:      milliseconds(cfg.MaxDrift),
+			FailMode:        cfg.FailMode,
+		},
+	}, nil
+}
+
+// Start checks drift once before returning, then every CheckInterval until
+// ctx is cancelled.
+func (g *Guard) Start(ctx context.Context) {
+	g.check(ctx)
+
+	go func() {
+		ticker := time.NewTicker(g.cfg.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.check(ctx)
+			}
+		}
+	}()
+}
+
+// check queries every server concurrently and keeps the median offset, so a
+// single misbehaving server cannot move the result.
+func (g *Guard) check(ctx context.Context) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		samples []Sample
+		lastErr error
+	)
+	for _, server := range g.cfg.NTPServers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			s, err := Query(ctx, server, g.cfg.Timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", server, err)
+				return
+			}
+			samples = append(samples, s)
+		}(server)
+	}
+	wg.Wait()
+
+	if len(samples) == 0 {
+		g.mu.Lock()
+		g.status.LastError = lastErr.Error()
+		g.mu.Unlock()
+		g.logger.Warn("Clock drift check failed", zap.Error(lastErr))
+		return
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Offset < samples[j].Offset })
+	s := samples[len(samples)/2]
+	drift := s.Offset.Abs()
+	within := drift <= g.cfg.MaxDrift
+
+	g.mu.Lock()
+	was := g.status.WithinThreshold
+	g.drift = drift
+	g.status.Server = s.Server
+	g.status.OffsetMs = milliseconds(s.Offset)
+	g.status.RTTMs = milliseconds(s.RTT)
+	g.status.CheckedAt = time.Now().UTC()
+	g.status.WithinThreshold = within
+	g.status.LastError = ""
+	g.mu.Unlock()
+
+	metrics.ClockOffsetSeconds.Set(s.Offset.Seconds())
+	if within {
+		metrics.ClockWithinThreshold.Set(1)
+	} else {
+		metrics.ClockWithinThreshold.Set(0)
+	}
+
+	switch {
+	case !within && was:
+		g.logger.Error("Clock drift exceeds threshold, time-based policies degraded",
+			zap.Duration("offset", s.Offset),
+			zap.Duration("max_drift", g.cfg.MaxDrift),
+			zap.String("server", s.Server),
+			zap.String("fail_mode", g.cfg.FailMode),
+		)
+	case within && !was:
+		g.logger.Info("Clock drift back within threshold", zap.Duration("offset", s.Offset), zap.String("server", s.Server))
+	}
+}
+
+// Drift returns the absolute offset from the last successful check and
+// whether it is within the configured threshold.
+func (g *Guard) Drift() (time.Duration, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.drift, g.status.WithinThreshold
+}
+
+// FailClosed reports whether requests depending on the clock are rejected
+// while drift exceeds the threshold.
+func (g *Guard) FailClosed() bool {
+	return g.cfg.FailMode == FailClosed
+}
+
+// Status returns the outcome of the most recent check.
+func (g *Guard) Status() Status {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.status
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,99 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and
+// the Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// Sample is the result of one SNTP exchange.
+type Sample struct {
+	Server string
+	// Offset is how far NTP time is ahead of the local clock.
+	Offset time.Duration
+	RTT    time.Duration
+}
+
+// Query performs a single SNTP (RFC 4330) exchange with server, which may
+// omit the port.
+func Query(ctx context.Context, server string, timeout time.Duration) (Sample, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, "123")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return Sample{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI 0, version 3, mode 3 (client)
+
+	// t1 and t4 share one wall-clock reading; the elapsed time between them
+	// comes from the monotonic clock so a step during the exchange cannot
+	// skew the result.
+	sent := time.Now()
+	t1 := toNTP(sent)
+	binary.BigEndian.PutUint64(req[40:], t1)
+	if _, err := conn.Write(req); err != nil {
+		return Sample{}, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return Sample{}, err
+	}
+	elapsed := time.Since(sent)
+	if n < 48 {
+		return Sample{}, errors.New("short NTP response")
+	}
+	if mode := resp[0] & 0x07; mode != 4 {
+		return Sample{}, fmt.Errorf("unexpected NTP mode %d", mode)
+	}
+	if resp[1] == 0 {
+		return Sample{}, fmt.Errorf("NTP kiss-of-death %q", resp[12:16])
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != t1 {
+		return Sample{}, errors.New("NTP response does not match request")
+	}
+
+	t2 := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	t4 := sent.Add(elapsed)
+	wall := fromNTP(t1)
+
+	return Sample{
+		Server: server,
+		Offset: (t2.Sub(wall) + t3.Sub(t4)) / 2,
+		RTT:    elapsed - t3.Sub(t2),
+	}, nil
+}
+
+func toNTP(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+func fromNTP(v uint64) time.Time {
+	sec := int64(v>>32) - ntpEpochOffset
+	nsec := int64((v & 0xFFFFFFFF) * uint64(time.Second) >> 32)
+	return time.Unix(sec, nsec)
+}
@@ -1,18 +1,320 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig       `mapstructure:"server"`
-	Redis    RedisConfig        `mapstructure:"redis"`
-	Services map[string]Service `mapstructure:"services"`
-	Security SecurityConfig     `mapstructure:"security"`
-	Cors     CorsConfig         `mapstructure:"cors"`
+	Server     ServerConfig       `mapstructure:"server"`
+	Redis      RedisConfig        `mapstructure:"redis"`
+	Services   map[string]Service `mapstructure:"services"`
+	Security   SecurityConfig     `mapstructure:"security"`
+	Cors       CorsConfig         `mapstructure:"cors"`
+	Routes     []RouteConfig      `mapstructure:"routes"`
+	Admin      AdminConfig        `mapstructure:"admin"`
+	Traffic    TrafficConfig      `mapstructure:"traffic"`
+	RateLimits RateLimitsConfig   `mapstructure:"rate_limits"`
+	Analytics  AnalyticsConfig    `mapstructure:"analytics"`
+	Status     StatusConfig       `mapstructure:"status"`
+	Kubernetes KubernetesConfig   `mapstructure:"kubernetes"`
+	Consul     ConsulConfig       `mapstructure:"consul"`
+	XDS        XDSConfig          `mapstructure:"xds"`
+	Registry   RegistryConfig     `mapstructure:"registry"`
+	RequestLog RequestLogConfig   `mapstructure:"request_log"`
+	Events     EventsConfig       `mapstructure:"events"`
+	Clock      ClockConfig        `mapstructure:"clock"`
+	Inspection InspectionConfig   `mapstructure:"inspection"`
+	BodyBuffer BodyBufferConfig   `mapstructure:"body_buffer"`
+	// Concurrency caps in-flight requests per client IP and per client.
+	Concurrency ConcurrencyConfig `mapstructure:"concurrency"`
+	Shield      ShieldConfig      `mapstructure:"shield"`
+	RetryAfter  RetryAfterConfig  `mapstructure:"retry_after"`
+	Federation  FederationConfig  `mapstructure:"federation"`
+	Migrations  []MigrationRule   `mapstructure:"migrations"`
+	Signing     SigningConfig     `mapstructure:"signing"`
+	Deadline    DeadlineConfig    `mapstructure:"deadline"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	Store       StoreConfig       `mapstructure:"store"`
+	Branding    BrandingConfig    `mapstructure:"branding"`
+	ErrorPages  ErrorPagesConfig  `mapstructure:"error_pages"`
+	// CurrencyExponents overrides or extends the built-in ISO 4217 minor
+	// unit exponents used by route money conversion.
+	CurrencyExponents map[string]int `mapstructure:"currency_exponents"`
+	// TLSFingerprint identifies clients by JA3/JA4 TLS fingerprints.
+	TLSFingerprint TLSFingerprintConfig `mapstructure:"tls_fingerprint"`
+	// ClientPolicy enforces minimum app versions.
+	ClientPolicy ClientPolicyConfig `mapstructure:"client_policy"`
+	// DarkTraffic bounds synthetic traffic sent to soft-launched routes.
+	DarkTraffic DarkTrafficConfig `mapstructure:"dark_traffic"`
+	// RetryBudget bounds retries and hedged requests to each service.
+	RetryBudget RetryBudgetConfig `mapstructure:"retry_budget"`
+	// ResponseCache sizes the in-memory response cache.
+	ResponseCache ResponseCacheConfig `mapstructure:"response_cache"`
+	// Products bundle routes into API products clients subscribe to.
+	Products []ProductConfig `mapstructure:"products"`
+	// WebSocket caps the WebSocket connections each user holds open.
+	WebSocket WebSocketConfig `mapstructure:"websocket"`
+	// FieldEncryption keeps card data in request bodies from services
+	// outside PCI scope.
+	FieldEncryption FieldEncryptionConfig `mapstructure:"field_encryption"`
+	// Retention bounds how long the gateway keeps what it stores itself.
+	Retention RetentionConfig `mapstructure:"retention"`
+
+	// IgnoredEnv lists GATEWAY_* variables that match no config key, such
+	// as the service links Kubernetes injects for a Service named gateway
+	// (GATEWAY_PORT, GATEWAY_SERVICE_HOST). They are skipped, not applied.
+	IgnoredEnv []string `mapstructure:"-"`
+}
+
+// FieldEncryptionConfig protects card data in JSON request bodies. Fields
+// are tokenized before they reach a service without pci_scope and restored
+// only for services with it, so only those see card numbers and security
+// codes, and only they are in PCI audit scope.
+type FieldEncryptionConfig struct {
+	// Key is a base64 256-bit key; tokens only restore with the key that
+	// made them.
+	Key    string           `mapstructure:"key" secret:"true"`
+	Fields []ProtectedField `mapstructure:"fields"`
+}
+
+// ProtectedField is a request body field holding card data.
+type ProtectedField struct {
+	// Path is dot-separated; "[]" after a segment visits every element, as
+	// in money fields.
+	Path string `mapstructure:"path"`
+	// Format is "pan" for card numbers, tokenized format-preserving: a
+	// token has as many digits and the same last four, but fails the Luhn
+	// check, so it is never mistaken for a card. "opaque" encrypts any
+	// string, e.g. a CVV, to an "enc:v1:" token.
+	Format string `mapstructure:"format"`
+}
+
+// ProductConfig is an API product: routes sold together, with the scopes
+// callers need, a quota shared by all of a client's requests to them and a
+// link to their documentation. Only subscribed clients may call the routes;
+// subscriptions can also be added and removed at runtime through the admin
+// API.
+type ProductConfig struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	DocsURL     string `mapstructure:"docs_url"`
+	// Routes are route patterns as registered, e.g. "/api/reporting/*". A
+	// route belongs to at most one product.
+	Routes []string `mapstructure:"routes"`
+	// Scopes must all be granted to the caller's token.
+	Scopes []string `mapstructure:"scopes"`
+	// Quota caps each subscriber's requests per QuotaPeriod (default 720h);
+	// zero only meters them.
+	Quota       int64         `mapstructure:"quota"`
+	QuotaPeriod time.Duration `mapstructure:"quota_period"`
+	// Subscribers are the client IDs subscribed in configuration.
+	Subscribers []string `mapstructure:"subscribers"`
+}
+
+// ResponseCacheConfig sizes the replica-local tier of the response cache,
+// used by routes with the memory or tiered backend.
+type ResponseCacheConfig struct {
+	// MaxMemoryBytes bounds the entries held in memory; the least recently
+	// used go first.
+	MaxMemoryBytes int64 `mapstructure:"max_memory_bytes"`
+	// LocalTTL is how long tiered routes keep an entry in memory after
+	// reading it from Redis, so how much staler than the shared entry a
+	// replica may serve.
+	LocalTTL time.Duration `mapstructure:"local_ttl"`
+}
+
+// StoreConfig selects the database holding durable entities: API keys,
+// consents, webhook subscriptions and session metadata. Driver is
+// "postgres" or empty (disabled).
+type StoreConfig struct {
+	Driver string `mapstructure:"driver"`
+	DSN    string `mapstructure:"dsn" secret:"true"`
+	// MaxConns bounds the connection pool (default 10).
+	MaxConns int32 `mapstructure:"max_conns"`
+	// Migrate applies pending schema migrations on startup.
+	Migrate bool `mapstructure:"migrate"`
+	// CacheTTL is how long lookups are cached in Redis; zero disables it.
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+}
+
+// BrandingConfig resolves the white-label brand (tenant) of each request,
+// so responses can carry the brand's headers, error messages and support
+// contacts.
+type BrandingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Header names a request header selecting the brand by name; without
+	// it, or when it names no brand, the brand is matched by Host.
+	Header string `mapstructure:"header"`
+	// Default applies to requests matching no brand.
+	Default string                 `mapstructure:"default"`
+	Brands  map[string]BrandConfig `mapstructure:"brands"`
+}
+
+// BrandConfig is the customization of one tenant or brand.
+type BrandConfig struct {
+	Hosts []string `mapstructure:"hosts"`
+	// Headers are set on every response to the brand's callers, replacing
+	// any the upstream sent.
+	Headers map[string]string `mapstructure:"headers"`
+	Support SupportContact    `mapstructure:"support"`
+	// Messages maps a locale ("en", "fr-ca") and a status code to a
+	// text/template rendering the error message (see ErrorPagesConfig).
+	Messages map[string]map[string]string `mapstructure:"messages"`
+	// Webhooks receive status-page incidents alongside status.webhook_url.
+	Webhooks []BrandWebhook `mapstructure:"webhooks"`
+}
+
+// BrandWebhook is an endpoint notified of status incidents, signed with
+// Secret when set.
+type BrandWebhook struct {
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret" secret:"true"`
+}
+
+// SupportContact is how customers reach a brand's support.
+type SupportContact struct {
+	Email string `mapstructure:"email" json:"email,omitempty"`
+	Phone string `mapstructure:"phone" json:"phone,omitempty"`
+	URL   string `mapstructure:"url" json:"url,omitempty"`
+}
+
+// ErrorPagesConfig adds the brand's localized customer-facing message and
+// support contacts to the gateway's own error responses (such as 401, 429
+// and 503). Upstream responses are left as they are. Message templates see
+// .Status, .Error, .Code, .RetryAfter, .RequestID, .Brand, .Locale and
+// .Support.
+type ErrorPagesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DefaultLocale is used when Accept-Language matches no locale of the
+	// brand (default "en").
+	DefaultLocale string `mapstructure:"default_locale"`
+}
+
+// TracingConfig enables OpenTelemetry tracing: a span per request and per
+// upstream call, exported over OTLP/HTTP. Latency histograms then carry the
+// trace ID of sampled requests as exemplars.
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Endpoint is the collector's OTLP/HTTP traces URL.
+	Endpoint string `mapstructure:"endpoint"`
+	// SampleRatio is the share of new traces recorded; requests arriving
+	// with a traceparent follow the caller's decision.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+// DeadlineConfig honours X-Request-Deadline from trusted internal clients:
+// the request context ends at the client's deadline, capped at MaxBudget (or
+// the route's max_deadline), and requests already past it get 504 without
+// reaching a backend. The header is stripped from other clients.
+type DeadlineConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TrustedCIDRs lists networks whose direct connections may set a
+	// deadline; forwarded-for headers are not consulted.
+	TrustedCIDRs []string      `mapstructure:"trusted_cidrs"`
+	MaxBudget    time.Duration `mapstructure:"max_budget"`
+}
+
+// TLSFingerprintConfig identifies clients by their TLS ClientHello, as JA3
+// and JA4 fingerprints, for logs, upstream risk checks and a denylist kept
+// in Redis. Fingerprints are computed on the gateway's own TLS listener;
+// behind a TLS-terminating load balancer they are read from JA3Header and
+// JA4Header, on connections from TrustedCIDRs only.
+type TLSFingerprintConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	JA3Header    string   `mapstructure:"ja3_header"`
+	JA4Header    string   `mapstructure:"ja4_header"`
+	TrustedCIDRs []string `mapstructure:"trusted_cidrs"`
+}
+
+// ClientPolicyConfig identifies the app and version behind each request,
+// from X-App-Version ("ios/5.2.1") or else the User-Agent, turns away apps
+// older than the minimum supported version with a force-upgrade response
+// and counts requests by app version. Requests not identifying an app, e.g.
+// from browsers and partners, are never turned away.
+type ClientPolicyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// UserAgentPattern is a regular expression whose named groups
+	// "platform" and "version" identify the app in a User-Agent.
+	UserAgentPattern string `mapstructure:"user_agent_pattern"`
+	// MinVersions is the oldest supported version per platform, on every
+	// route unless the route sets its own in min_client_versions.
+	MinVersions map[string]string `mapstructure:"min_versions"`
+	// UpgradeURLs are the store links per platform returned to outdated apps.
+	UpgradeURLs map[string]string `mapstructure:"upgrade_urls"`
+}
+
+// DarkTrafficConfig bounds dark traffic runs: synthetic requests an operator
+// sends through the gateway to a newly configured route, to check it end to
+// end before customers are switched over.
+type DarkTrafficConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxRPS caps the rate of a run.
+	MaxRPS float64 `mapstructure:"max_rps"`
+	// MaxRequests caps the number of requests of a run.
+	MaxRequests int `mapstructure:"max_requests"`
+}
+
+// RetryBudgetConfig caps the extra upstream attempts, retries and hedged
+// requests, each service may get to a share of its recent requests, so they
+// can never amplify load beyond 1 + Percent/100 during a brownout.
+type RetryBudgetConfig struct {
+	// Percent of a service's requests in the window that may be retried or
+	// hedged.
+	Percent float64 `mapstructure:"percent"`
+	// MinPerSecond extra attempts are allowed whatever the traffic, so
+	// services with few requests can still retry.
+	MinPerSecond float64 `mapstructure:"min_per_second"`
+	// Window is how far back requests and extra attempts count.
+	Window time.Duration `mapstructure:"window"`
+}
+
+// SigningConfig selects the key the gateway signs its own statements with
+// (e.g. /version responses). The private key stays in an HSM reached through
+// PKCS#11 or in Cloud KMS; the gateway only ever holds a handle to it.
+// Backend is "pkcs11", "kms" or empty (signing disabled).
+type SigningConfig struct {
+	Backend string `mapstructure:"backend"`
+	// Algorithm is the JWS algorithm: ES256, ES384, RS256 or PS256.
+	Algorithm string `mapstructure:"algorithm"`
+	// KeyID is published as "kid" in signatures and the JWKS.
+	KeyID  string       `mapstructure:"key_id"`
+	PKCS11 PKCS11Config `mapstructure:"pkcs11"`
+	KMS    KMSConfig    `mapstructure:"kms"`
+}
+
+// PKCS11Config locates a private key on an HSM token. Requires a binary built
+// with -tags pkcs11 and cgo.
+type PKCS11Config struct {
+	// Module is the path of the vendor's PKCS#11 library.
+	Module     string `mapstructure:"module"`
+	TokenLabel string `mapstructure:"token_label"`
+	KeyLabel   string `mapstructure:"key_label"`
+	PIN        string `mapstructure:"pin" secret:"true"`
+}
+
+// KMSConfig names a Google Cloud KMS asymmetric signing key version. Access
+// tokens come from the metadata server, or from the variable named by
+// TokenEnv when set.
+type KMSConfig struct {
+	KeyName  string        `mapstructure:"key_name"`
+	Endpoint string        `mapstructure:"endpoint"`
+	TokenEnv string        `mapstructure:"token_env"`
+	Timeout  time.Duration `mapstructure:"timeout"`
 }
 
 type ServerConfig struct {
@@ -20,55 +322,1794 @@ type ServerConfig struct {
 	Environment  string        `mapstructure:"environment"`
 	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// ProblemBaseURL prefixes the "type" URI of problem+json error bodies.
+	ProblemBaseURL string `mapstructure:"problem_base_url"`
+	// VersionSigningSecret signs /version responses (HMAC-SHA256 in
+	// X-Signature-SHA256) so deploy tooling can trust the reported build.
+	VersionSigningSecret string `mapstructure:"version_signing_secret" secret:"true"`
+	// ShutdownDelay keeps serving, with /health reporting DRAINING, after
+	// SIGTERM so load balancers stop routing here before the listener
+	// closes. ShutdownTimeout then bounds the wait for in-flight requests.
+	ShutdownDelay   time.Duration `mapstructure:"shutdown_delay"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	Warmup          WarmupConfig  `mapstructure:"warmup"`
+	// H2C accepts HTTP/2 without TLS (prior knowledge) next to HTTP/1.1, as
+	// gRPC clients inside the cluster speak it.
+	H2C bool `mapstructure:"h2c"`
+	// TLS serves HTTPS on Port, for deployments without a load balancer
+	// terminating TLS in front of the gateway.
+	TLS ServerTLSConfig `mapstructure:"tls"`
+	// ACME obtains and renews the HTTPS certificate from a CA such as Let's
+	// Encrypt, in place of TLS's cert_file and key_file.
+	ACME ACMEConfig `mapstructure:"acme"`
+}
+
+// ACMEConfig manages the certificate of the HTTPS listener through ACME.
+// Domains are validated with TLS-ALPN-01 on the HTTPS listener, which the CA
+// reaches on port 443, and with HTTP-01 on the redirect listener, reached on
+// port 80, when server.tls.redirect_port is set.
+type ACMEConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AcceptTerms agrees to the CA's terms of service, which it requires.
+	AcceptTerms bool `mapstructure:"accept_terms"`
+	// Domains are the names certificates are issued for; handshakes for
+	// other names are refused.
+	Domains []string `mapstructure:"domains"`
+	// Email is the account contact for the CA's expiry and problem notices.
+	Email string `mapstructure:"email"`
+	// DirectoryURL is the CA's ACME directory; Let's Encrypt production by
+	// default, its staging directory when testing.
+	DirectoryURL string `mapstructure:"directory_url"`
+	// CacheDir keeps the account key and certificates across restarts so
+	// they are not requested again. Replicas sharing the directory share
+	// certificates, keeping within the CA's rate limits.
+	CacheDir string `mapstructure:"cache_dir"`
+	// RenewBefore renews certificates this long before they expire.
+	RenewBefore time.Duration `mapstructure:"renew_before"`
+}
+
+// ServerTLSConfig terminates TLS on the gateway's listener. The certificate
+// and key files are read again when they change, so renewed certificates
+// are served without a restart.
+type ServerTLSConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CertFile is a PEM certificate chain, leaf first; KeyFile its PEM
+	// private key.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// MinVersion is "1.2" (default) or "1.3".
+	MinVersion string `mapstructure:"min_version"`
+	// CipherSuites limits TLS 1.2 to these suites, by their crypto/tls
+	// names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; empty keeps Go's
+	// defaults. TLS 1.3 suites are not configurable. FIPS mode overrides
+	// both with its approved suites.
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// RedirectPort, when set, serves plain HTTP there only to redirect
+	// every request to HTTPS on Port.
+	RedirectPort string `mapstructure:"redirect_port"`
+}
+
+// WarmupConfig holds /health at WARMING_UP after start until upstream DNS
+// and connections (TLS included), JWKS key sets and Redis connections are
+// primed, or Timeout passes, so the first requests after a deploy do not pay
+// for them.
+type WarmupConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Connections opened to each service ahead of traffic.
+	Connections int `mapstructure:"connections"`
 }
 
 type RedisConfig struct {
-	Address  string `mapstructure:"address"`
-	Password string `mapstructure:"password"`
+	Address string `mapstructure:"address"`
+	// Username authenticates as a Redis ACL user, with Password.
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
 	DB       int    `mapstructure:"db"`
+	// DialTimeout bounds connecting, TLS and AUTH included (default 5s);
+	// ReadTimeout and WriteTimeout bound each command's socket reads and
+	// writes (default 3s). Zero keeps the defaults.
+	DialTimeout  time.Duration `mapstructure:"dial_timeout"`
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// TLS connects over TLS 1.2+, verifying the server against CACert (PEM
+	// file; system roots when empty). ClientCert and ClientKey are PEM files
+	// for servers requiring client certificates. ServerName overrides the
+	// name checked in the server certificate, e.g. when connecting by IP.
+	TLS        bool   `mapstructure:"tls"`
+	CACert     string `mapstructure:"ca_cert"`
+	ClientCert string `mapstructure:"client_cert"`
+	ClientKey  string `mapstructure:"client_key"`
+	ServerName string `mapstructure:"server_name"`
+	// KeyPrefix namespaces every gateway key, e.g. "gw:prod:" or "gw:tenant-a:".
+	KeyPrefix string `mapstructure:"key_prefix"`
+	// DefaultKeyTTL is applied to gateway keys written without an explicit TTL.
+	DefaultKeyTTL time.Duration `mapstructure:"default_key_ttl"`
+	// EnforceKeyTTL has the keyspace scanner give DefaultKeyTTL to keys it
+	// finds without an expiry. It needs KeyPrefix, so that keys of other
+	// applications sharing the instance are never touched; off, the scanner
+	// only reports them.
+	EnforceKeyTTL bool `mapstructure:"enforce_key_ttl"`
+	// ScanInterval controls the keyspace memory scanner; zero disables it.
+	ScanInterval time.Duration `mapstructure:"scan_interval"`
+	ScanMaxKeys  int           `mapstructure:"scan_max_keys"`
+	// ReplicaAddress serves the read pool when set. Reads, blacklist checks
+	// included, then lag writes by the replication delay.
+	ReplicaAddress string `mapstructure:"replica_address"`
+	// LockAddresses are independent Redis instances (not replicas of one
+	// another) distributed locks are taken on, Redlock-style: a lock is held
+	// once a majority grants it, so it survives the loss of a minority.
+	// Empty takes locks on Address alone.
+	LockAddresses []string `mapstructure:"lock_addresses"`
+	// ReadPool serves lookups (blacklist checks, cache and counter reads),
+	// WritePool everything else (INCR, blacklisting, streams, locks).
+	ReadPool  RedisPoolConfig `mapstructure:"read_pool"`
+	WritePool RedisPoolConfig `mapstructure:"write_pool"`
+}
+
+// RedisPoolConfig sizes one Redis connection pool. Zero values keep the
+// client defaults (10 connections per CPU, timeout of read timeout + 1s).
+type RedisPoolConfig struct {
+	Size    int `mapstructure:"size"`
+	MinIdle int `mapstructure:"min_idle"`
+	// Timeout is how long a command waits for a free connection.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// MigrationRule moves part of a legacy service's traffic to a new backend
+// (strangler pattern). Requests to Service whose path starts with PathPrefix
+// and, when Methods is set, use one of those methods go to Target for
+// Percent of clients; the rest stay on Service. The first matching rule
+// applies. Percent can be changed at runtime through the admin API; 0 rolls
+// the rule back.
+type MigrationRule struct {
+	Name       string   `mapstructure:"name"`
+	Service    string   `mapstructure:"service"`
+	PathPrefix string   `mapstructure:"path_prefix"`
+	Methods    []string `mapstructure:"methods"`
+	Target     string   `mapstructure:"target"`
+	Percent    int      `mapstructure:"percent"`
+}
+
+// FederationConfig forwards requests that match no local route to a peer
+// gateway, e.g. the legacy gateway while routes migrate. Forwarded requests
+// carry "Via: 1.1 <gateway_id>"; a request already carrying it is answered
+// with 508 instead of looping.
+type FederationConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	PeerURL string `mapstructure:"peer_url"`
+	// GatewayID names this gateway in Via; peers must use different IDs.
+	GatewayID string `mapstructure:"gateway_id"`
+	// Paths limits forwarding to these path prefixes.
+	Paths   []string      `mapstructure:"paths"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Mesh secures forwarding between gateways, e.g. across regions.
+	Mesh MeshConfig `mapstructure:"mesh"`
+}
+
+// MeshConfig connects gateways with mutual TLS between SPIFFE identities:
+// the URI SAN of each gateway's X.509 SVID. Peers forward to each other's
+// mesh port, where only the certificates of PeerIDs are accepted; the
+// peer_url must present one of them too. Certificate files are reread when
+// they change, so SVIDs can rotate without a restart.
+type MeshConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Port serves requests from peer gateways.
+	Port string `mapstructure:"port"`
+	// SPIFFEID is this gateway's identity, as in its certificate.
+	SPIFFEID string `mapstructure:"spiffe_id"`
+	// PeerIDs are the identities of the gateways trusted as peers.
+	PeerIDs []string `mapstructure:"peer_ids"`
+	// CertFile and KeyFile are this gateway's SVID; CAFile is the trust
+	// bundle peers' certificates must chain to.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	CAFile   string `mapstructure:"ca_file"`
+}
+
+// RetryAfterConfig shapes the Retry-After header on 429, 502 and 503
+// responses. Upstream values are normalized to delta-seconds and capped at
+// Max; when an upstream omits one the gateway suggests Base, doubled for each
+// consecutive overload response from the same service.
+type RetryAfterConfig struct {
+	Base time.Duration `mapstructure:"base"`
+	Max  time.Duration `mapstructure:"max"`
+}
+
+// ConcurrencyConfig caps simultaneous in-flight requests per client IP and
+// per authenticated client, which per-window rate limits cannot see (e.g.
+// slowloris-style slow bodies). Per-replica limits are tracked in process;
+// cluster limits, when set, are coordinated through Redis leases renewed
+// every LeaseTTL/2 during the request, which expire after LeaseTTL if a
+// replica dies mid-request. Zero disables a limit.
+type ConcurrencyConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	PerIP            int           `mapstructure:"per_ip"`
+	PerClient        int           `mapstructure:"per_client"`
+	ClusterPerIP     int           `mapstructure:"cluster_per_ip"`
+	ClusterPerClient int           `mapstructure:"cluster_per_client"`
+	LeaseTTL         time.Duration `mapstructure:"lease_ttl"`
+}
+
+// WebSocketConfig caps the WebSocket connections a user holds open on routes
+// with websocket enabled. Connections count for as long as they stay open,
+// not against concurrency limits: on each replica and, with Redis, across
+// the cluster through leases renewed every LeaseTTL/2 while the connection
+// lives. Zero disables a limit.
+type WebSocketConfig struct {
+	PerUser        int           `mapstructure:"per_user"`
+	ClusterPerUser int           `mapstructure:"cluster_per_user"`
+	LeaseTTL       time.Duration `mapstructure:"lease_ttl"`
+}
+
+// ShieldConfig is the emergency global rate limit (DDoS shield): a
+// requests-per-second ceiling on each replica, kept in process, switched on
+// through the admin API during an attack. RPS and Burst are the ceiling used
+// when the switch does not give its own.
+type ShieldConfig struct {
+	RPS   float64 `mapstructure:"rps"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// BodyBufferConfig bounds request body buffering on routes with buffer_body.
+// Bodies are kept in memory up to MemoryLimit bytes each, while the total held
+// by in-flight requests stays under MemoryBudget; anything else spills to a
+// temporary file.
+type BodyBufferConfig struct {
+	MemoryLimit  int64 `mapstructure:"memory_limit"`
+	MemoryBudget int64 `mapstructure:"memory_budget"`
+	// MaxSize rejects larger bodies with 413.
+	MaxSize int64  `mapstructure:"max_size"`
+	TempDir string `mapstructure:"temp_dir"`
+}
+
+// InspectionConfig drives the sampling inspector, which keeps sanitized
+// headers, timings and policy decisions for a fraction of requests in an
+// in-memory buffer served by the admin API.
+type InspectionConfig struct {
+	Enabled    bool    `mapstructure:"enabled"`
+	SampleRate float64 `mapstructure:"sample_rate"`
+	BufferSize int     `mapstructure:"buffer_size"`
+	// Clients restricts sampling to these user IDs; empty samples everyone.
+	Clients []string `mapstructure:"clients"`
+	// RedactHeaders are masked in addition to credentials and cookies.
+	RedactHeaders []string `mapstructure:"redact_headers"`
+}
+
+// ClockConfig drives the NTP drift check guarding clock-sensitive policies
+// (JWT expiry, deprecation sunsets and brown-outs).
+type ClockConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	NTPServers    []string      `mapstructure:"ntp_servers"`
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+	// MaxDrift is the largest tolerated offset from NTP time.
+	MaxDrift time.Duration `mapstructure:"max_drift"`
+	// FailMode is "open" (relax time checks by the measured drift) or
+	// "closed" (reject requests that depend on them) while drift exceeds
+	// MaxDrift.
+	FailMode string `mapstructure:"fail_mode"`
+}
+
+// EventsConfig controls the capped Redis Streams (events:<stream>) that keep
+// access, audit and error events for consumers such as exporters.
+type EventsConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Streams []string `mapstructure:"streams"`
+	// MaxLen caps each stream (approximately); Retention expires idle streams.
+	MaxLen    int64         `mapstructure:"max_len"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// RetentionConfig drives the retention manager, which enforces how long the
+// gateway's own stored artifacts are kept, by category, and redacts fields
+// of entries as they age. Policies only tighten the capping and expiry the
+// stores already have.
+type RetentionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval is the time between passes.
+	Interval time.Duration `mapstructure:"interval"`
+	// Policies by category: access_events, audit_events, error_events,
+	// request_logs or body_spill.
+	Policies map[string]RetentionPolicy `mapstructure:"policies"`
+}
+
+// Retention categories.
+const (
+	RetentionAccessEvents = "access_events"
+	RetentionAuditEvents  = "audit_events"
+	RetentionErrorEvents  = "error_events"
+	RetentionRequestLogs  = "request_logs"
+	// RetentionBodySpill is the temporary files large buffered request
+	// bodies spill to, left behind when a replica dies mid-request.
+	RetentionBodySpill = "body_spill"
+)
+
+// RetentionPolicy is how long one category is kept.
+type RetentionPolicy struct {
+	// MaxAge removes entries older than this.
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// RedactAfter replaces RedactFields with "[redacted]" in entries older
+	// than this, which are otherwise kept until MaxAge. Fields are entry
+	// values, such as ip and user_id in access events, or log fields of
+	// audit and error events and request logs.
+	RedactAfter  time.Duration `mapstructure:"redact_after"`
+	RedactFields []string      `mapstructure:"redact_fields"`
+}
+
+// RequestLogConfig keeps every log and audit event tagged with a request ID in
+// Redis for Retention, so support can look a request up by its ID.
+type RequestLogConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Retention time.Duration `mapstructure:"retention"`
+	// MaxEvents caps the events kept per request.
+	MaxEvents int64 `mapstructure:"max_events"`
+}
+
+// KubernetesConfig controls access to the cluster API. With Controller set,
+// annotated Services are registered as upstreams and routes automatically.
+type KubernetesConfig struct {
+	Controller bool `mapstructure:"controller"`
+	// Namespace limits the watch to one namespace; empty watches all.
+	Namespace        string `mapstructure:"namespace"`
+	AnnotationPrefix string `mapstructure:"annotation_prefix"`
+	// ResyncInterval bounds each watch before a full re-list.
+	ResyncInterval time.Duration `mapstructure:"resync_interval"`
+	// APIServer, TokenFile and CAFile default to in-cluster credentials.
+	APIServer string `mapstructure:"api_server"`
+	TokenFile string `mapstructure:"token_file"`
+	CAFile    string `mapstructure:"ca_file"`
+}
+
+// ConsulConfig locates the Consul agent that resolves services with
+// discovery "consul".
+type ConsulConfig struct {
+	// Address is the agent's HTTP API, e.g. http://127.0.0.1:8500.
+	Address string `mapstructure:"address"`
+	// Token is the ACL token; it needs service:read and node:read.
+	Token      string `mapstructure:"token" secret:"true"`
+	Datacenter string `mapstructure:"datacenter"`
+	// CAFile verifies an https agent; empty uses the system roots.
+	CAFile string `mapstructure:"ca_file"`
+	// WaitTime bounds each blocking query before it is repeated.
+	WaitTime time.Duration `mapstructure:"wait_time"`
+}
+
+// XDSConfig points the gateway at an xDS control plane serving the REST-JSON
+// transport. Listeners, routes and clusters become dynamic gateway routes.
+type XDSConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Server  string `mapstructure:"server"`
+	NodeID  string `mapstructure:"node_id"`
+	Cluster string `mapstructure:"cluster"`
+	// Listeners restricts which listeners are translated; empty means all.
+	Listeners       []string      `mapstructure:"listeners"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// RegistryConfig serves an admin API on its own port for registering
+// upstream services and routes at runtime. Registrations are kept in Redis
+// and picked up by every replica.
+type RegistryConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Port    string `mapstructure:"port"`
 }
 
 type Service struct {
-	Name           string        `mapstructure:"name"`
-	URL            string        `mapstructure:"url"`
-	Timeout        time.Duration `mapstructure:"timeout"`
-	CircuitBreaker bool          `mapstructure:"circuit_breaker"`
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+	// Timeout bounds each upstream attempt, response body included; when it
+	// runs out the client gets 504. Zero waits as long as the client does.
+	Timeout          time.Duration    `mapstructure:"timeout"`
+	CircuitBreaker   bool             `mapstructure:"circuit_breaker"`
+	BodySanitization BodySanitization `mapstructure:"body_sanitization"`
+	Transport        TransportConfig  `mapstructure:"transport"`
+	// Discovery resolves the instances at runtime instead of using URL:
+	// "kubernetes" follows the ready endpoints of a Kubernetes Service,
+	// "consul" the instances passing their health checks in Consul.
+	Discovery  string           `mapstructure:"discovery"`
+	Kubernetes KubernetesTarget `mapstructure:"kubernetes"`
+	Consul     ConsulTarget     `mapstructure:"consul"`
+	// URLs lists several instances to balance round-robin instead of URL.
+	URLs []string `mapstructure:"urls"`
+	// Weights splits traffic over URLs in proportion, in the same order, e.g.
+	// [95, 5] to send a canary a 5% slice. Unset, instances share it evenly.
+	Weights []int `mapstructure:"weights"`
+	// Balancing picks among the instances: "round_robin" (default),
+	// "least_conn" for the fewest requests in flight, "least_latency" for
+	// the lowest recent latency weighed by requests in flight, or
+	// "consistent_hash" to keep each client on one instance.
+	Balancing string `mapstructure:"balancing"`
+	// HashOn keys consistent_hash: "user" (default), "session" (the token's
+	// sid claim), "ip", "header:<name>" or "cookie:<name>". Requests without
+	// the key are hashed by client IP.
+	HashOn string `mapstructure:"hash_on"`
+	// HashLoadFactor bounds an instance's requests in flight to this multiple
+	// of its fair share (default 1.25); clients of a busier instance spill
+	// over to the next on the ring.
+	HashLoadFactor float64 `mapstructure:"hash_load_factor"`
+	// HealthCheck actively probes every instance of the service.
+	HealthCheck HealthCheckConfig `mapstructure:"health_check"`
+	// OutlierDetection ejects instances failing too many requests.
+	OutlierDetection OutlierDetectionConfig `mapstructure:"outlier_detection"`
+	// Retry retries failed idempotent requests.
+	Retry RetryPolicy `mapstructure:"retry"`
+	// PCIScope marks a service in PCI audit scope: field_encryption tokens
+	// are restored for it, and only for it.
+	PCIScope bool `mapstructure:"pci_scope"`
+	// Protocol is how the gateway talks to the service: "http1" (default),
+	// "http2" (cleartext h2c with prior knowledge for http URLs, negotiated
+	// over TLS for https) or "grpc", HTTP/2 with gRPC deadlines and status
+	// codes.
+	Protocol string `mapstructure:"protocol"`
+	// GRPCServices lists the fully qualified gRPC services a grpc service
+	// implements, e.g. "ledger.v1.LedgerService". Calls to them are routed
+	// at /api/<name>/*, and clients may leave out the /api prefix they
+	// cannot add.
+	GRPCServices []string `mapstructure:"grpc_services"`
+	// Descriptor is a FileDescriptorSet of a grpc service's protos, with
+	// their imports (protoc --include_imports --descriptor_set_out), for
+	// Transcode.
+	Descriptor string `mapstructure:"descriptor"`
+	// Transcode exposes unary methods of a grpc service as JSON routes, for
+	// clients that cannot speak gRPC.
+	Transcode []TranscodeRule `mapstructure:"transcode"`
+}
+
+// TranscodeRule maps a JSON route to a unary gRPC method. The JSON body of
+// POST, PUT and PATCH requests is the request message; path and query
+// parameters set fields by name, with dots reaching into nested messages.
+// The response message is returned as JSON, and a gRPC error as the closest
+// HTTP status.
+type TranscodeRule struct {
+	Method string `mapstructure:"method"`
+	// Path is the route, e.g. "/api/v1/accounts/:account_id/balance".
+	Path string `mapstructure:"path"`
+	// RPC is the method, e.g. "ledger.v1.LedgerService/GetBalance".
+	RPC string `mapstructure:"rpc"`
+}
+
+// RetryPolicy retries GET and HEAD requests, and those carrying an
+// Idempotency-Key, that fail with a connection error or one of the RetryOn
+// statuses, within the retry budget. Attempts back off exponentially with
+// jitter, and a failed response is only withheld from the client when another
+// attempt will follow.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too; 0 or 1 disables retries.
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// Backoff before the first retry (default 50ms), doubling for each one
+	// after up to MaxBackoff (default 1s).
+	Backoff    time.Duration `mapstructure:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// RetryOn are the upstream statuses retried (default 502, 503 and 504).
+	RetryOn []int `mapstructure:"retry_on"`
+}
+
+// OutlierDetectionConfig ejects an instance of a balanced service from
+// rotation for a while when too many of its requests fail (5xx responses and
+// connection errors), whatever its circuit breaker or health checks say.
+type OutlierDetectionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval is the window failure ratios are measured over (default 10s).
+	Interval time.Duration `mapstructure:"interval"`
+	// FailureRatio of an instance's requests in a window ejects it (default
+	// 0.5), once it got MinRequests (default 10).
+	FailureRatio float64 `mapstructure:"failure_ratio"`
+	MinRequests  int     `mapstructure:"min_requests"`
+	// EjectionTime is the length of a first ejection (default 30s); an
+	// instance ejected again soon after stays out longer each time, up to ten
+	// times as long.
+	EjectionTime time.Duration `mapstructure:"ejection_time"`
+	// MaxEjectedPercent of the instances may be ejected at once (default
+	// 50); one always may.
+	MaxEjectedPercent int `mapstructure:"max_ejected_percent"`
+}
+
+// HealthCheckConfig is how a service's instances are probed. Type is "tcp"
+// (connect only), "http" (status code), "json" (status code and body fields)
+// or "grpc" (grpc.health.v1 over HTTP/2); empty disables checks.
+type HealthCheckConfig struct {
+	Type string `mapstructure:"type"`
+	// Path is the HTTP path probed by http and json checks (default /health).
+	Path string `mapstructure:"path"`
+	// Statuses are the passing status codes, exact ("204") or by class
+	// ("2xx"); default 2xx.
+	Statuses []string `mapstructure:"statuses"`
+	// JSONExpect are "field=value" predicates on the JSON body, with dotted
+	// paths into nested objects, e.g. "status=UP" or "components.db.status=UP".
+	JSONExpect []string `mapstructure:"json_expect"`
+	// GRPCService is the service name sent in the gRPC health request; empty
+	// asks about the server as a whole.
+	GRPCService string `mapstructure:"grpc_service"`
+	// Interval between probes (default 10s) and the Timeout of each (2s).
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// Rise consecutive passes mark an instance healthy again (default 2) and
+	// Fall consecutive failures unhealthy (default 3).
+	Rise int `mapstructure:"rise"`
+	Fall int `mapstructure:"fall"`
+}
+
+// KubernetesTarget is the Kubernetes Service whose ready endpoints serve a
+// service with discovery "kubernetes".
+type KubernetesTarget struct {
+	Service string `mapstructure:"service"`
+	// Namespace defaults to kubernetes.namespace, then "default".
+	Namespace string `mapstructure:"namespace"`
+	// Port is a port name or number; empty uses the first port.
+	Port string `mapstructure:"port"`
+	// Scheme is "http" (default) or "https".
+	Scheme string `mapstructure:"scheme"`
+}
+
+// ConsulTarget is the Consul service whose passing instances serve a service
+// with discovery "consul".
+type ConsulTarget struct {
+	Service string `mapstructure:"service"`
+	// Tag only uses instances carrying it.
+	Tag string `mapstructure:"tag"`
+	// Datacenter defaults to consul.datacenter, then the agent's own.
+	Datacenter string `mapstructure:"datacenter"`
+	// Scheme is "http" (default) or "https".
+	Scheme string `mapstructure:"scheme"`
+}
+
+// TransportConfig tunes the upstream connection pool of one service.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost keeps this many idle connections for reuse
+	// (default 100).
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost caps dialing, in-use and idle connections together;
+	// requests wait for a free connection beyond it. Zero is unlimited.
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"`
+	// DisableKeepAlives opens a fresh connection for every request.
+	DisableKeepAlives bool `mapstructure:"disable_keepalives"`
+	// TLSSessionCache keeps this many TLS sessions to https instances, so
+	// new connections resume them with an abbreviated handshake (default
+	// 64); -1 disables resumption.
+	TLSSessionCache int `mapstructure:"tls_session_cache"`
+}
+
+// BodySanitization filters dangerous keys out of JSON bodies for services
+// whose runtimes are vulnerable to prototype pollution or operator injection.
+// Mode is "strip", "reject" or empty (disabled).
+type BodySanitization struct {
+	Mode           string   `mapstructure:"mode"`
+	Keys           []string `mapstructure:"keys"`
+	BlockOperators bool     `mapstructure:"block_operators"`
 }
 
 type SecurityConfig struct {
-	JWTSecret       string        `mapstructure:"jwt_secret"`
+	JWTSecret       string        `mapstructure:"jwt_secret" secret:"true"`
 	TokenExpiration time.Duration `mapstructure:"token_expiration"`
+	JSONLimits      JSONLimits    `mapstructure:"json_limits"`
+	// TransformBudget bounds the work request transformation and validation
+	// may do per request.
+	TransformBudget TransformBudgetConfig `mapstructure:"transform_budget"`
+	// Issuers selects verification settings by the token's iss claim. When
+	// empty, tokens are verified with JWTSecret using HMAC.
+	Issuers       []IssuerConfig      `mapstructure:"issuers"`
+	Impersonation ImpersonationConfig `mapstructure:"impersonation"`
+	Revocation    RevocationConfig    `mapstructure:"revocation"`
+	RouteLint     RouteLintConfig     `mapstructure:"route_lint"`
+	FIPS          FIPSConfig          `mapstructure:"fips"`
+	// PCIGuard keeps card numbers out of services outside PCI scope.
+	PCIGuard PCIGuardConfig `mapstructure:"pci_guard"`
+}
+
+// PCIGuardConfig scans requests to services without pci_scope for card
+// numbers in the path, query, headers and text bodies. Action is "block"
+// (reject the request) or "mask" (forward it with all but the first six and
+// last four digits masked); card numbers in the path are always blocked.
+type PCIGuardConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Action  string `mapstructure:"action"`
+}
+
+// FIPSConfig restricts TLS, JWT and HMAC to FIPS 140-2 approved algorithms.
+// Binaries built with -tags fips are always in FIPS mode.
+type FIPSConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// RouteLintConfig controls the startup check of the routing table for auth
+// bypasses. Mode is "fail" (refuse to start), "warn" (log only) or "off".
+type RouteLintConfig struct {
+	Mode string `mapstructure:"mode"`
+	// PublicRoutes lists route paths that are meant to be served without a
+	// token, e.g. "/api/auth/*".
+	PublicRoutes []string `mapstructure:"public_routes"`
+}
+
+// RevocationConfig consumes logout/revocation events from the auth service,
+// published on a Redis channel or posted to the revocation webhook, and
+// blacklists the token ID (jti) or every token of a subject on all replicas.
+type RevocationConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Channel string `mapstructure:"channel"`
+	// WebhookSecret verifies X-Signature-SHA256 on POST
+	// /webhooks/auth/revocations; the webhook is disabled while empty.
+	WebhookSecret string `mapstructure:"webhook_secret" secret:"true"`
+	// CacheTTL is how long a "not revoked" lookup is cached per replica;
+	// revocation events invalidate it immediately.
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// Export publishes the revocation list for backends to enforce locally.
+	Export RevocationExportConfig `mapstructure:"export"`
+}
+
+// RevocationExportConfig publishes the current revocation list (revoked
+// token IDs and subjects, with their expiry) at GET /revocations, signed
+// with the gateway signing key, so backends can check tokens without calling
+// the gateway. A background job drops expired entries and re-signs the list
+// every Interval.
+type RevocationExportConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	// AllowedCIDRs are the networks backends fetch the list from; it names
+	// users, so it is not public.
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+}
+
+// ImpersonationConfig governs tokens carrying an RFC 8693 "act" claim, where
+// sub is the effective user and act.sub the real (acting) principal.
+type ImpersonationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AllowedRoles lists roles (from act.roles) that may act on behalf of users.
+	AllowedRoles []string `mapstructure:"allowed_roles"`
+	// AllowedClients restricts which azp clients may mint delegated tokens.
+	AllowedClients []string `mapstructure:"allowed_clients"`
+	MaxChainDepth  int      `mapstructure:"max_chain_depth"`
+}
+
+// IssuerConfig pins the algorithm, key material and audience for tokens
+// from one issuer. HMAC algorithms use Secret; RSA/ECDSA use a PEM public key.
+type IssuerConfig struct {
+	Issuer        string `mapstructure:"issuer"`
+	Algorithm     string `mapstructure:"algorithm"`
+	Secret        string `mapstructure:"secret" secret:"true"`
+	PublicKey     string `mapstructure:"public_key"`
+	PublicKeyFile string `mapstructure:"public_key_file"`
+	// JWKSURL fetches verification keys from the issuer's JSON Web Key Set
+	// instead of a fixed public key; tokens select a key by kid.
+	JWKSURL string `mapstructure:"jwks_url"`
+	// JWKSRefresh is how often the key set is re-fetched (default 5m).
+	JWKSRefresh time.Duration `mapstructure:"jwks_refresh"`
+	Audience    string        `mapstructure:"audience"`
+}
+
+// JSONLimits bounds the structure of JSON request bodies. A zero value
+// disables the corresponding check.
+type JSONLimits struct {
+	MaxDepth        int `mapstructure:"max_depth"`
+	MaxKeys         int `mapstructure:"max_keys"`
+	MaxArrayLength  int `mapstructure:"max_array_length"`
+	MaxStringLength int `mapstructure:"max_string_length"`
+}
+
+// TransformBudgetConfig limits the query policy, JSON limits, money
+// conversion and body sanitization steps of one request, so a pathological
+// pattern or body cannot tie up the gateway. A zero limit is not enforced.
+type TransformBudgetConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// WallTime bounds each step; steps stop cooperatively once it passes.
+	WallTime time.Duration `mapstructure:"wall_time"`
+	// ProcessingTime bounds the time spent in all steps of a request. Go has
+	// no per-goroutine CPU clock, so time inside the steps stands in for it.
+	ProcessingTime time.Duration `mapstructure:"processing_time"`
+	// MaxAllocBytes is a soft cap on the memory the steps allocate, estimated
+	// from the bodies they decode and re-encode.
+	MaxAllocBytes int64 `mapstructure:"max_alloc_bytes"`
+}
+
+type AdminConfig struct {
+	// APIKey is the shared admin key. It has security-admin access on its
+	// own, but only viewer access once operators or SSO are configured, so
+	// named admins are not bypassed; SharedKeyRole overrides either.
+	APIKey        string `mapstructure:"api_key" secret:"true"`
+	SharedKeyRole string `mapstructure:"shared_key_role"`
+	// WatchConfig reloads configuration when the file changes, validating it
+	// and auditing the changes staged for the next restart.
+	WatchConfig bool `mapstructure:"watch_config"`
+	// ExplainKey must be presented in X-Gateway-Explain for a request to get a
+	// policy decision trace, and only while explain mode is switched on.
+	ExplainKey string `mapstructure:"explain_key" secret:"true"`
+	// Operators are named admins with their own keys, so audit records and
+	// approvals know who acted. They authenticate like the shared key.
+	Operators map[string]AdminOperator `mapstructure:"operators"`
+	Approvals ApprovalsConfig          `mapstructure:"approvals"`
+	// SSO accepts operator tokens from the corporate IdP.
+	SSO AdminSSOConfig `mapstructure:"sso"`
+	// AllowedCIDRs restricts the admin API to directly connecting networks;
+	// empty allows any.
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+	// EndpointRoles override the role an admin endpoint needs. By default
+	// reads need viewer, changes need operator, and credential, session and
+	// consent management and user data erasure need security-admin.
+	EndpointRoles []EndpointRole `mapstructure:"endpoint_roles"`
+}
+
+// Admin roles, each including the ones before it.
+const (
+	RoleViewer        = "viewer"
+	RoleOperator      = "operator"
+	RoleSecurityAdmin = "security-admin"
+)
+
+// AdminOperator is one named admin. Role is required, so no operator gets
+// security-admin access by leaving it out.
+type AdminOperator struct {
+	APIKey string `mapstructure:"api_key" secret:"true"`
+	Role   string `mapstructure:"role"`
+}
+
+// AdminSSOConfig validates short-lived operator JWTs (Authorization: Bearer)
+// from the corporate IdP on the admin API.
+type AdminSSOConfig struct {
+	Enabled bool         `mapstructure:"enabled"`
+	Issuer  IssuerConfig `mapstructure:"issuer"`
+	// SubjectClaim names the operator in audit records (default "sub").
+	SubjectClaim string `mapstructure:"subject_claim"`
+	// RolesClaim lists the operator's IdP roles or groups (default "roles").
+	RolesClaim string `mapstructure:"roles_claim"`
+	// RoleMapping maps IdP roles or groups to gateway roles; without it the
+	// claim must hold gateway role names.
+	RoleMapping map[string]string `mapstructure:"role_mapping"`
+	// MaxLifetime rejects tokens valid for longer than this from issue
+	// (default 1h); exp and iat are required.
+	MaxLifetime time.Duration `mapstructure:"max_lifetime"`
+}
+
+// EndpointRole is the role needed for "METHOD /admin/path" (route pattern).
+type EndpointRole struct {
+	Operation string `mapstructure:"operation"`
+	Role      string `mapstructure:"role"`
+}
+
+// ApprovalsConfig puts destructive admin operations behind two-person
+// approval: one operator proposes the request, a second approves it within
+// TTL, and the request is then repeated with X-Approval-ID to run it.
+type ApprovalsConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	TTL     time.Duration `mapstructure:"ttl"`
+	// Operations are "METHOD /admin/path" entries, matched against the
+	// route pattern or the literal path (e.g. "PUT /admin/readonly/global").
+	Operations []string `mapstructure:"operations"`
+}
+
+// TrafficConfig sizes the in-memory sketches behind the admin traffic report.
+type TrafficConfig struct {
+	Retention time.Duration `mapstructure:"retention"`
+	TopK      int           `mapstructure:"top_k"`
+}
+
+type RateLimitsConfig struct {
+	// DocsURL is returned in 429 responses so clients can look up the policy.
+	DocsURL string `mapstructure:"docs_url"`
+	// SoftLimitRatio is the fraction of a limit after which warning headers
+	// are sent. Zero disables soft-limit warnings.
+	SoftLimitRatio float64 `mapstructure:"soft_limit_ratio"`
+	// GraceBurstRatio is the extra fraction of a limit that established
+	// authenticated clients may use before being rejected. Zero disables it.
+	GraceBurstRatio   float64       `mapstructure:"grace_burst_ratio"`
+	GraceMinClientAge time.Duration `mapstructure:"grace_min_client_age"`
+	// KeySecret keys the HMAC used to derive rate limit keys; KeyRotation is
+	// how often the derived salt changes (at least 1s; 0 never rotates). All
+	// replicas must share the secret, which must be at least 16 bytes when
+	// Redis is configured.
+	KeySecret   string        `mapstructure:"key_secret" secret:"true"`
+	KeyRotation time.Duration `mapstructure:"key_rotation"`
+	// Policies are the named limits routes reference by rate_limit. The
+	// auth, transfer and default policies guard the built-in route groups.
+	Policies map[string]RateLimitPolicy `mapstructure:"policies"`
+}
+
+// RateLimitPolicy allows Limit requests per Window, counted per client IP
+// (Key "ip") or per authenticated user (Key "user", IP when anonymous).
+type RateLimitPolicy struct {
+	Limit  int64         `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+	Key    string        `mapstructure:"key"`
+	// Strategy is "fixed_window" (default) or "token_bucket". A token bucket
+	// refills at Limit per Window and holds up to Burst requests.
+	Strategy string `mapstructure:"strategy"`
+	Burst    int64  `mapstructure:"burst"`
+}
+
+// AnalyticsConfig controls client/endpoint usage aggregation in Redis.
+type AnalyticsConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	Retention     time.Duration `mapstructure:"retention"`
+}
+
+// StatusConfig drives the public /status feed and status-page webhook.
+type StatusConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Interval      time.Duration `mapstructure:"interval"`
+	WebhookURL    string        `mapstructure:"webhook_url"`
+	WebhookSecret string        `mapstructure:"webhook_secret" secret:"true"`
 }
 
 type CorsConfig struct {
-	AllowOrigins []string `mapstructure:"allow_origins"`
+	AllowOrigins     []string `mapstructure:"allow_origins"`
+	AllowMethods     []string `mapstructure:"allow_methods"`
+	AllowHeaders     []string `mapstructure:"allow_headers"`
+	ExposeHeaders    []string `mapstructure:"expose_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	// MaxAge is how long (in seconds) browsers may cache a preflight result.
+	MaxAge int `mapstructure:"max_age"`
+}
+
+// RouteConfig holds policies applied to a single route, matched against the
+// registered Echo path pattern (e.g. "/api/transfers/*").
+type RouteConfig struct {
+	Path  string      `mapstructure:"path"`
+	Query QueryPolicy `mapstructure:"query"`
+	// JSONLimits tightens or loosens security.json_limits for this route,
+	// field by field: limits left at zero keep the global value, so a route
+	// cannot lift a limit entirely.
+	JSONLimits *JSONLimits `mapstructure:"json_limits"`
+	// Scopes lists the token scopes this route exercises, used by the usage
+	// report to find granted-but-unused scopes.
+	Scopes      []string           `mapstructure:"scopes"`
+	Deprecation *DeprecationConfig `mapstructure:"deprecation"`
+	// Cors overrides the global CORS settings for this route.
+	Cors *CorsConfig `mapstructure:"cors"`
+	// SynthesizeHead answers HEAD by issuing GET upstream and discarding the
+	// body, for backends that reject HEAD with 405.
+	SynthesizeHead bool `mapstructure:"synthesize_head"`
+	// BufferBody reads the whole request body before proxying so it can be
+	// replayed (retries, mirroring, idempotency), within body_buffer limits.
+	BufferBody bool `mapstructure:"buffer_body"`
+	// Cache enables the response cache for GET requests on this route.
+	Cache *CacheConfig `mapstructure:"cache"`
+	// Integrity verifies GET responses against an upstream digest.
+	Integrity *IntegrityConfig `mapstructure:"integrity"`
+	// MaxDeadline caps the budget a client deadline may grant on this route,
+	// overriding deadline.max_budget.
+	MaxDeadline time.Duration `mapstructure:"max_deadline"`
+	// Money converts amounts between the client and backend representations.
+	Money *MoneyConfig `mapstructure:"money"`
+	// MinClientVersions overrides client_policy.min_versions per platform.
+	MinClientVersions map[string]string `mapstructure:"min_client_versions"`
+	// Hedge sends slow GET and HEAD requests a second time, to another
+	// instance.
+	Hedge *HedgeConfig `mapstructure:"hedge"`
+	// WebSocket tunnels WebSocket upgrade requests to the upstream; they are
+	// refused on other routes.
+	WebSocket bool `mapstructure:"websocket"`
+	// Streaming passes responses through as they arrive, e.g. Server-Sent
+	// Events or chunked exports.
+	Streaming *StreamingConfig `mapstructure:"streaming"`
+}
+
+// StreamingConfig streams a route's responses to the client. The service's
+// timeout bounds waiting for the response headers, and neither it nor the
+// server's write timeout cuts the body short. Middleware holding whole
+// responses still delays them: integrity within its buffer, and the cache
+// while it has a stale entry to fall back on.
+type StreamingConfig struct {
+	// FlushInterval flushes responses periodically; zero flushes after
+	// every write. Server-Sent Events and responses of unknown length are
+	// always flushed after every write.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// MaxDuration bounds a response, zero leaving it open for as long as
+	// client and upstream keep it.
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+}
+
+// HedgeConfig hedges a route's GET and HEAD requests: when the upstream has
+// not answered within the route's recent Percentile latency, the request is
+// also sent to another instance of the service and the first response is
+// used, the other request being cancelled. Services with a single URL are
+// hedged to it again, over another connection. Hedged requests are drawn
+// from the retry budget.
+type HedgeConfig struct {
+	// Percentile of the route's recent upstream latencies waited before
+	// hedging (default 95).
+	Percentile float64 `mapstructure:"percentile"`
+	// MinDelay and MaxDelay bound the wait (default 10ms and 1s); MaxDelay
+	// applies until enough latencies are measured.
+	MinDelay time.Duration `mapstructure:"min_delay"`
+	MaxDelay time.Duration `mapstructure:"max_delay"`
+}
+
+// MoneyConfig declares the amount fields of a route's JSON bodies and how
+// each side represents them: "decimal" ("12.34"; numbers are accepted from
+// clients, strings are sent) or "minor" (integer minor units, 1234). Request
+// bodies are converted from Client to Backend and responses back, using the
+// currency's ISO 4217 exponent; amounts with more precision than the
+// currency allows are rejected rather than rounded.
+type MoneyConfig struct {
+	// Fields are dot-separated paths to amounts; "[]" after a segment visits
+	// every element of that array, e.g. "legs[].amount".
+	Fields  []string `mapstructure:"fields"`
+	Client  string   `mapstructure:"client"`
+	Backend string   `mapstructure:"backend"`
+	// CurrencyField names the currency code next to each amount (default
+	// "currency"); Currency applies when it is missing.
+	CurrencyField string `mapstructure:"currency_field"`
+	Currency      string `mapstructure:"currency"`
 }
 
+// IntegrityConfig checks downloads from backends that may truncate them.
+// The body is hashed as it streams and compared with the digest the upstream
+// sent in Header: Content-Digest (RFC 9530), Digest (RFC 3230), or a header
+// holding a bare hex or base64 SHA-256.
+type IntegrityConfig struct {
+	// Header names the upstream digest header (default "Content-Digest").
+	Header string `mapstructure:"header"`
+	// Require fails responses that arrive without a digest.
+	Require bool `mapstructure:"require"`
+	// Attach adds a computed Content-Digest when the upstream sent none.
+	Attach bool `mapstructure:"attach"`
+	// MaxBufferBytes holds responses up to this size until verified, so a
+	// mismatch returns 502. Larger responses are streamed and the connection
+	// is aborted on mismatch.
+	MaxBufferBytes int64 `mapstructure:"max_buffer_bytes"`
+}
+
+// CacheConfig sets a route's response cache lifetimes. Responses are fresh
+// for TTL; for StaleWhileRevalidate after that they are served while a
+// background request refreshes them, and for StaleIfError they are served
+// when the upstream fails with a 5xx or its circuit breaker is open.
+// Entries are kept per authenticated user.
+type CacheConfig struct {
+	TTL                  time.Duration `mapstructure:"ttl"`
+	StaleWhileRevalidate time.Duration `mapstructure:"stale_while_revalidate"`
+	StaleIfError         time.Duration `mapstructure:"stale_if_error"`
+	// MaxBodyBytes skips caching larger responses (default 1 MiB).
+	MaxBodyBytes int            `mapstructure:"max_body_bytes"`
+	Key          CacheKeyConfig `mapstructure:"key"`
+	// Backend stores the entries: "redis" (shared by replicas), "memory"
+	// (per replica) or "tiered" (memory in front of Redis). The default is
+	// redis, or memory without Redis.
+	Backend string `mapstructure:"backend"`
+}
+
+// CacheKeyConfig decides which parts of a request select a cache entry.
+type CacheKeyConfig struct {
+	// Scope is "user" (the default: one entry per authenticated user) or
+	// "shared" (one entry for every caller; only for non-personal data).
+	Scope string `mapstructure:"scope"`
+	// IncludeQuery, when set, keys on these query parameters only;
+	// ExcludeQuery drops parameters (glob patterns, e.g. "utm_*").
+	IncludeQuery []string `mapstructure:"include_query"`
+	ExcludeQuery []string `mapstructure:"exclude_query"`
+	// VaryHeaders adds these request headers to the key and to the Vary
+	// response header. Responses varying on any other header are not stored.
+	VaryHeaders []string `mapstructure:"vary_headers"`
+}
+
+// DeprecationConfig announces a route's deprecation and sunset. Dates are
+// RFC3339 timestamps or YYYY-MM-DD.
+type DeprecationConfig struct {
+	Since     string `mapstructure:"since"`
+	Sunset    string `mapstructure:"sunset"`
+	Link      string `mapstructure:"link"`
+	Successor string `mapstructure:"successor"`
+	// EnforceSunset returns 410 Gone for every call after the sunset date.
+	EnforceSunset bool            `mapstructure:"enforce_sunset"`
+	Brownout      *BrownoutConfig `mapstructure:"brownout"`
+}
+
+// BrownoutConfig schedules intermittent 410 responses ahead of the sunset:
+// from Start onwards, the first Duration of every Every period is refused.
+type BrownoutConfig struct {
+	Start    string        `mapstructure:"start"`
+	Duration time.Duration `mapstructure:"duration"`
+	Every    time.Duration `mapstructure:"every"`
+}
+
+// QueryPolicy controls which query parameters reach the upstream service.
+type QueryPolicy struct {
+	Allow         []string         `mapstructure:"allow"`
+	Strip         []string         `mapstructure:"strip"`
+	RejectUnknown bool             `mapstructure:"reject_unknown"`
+	Params        []QueryParam     `mapstructure:"params"`
+	DateRanges    []QueryDateRange `mapstructure:"date_ranges"`
+}
+
+// QueryParam describes the expected shape of a single query parameter.
+// Type is one of "string", "int", "uuid", "date" or "enum".
+type QueryParam struct {
+	Name      string   `mapstructure:"name"`
+	Type      string   `mapstructure:"type"`
+	Required  bool     `mapstructure:"required"`
+	Min       *int64   `mapstructure:"min"`
+	Max       *int64   `mapstructure:"max"`
+	MaxLength int      `mapstructure:"max_length"`
+	Pattern   string   `mapstructure:"pattern"`
+	Values    []string `mapstructure:"values"`
+}
+
+// QueryDateRange bounds the span between two date parameters.
+type QueryDateRange struct {
+	From    string `mapstructure:"from"`
+	To      string `mapstructure:"to"`
+	MaxDays int    `mapstructure:"max_days"`
+}
+
+// Route returns the configuration for the given route path, or nil if the
+// route has no explicit policies.
+func (c *Config) Route(path string) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Path == path {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
+// validate rejects route policies defined more than once for the same path,
+// where only the first would ever apply, and malformed trusted networks.
+func (c *Config) validate() error {
+	for name, svc := range c.Services {
+		if len(svc.URLs) > 0 && (svc.URL != "" || svc.Discovery != "") {
+			return fmt.Errorf("services.%s: urls cannot be combined with url or discovery", name)
+		}
+		for _, raw := range svc.URLs {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("services.%s.urls: %q is not an absolute http(s) URL", name, raw)
+			}
+		}
+		if len(svc.Weights) > 0 {
+			if len(svc.Weights) != len(svc.URLs) {
+				return fmt.Errorf("services.%s.weights: need one weight per url, got %d for %d", name, len(svc.Weights), len(svc.URLs))
+			}
+			total := 0
+			for _, w := range svc.Weights {
+				if w < 0 {
+					return fmt.Errorf("services.%s.weights: must not be negative", name)
+				}
+				total += w
+			}
+			if total == 0 {
+				return fmt.Errorf("services.%s.weights: at least one must be positive", name)
+			}
+		}
+		switch svc.Balancing {
+		case "", "round_robin", "least_conn", "least_latency", "consistent_hash":
+		default:
+			return fmt.Errorf("services.%s: balancing must be round_robin, least_conn, least_latency or consistent_hash, got %q", name, svc.Balancing)
+		}
+		if (svc.HashOn != "" || svc.HashLoadFactor != 0) && svc.Balancing != "consistent_hash" {
+			return fmt.Errorf("services.%s: hash_on and hash_load_factor need balancing: consistent_hash", name)
+		}
+		if !validHashOn(svc.HashOn) {
+			return fmt.Errorf("services.%s: hash_on must be user, session, ip, header:<name> or cookie:<name>, got %q", name, svc.HashOn)
+		}
+		if svc.HashLoadFactor != 0 && svc.HashLoadFactor < 1 {
+			return fmt.Errorf("services.%s: hash_load_factor must be at least 1", name)
+		}
+		if svc.Balancing != "" && len(svc.URLs) == 0 && svc.Discovery == "" {
+			return fmt.Errorf("services.%s: balancing needs urls or discovery", name)
+		}
+		if err := svc.HealthCheck.validate(); err != nil {
+			return fmt.Errorf("services.%s.health_check: %w", name, err)
+		}
+		if svc.Timeout < 0 {
+			return fmt.Errorf("services.%s.timeout must not be negative", name)
+		}
+		switch svc.Protocol {
+		case "", "http1", "http2", "grpc":
+		default:
+			return fmt.Errorf("services.%s: protocol must be http1, http2 or grpc, got %q", name, svc.Protocol)
+		}
+		if len(svc.GRPCServices) > 0 && svc.Protocol != "grpc" {
+			return fmt.Errorf("services.%s: grpc_services needs protocol grpc", name)
+		}
+		for _, g := range svc.GRPCServices {
+			if g == "" || strings.ContainsAny(g, "/*") {
+				return fmt.Errorf("services.%s.grpc_services: %q is not a gRPC service name", name, g)
+			}
+		}
+		if len(svc.Transcode) > 0 && (svc.Protocol != "grpc" || svc.Descriptor == "") {
+			return fmt.Errorf("services.%s: transcode needs protocol grpc and a descriptor", name)
+		}
+		for _, t := range svc.Transcode {
+			switch t.Method {
+			case "GET", "POST", "PUT", "PATCH", "DELETE":
+			default:
+				return fmt.Errorf("services.%s.transcode: method must be GET, POST, PUT, PATCH or DELETE, got %q", name, t.Method)
+			}
+			if !strings.HasPrefix(t.Path, "/api/") {
+				return fmt.Errorf("services.%s.transcode: path %q must start with /api/", name, t.Path)
+			}
+			if service, method, ok := strings.Cut(t.RPC, "/"); !ok || service == "" || method == "" || strings.Contains(method, "/") {
+				return fmt.Errorf("services.%s.transcode: rpc %q must be <service>/<method>", name, t.RPC)
+			}
+		}
+		if svc.Transport.TLSSessionCache < -1 {
+			return fmt.Errorf("services.%s.transport.tls_session_cache must be -1 (disabled) or more", name)
+		}
+		if od := svc.OutlierDetection; od.Enabled {
+			if len(svc.URLs) == 0 && svc.Discovery == "" {
+				return fmt.Errorf("services.%s: outlier_detection needs urls or discovery", name)
+			}
+			if od.Interval < 0 || od.EjectionTime < 0 || od.MinRequests < 0 || od.FailureRatio < 0 || od.FailureRatio > 1 || od.MaxEjectedPercent < 0 || od.MaxEjectedPercent > 100 {
+				return fmt.Errorf("services.%s.outlier_detection: durations and min_requests must not be negative, failure_ratio must be within 0-1 and max_ejected_percent within 0-100", name)
+			}
+		}
+		if r := svc.Retry; r.MaxAttempts < 0 || r.MaxAttempts > 10 || r.Backoff < 0 || r.MaxBackoff < 0 {
+			return fmt.Errorf("services.%s.retry: max_attempts must be within 0-10 and backoffs must not be negative", name)
+		} else if r.MaxBackoff > 0 && r.MaxBackoff < r.Backoff {
+			return fmt.Errorf("services.%s.retry: max_backoff must not be below backoff", name)
+		}
+		for _, code := range svc.Retry.RetryOn {
+			if code < 400 || code > 599 {
+				return fmt.Errorf("services.%s.retry.retry_on: %d is not an error status", name, code)
+			}
+		}
+		switch svc.Discovery {
+		case "":
+		case "kubernetes":
+			if svc.Kubernetes.Service == "" {
+				return fmt.Errorf("services.%s: kubernetes.service is required for kubernetes discovery", name)
+			}
+			if s := svc.Kubernetes.Scheme; s != "" && s != "http" && s != "https" {
+				return fmt.Errorf("services.%s: kubernetes.scheme must be http or https, got %q", name, s)
+			}
+		case "consul":
+			if svc.Consul.Service == "" {
+				return fmt.Errorf("services.%s: consul.service is required for consul discovery", name)
+			}
+			if s := svc.Consul.Scheme; s != "" && s != "http" && s != "https" {
+				return fmt.Errorf("services.%s: consul.scheme must be http or https, got %q", name, s)
+			}
+		default:
+			return fmt.Errorf("services.%s: discovery must be empty, \"kubernetes\" or \"consul\", got %q", name, svc.Discovery)
+		}
+	}
+	seen := make(map[string]bool, len(c.Routes))
+	for _, r := range c.Routes {
+		if seen[r.Path] {
+			return fmt.Errorf("routes: %q is defined more than once", r.Path)
+		}
+		seen[r.Path] = true
+	}
+	grpcServices := make(map[string]string)
+	for name, svc := range c.Services {
+		for _, g := range svc.GRPCServices {
+			if other, ok := grpcServices[g]; ok {
+				return fmt.Errorf("services.%s.grpc_services: %q is already served by %s", name, g, other)
+			}
+			grpcServices[g] = name
+		}
+	}
+	transcoded := make(map[string]string)
+	for name, svc := range c.Services {
+		for _, t := range svc.Transcode {
+			route := t.Method + " " + t.Path
+			if other, ok := transcoded[route]; ok {
+				return fmt.Errorf("services.%s.transcode: %s is already transcoded for %s", name, route, other)
+			}
+			transcoded[route] = name
+		}
+	}
+	if e := c.Security.Revocation.Export; e.Enabled {
+		if !c.Security.Revocation.Enabled || c.Signing.Backend == "" {
+			return errors.New("security.revocation.export needs security.revocation and a signing backend")
+		}
+		if e.Interval < time.Second {
+			return errors.New("security.revocation.export.interval must be at least 1s")
+		}
+		for _, cidr := range e.AllowedCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("security.revocation.export.allowed_cidrs: %w", err)
+			}
+		}
+	}
+	if a := c.Security.PCIGuard.Action; a != "block" && a != "mask" {
+		return fmt.Errorf("security.pci_guard.action must be block or mask, got %q", a)
+	}
+	for _, cidr := range c.Deadline.TrustedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("deadline.trusted_cidrs: %w", err)
+		}
+	}
+	if p := c.ClientPolicy.UserAgentPattern; p != "" {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("client_policy.user_agent_pattern: %w", err)
+		}
+		if re.SubexpIndex("version") < 0 {
+			return errors.New(`client_policy.user_agent_pattern needs a named group "version"`)
+		}
+	}
+	for platform, v := range c.ClientPolicy.MinVersions {
+		if !validAppVersion(v) {
+			return fmt.Errorf("client_policy.min_versions.%s: %q is not a dotted numeric version", platform, v)
+		}
+	}
+	for _, r := range c.Routes {
+		if h := r.Hedge; h != nil {
+			switch {
+			case h.Percentile < 0 || h.Percentile >= 100:
+				return fmt.Errorf("routes %s: hedge.percentile must be below 100, got %g", r.Path, h.Percentile)
+			case h.MinDelay < 0 || h.MaxDelay < 0:
+				return fmt.Errorf("routes %s: hedge delays must not be negative", r.Path)
+			case h.MaxDelay > 0 && h.MinDelay > h.MaxDelay:
+				return fmt.Errorf("routes %s: hedge.min_delay exceeds max_delay", r.Path)
+			}
+		}
+		if r.WebSocket && r.Cache != nil {
+			return fmt.Errorf("routes %s: websocket routes cannot be cached", r.Path)
+		}
+		if st := r.Streaming; st != nil {
+			switch {
+			case st.FlushInterval < 0 || st.MaxDuration < 0:
+				return fmt.Errorf("routes %s: streaming durations must not be negative", r.Path)
+			case r.Money != nil:
+				return fmt.Errorf("routes %s: money conversion holds whole responses and cannot stream", r.Path)
+			}
+		}
+		for platform, v := range r.MinClientVersions {
+			if !validAppVersion(v) {
+				return fmt.Errorf("routes %s: min_client_versions.%s: %q is not a dotted numeric version", r.Path, platform, v)
+			}
+		}
+	}
+	if cc := c.Concurrency; cc.Enabled && (cc.ClusterPerIP > 0 || cc.ClusterPerClient > 0) && cc.LeaseTTL < time.Second {
+		return errors.New("concurrency.lease_ttl must be at least 1s")
+	}
+	if ws := c.WebSocket; ws.PerUser < 0 || ws.ClusterPerUser < 0 {
+		return errors.New("websocket: connection limits must not be negative")
+	} else if ws.ClusterPerUser > 0 && ws.LeaseTTL < time.Second {
+		return errors.New("websocket.lease_ttl must be at least 1s")
+	}
+	if fe := c.FieldEncryption; len(fe.Fields) > 0 {
+		if key, err := base64.StdEncoding.DecodeString(fe.Key); err != nil || len(key) != 32 {
+			return errors.New("field_encryption.key must be a base64 256-bit key")
+		}
+		for _, f := range fe.Fields {
+			if f.Path == "" || strings.HasSuffix(f.Path, "[]") {
+				return fmt.Errorf("field_encryption.fields: path %q must end at an object field", f.Path)
+			}
+			if f.Format != "pan" && f.Format != "opaque" {
+				return fmt.Errorf("field_encryption.fields %s: format must be \"pan\" or \"opaque\"", f.Path)
+			}
+		}
+	}
+	for _, cidr := range c.TLSFingerprint.TrustedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("tls_fingerprint.trusted_cidrs: %w", err)
+		}
+	}
+	if m := c.Federation.Mesh; m.Enabled {
+		if m.Port == "" || m.CertFile == "" || m.KeyFile == "" || m.CAFile == "" {
+			return errors.New("federation.mesh: port, cert_file, key_file and ca_file are required")
+		}
+		if !strings.HasPrefix(m.SPIFFEID, "spiffe://") || len(m.PeerIDs) == 0 {
+			return errors.New("federation.mesh: spiffe_id and at least one of peer_ids are required")
+		}
+		for _, id := range m.PeerIDs {
+			if !strings.HasPrefix(id, "spiffe://") {
+				return fmt.Errorf("federation.mesh.peer_ids: %q is not a SPIFFE ID", id)
+			}
+		}
+		if c.Federation.Enabled && !strings.HasPrefix(c.Federation.PeerURL, "https://") {
+			return errors.New("federation.mesh needs an https peer_url")
+		}
+	}
+	if d := c.DarkTraffic; d.Enabled && (d.MaxRPS <= 0 || d.MaxRequests <= 0) {
+		return errors.New("dark_traffic: max_rps and max_requests must be positive")
+	}
+	if r := c.ResponseCache; r.MaxMemoryBytes <= 0 || r.LocalTTL <= 0 {
+		return errors.New("response_cache: max_memory_bytes and local_ttl must be positive")
+	}
+	if r := c.RetryBudget; r.Percent < 0 || r.MinPerSecond < 0 || r.Window < time.Second {
+		return errors.New("retry_budget: percent and min_per_second must not be negative, window must be at least 1s")
+	}
+	products := make(map[string]bool)
+	productRoutes := make(map[string]string)
+	for _, p := range c.Products {
+		switch {
+		case p.Name == "":
+			return errors.New("products: every product needs a name")
+		case products[p.Name]:
+			return fmt.Errorf("products: %q is defined more than once", p.Name)
+		case len(p.Routes) == 0:
+			return fmt.Errorf("products.%s: routes are required", p.Name)
+		case p.Quota < 0 || p.QuotaPeriod < 0:
+			return fmt.Errorf("products.%s: quota and quota_period must not be negative", p.Name)
+		}
+		products[p.Name] = true
+		for _, route := range p.Routes {
+			if other, ok := productRoutes[route]; ok {
+				return fmt.Errorf("products.%s: route %q already belongs to product %s", p.Name, route, other)
+			}
+			productRoutes[route] = p.Name
+		}
+	}
+	for _, name := range []string{"auth", "transfer", "default"} {
+		if _, ok := c.RateLimits.Policies[name]; !ok {
+			return fmt.Errorf("rate_limits.policies.%s is required", name)
+		}
+	}
+	for name, p := range c.RateLimits.Policies {
+		switch {
+		case name == "none":
+			return errors.New(`rate_limits.policies: "none" is reserved for unlimited routes`)
+		case p.Limit <= 0 || p.Window <= 0:
+			return fmt.Errorf("rate_limits.policies.%s: limit and window must be positive", name)
+		case p.Key != "ip" && p.Key != "user":
+			return fmt.Errorf("rate_limits.policies.%s: key must be \"ip\" or \"user\", got %q", name, p.Key)
+		case p.Strategy != "" && p.Strategy != "fixed_window" && p.Strategy != "token_bucket":
+			return fmt.Errorf("rate_limits.policies.%s: strategy must be fixed_window or token_bucket, got %q", name, p.Strategy)
+		case p.Strategy == "token_bucket" && p.Burst < 1:
+			return fmt.Errorf("rate_limits.policies.%s: token_bucket needs a burst of at least 1", name)
+		}
+	}
+	if r := c.RateLimits.KeyRotation; r != 0 && r < time.Second {
+		return errors.New("rate_limits.key_rotation must be at least 1s, or 0 to never rotate")
+	}
+	// Rate limit counters, concurrency leases and cached responses are all
+	// keyed in Redis by an HMAC under this secret
+	if c.Redis.Address != "" && len(c.RateLimits.KeySecret) < 16 {
+		return errors.New("rate_limits.key_secret must be at least 16 bytes when Redis is configured")
+	}
+	if b := c.Security.TransformBudget; b.WallTime < 0 || b.ProcessingTime < 0 || b.MaxAllocBytes < 0 {
+		return errors.New("security.transform_budget limits must not be negative")
+	}
+	switch c.Store.Driver {
+	case "":
+	case "postgres":
+		if c.Store.DSN == "" {
+			return errors.New("store.dsn is required for the postgres driver")
+		}
+	default:
+		return fmt.Errorf("store.driver must be postgres or empty, got %q", c.Store.Driver)
+	}
+	if r := c.Redis; r.DialTimeout < 0 || r.ReadTimeout < 0 || r.WriteTimeout < 0 {
+		return errors.New("redis: dial_timeout, read_timeout and write_timeout must not be negative")
+	}
+	if r := c.Redis; (r.ClientCert == "") != (r.ClientKey == "") {
+		return errors.New("redis.client_cert and redis.client_key must be set together")
+	} else if !r.TLS && (r.CACert != "" || r.ClientCert != "" || r.ServerName != "") {
+		return errors.New("redis.ca_cert, client_cert and server_name need redis.tls")
+	}
+	if n := len(c.Redis.LockAddresses); n > 0 && n < 3 {
+		return errors.New("redis.lock_addresses needs at least three instances for a majority to survive a failure")
+	}
+	if r := c.Redis; r.EnforceKeyTTL && r.KeyPrefix == "" {
+		return errors.New("redis.enforce_key_ttl needs redis.key_prefix")
+	}
+	if a := c.Admin.Approvals; a.Enabled {
+		if a.TTL <= 0 {
+			return errors.New("admin.approvals.ttl must be positive")
+		}
+		if len(c.Admin.Operators) < 2 && !c.Admin.SSO.Enabled {
+			return errors.New("admin.approvals needs admin.sso or at least two admin.operators")
+		}
+		for _, op := range a.Operations {
+			if method, path, ok := strings.Cut(op, " "); !ok || method == "" || !strings.HasPrefix(path, "/admin/") {
+				return fmt.Errorf("admin.approvals.operations: %q is not \"METHOD /admin/path\"", op)
+			}
+		}
+	}
+	if r := c.Admin.SharedKeyRole; r != "" && !isAdminRole(r) {
+		return fmt.Errorf("admin.shared_key_role: unknown role %q", r)
+	}
+	for name, op := range c.Admin.Operators {
+		if op.APIKey == "" {
+			return fmt.Errorf("admin.operators.%s.api_key is required", name)
+		}
+		if op.Role == "" {
+			return fmt.Errorf("admin.operators.%s.role is required", name)
+		}
+		if !isAdminRole(op.Role) {
+			return fmt.Errorf("admin.operators.%s.role: unknown role %q", name, op.Role)
+		}
+	}
+	if sso := c.Admin.SSO; sso.Enabled {
+		if sso.Issuer.Issuer == "" || sso.Issuer.Audience == "" {
+			return errors.New("admin.sso.issuer needs an issuer and an audience")
+		}
+		if sso.MaxLifetime <= 0 {
+			return errors.New("admin.sso.max_lifetime must be positive")
+		}
+		for from, to := range sso.RoleMapping {
+			if !isAdminRole(to) {
+				return fmt.Errorf("admin.sso.role_mapping.%s: unknown role %q", from, to)
+			}
+		}
+	}
+	for _, cidr := range c.Admin.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("admin.allowed_cidrs: %w", err)
+		}
+	}
+	for _, er := range c.Admin.EndpointRoles {
+		if !isAdminRole(er.Role) {
+			return fmt.Errorf("admin.endpoint_roles: unknown role %q for %q", er.Role, er.Operation)
+		}
+	}
+	if c.Shield.RPS <= 0 || c.Shield.Burst < 1 {
+		return errors.New("shield.rps must be positive and shield.burst at least 1")
+	}
+	if c.Registry.Enabled {
+		if c.Admin.APIKey == "" && len(c.Admin.Operators) == 0 && !c.Admin.SSO.Enabled {
+			return errors.New("registry needs admin credentials (admin.api_key, operators or sso)")
+		}
+		if c.Registry.Port == "" || c.Registry.Port == c.Server.Port {
+			return errors.New("registry.port must be set and differ from server.port")
+		}
+	}
+	if err := c.Server.TLS.validate(c.Server); err != nil {
+		return err
+	}
+	if err := c.Server.ACME.validate(c.Server); err != nil {
+		return err
+	}
+	if err := c.Retention.validate(); err != nil {
+		return err
+	}
+	if err := c.Branding.validate(); err != nil {
+		return err
+	}
+	if c.ErrorPages.Enabled && !c.Branding.Enabled {
+		return errors.New("error_pages needs branding to be enabled")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	return nil
+}
+
+// validAppVersion reports whether v is a dotted numeric version like 5.2.1.
+func validAppVersion(v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, part := range strings.Split(v, ".") {
+		if _, err := strconv.Atoi(part); err != nil || strings.HasPrefix(part, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+func isAdminRole(role string) bool {
+	return role == RoleViewer || role == RoleOperator || role == RoleSecurityAdmin
+}
+
+// validHashOn reports whether s is a consistent_hash key source.
+func validHashOn(s string) bool {
+	switch kind, name, _ := strings.Cut(s, ":"); kind {
+	case "", "user", "session", "ip":
+		return name == ""
+	case "header", "cookie":
+		return name != ""
+	}
+	return false
+}
+
+// validate checks the check type, status patterns and json_expect
+// predicates.
+func (h HealthCheckConfig) validate() error {
+	switch h.Type {
+	case "", "tcp", "http", "json", "grpc":
+	default:
+		return fmt.Errorf("type must be tcp, http, json or grpc, got %q", h.Type)
+	}
+	for _, s := range h.Statuses {
+		if !validStatusPattern(s) {
+			return fmt.Errorf("statuses: %q is not a status code or class such as 2xx", s)
+		}
+	}
+	for _, e := range h.JSONExpect {
+		if field, _, ok := strings.Cut(e, "="); !ok || field == "" {
+			return fmt.Errorf("json_expect: %q is not field=value", e)
+		}
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.Rise < 0 || h.Fall < 0 {
+		return errors.New("interval, timeout, rise and fall must not be negative")
+	}
+	return nil
+}
+
+// validStatusPattern reports whether s is an HTTP status code ("503") or
+// class ("5xx").
+func validStatusPattern(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	if s[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// validate checks the CA, domains and cache, and that the HTTPS listener is
+// on.
+func (a ACMEConfig) validate(server ServerConfig) error {
+	if !a.Enabled {
+		return nil
+	}
+	if !server.TLS.Enabled {
+		return errors.New("server.acme needs server.tls to be enabled")
+	}
+	if !a.AcceptTerms {
+		return errors.New("server.acme.accept_terms must be set to agree to the CA's terms of service")
+	}
+	if len(a.Domains) == 0 {
+		return errors.New("server.acme.domains is required")
+	}
+	for _, d := range a.Domains {
+		if d == "" || strings.ContainsAny(d, "*:/ ") || net.ParseIP(d) != nil {
+			return fmt.Errorf("server.acme.domains: %q is not a DNS name (wildcards need DNS-01, which is not supported)", d)
+		}
+	}
+	if u, err := url.Parse(a.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("server.acme.directory_url: %q is not an https URL", a.DirectoryURL)
+	}
+	if a.CacheDir == "" {
+		return errors.New("server.acme.cache_dir is required")
+	}
+	if a.RenewBefore < 0 {
+		return errors.New("server.acme.renew_before must not be negative")
+	}
+	return nil
+}
+
+// validate checks the files are set, the version and suites are known, and
+// the listener can serve them.
+func (t ServerTLSConfig) validate(server ServerConfig) error {
+	if !t.Enabled {
+		return nil
+	}
+	switch {
+	case server.ACME.Enabled && (t.CertFile != "" || t.KeyFile != ""):
+		return errors.New("server.tls.cert_file and key_file cannot be combined with server.acme")
+	case !server.ACME.Enabled && (t.CertFile == "" || t.KeyFile == ""):
+		return errors.New("server.tls needs cert_file and key_file, or server.acme")
+	}
+	if server.H2C {
+		return errors.New("server.h2c serves HTTP/2 without TLS and cannot be combined with server.tls")
+	}
+	switch t.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		if len(t.CipherSuites) > 0 {
+			return errors.New("server.tls.cipher_suites apply to TLS 1.2 and need min_version 1.2")
+		}
+	default:
+		return fmt.Errorf("server.tls.min_version must be 1.2 or 1.3, got %q", t.MinVersion)
+	}
+	for _, name := range t.CipherSuites {
+		if !slices.ContainsFunc(tls.CipherSuites(), func(cs *tls.CipherSuite) bool {
+			return cs.Name == name && slices.Contains(cs.SupportedVersions, tls.VersionTLS12)
+		}) {
+			return fmt.Errorf("server.tls.cipher_suites: %q is not a secure TLS 1.2 cipher suite", name)
+		}
+	}
+	if t.RedirectPort != "" && t.RedirectPort == server.Port {
+		return errors.New("server.tls.redirect_port must differ from server.port")
+	}
+	return nil
+}
+
+// validate checks policy categories and that redaction comes before removal.
+func (c RetentionConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval <= 0 {
+		return errors.New("retention.interval must be positive")
+	}
+	for category, p := range c.Policies {
+		switch category {
+		case RetentionAccessEvents, RetentionAuditEvents, RetentionErrorEvents, RetentionRequestLogs, RetentionBodySpill:
+		default:
+			return fmt.Errorf("retention.policies: unknown category %q", category)
+		}
+		switch {
+		case p.MaxAge < 0 || p.RedactAfter < 0:
+			return fmt.Errorf("retention.policies.%s: max_age and redact_after must not be negative", category)
+		case p.MaxAge == 0 && p.RedactAfter == 0:
+			return fmt.Errorf("retention.policies.%s: needs max_age or redact_after", category)
+		case (p.RedactAfter > 0) != (len(p.RedactFields) > 0):
+			return fmt.Errorf("retention.policies.%s: redact_after and redact_fields must be set together", category)
+		case p.RedactAfter > 0 && category == RetentionBodySpill:
+			return fmt.Errorf("retention.policies.%s: spill files are removed, not redacted", category)
+		case p.RedactAfter > 0 && p.MaxAge > 0 && p.RedactAfter >= p.MaxAge:
+			return fmt.Errorf("retention.policies.%s: redact_after must be shorter than max_age", category)
+		}
+	}
+	return nil
+}
+
+// validate checks that the default brand exists, webhooks are absolute URLs
+// and every message is a parsable template for an error status.
+func (c BrandingConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, ok := c.Brands[c.Default]; c.Default != "" && !ok {
+		return fmt.Errorf("branding.default: no brand named %q", c.Default)
+	}
+	for name, brand := range c.Brands {
+		for locale, messages := range brand.Messages {
+			for status, text := range messages {
+				if code, err := strconv.Atoi(status); err != nil || code < 400 || code > 599 {
+					return fmt.Errorf("branding.brands.%s.messages.%s: %q is not an error status", name, locale, status)
+				}
+				if _, err := template.New(status).Parse(text); err != nil {
+					return fmt.Errorf("branding.brands.%s.messages.%s.%s: %w", name, locale, status, err)
+				}
+			}
+		}
+		for _, hook := range brand.Webhooks {
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("branding.brands.%s.webhooks: %q is not an absolute URL", name, hook.URL)
+			}
+		}
+	}
+	return nil
+}
+
+// Load reads, overrides and validates the configuration. Each call works on
+// its own viper instance, so loads may run concurrently.
 func Load() (*Config, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("../config")
-
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.AutomaticEnv()
-
-	viper.SetDefault("server.port", "8080")
-	viper.SetDefault("server.read_timeout", 10*time.Second)
-	viper.SetDefault("server.write_timeout", 10*time.Second)
-	viper.SetDefault("security.token_expiration", 1*time.Hour)
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+	v := newViper()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	v.SetDefault("server.port", "8080")
+	v.SetDefault("server.read_timeout", 10*time.Second)
+	v.SetDefault("server.write_timeout", 10*time.Second)
+	v.SetDefault("server.shutdown_delay", 5*time.Second)
+	v.SetDefault("server.shutdown_timeout", 30*time.Second)
+	v.SetDefault("server.warmup.timeout", 30*time.Second)
+	v.SetDefault("server.acme.directory_url", "https://acme-v02.api.letsencrypt.org/directory")
+	v.SetDefault("server.acme.renew_before", 720*time.Hour)
+	v.SetDefault("server.warmup.connections", 2)
+	v.SetDefault("server.problem_base_url", "https://developer.banking.example/problems/")
+	v.SetDefault("security.token_expiration", 1*time.Hour)
+	v.SetDefault("redis.default_key_ttl", 24*time.Hour)
+	v.SetDefault("redis.scan_interval", 5*time.Minute)
+	v.SetDefault("redis.scan_max_keys", 50000)
+	v.SetDefault("response_cache.max_memory_bytes", 64<<20)
+	v.SetDefault("response_cache.local_ttl", 5*time.Second)
+	v.SetDefault("admin.sso.subject_claim", "sub")
+	v.SetDefault("admin.sso.roles_claim", "roles")
+	v.SetDefault("admin.sso.max_lifetime", time.Hour)
+	v.SetDefault("admin.approvals.ttl", 15*time.Minute)
+	v.SetDefault("admin.approvals.operations", []string{"PUT /admin/readonly/global", "DELETE /admin/readonly/global", "PUT /admin/shield", "DELETE /admin/shield", "DELETE /admin/ratelimits"})
+	v.SetDefault("traffic.retention", 15*time.Minute)
+	v.SetDefault("traffic.top_k", 20)
+	v.SetDefault("rate_limits.soft_limit_ratio", 0.8)
+	v.SetDefault("rate_limits.grace_min_client_age", 30*24*time.Hour)
+	v.SetDefault("rate_limits.key_rotation", 24*time.Hour)
+	v.SetDefault("rate_limits.policies.auth.limit", 5)
+	v.SetDefault("rate_limits.policies.auth.window", time.Minute)
+	v.SetDefault("rate_limits.policies.auth.key", "ip")
+	v.SetDefault("rate_limits.policies.transfer.limit", 100)
+	v.SetDefault("rate_limits.policies.transfer.window", time.Hour)
+	v.SetDefault("rate_limits.policies.transfer.key", "user")
+	v.SetDefault("rate_limits.policies.default.limit", 1000)
+	v.SetDefault("rate_limits.policies.default.window", time.Hour)
+	v.SetDefault("rate_limits.policies.default.key", "user")
+	v.SetDefault("analytics.flush_interval", 1*time.Minute)
+	v.SetDefault("analytics.retention", 90*24*time.Hour)
+	v.SetDefault("status.interval", 15*time.Second)
+	v.SetDefault("inspection.buffer_size", 500)
+	v.SetDefault("concurrency.lease_ttl", 2*time.Minute)
+	v.SetDefault("websocket.per_user", 5)
+	v.SetDefault("websocket.lease_ttl", time.Minute)
+	v.SetDefault("shield.rps", 2000)
+	v.SetDefault("shield.burst", 500)
+	v.SetDefault("federation.gateway_id", "banking-api-gateway")
+	v.SetDefault("federation.paths", []string{"/api/"})
+	v.SetDefault("federation.timeout", 30*time.Second)
+	v.SetDefault("deadline.max_budget", 30*time.Second)
+	v.SetDefault("store.max_conns", 10)
+	v.SetDefault("store.migrate", true)
+	v.SetDefault("store.cache_ttl", 5*time.Minute)
+	v.SetDefault("error_pages.default_locale", "en")
+	v.SetDefault("tracing.endpoint", "http://localhost:4318/v1/traces")
+	v.SetDefault("tracing.sample_ratio", 0.1)
+	v.SetDefault("tracing.service_name", "api-gateway")
+	v.SetDefault("signing.algorithm", "ES256")
+	v.SetDefault("signing.kms.endpoint", "https://cloudkms.googleapis.com")
+	v.SetDefault("signing.kms.timeout", 5*time.Second)
+	v.SetDefault("retry_after.base", 1*time.Second)
+	v.SetDefault("retry_after.max", 5*time.Minute)
+	v.SetDefault("retry_budget.percent", 20)
+	v.SetDefault("retry_budget.min_per_second", 3)
+	v.SetDefault("retry_budget.window", 10*time.Second)
+	v.SetDefault("body_buffer.memory_limit", 64<<10)
+	v.SetDefault("body_buffer.memory_budget", 64<<20)
+	v.SetDefault("body_buffer.max_size", 2<<20)
+	v.SetDefault("clock.ntp_servers", []string{"pool.ntp.org"})
+	v.SetDefault("clock.check_interval", 10*time.Minute)
+	v.SetDefault("clock.timeout", 2*time.Second)
+	v.SetDefault("clock.max_drift", 2*time.Second)
+	v.SetDefault("clock.fail_mode", "open")
+	v.SetDefault("events.streams", []string{"access", "audit", "error"})
+	v.SetDefault("events.max_len", 100000)
+	v.SetDefault("events.retention", 72*time.Hour)
+	v.SetDefault("request_log.retention", 24*time.Hour)
+	v.SetDefault("request_log.max_events", 200)
+	v.SetDefault("retention.interval", 15*time.Minute)
+	v.SetDefault("xds.node_id", "api-gateway")
+	v.SetDefault("xds.cluster", "banking-gateway")
+	v.SetDefault("xds.refresh_interval", 15*time.Second)
+	v.SetDefault("registry.port", "9091")
+	v.SetDefault("kubernetes.annotation_prefix", "gateway.banking.io")
+	v.SetDefault("kubernetes.resync_interval", 5*time.Minute)
+	v.SetDefault("kubernetes.token_file", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+	v.SetDefault("kubernetes.ca_file", "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+	v.SetDefault("client_policy.user_agent_pattern", `(?i)BankingApp/(?P<version>[0-9.]+) \((?P<platform>iOS|Android)`)
+	v.SetDefault("dark_traffic.max_rps", 20)
+	v.SetDefault("dark_traffic.max_requests", 10000)
+	v.SetDefault("consul.address", "http://127.0.0.1:8500")
+	v.SetDefault("consul.wait_time", 5*time.Minute)
+	v.SetDefault("cors.allow_methods", []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE"})
+	v.SetDefault("cors.max_age", 600)
+	v.SetDefault("security.impersonation.max_chain_depth", 1)
+	v.SetDefault("security.route_lint.mode", "fail")
+	v.SetDefault("security.route_lint.public_routes", []string{"/api/auth/*"})
+	v.SetDefault("security.pci_guard.action", "block")
+	v.SetDefault("security.revocation.channel", "auth:revocations")
+	v.SetDefault("security.revocation.cache_ttl", 30*time.Second)
+	v.SetDefault("security.revocation.export.interval", time.Minute)
+	v.SetDefault("security.revocation.export.allowed_cidrs", []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.1/32"})
+	v.SetDefault("security.json_limits.max_depth", 32)
+	v.SetDefault("security.json_limits.max_keys", 1000)
+	v.SetDefault("security.json_limits.max_array_length", 10000)
+	v.SetDefault("security.json_limits.max_string_length", 65536)
+	v.SetDefault("security.transform_budget.enabled", true)
+	v.SetDefault("security.transform_budget.wall_time", 100*time.Millisecond)
+	v.SetDefault("security.transform_budget.processing_time", 250*time.Millisecond)
+	v.SetDefault("security.transform_budget.max_alloc_bytes", 64<<20)
+
+	keyFile := os.Getenv(envConfigPublicKey)
+	switch {
+	case keyFile != "" && os.Getenv(EnvPrefix+"_CONFIG_MODE") == "env":
+		return nil, fmt.Errorf("%s requires a signed config file; %s_CONFIG_MODE=env is not allowed", envConfigPublicKey, EnvPrefix)
+	case keyFile != "":
+		if err := readSignedConfig(v, keyFile); err != nil {
 			return nil, err
 		}
+		if names := nonSecretOverrides(os.Environ()); len(names) > 0 {
+			return nil, &UnsignedOverrideError{Variables: names}
+		}
+	case os.Getenv(EnvPrefix+"_CONFIG_MODE") != "env":
+		if err := v.ReadInConfig(); err != nil {
+			if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+				return nil, err
+			}
+		}
+	}
+
+	overrides, ignored := envOverrides(os.Environ())
+	if err := v.MergeConfigMap(overrides); err != nil {
+		return nil, err
 	}
 
 	var cfg Config
-	if err := viper.Unmarshal(&cfg); err != nil {
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	cfg.IgnoredEnv = ignored
+	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
+
+// newViper returns a viper instance looking for config.yaml in the working
+// directory and the config directories next to it.
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
+	v.AddConfigPath("../config")
+	return v
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// secretTag marks config fields whose values must never be logged, e.g.
+// `secret:"true"`; everything under a marked field is redacted.
+const secretTag = "secret"
+
+// Change is a single leaf-level difference between two configurations.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Diff returns the leaf-level differences between two configurations, keyed by
+// their mapstructure paths, with secret values redacted.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*new), false, &changes)
+	return changes
+}
+
+func diffValue(path string, a, b reflect.Value, secret bool, changes *[]Change) {
+	switch a.Kind() {
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := t.Field(i).Tag.Get("mapstructure")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+			diffValue(join(path, name), a.Field(i), b.Field(i), secret || t.Field(i).Tag.Get(secretTag) == "true", changes)
+		}
+
+	case reflect.Ptr:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil():
+			diffValue(path, reflect.Zero(b.Elem().Type()), b.Elem(), secret, changes)
+		case b.IsNil():
+			diffValue(path, a.Elem(), reflect.Zero(a.Elem().Type()), secret, changes)
+		default:
+			diffValue(path, a.Elem(), b.Elem(), secret, changes)
+		}
+
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range a.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for _, k := range b.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		zero := reflect.Zero(a.Type().Elem())
+		for _, name := range names {
+			av, bv := a.MapIndex(keys[name]), b.MapIndex(keys[name])
+			if !av.IsValid() {
+				av = zero
+			}
+			if !bv.IsValid() {
+				bv = zero
+			}
+			diffValue(join(path, name), av, bv, secret, changes)
+		}
+
+	case reflect.Slice:
+		// Slices of structs are compared element by element; scalar slices
+		// are treated as a single value.
+		if a.Type().Elem().Kind() != reflect.Struct {
+			diffLeaf(path, a, b, secret, changes)
+			return
+		}
+		zero := reflect.Zero(a.Type().Elem())
+		for i := 0; i < max(a.Len(), b.Len()); i++ {
+			av, bv := zero, zero
+			if i < a.Len() {
+				av = a.Index(i)
+			}
+			if i < b.Len() {
+				bv = b.Index(i)
+			}
+			diffValue(fmt.Sprintf("%s[%d]", path, i), av, bv, secret, changes)
+		}
+
+	default:
+		diffLeaf(path, a, b, secret, changes)
+	}
+}
+
+func diffLeaf(path string, a, b reflect.Value, secret bool, changes *[]Change) {
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return
+	}
+	c := Change{Path: path, Old: a.Interface(), New: b.Interface()}
+	if secret {
+		c.Old, c.New = redacted, redacted
+	}
+	*changes = append(*changes, c)
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes environment variables that override any config key.
+//
+// Every field is reachable by upper-casing its path and joining segments with
+// underscores:
+//
+//	GATEWAY_SERVER_PORT=8080
+//	GATEWAY_SERVICES_USER_SERVICE_URL=http://user-service:8082
+//	GATEWAY_ROUTES_0_PATH=/api/transfers/*
+//	GATEWAY_CORS_ALLOW_ORIGINS=https://a.example,https://b.example
+//
+// Map keys (such as service names) are lower-cased with underscores turned
+// into hyphens, list entries are addressed by index, and scalar lists are
+// comma separated. Setting GATEWAY_CONFIG_MODE=env skips the config file
+// entirely so the gateway can run from the environment alone.
+const EnvPrefix = "GATEWAY"
+
+// envOverrides converts prefixed environment variables into a nested map
+// suitable for viper.MergeConfigMap. Variables matching no config key are
+// returned as ignored: the prefix is shared with variables the gateway does
+// not own, such as Kubernetes service links for a Service named gateway.
+func envOverrides(environ []string) (overrides map[string]interface{}, ignored []string) {
+	root := make(map[string]interface{})
+	configType := reflect.TypeOf(Config{})
+
+	for _, kv := range environ {
+		name, value, ok := configEnv(kv)
+		if !ok {
+			continue
+		}
+
+		segments := strings.Split(strings.TrimPrefix(name, EnvPrefix+"_"), "_")
+		path, ok := resolveEnvPath(configType, segments)
+		if !ok {
+			ignored = append(ignored, name)
+			continue
+		}
+		setNested(root, path, value)
+	}
+
+	sort.Strings(ignored)
+	return listify(root).(map[string]interface{}), ignored
+}
+
+// nonSecretOverrides returns the prefixed variables that override a config
+// key not tagged secret. With a signed config file only secrets may come
+// from the environment.
+func nonSecretOverrides(environ []string) []string {
+	configType := reflect.TypeOf(Config{})
+
+	var names []string
+	for _, kv := range environ {
+		name, _, ok := configEnv(kv)
+		if !ok {
+			continue
+		}
+		segments := strings.Split(strings.TrimPrefix(name, EnvPrefix+"_"), "_")
+		if path, ok := resolveEnvPath(configType, segments); ok && !secretPath(configType, path) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configEnv splits a prefixed variable that may override config, skipping
+// those selecting how config is loaded.
+func configEnv(kv string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(kv, "=")
+	if !ok || !strings.HasPrefix(name, EnvPrefix+"_") {
+		return "", "", false
+	}
+	switch name {
+	case EnvPrefix + "_CONFIG_MODE", envConfigPublicKey, envConfigSignature:
+		return "", "", false
+	}
+	return name, value, true
+}
+
+// secretPath reports whether the key path lies in or under a field tagged
+// secret.
+func secretPath(t reflect.Type, path []string) bool {
+	for _, p := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := fieldByTag(t, p)
+			if !ok {
+				return false
+			}
+			if field.Tag.Get(secretTag) == "true" {
+				return true
+			}
+			t = field.Type
+		case reflect.Map, reflect.Slice:
+			t = t.Elem()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func fieldByTag(t reflect.Type, tag string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("mapstructure") == tag {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// resolveEnvPath maps upper-case env segments onto the config key path for t.
+func resolveEnvPath(t reflect.Type, segments []string) ([]string, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			for n := 1; n <= len(segments); n++ {
+				if strings.ToLower(strings.Join(segments[:n], "_")) != tag {
+					continue
+				}
+				if rest, ok := resolveEnvPath(t.Field(i).Type, segments[n:]); ok {
+					return append([]string{tag}, rest...), true
+				}
+			}
+		}
+		return nil, false
+
+	case reflect.Map:
+		for n := 1; n <= len(segments); n++ {
+			key := strings.ToLower(strings.Join(segments[:n], "-"))
+			if rest, ok := resolveEnvPath(t.Elem(), segments[n:]); ok {
+				return append([]string{key}, rest...), true
+			}
+		}
+		return nil, false
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Struct && len(segments) > 0 {
+			if _, err := strconv.Atoi(segments[0]); err != nil {
+				return nil, false
+			}
+			rest, ok := resolveEnvPath(t.Elem(), segments[1:])
+			if !ok {
+				return nil, false
+			}
+			return append([]string{segments[0]}, rest...), true
+		}
+		return nil, len(segments) == 0
+
+	default:
+		return nil, len(segments) == 0
+	}
+}
+
+func setNested(m map[string]interface{}, path []string, value string) {
+	for _, p := range path[:len(path)-1] {
+		child, ok := m[p].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[p] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+// listify turns maps whose keys are all indexes into slices, so indexed env
+// vars decode into lists of structs.
+func listify(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	maxIdx := -1
+	for k, child := range m {
+		m[k] = listify(child)
+		idx, err := strconv.Atoi(k)
+		if err != nil {
+			maxIdx = -2
+		} else if maxIdx != -2 && idx > maxIdx {
+			maxIdx = idx
+		}
+	}
+	if maxIdx < 0 {
+		return m
+	}
+
+	list := make([]interface{}, maxIdx+1)
+	for k, child := range m {
+		idx, _ := strconv.Atoi(k)
+		list[idx] = child
+	}
+	for i := range list {
+		if list[i] == nil {
+			list[i] = map[string]interface{}{}
+		}
+	}
+	return list
+}
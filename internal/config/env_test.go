@@ -0,0 +1,131 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// envLeaves returns the config key path of every scalar field reachable
+// from t, addressing map entries as "primary" and list entries as 0.
+func envLeaves(t reflect.Type, path []string, seen map[reflect.Type]bool) [][]string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if seen[t] {
+			return nil
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		var leaves [][]string
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			leaves = append(leaves, envLeaves(t.Field(i).Type, append(path[:len(path):len(path)], tag), seen)...)
+		}
+		return leaves
+	case reflect.Map:
+		return envLeaves(t.Elem(), append(path[:len(path):len(path)], "primary"), seen)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Struct || t.Elem().Kind() == reflect.Ptr {
+			return envLeaves(t.Elem(), append(path[:len(path):len(path)], "0"), seen)
+		}
+	}
+	return [][]string{path}
+}
+
+func envName(path []string) string {
+	name := strings.ToUpper(strings.Join(path, "_"))
+	return EnvPrefix + "_" + strings.ReplaceAll(name, "-", "_")
+}
+
+// lookupOverride follows path through the nested maps and lists built by
+// envOverrides.
+func lookupOverride(v interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[p]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func TestEveryConfigFieldIsReachableFromEnv(t *testing.T) {
+	leaves := envLeaves(reflect.TypeOf(Config{}), nil, make(map[reflect.Type]bool))
+	if len(leaves) == 0 {
+		t.Fatal("no config fields found")
+	}
+	for _, path := range leaves {
+		name := envName(path)
+		overrides, ignored := envOverrides([]string{name + "=value"})
+		if len(ignored) > 0 {
+			t.Errorf("%s does not match %s", name, strings.Join(path, "."))
+			continue
+		}
+		if got, ok := lookupOverride(overrides, path); !ok || got != "value" {
+			t.Errorf("%s sets a different key than %s: %v", name, strings.Join(path, "."), overrides)
+		}
+	}
+}
+
+func TestEnvOverridesMapKeysWithHyphens(t *testing.T) {
+	overrides, ignored := envOverrides([]string{"GATEWAY_SERVICES_USER_SERVICE_URL=http://user-service:8082"})
+	if len(ignored) > 0 {
+		t.Fatalf("ignored = %v", ignored)
+	}
+	if got, _ := lookupOverride(overrides, []string{"services", "user-service", "url"}); got != "http://user-service:8082" {
+		t.Errorf("services.user-service.url = %v, want http://user-service:8082", got)
+	}
+}
+
+func TestEnvOverridesSkipUnknownVariables(t *testing.T) {
+	overrides, ignored := envOverrides([]string{
+		"GATEWAY_PORT=tcp://10.0.0.1:8080",
+		"GATEWAY_SERVICE_HOST=10.0.0.1",
+		"GATEWAY_PORT_8080_TCP_ADDR=10.0.0.1",
+		"GATEWAY_SERVER_PORT=9090",
+		"GATEWAY_CONFIG_MODE=env",
+		"HOME=/root",
+	})
+	want := []string{"GATEWAY_PORT", "GATEWAY_PORT_8080_TCP_ADDR", "GATEWAY_SERVICE_HOST"}
+	if !reflect.DeepEqual(ignored, want) {
+		t.Errorf("ignored = %v, want %v", ignored, want)
+	}
+	if got, _ := lookupOverride(overrides, []string{"server", "port"}); got != "9090" {
+		t.Errorf("server.port = %v, want 9090", got)
+	}
+}
+
+func TestNonSecretOverrides(t *testing.T) {
+	got := nonSecretOverrides([]string{
+		"GATEWAY_REDIS_PASSWORD=hunter2",
+		"GATEWAY_RATE_LIMITS_KEY_SECRET=0123456789abcdef",
+		"GATEWAY_SERVER_PORT=9090",
+		"GATEWAY_SERVICES_USER_SERVICE_URL=http://evil.example",
+		"GATEWAY_CONFIG_PUBLIC_KEY=/etc/gateway/config.pub",
+		"GATEWAY_PORT=tcp://10.0.0.1:8080",
+	})
+	want := []string{"GATEWAY_SERVER_PORT", "GATEWAY_SERVICES_USER_SERVICE_URL"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nonSecretOverrides = %v, want %v", got, want)
+	}
+}
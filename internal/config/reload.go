@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// maxChangeRecords bounds the in-memory config change history.
+const maxChangeRecords = 50
+
+// ChangeRecord is the audit entry for one configuration reload. Changes are
+// the differences from the configuration the gateway is running.
+type ChangeRecord struct {
+	At      time.Time `json:"at"`
+	By      string    `json:"by"`
+	Source  string    `json:"source"`
+	Changes []Change  `json:"changes"`
+	// AppliesOn tells when the changes take effect: the running gateway
+	// keeps its startup configuration until it is restarted.
+	AppliesOn string `json:"applies_on"`
+}
+
+// Reloader re-reads configuration on demand or on file change, validates it
+// and records a redacted diff against the running configuration. Components
+// compile configuration at startup (routes, policies, clients), so a reload
+// does not change what the gateway serves: records and audit events report
+// the changes as staged for the next restart.
+type Reloader struct {
+	logger  *zap.Logger
+	audit   *zap.Logger
+	running *Config
+
+	mu      sync.Mutex
+	records []ChangeRecord
+}
+
+func NewReloader(running *Config, logger *zap.Logger) *Reloader {
+	return &Reloader{
+		logger:  logger,
+		audit:   logger.Named("audit"),
+		running: running,
+	}
+}
+
+// Reload reads and validates the configuration again and records how it
+// differs from the running one. by identifies the operator (or
+// "file-watch"), source how the reload started.
+func (r *Reloader) Reload(by, source string) (*ChangeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load()
+	if err != nil {
+		var sigErr *SignatureError
+		if errors.As(err, &sigErr) {
+			r.audit.Error("Configuration signature rejected",
+				zap.String("event", "config_signature_invalid"),
+				zap.String("file", sigErr.File),
+				zap.String("by", by),
+				zap.String("source", source),
+				zap.Error(sigErr.Err),
+			)
+		}
+		r.logger.Error("Configuration reload failed", zap.String("by", by), zap.Error(err))
+		return nil, err
+	}
+
+	record := ChangeRecord{
+		At:        time.Now().UTC(),
+		By:        by,
+		Source:    source,
+		Changes:   Diff(r.running, next),
+		AppliesOn: "restart",
+	}
+
+	r.records = append(r.records, record)
+	if len(r.records) > maxChangeRecords {
+		r.records = r.records[len(r.records)-maxChangeRecords:]
+	}
+
+	r.audit.Info("Configuration change staged for restart",
+		zap.String("event", "config_staged"),
+		zap.String("by", by),
+		zap.String("source", source),
+		zap.Time("at", record.At),
+		zap.Any("changes", record.Changes),
+	)
+	return &record, nil
+}
+
+// Records returns up to n of the most recent change records, newest first.
+func (r *Reloader) Records(n int) []ChangeRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 || n > len(r.records) {
+		n = len(r.records)
+	}
+	out := make([]ChangeRecord, 0, n)
+	for i := len(r.records) - 1; i >= 0 && len(out) < n; i-- {
+		out = append(out, r.records[i])
+	}
+	return out
+}
+
+// Watch reloads the configuration whenever the config file changes. The
+// watcher has a viper instance of its own; each reload loads afresh.
+func (r *Reloader) Watch() {
+	v := newViper()
+	if err := v.ReadInConfig(); err != nil {
+		r.logger.Warn("Not watching configuration, no config file found", zap.Error(err))
+		return
+	}
+	v.OnConfigChange(func(e fsnotify.Event) {
+		if _, err := r.Reload("file-watch", e.Name); err != nil {
+			r.logger.Warn("Ignoring invalid configuration change", zap.String("file", e.Name))
+		}
+	})
+	v.WatchConfig()
+}
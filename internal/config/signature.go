@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Signed configuration. When GATEWAY_CONFIG_PUBLIC_KEY names a PEM public
+// key (Ed25519, ECDSA or RSA), the config file must carry a detached
+// signature over its exact bytes, base64 or raw, in GATEWAY_CONFIG_SIGNATURE
+// (default: the config file path plus ".sig"). Startup and reloads fail when
+// it does not verify. Only secrets may then be overridden from the
+// environment, so they can be injected at deploy time; any other override
+// fails startup, since it would change what was signed.
+const (
+	envConfigPublicKey = EnvPrefix + "_CONFIG_PUBLIC_KEY"
+	envConfigSignature = EnvPrefix + "_CONFIG_SIGNATURE"
+)
+
+// SignatureError reports a configuration file whose detached signature is
+// missing or does not verify.
+type SignatureError struct {
+	File string
+	Err  error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("configuration signature check failed for %s: %v", e.File, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// UnsignedOverrideError reports environment variables overriding keys of a
+// signed configuration file that are not secrets.
+type UnsignedOverrideError struct {
+	Variables []string
+}
+
+func (e *UnsignedOverrideError) Error() string {
+	return fmt.Sprintf("configuration is signed; only secrets may be overridden from the environment, not by %s", strings.Join(e.Variables, ", "))
+}
+
+// readSignedConfig verifies the config file and loads the verified bytes,
+// so what is parsed is exactly what was signed.
+func readSignedConfig(v *viper.Viper, keyFile string) error {
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	file := v.ConfigFileUsed()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	sigFile := os.Getenv(envConfigSignature)
+	if sigFile == "" {
+		sigFile = file + ".sig"
+	}
+	if err := verifyDetached(keyFile, sigFile, data); err != nil {
+		return &SignatureError{File: file, Err: err}
+	}
+	return v.ReadConfig(bytes.NewReader(data))
+}
+
+func verifyDetached(keyFile, sigFile string, data []byte) error {
+	rawKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("read public key: %w", err)
+	}
+	block, _ := pem.Decode(rawKey)
+	if block == nil {
+		return errors.New("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse public key: %w", err)
+	}
+
+	sig, err := os.ReadFile(sigFile)
+	if err != nil {
+		return fmt.Errorf("read signature: %w", err)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	digest := sha256.Sum256(data)
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, data, sig) {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil || rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return errors.New("signature does not match")
+}
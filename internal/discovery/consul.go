@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"go.uber.org/zap"
+)
+
+// consulEntry is one instance in a Consul health query result.
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// ConsulResolver follows the instances of the Consul services behind
+// services with discovery "consul" through blocking health queries, keeping
+// those passing their checks as base URLs for the proxy.
+type ConsulResolver struct {
+	cfg     config.ConsulConfig
+	targets map[string]config.ConsulTarget // by gateway service
+	http    *http.Client
+	logger  *zap.Logger
+
+	mu        sync.RWMutex
+	endpoints map[string][]string // by gateway service
+}
+
+func NewConsulResolver(cfg *config.Config, logger *zap.Logger) (*ConsulResolver, error) {
+	tlsConfig := fips.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	if cfg.Consul.CAFile != "" {
+		pem, err := os.ReadFile(cfg.Consul.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("consul: read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("consul: no certificates in %s", cfg.Consul.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	targets := make(map[string]config.ConsulTarget)
+	for name, svc := range cfg.Services {
+		if svc.Discovery != "consul" {
+			continue
+		}
+		t := svc.Consul
+		if t.Datacenter == "" {
+			t.Datacenter = cfg.Consul.Datacenter
+		}
+		if t.Scheme == "" {
+			t.Scheme = "http"
+		}
+		targets[name] = t
+	}
+	return &ConsulResolver{
+		cfg:     cfg.Consul,
+		targets: targets,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		logger:    logger,
+		endpoints: make(map[string][]string),
+	}, nil
+}
+
+// Start watches every target until ctx is cancelled, backing off after
+// failed queries.
+func (r *ConsulResolver) Start(ctx context.Context) {
+	for name, target := range r.targets {
+		go func() {
+			var index uint64
+			backoff := time.Second
+			for ctx.Err() == nil {
+				next, err := r.query(ctx, name, target, index)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					r.logger.Warn("Consul service query failed",
+						zap.String("service", name),
+						zap.Error(err),
+						zap.Duration("retry_in", backoff),
+					)
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(backoff):
+					}
+					backoff = min(backoff*2, 30*time.Second)
+					continue
+				}
+				backoff = time.Second
+				// A lower index means the catalog was restored or the agent
+				// changed: start over rather than block on a stale index
+				if next < index {
+					next = 0
+				}
+				index = next
+			}
+		}()
+	}
+}
+
+// Endpoints returns the base URLs of a service's passing instances.
+func (r *ConsulResolver) Endpoints(service string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.endpoints[service]
+}
+
+// query runs one blocking health query, returning once the instances change
+// from index or the wait time passes, and publishes the result.
+func (r *ConsulResolver) query(ctx context.Context, name string, target config.ConsulTarget, index uint64) (uint64, error) {
+	q := url.Values{"passing": {"true"}}
+	if target.Tag != "" {
+		q.Set("tag", target.Tag)
+	}
+	if target.Datacenter != "" {
+		q.Set("dc", target.Datacenter)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.Itoa(int(r.cfg.WaitTime.Seconds()))+"s")
+	}
+	u := strings.TrimSuffix(r.cfg.Address, "/") + "/v1/health/service/" + url.PathEscape(target.Service) + "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	if r.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", r.cfg.Token)
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("consul: GET %s: %s: %s", target.Service, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return 0, fmt.Errorf("consul: decode %s: %w", target.Service, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	r.publish(name, target, entries)
+	return next, nil
+}
+
+// publish replaces a service's endpoints with the instances in entries.
+func (r *ConsulResolver) publish(name string, target config.ConsulTarget, entries []consulEntry) {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			// Instances registered without an address use their node's
+			addr = e.Node.Address
+		}
+		if addr == "" || e.Service.Port == 0 {
+			continue
+		}
+		u := target.Scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(e.Service.Port))
+		if !seen[u] {
+			seen[u] = true
+			endpoints = append(endpoints, u)
+		}
+	}
+	sort.Strings(endpoints)
+
+	r.mu.Lock()
+	prev, listed := r.endpoints[name]
+	changed := !listed || !slices.Equal(endpoints, prev)
+	r.endpoints[name] = endpoints
+	r.mu.Unlock()
+
+	switch {
+	case !changed:
+	case len(endpoints) == 0:
+		r.logger.Warn("Consul service has no passing instances",
+			zap.String("service", name),
+			zap.String("consul_service", target.Service),
+		)
+	default:
+		r.logger.Info("Consul instances updated",
+			zap.String("service", name),
+			zap.String("consul_service", target.Service),
+			zap.Int("passing", len(endpoints)),
+		)
+	}
+}
@@ -0,0 +1,284 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/routing"
+	"go.uber.org/zap"
+)
+
+// SourceKubernetes labels routes registered by the service controller.
+const SourceKubernetes = "kubernetes"
+
+type kubeService struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type kubeServiceList struct {
+	Metadata listMeta      `json:"metadata"`
+	Items    []kubeService `json:"items"`
+}
+
+// ServiceController watches Kubernetes Services and registers those carrying
+// a route-prefix annotation as gateway upstreams in the dynamic route table.
+type ServiceController struct {
+	cfg    config.KubernetesConfig
+	static map[string]config.Service
+	public []string
+	client *KubeClient
+	table  *routing.Table
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	services map[string]kubeService
+}
+
+func NewServiceController(cfg *config.Config, table *routing.Table, logger *zap.Logger) (*ServiceController, error) {
+	client, err := NewKubeClient(cfg.Kubernetes)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceController{
+		cfg:      cfg.Kubernetes,
+		static:   cfg.Services,
+		public:   cfg.Security.RouteLint.PublicRoutes,
+		client:   client,
+		table:    table,
+		logger:   logger,
+		services: make(map[string]kubeService),
+	}, nil
+}
+
+// Start lists and watches Services until ctx is cancelled, re-listing after
+// every resync interval or watch failure.
+func (sc *ServiceController) Start(ctx context.Context) {
+	go func() {
+		backoff := time.Second
+		for ctx.Err() == nil {
+			err := sc.sync(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				sc.logger.Warn("Kubernetes service watch failed", zap.Error(err), zap.Duration("retry_in", backoff))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(backoff*2, 30*time.Second)
+				continue
+			}
+			backoff = time.Second
+		}
+	}()
+}
+
+func (sc *ServiceController) servicesPath() string {
+	if sc.cfg.Namespace != "" {
+		return "/api/v1/namespaces/" + url.PathEscape(sc.cfg.Namespace) + "/services"
+	}
+	return "/api/v1/services"
+}
+
+func (sc *ServiceController) sync(ctx context.Context) error {
+	var list kubeServiceList
+	if err := sc.client.Get(ctx, sc.servicesPath(), &list); err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	sc.services = make(map[string]kubeService, len(list.Items))
+	for _, svc := range list.Items {
+		sc.services[svc.Metadata.Namespace+"/"+svc.Metadata.Name] = svc
+	}
+	sc.mu.Unlock()
+	sc.publish()
+
+	timeout := int(sc.cfg.ResyncInterval.Seconds())
+	if timeout <= 0 {
+		timeout = 300
+	}
+	path := fmt.Sprintf("%s?watch=1&allowWatchBookmarks=true&resourceVersion=%s&timeoutSeconds=%d",
+		sc.servicesPath(), url.QueryEscape(list.Metadata.ResourceVersion), timeout)
+
+	return sc.client.Watch(ctx, path, func(ev watchEvent) error {
+		if ev.Type == "BOOKMARK" {
+			return nil
+		}
+		var svc kubeService
+		if err := json.Unmarshal(ev.Object, &svc); err != nil {
+			return err
+		}
+		key := svc.Metadata.Namespace + "/" + svc.Metadata.Name
+
+		sc.mu.Lock()
+		if ev.Type == "DELETED" {
+			delete(sc.services, key)
+		} else {
+			sc.services[key] = svc
+		}
+		sc.mu.Unlock()
+		sc.publish()
+		return nil
+	})
+}
+
+// publish rebuilds the controller's routes from the current Service set.
+func (sc *ServiceController) publish() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var routes []routing.Route
+	prefixes := make(map[string]string)
+	for key, svc := range sc.services {
+		route, ok, err := sc.routeFor(svc)
+		if err != nil {
+			sc.logger.Warn("Ignoring Kubernetes service with invalid gateway annotations", zap.String("service", key), zap.Error(err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if owner, dup := prefixes[route.Prefix]; dup {
+			sc.logger.Warn("Duplicate gateway route prefix, ignoring service",
+				zap.String("prefix", route.Prefix), zap.String("service", key), zap.String("registered_by", owner))
+			continue
+		}
+		prefixes[route.Prefix] = key
+		routes = append(routes, route)
+	}
+
+	logConflicts(sc.logger, sc.table.Replace(SourceKubernetes, routes))
+	sc.logger.Info("Kubernetes routes updated", zap.Int("routes", len(routes)))
+}
+
+// logConflicts warns about routes that can never match after a sync.
+func logConflicts(logger *zap.Logger, conflicts []routing.Conflict) {
+	for _, c := range conflicts {
+		logger.Warn("Gateway route is shadowed and will never match",
+			zap.String("prefix", c.Prefix),
+			zap.String("source", c.Source),
+			zap.String("shadowed_by", c.ShadowedBy),
+			zap.String("shadowed_by_source", c.ShadowedBySource),
+		)
+	}
+}
+
+func (sc *ServiceController) annotation(svc kubeService, name string) string {
+	return strings.TrimSpace(svc.Metadata.Annotations[sc.cfg.AnnotationPrefix+"/"+name])
+}
+
+// routeFor translates a Service's annotations into a route. ok is false for
+// Services that are not exposed through the gateway.
+func (sc *ServiceController) routeFor(svc kubeService) (routing.Route, bool, error) {
+	prefix := sc.annotation(svc, "route-prefix")
+	if prefix == "" {
+		return routing.Route{}, false, nil
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(prefix, "/api/") || strings.ContainsAny(prefix, "*:?#") {
+		return routing.Route{}, false, fmt.Errorf("route-prefix %q must be a literal path under /api/", prefix)
+	}
+
+	name := svc.Metadata.Name + "." + svc.Metadata.Namespace
+	if _, clash := sc.static[name]; clash {
+		return routing.Route{}, false, fmt.Errorf("service name %q is already configured statically", name)
+	}
+
+	port, err := sc.port(svc)
+	if err != nil {
+		return routing.Route{}, false, err
+	}
+	scheme := sc.annotation(svc, "scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return routing.Route{}, false, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+
+	route := routing.Route{
+		Prefix: prefix,
+		Service: config.Service{
+			Name: name,
+			URL:  fmt.Sprintf("%s://%s.%s.svc:%d", scheme, svc.Metadata.Name, svc.Metadata.Namespace, port),
+		},
+		Auth:      routing.AuthRequired,
+		RateLimit: "default",
+	}
+
+	if auth := sc.annotation(svc, "auth"); auth != "" {
+		if auth != routing.AuthRequired && auth != routing.AuthPublic {
+			return routing.Route{}, false, fmt.Errorf("auth must be %q or %q", routing.AuthRequired, routing.AuthPublic)
+		}
+		// Held to the route linter's allowlist, as in the registry, so
+		// annotating a Service cannot open an auth bypass
+		if path := prefix + "/*"; auth == routing.AuthPublic && !slices.Contains(sc.public, path) {
+			return routing.Route{}, false, fmt.Errorf("%s is not listed in security.route_lint.public_routes", path)
+		}
+		route.Auth = auth
+	}
+	if limit := sc.annotation(svc, "rate-limit"); limit != "" {
+		switch limit {
+		case "auth", "transfer", "default", "none":
+			route.RateLimit = limit
+		default:
+			return routing.Route{}, false, fmt.Errorf("unknown rate-limit policy %q", limit)
+		}
+	}
+	if raw := sc.annotation(svc, "timeout"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			return routing.Route{}, false, fmt.Errorf("timeout: %w", err)
+		}
+		route.Service.Timeout = timeout
+	}
+	if raw := sc.annotation(svc, "priority"); raw != "" {
+		priority, err := strconv.Atoi(raw)
+		if err != nil {
+			return routing.Route{}, false, fmt.Errorf("priority: %w", err)
+		}
+		route.Priority = priority
+	}
+	if raw := sc.annotation(svc, "circuit-breaker"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return routing.Route{}, false, fmt.Errorf("circuit-breaker: %w", err)
+		}
+		route.Service.CircuitBreaker = enabled
+	}
+
+	return route, true, nil
+}
+
+func (sc *ServiceController) port(svc kubeService) (int, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return 0, fmt.Errorf("service has no ports")
+	}
+	want := sc.annotation(svc, "port")
+	if want == "" {
+		return svc.Spec.Ports[0].Port, nil
+	}
+	for _, p := range svc.Spec.Ports {
+		if p.Name == want || strconv.Itoa(p.Port) == want {
+			return p.Port, nil
+		}
+	}
+	return 0, fmt.Errorf("port %q not found on service", want)
+}
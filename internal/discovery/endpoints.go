@@ -0,0 +1,215 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+type endpointSlice struct {
+	Metadata  objectMeta `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			// Ready is unset when the controller does not know; treated as ready
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata listMeta        `json:"metadata"`
+	Items    []endpointSlice `json:"items"`
+}
+
+// EndpointResolver watches the EndpointSlices of the Kubernetes Services
+// behind services with discovery "kubernetes" and keeps their ready
+// endpoints as base URLs for the proxy.
+type EndpointResolver struct {
+	client  *KubeClient
+	resync  time.Duration
+	targets map[string]config.KubernetesTarget // by gateway service
+	logger  *zap.Logger
+
+	mu        sync.RWMutex
+	endpoints map[string][]string // by gateway service
+}
+
+func NewEndpointResolver(cfg *config.Config, logger *zap.Logger) (*EndpointResolver, error) {
+	client, err := NewKubeClient(cfg.Kubernetes)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]config.KubernetesTarget)
+	for name, svc := range cfg.Services {
+		if svc.Discovery != "kubernetes" {
+			continue
+		}
+		t := svc.Kubernetes
+		if t.Namespace == "" {
+			t.Namespace = cfg.Kubernetes.Namespace
+		}
+		if t.Namespace == "" {
+			t.Namespace = "default"
+		}
+		if t.Scheme == "" {
+			t.Scheme = "http"
+		}
+		targets[name] = t
+	}
+	return &EndpointResolver{
+		client:    client,
+		resync:    cfg.Kubernetes.ResyncInterval,
+		targets:   targets,
+		logger:    logger,
+		endpoints: make(map[string][]string),
+	}, nil
+}
+
+// Start lists and watches the EndpointSlices of every target until ctx is
+// cancelled, re-listing after every resync interval or watch failure.
+func (r *EndpointResolver) Start(ctx context.Context) {
+	for name, target := range r.targets {
+		go func() {
+			backoff := time.Second
+			for ctx.Err() == nil {
+				err := r.sync(ctx, name, target)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					r.logger.Warn("Kubernetes endpoint watch failed",
+						zap.String("service", name),
+						zap.Error(err),
+						zap.Duration("retry_in", backoff),
+					)
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(backoff):
+					}
+					backoff = min(backoff*2, 30*time.Second)
+					continue
+				}
+				backoff = time.Second
+			}
+		}()
+	}
+}
+
+// Endpoints returns the base URLs of a service's ready endpoints.
+func (r *EndpointResolver) Endpoints(service string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.endpoints[service]
+}
+
+func (r *EndpointResolver) sync(ctx context.Context, name string, target config.KubernetesTarget) error {
+	path := "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(target.Namespace) + "/endpointslices"
+	selector := "labelSelector=" + url.QueryEscape("kubernetes.io/service-name="+target.Service)
+
+	var list endpointSliceList
+	if err := r.client.Get(ctx, path+"?"+selector, &list); err != nil {
+		return err
+	}
+	set := make(map[string]endpointSlice, len(list.Items))
+	for _, es := range list.Items {
+		set[es.Metadata.Name] = es
+	}
+	r.publish(name, target, set)
+
+	timeout := int(r.resync.Seconds())
+	if timeout <= 0 {
+		timeout = 300
+	}
+	watch := fmt.Sprintf("%s?%s&watch=1&allowWatchBookmarks=true&resourceVersion=%s&timeoutSeconds=%d",
+		path, selector, url.QueryEscape(list.Metadata.ResourceVersion), timeout)
+
+	return r.client.Watch(ctx, watch, func(ev watchEvent) error {
+		if ev.Type == "BOOKMARK" {
+			return nil
+		}
+		var es endpointSlice
+		if err := json.Unmarshal(ev.Object, &es); err != nil {
+			return err
+		}
+		if ev.Type == "DELETED" {
+			delete(set, es.Metadata.Name)
+		} else {
+			set[es.Metadata.Name] = es
+		}
+		r.publish(name, target, set)
+		return nil
+	})
+}
+
+// publish replaces a service's endpoints with the ready addresses in set.
+func (r *EndpointResolver) publish(name string, target config.KubernetesTarget, set map[string]endpointSlice) {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, es := range set {
+		port, ok := slicePort(es, target.Port)
+		if !ok {
+			continue
+		}
+		for _, ep := range es.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				u := target.Scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(port))
+				if !seen[u] {
+					seen[u] = true
+					endpoints = append(endpoints, u)
+				}
+			}
+		}
+	}
+	sort.Strings(endpoints)
+
+	r.mu.Lock()
+	prev, listed := r.endpoints[name]
+	changed := !listed || !slices.Equal(endpoints, prev)
+	r.endpoints[name] = endpoints
+	r.mu.Unlock()
+
+	switch {
+	case !changed:
+	case len(endpoints) == 0:
+		r.logger.Warn("Kubernetes service has no ready endpoints",
+			zap.String("service", name),
+			zap.String("kubernetes_service", target.Namespace+"/"+target.Service),
+		)
+	default:
+		r.logger.Info("Kubernetes endpoints updated",
+			zap.String("service", name),
+			zap.String("kubernetes_service", target.Namespace+"/"+target.Service),
+			zap.Int("ready", len(endpoints)),
+		)
+	}
+}
+
+// slicePort finds the port named (or numbered) want in an EndpointSlice; an
+// empty want takes the first port.
+func slicePort(es endpointSlice, want string) (int, bool) {
+	for _, p := range es.Ports {
+		if want == "" || p.Name == want || strconv.Itoa(p.Port) == want {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,146 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+)
+
+// KubeClient is a minimal Kubernetes API client using the pod's service
+// account credentials.
+type KubeClient struct {
+	host      string
+	tokenFile string
+	http      *http.Client
+}
+
+// watchEvent is a single line of a Kubernetes watch stream.
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Annotations     map[string]string `json:"annotations"`
+	Labels          map[string]string `json:"labels"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+func NewKubeClient(cfg config.KubernetesConfig) (*KubeClient, error) {
+	host := cfg.APIServer
+	if host == "" {
+		h, p := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if h == "" || p == "" {
+			return nil, fmt.Errorf("kubernetes: not running in a cluster and api_server not set")
+		}
+		host = "https://" + net.JoinHostPort(h, p)
+	}
+
+	tlsConfig := fips.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kubernetes: no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &KubeClient{
+		host:      strings.TrimSuffix(host, "/"),
+		tokenFile: cfg.TokenFile,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+func (k *KubeClient) request(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// Projected service account tokens rotate, so read on every request
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: read token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes: GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// Get decodes the object at path into out.
+func (k *KubeClient) Get(ctx context.Context, path string, out interface{}) error {
+	resp, err := k.request(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Watch streams events for path (which must carry watch=1) until the server
+// closes the stream, ctx is done or fn returns an error.
+func (k *KubeClient) Watch(ctx context.Context, path string, fn func(watchEvent) error) error {
+	resp, err := k.request(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var ev watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return fmt.Errorf("kubernetes: decode watch event: %w", err)
+		}
+		if ev.Type == "ERROR" {
+			// Typically 410 Gone when the resource version is too old
+			return fmt.Errorf("kubernetes: watch error: %s", string(ev.Object))
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
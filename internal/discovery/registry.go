@@ -0,0 +1,346 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/routing"
+	"go.uber.org/zap"
+)
+
+// SourceAdmin labels routes registered through the registry admin API.
+const SourceAdmin = "admin"
+
+const (
+	registryKey = "registry"
+	// registryTTL keeps registrations alive while no replica is running;
+	// every change and every refresh extends it.
+	registryTTL = 90 * 24 * time.Hour
+)
+
+var (
+	ErrNotRegistered  = errors.New("not registered")
+	ErrServiceInUse   = errors.New("service is used by a registered route")
+	ErrInvalidService = errors.New("invalid service")
+	ErrInvalidRoute   = errors.New("invalid route")
+)
+
+// RegisteredService is an upstream added at runtime.
+type RegisteredService struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Timeout is a duration such as "10s"; empty uses the proxy default.
+	Timeout        string    `json:"timeout,omitempty"`
+	CircuitBreaker bool      `json:"circuit_breaker"`
+	UpdatedBy      string    `json:"updated_by"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// RegisteredRoute sends an /api path prefix to a registered service.
+type RegisteredRoute struct {
+	Prefix  string `json:"prefix"`
+	Service string `json:"service"`
+	Auth    string `json:"auth"`
+	// RateLimit names a policy, "default" if empty; "none" is unlimited.
+	RateLimit string    `json:"rate_limit"`
+	Priority  int       `json:"priority,omitempty"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Registry holds services and routes registered through the admin API and
+// publishes them to the dynamic route table. Registrations live in a Redis
+// hash that every replica reloads periodically; without Redis they are kept
+// in memory by the replica that received them.
+type Registry struct {
+	cfg    *config.Config
+	table  *routing.Table
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	services map[string]RegisteredService
+	routes   map[string]RegisteredRoute
+}
+
+func NewRegistry(cfg *config.Config, table *routing.Table, redis *infrastructure.RedisClient, logger *zap.Logger) *Registry {
+	if redis == nil {
+		logger.Warn("Redis unavailable, registry changes are neither persisted nor shared between replicas")
+	}
+	return &Registry{
+		cfg:      cfg,
+		table:    table,
+		redis:    redis,
+		logger:   logger,
+		services: make(map[string]RegisteredService),
+		routes:   make(map[string]RegisteredRoute),
+	}
+}
+
+// Start loads the registrations and reloads them every interval until ctx is
+// cancelled, picking up changes made through other replicas.
+func (r *Registry) Start(ctx context.Context, interval time.Duration) {
+	if r.redis == nil {
+		return
+	}
+	r.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Registry) refresh(ctx context.Context) {
+	// Held across the read so a concurrent change is not overwritten
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	raw, err := r.redis.GetHash(ctx, registryKey)
+	if err != nil {
+		r.logger.Warn("Failed to refresh service registry", zap.Error(err))
+		return
+	}
+	if len(raw) > 0 {
+		if err := r.redis.Expire(ctx, registryKey, registryTTL); err != nil {
+			r.logger.Warn("Failed to extend service registry expiry", zap.Error(err))
+		}
+	}
+
+	services := make(map[string]RegisteredService)
+	routes := make(map[string]RegisteredRoute)
+	for field, v := range raw {
+		kind, _, _ := strings.Cut(field, ":")
+		switch kind {
+		case "service":
+			var svc RegisteredService
+			if json.Unmarshal([]byte(v), &svc) == nil {
+				services[svc.Name] = svc
+			}
+		case "route":
+			var route RegisteredRoute
+			if json.Unmarshal([]byte(v), &route) == nil {
+				routes[route.Prefix] = route
+			}
+		}
+	}
+
+	r.services, r.routes = services, routes
+	r.publish()
+}
+
+// Services returns the registered services by name.
+func (r *Registry) Services() []RegisteredService {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RegisteredService, 0, len(r.services))
+	for _, svc := range r.services {
+		out = append(out, svc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Routes returns the registered routes by prefix.
+func (r *Registry) Routes() []RegisteredRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RegisteredRoute, 0, len(r.routes))
+	for _, route := range r.routes {
+		out = append(out, route)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out
+}
+
+// PutService adds or updates a service; routes using it follow the change.
+func (r *Registry) PutService(ctx context.Context, svc RegisteredService, by string) (RegisteredService, error) {
+	if err := r.validateService(svc); err != nil {
+		return svc, err
+	}
+	svc.UpdatedBy, svc.UpdatedAt = by, time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.save(ctx, "service:"+svc.Name, svc); err != nil {
+		return svc, err
+	}
+	r.services[svc.Name] = svc
+	r.publish()
+	r.logger.Info("Registry service updated",
+		zap.String("service", svc.Name),
+		zap.String("url", svc.URL),
+		zap.String("by", by),
+	)
+	return svc, nil
+}
+
+// DeleteService removes a service no registered route uses.
+func (r *Registry) DeleteService(ctx context.Context, name, by string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.services[name]; !ok {
+		return ErrNotRegistered
+	}
+	for _, route := range r.routes {
+		if route.Service == name {
+			return fmt.Errorf("%w: %s", ErrServiceInUse, route.Prefix)
+		}
+	}
+	if err := r.remove(ctx, "service:"+name); err != nil {
+		return err
+	}
+	delete(r.services, name)
+	r.logger.Info("Registry service removed", zap.String("service", name), zap.String("by", by))
+	return nil
+}
+
+// PutRoute adds or updates the route for a prefix.
+func (r *Registry) PutRoute(ctx context.Context, route RegisteredRoute, by string) (RegisteredRoute, error) {
+	route.Prefix = strings.TrimSuffix(route.Prefix, "/")
+	if route.Auth == "" {
+		route.Auth = routing.AuthRequired
+	}
+	if route.RateLimit == "" {
+		route.RateLimit = "default"
+	}
+	if err := r.validateRoute(route); err != nil {
+		return route, err
+	}
+	route.UpdatedBy, route.UpdatedAt = by, time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.services[route.Service]; !ok {
+		return route, fmt.Errorf("%w: service %q is not registered", ErrInvalidRoute, route.Service)
+	}
+	if err := r.save(ctx, "route:"+route.Prefix, route); err != nil {
+		return route, err
+	}
+	r.routes[route.Prefix] = route
+	r.publish()
+	r.logger.Info("Registry route updated",
+		zap.String("prefix", route.Prefix),
+		zap.String("service", route.Service),
+		zap.String("auth", route.Auth),
+		zap.String("rate_limit", route.RateLimit),
+		zap.String("by", by),
+	)
+	return route, nil
+}
+
+// DeleteRoute removes the route for a prefix.
+func (r *Registry) DeleteRoute(ctx context.Context, prefix, by string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.routes[prefix]; !ok {
+		return ErrNotRegistered
+	}
+	if err := r.remove(ctx, "route:"+prefix); err != nil {
+		return err
+	}
+	delete(r.routes, prefix)
+	r.publish()
+	r.logger.Info("Registry route removed", zap.String("prefix", prefix), zap.String("by", by))
+	return nil
+}
+
+func (r *Registry) validateService(svc RegisteredService) error {
+	if svc.Name == "" || strings.ContainsAny(svc.Name, " /:") {
+		return fmt.Errorf("%w: name %q", ErrInvalidService, svc.Name)
+	}
+	if _, clash := r.cfg.Services[svc.Name]; clash {
+		return fmt.Errorf("%w: %q is a configured service", ErrInvalidService, svc.Name)
+	}
+	if u, err := url.Parse(svc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url %q is not an absolute http(s) URL", ErrInvalidService, svc.URL)
+	}
+	if svc.Timeout != "" {
+		if d, err := time.ParseDuration(svc.Timeout); err != nil || d < 0 {
+			return fmt.Errorf("%w: timeout %q", ErrInvalidService, svc.Timeout)
+		}
+	}
+	return nil
+}
+
+// validateRoute also holds public routes to the route linter's allowlist, so
+// the registry cannot open an auth bypass.
+func (r *Registry) validateRoute(route RegisteredRoute) error {
+	if !strings.HasPrefix(route.Prefix, "/api/") || strings.ContainsAny(route.Prefix, "*:") {
+		return fmt.Errorf("%w: prefix %q must be a path under /api/", ErrInvalidRoute, route.Prefix)
+	}
+	switch route.Auth {
+	case routing.AuthRequired:
+	case routing.AuthPublic:
+		if path := route.Prefix + "/*"; !slices.Contains(r.cfg.Security.RouteLint.PublicRoutes, path) {
+			return fmt.Errorf("%w: %s is not listed in security.route_lint.public_routes", ErrInvalidRoute, path)
+		}
+	default:
+		return fmt.Errorf("%w: auth must be %q or %q", ErrInvalidRoute, routing.AuthRequired, routing.AuthPublic)
+	}
+	if _, ok := r.cfg.RateLimits.Policies[route.RateLimit]; route.RateLimit != "none" && !ok {
+		return fmt.Errorf("%w: rate limit policy %q is not defined", ErrInvalidRoute, route.RateLimit)
+	}
+	return nil
+}
+
+func (r *Registry) save(ctx context.Context, field string, v interface{}) error {
+	if r.redis == nil {
+		return nil
+	}
+	data, _ := json.Marshal(v)
+	return r.redis.SetHashField(ctx, registryKey, field, string(data), registryTTL)
+}
+
+func (r *Registry) remove(ctx context.Context, field string) error {
+	if r.redis == nil {
+		return nil
+	}
+	return r.redis.DeleteHashField(ctx, registryKey, field)
+}
+
+// publish replaces the admin-registered routes in the route table. Callers
+// hold r.mu.
+func (r *Registry) publish() {
+	routes := make([]routing.Route, 0, len(r.routes))
+	for _, rr := range r.routes {
+		svc, ok := r.services[rr.Service]
+		if !ok {
+			continue
+		}
+		timeout, _ := time.ParseDuration(svc.Timeout)
+		routes = append(routes, routing.Route{
+			Prefix: rr.Prefix,
+			Service: config.Service{
+				Name:           svc.Name,
+				URL:            svc.URL,
+				Timeout:        timeout,
+				CircuitBreaker: svc.CircuitBreaker,
+			},
+			Auth:      rr.Auth,
+			RateLimit: rr.RateLimit,
+			Priority:  rr.Priority,
+		})
+	}
+	logConflicts(r.logger, r.table.Replace(SourceAdmin, routes))
+}
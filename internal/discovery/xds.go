@@ -0,0 +1,462 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/routing"
+	"go.uber.org/zap"
+)
+
+// SourceXDS labels routes translated from the xDS control plane.
+const SourceXDS = "xds"
+
+// xdsMetadataKey is the filter_metadata namespace carrying gateway policy.
+const xdsMetadataKey = "banking.gateway"
+
+const (
+	typeListener    = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	typeRoute       = "type.googleapis.com/envoy.config.route.v3.RouteConfiguration"
+	typeCluster     = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	typeEndpoint    = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+	tlsSocketFilter = "envoy.transport_sockets.tls"
+)
+
+var xdsEndpoints = map[string]string{
+	typeListener: "/v3/discovery:listeners",
+	typeRoute:    "/v3/discovery:routes",
+	typeCluster:  "/v3/discovery:clusters",
+	typeEndpoint: "/v3/discovery:endpoints",
+}
+
+type xdsNode struct {
+	ID      string `json:"id"`
+	Cluster string `json:"cluster"`
+}
+
+type discoveryRequest struct {
+	VersionInfo   string   `json:"version_info,omitempty"`
+	Node          xdsNode  `json:"node"`
+	ResourceNames []string `json:"resource_names,omitempty"`
+	TypeURL       string   `json:"type_url"`
+}
+
+type discoveryResponse struct {
+	VersionInfo string            `json:"versionInfo"`
+	Resources   []json.RawMessage `json:"resources"`
+}
+
+type xdsListener struct {
+	Name         string `json:"name"`
+	FilterChains []struct {
+		Filters []struct {
+			TypedConfig struct {
+				Rds *struct {
+					RouteConfigName string `json:"routeConfigName"`
+				} `json:"rds"`
+				RouteConfig *xdsRouteConfig `json:"routeConfig"`
+			} `json:"typedConfig"`
+		} `json:"filters"`
+	} `json:"filterChains"`
+}
+
+type xdsRouteConfig struct {
+	Name         string `json:"name"`
+	VirtualHosts []struct {
+		Routes []xdsRoute `json:"routes"`
+	} `json:"virtualHosts"`
+}
+
+type xdsRoute struct {
+	Match struct {
+		Prefix string `json:"prefix"`
+		Path   string `json:"path"`
+	} `json:"match"`
+	Route *struct {
+		Cluster string `json:"cluster"`
+		Timeout string `json:"timeout"`
+	} `json:"route"`
+	Metadata struct {
+		FilterMetadata map[string]map[string]interface{} `json:"filterMetadata"`
+	} `json:"metadata"`
+}
+
+type xdsCluster struct {
+	Name            string             `json:"name"`
+	Type            string             `json:"type"`
+	LoadAssignment  *xdsLoadAssignment `json:"loadAssignment"`
+	TransportSocket *struct {
+		Name string `json:"name"`
+	} `json:"transportSocket"`
+	CircuitBreakers  json.RawMessage `json:"circuitBreakers"`
+	OutlierDetection json.RawMessage `json:"outlierDetection"`
+}
+
+type xdsLoadAssignment struct {
+	ClusterName string `json:"clusterName"`
+	Endpoints   []struct {
+		LbEndpoints []struct {
+			HealthStatus string `json:"healthStatus"`
+			Endpoint     struct {
+				Address struct {
+					SocketAddress struct {
+						Address   string `json:"address"`
+						PortValue int    `json:"portValue"`
+					} `json:"socketAddress"`
+				} `json:"address"`
+			} `json:"endpoint"`
+		} `json:"lbEndpoints"`
+	} `json:"endpoints"`
+}
+
+// XDSClient polls an xDS control plane over the REST-JSON transport and
+// translates listeners, route configurations and clusters into gateway routes.
+type XDSClient struct {
+	cfg    config.XDSConfig
+	static map[string]config.Service
+	public []string
+	table  *routing.Table
+	logger *zap.Logger
+	http   *http.Client
+
+	versions map[string]string
+}
+
+func NewXDSClient(cfg *config.Config, table *routing.Table, logger *zap.Logger) (*XDSClient, error) {
+	if cfg.XDS.Server == "" {
+		return nil, fmt.Errorf("xds: server not configured")
+	}
+	return &XDSClient{
+		cfg:      cfg.XDS,
+		static:   cfg.Services,
+		public:   cfg.Security.RouteLint.PublicRoutes,
+		table:    table,
+		logger:   logger,
+		http:     &http.Client{Timeout: 30 * time.Second},
+		versions: make(map[string]string),
+	}, nil
+}
+
+// Start polls the control plane until ctx is cancelled. The last good
+// snapshot stays in place while the control plane is unreachable.
+func (x *XDSClient) Start(ctx context.Context) {
+	interval := x.cfg.RefreshInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := x.refresh(ctx); err != nil && ctx.Err() == nil {
+				x.logger.Warn("xDS refresh failed, keeping last snapshot", zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (x *XDSClient) refresh(ctx context.Context) error {
+	var listeners []xdsListener
+	if err := x.fetch(ctx, typeListener, x.cfg.Listeners, &listeners); err != nil {
+		return err
+	}
+
+	var routeConfigs []xdsRouteConfig
+	var rdsNames []string
+	for _, l := range listeners {
+		for _, chain := range l.FilterChains {
+			for _, f := range chain.Filters {
+				if f.TypedConfig.RouteConfig != nil {
+					routeConfigs = append(routeConfigs, *f.TypedConfig.RouteConfig)
+				}
+				if f.TypedConfig.Rds != nil && f.TypedConfig.Rds.RouteConfigName != "" {
+					rdsNames = append(rdsNames, f.TypedConfig.Rds.RouteConfigName)
+				}
+			}
+		}
+	}
+	if len(rdsNames) > 0 {
+		var fetched []xdsRouteConfig
+		if err := x.fetch(ctx, typeRoute, rdsNames, &fetched); err != nil {
+			return err
+		}
+		routeConfigs = append(routeConfigs, fetched...)
+	}
+
+	var xroutes []xdsRoute
+	clusterSet := make(map[string]bool)
+	for _, rc := range routeConfigs {
+		for _, vh := range rc.VirtualHosts {
+			for _, r := range vh.Routes {
+				if r.Route == nil || r.Route.Cluster == "" {
+					continue
+				}
+				xroutes = append(xroutes, r)
+				clusterSet[r.Route.Cluster] = true
+			}
+		}
+	}
+
+	clusters := make(map[string]xdsCluster)
+	if len(clusterSet) > 0 {
+		names := make([]string, 0, len(clusterSet))
+		for name := range clusterSet {
+			names = append(names, name)
+		}
+		var fetched []xdsCluster
+		if err := x.fetch(ctx, typeCluster, names, &fetched); err != nil {
+			return err
+		}
+
+		var edsNames []string
+		for _, c := range fetched {
+			clusters[c.Name] = c
+			if c.Type == "EDS" || c.LoadAssignment == nil {
+				edsNames = append(edsNames, c.Name)
+			}
+		}
+		if len(edsNames) > 0 {
+			var assignments []xdsLoadAssignment
+			if err := x.fetch(ctx, typeEndpoint, edsNames, &assignments); err != nil {
+				return err
+			}
+			for _, a := range assignments {
+				if c, ok := clusters[a.ClusterName]; ok {
+					la := a
+					c.LoadAssignment = &la
+					clusters[a.ClusterName] = c
+				}
+			}
+		}
+	}
+
+	logConflicts(x.logger, x.table.Replace(SourceXDS, x.translate(xroutes, clusters)))
+	return nil
+}
+
+// translate maps xDS routes onto gateway routes. Prefixes are served under
+// /api; the gateway strips /api again before forwarding upstream.
+func (x *XDSClient) translate(xroutes []xdsRoute, clusters map[string]xdsCluster) []routing.Route {
+	var routes []routing.Route
+	seen := make(map[string]bool)
+
+	for _, r := range xroutes {
+		prefix := r.Match.Prefix
+		if prefix == "" {
+			prefix = r.Match.Path
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix == "" || !strings.HasPrefix(prefix, "/") {
+			// Catch-all routes would shadow the gateway's own 404 handling
+			continue
+		}
+		if !strings.HasPrefix(prefix, "/api/") {
+			prefix = "/api" + prefix
+		}
+		if seen[prefix] {
+			continue
+		}
+
+		name := r.Route.Cluster
+		if _, clash := x.static[name]; clash {
+			x.logger.Warn("xDS cluster shadows a configured service, ignoring", zap.String("cluster", name))
+			continue
+		}
+		cluster, ok := clusters[name]
+		if !ok {
+			x.logger.Warn("xDS route references unknown cluster", zap.String("cluster", name), zap.String("prefix", prefix))
+			continue
+		}
+		target, ok := clusterTarget(cluster)
+		if !ok {
+			x.logger.Warn("xDS cluster has no healthy endpoints", zap.String("cluster", name))
+			continue
+		}
+
+		route := routing.Route{
+			Prefix: prefix,
+			Service: config.Service{
+				Name:           name,
+				URL:            target,
+				CircuitBreaker: len(cluster.CircuitBreakers) > 0 || len(cluster.OutlierDetection) > 0,
+			},
+			Auth:      routing.AuthRequired,
+			RateLimit: "default",
+		}
+		if r.Route.Timeout != "" {
+			if d, err := time.ParseDuration(r.Route.Timeout); err == nil {
+				route.Service.Timeout = d
+			}
+		}
+		if meta := r.Metadata.FilterMetadata[xdsMetadataKey]; meta != nil {
+			if auth, _ := meta["auth"].(string); auth == routing.AuthPublic {
+				// Held to the route linter's allowlist, as in the registry
+				if !slices.Contains(x.public, prefix+"/*") {
+					x.logger.Warn("xDS public route is not listed in security.route_lint.public_routes, ignoring", zap.String("prefix", prefix))
+					continue
+				}
+				route.Auth = routing.AuthPublic
+			}
+			if limit, _ := meta["rate_limit"].(string); limit != "" {
+				route.RateLimit = limit
+			}
+			if priority, ok := meta["priority"].(float64); ok {
+				route.Priority = int(priority)
+			}
+		}
+
+		seen[prefix] = true
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// clusterTarget picks the upstream URL for a cluster: the first endpoint that
+// is not reported unhealthy or draining.
+func clusterTarget(c xdsCluster) (string, bool) {
+	if c.LoadAssignment == nil {
+		return "", false
+	}
+	scheme := "http"
+	if c.TransportSocket != nil && c.TransportSocket.Name == tlsSocketFilter {
+		scheme = "https"
+	}
+	for _, locality := range c.LoadAssignment.Endpoints {
+		for _, ep := range locality.LbEndpoints {
+			switch ep.HealthStatus {
+			case "UNHEALTHY", "DRAINING", "TIMEOUT":
+				continue
+			}
+			sa := ep.Endpoint.Address.SocketAddress
+			if sa.Address == "" || sa.PortValue == 0 {
+				continue
+			}
+			return scheme + "://" + sa.Address + ":" + strconv.Itoa(sa.PortValue), true
+		}
+	}
+	return "", false
+}
+
+// fetch issues a DiscoveryRequest and decodes the returned resources into out,
+// which must be a pointer to a slice.
+func (x *XDSClient) fetch(ctx context.Context, typeURL string, names []string, out interface{}) error {
+	body, err := json.Marshal(discoveryRequest{
+		VersionInfo:   x.versions[typeURL],
+		Node:          xdsNode{ID: x.cfg.NodeID, Cluster: x.cfg.Cluster},
+		ResourceNames: names,
+		TypeURL:       typeURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(x.cfg.Server, "/")+xdsEndpoints[typeURL], bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := x.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("xds: %s: %s: %s", typeURL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var raw interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("xds: decode %s: %w", typeURL, err)
+	}
+	// Control planes emit either proto field names or lowerCamelCase JSON
+	normalized, err := json.Marshal(camelKeys(raw))
+	if err != nil {
+		return err
+	}
+	var dr discoveryResponse
+	if err := json.Unmarshal(normalized, &dr); err != nil {
+		return err
+	}
+	if dr.VersionInfo != x.versions[typeURL] {
+		x.logger.Info("xDS resources updated", zap.String("type", typeURL), zap.String("version", dr.VersionInfo), zap.Int("resources", len(dr.Resources)))
+		x.versions[typeURL] = dr.VersionInfo
+	}
+
+	return json.Unmarshal(joinResources(dr.Resources), out)
+}
+
+func joinResources(resources []json.RawMessage) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, r := range resources {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(r)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// camelKeys rewrites snake_case object keys to lowerCamelCase, leaving the
+// contents of filter metadata untouched.
+func camelKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			key := snakeToCamel(k)
+			if key == "filterMetadata" {
+				out[key] = val
+				continue
+			}
+			out[key] = camelKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = camelKeys(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,6 @@
+//go:build fips
+
+package fips
+
+// buildTag forces FIPS mode in binaries built with -tags fips.
+const buildTag = true
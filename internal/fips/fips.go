@@ -0,0 +1,133 @@
+// Package fips restricts the gateway's cryptography to FIPS 140-2 approved
+// algorithms. FIPS mode is on when security.fips.enabled is set or the binary
+// was built with -tags fips; it then requires the Go FIPS 140 module
+// (GODEBUG=fips140=on, or a build with GOFIPS140) and refuses to start with
+// any configured feature that needs a non-approved primitive or key size.
+package fips
+
+import (
+	"crypto/fips140"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACKeyBytes is the 112-bit minimum for approved HMAC keys.
+const minHMACKeyBytes = 14
+
+// minRSABits is the smallest approved RSA modulus.
+const minRSABits = 2048
+
+// JWTAlgorithms are the approved JWT signing algorithms (HMAC-SHA2, RSA
+// PKCS#1 v1.5 and PSS, ECDSA on NIST curves).
+var JWTAlgorithms = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// cipherSuites are the approved TLS 1.2 suites; TLS 1.3 suites are not
+// configurable and the FIPS 140 module limits them to AES-GCM.
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var enabled atomic.Bool
+
+// Enabled reports whether FIPS mode passed Check for this process.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Check turns FIPS mode on when configured, failing if the Go FIPS module is
+// not active or a configured feature needs a non-approved primitive.
+func Check(cfg *config.Config) error {
+	if !buildTag && !cfg.Security.FIPS.Enabled {
+		return nil
+	}
+
+	var problems []string
+	if !fips140.Enabled() {
+		problems = append(problems, "the Go FIPS 140 module is not enabled (set GODEBUG=fips140=on or build with GOFIPS140=v1.0.0)")
+	}
+
+	if len(cfg.Security.Issuers) == 0 {
+		problems = append(problems, hmacKey("security.jwt_secret", cfg.Security.JWTSecret)...)
+	}
+	for _, iss := range cfg.Security.Issuers {
+		problems = append(problems, issuer(iss)...)
+	}
+
+	// HMAC-SHA256 signing keys; an empty secret leaves the feature off
+	for name, secret := range map[string]string{
+		"server.version_signing_secret":      cfg.Server.VersionSigningSecret,
+		"security.revocation.webhook_secret": cfg.Security.Revocation.WebhookSecret,
+		"rate_limits.key_secret":             cfg.RateLimits.KeySecret,
+		"status.webhook_secret":              cfg.Status.WebhookSecret,
+	} {
+		if secret != "" {
+			problems = append(problems, hmacKey(name, secret)...)
+		}
+	}
+
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("FIPS mode: %s", strings.Join(problems, "; "))
+	}
+	enabled.Store(true)
+	return nil
+}
+
+func hmacKey(name, secret string) []string {
+	if len(secret) < minHMACKeyBytes {
+		return []string{fmt.Sprintf("%s must be at least %d bytes", name, minHMACKeyBytes)}
+	}
+	return nil
+}
+
+func issuer(iss config.IssuerConfig) []string {
+	if !slices.Contains(JWTAlgorithms, iss.Algorithm) {
+		return []string{fmt.Sprintf("issuer %s: algorithm %q is not approved", iss.Issuer, iss.Algorithm)}
+	}
+	switch iss.Algorithm[:2] {
+	case "HS":
+		return hmacKey("issuer "+iss.Issuer+" secret", iss.Secret)
+	case "RS", "PS":
+		pem := []byte(iss.PublicKey)
+		if iss.PublicKeyFile != "" {
+			// Unreadable keys are reported when the issuer is loaded
+			pem, _ = os.ReadFile(iss.PublicKeyFile)
+		}
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil && key.N.BitLen() < minRSABits {
+			return []string{fmt.Sprintf("issuer %s: RSA key must be at least %d bits", iss.Issuer, minRSABits)}
+		}
+	}
+	return nil
+}
+
+// CheckRSAKey rejects RSA keys below the approved size while FIPS mode is on,
+// for keys that only arrive at runtime (e.g. from a JWKS endpoint).
+func CheckRSAKey(key *rsa.PublicKey) error {
+	if Enabled() && key.N.BitLen() < minRSABits {
+		return fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+	}
+	return nil
+}
+
+// TLSConfig limits c to TLS 1.2+, approved suites and NIST curves while FIPS
+// mode is on, and returns it.
+func TLSConfig(c *tls.Config) *tls.Config {
+	if !Enabled() {
+		return c
+	}
+	c.MinVersion = max(c.MinVersion, tls.VersionTLS12)
+	c.CipherSuites = cipherSuites
+	c.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+	return c
+}
@@ -0,0 +1,167 @@
+// Package fpe implements FF1 format-preserving encryption (NIST SP 800-38G)
+// over decimal digit strings: a string of n digits encrypts to another string
+// of n digits, so protected values still fit the fields and validation of
+// systems that store them.
+package fpe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const (
+	radix  = 10
+	rounds = 10
+	// MinLength digits give the million values SP 800-38G requires of the
+	// domain.
+	MinLength = 6
+	// MaxLength bounds inputs well below the standard's limit; card numbers
+	// and account identifiers are far shorter.
+	MaxLength = 64
+)
+
+// ErrInvalidInput is returned for input that is not MinLength to MaxLength
+// decimal digits.
+var ErrInvalidInput = errors.New("fpe: input must be 6 to 64 decimal digits")
+
+// FF1 encrypts decimal digit strings with AES as the FF1 round function.
+type FF1 struct {
+	block cipher.Block
+}
+
+// NewFF1 returns an FF1 cipher with an AES-128, -192 or -256 key.
+func NewFF1(key []byte) (*FF1, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FF1{block: block}, nil
+}
+
+// Encrypt returns the digits encrypting x under tweak.
+func (f *FF1) Encrypt(x string, tweak []byte) (string, error) {
+	return f.crypt(x, tweak, false)
+}
+
+// Decrypt returns the digits x encrypts, under the tweak used to encrypt
+// them.
+func (f *FF1) Decrypt(x string, tweak []byte) (string, error) {
+	return f.crypt(x, tweak, true)
+}
+
+func (f *FF1) crypt(x string, tweak []byte, decrypt bool) (string, error) {
+	n := len(x)
+	if n < MinLength || n > MaxLength || strings.Trim(x, "0123456789") != "" {
+		return "", ErrInvalidInput
+	}
+	u, v := n/2, n-n/2
+	a, b := x[:u], x[u:]
+
+	// b bytes hold any v-digit number; d bytes of round output feed each round
+	byteLen := (pow10(v).BitLen() + 7) / 8
+	d := 4*((byteLen+3)/4) + 4
+
+	t := len(tweak)
+	p := make([]byte, aes.BlockSize)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = 0, 0, radix
+	p[6], p[7] = rounds, byte(u%256)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(t))
+
+	pad := mod(-t-byteLen-1, 16)
+	q := make([]byte, t+pad+1+byteLen)
+	copy(q, tweak)
+
+	modU, modV := pow10(u), pow10(v)
+	y, c := new(big.Int), new(big.Int)
+	for r := 0; r < rounds; r++ {
+		i := r
+		if decrypt {
+			i = rounds - 1 - r
+		}
+		// The half fed to the round function: B encrypting, A decrypting
+		in := b
+		if decrypt {
+			in = a
+		}
+		q[t+pad] = byte(i)
+		num(in).FillBytes(q[t+pad+1:])
+		y.SetBytes(f.expand(f.prf(p, q), d))
+
+		m, modulus := u, modU
+		if i%2 == 1 {
+			m, modulus = v, modV
+		}
+		if decrypt {
+			c.Sub(num(b), y)
+		} else {
+			c.Add(num(a), y)
+		}
+		c.Mod(c, modulus)
+		digits := str(c, m)
+		if decrypt {
+			a, b = digits, a
+		} else {
+			a, b = b, digits
+		}
+	}
+	return a + b, nil
+}
+
+// prf is the CBC-MAC of p and q under the key. Both are whole blocks.
+func (f *FF1) prf(p, q []byte) []byte {
+	r := make([]byte, aes.BlockSize)
+	for _, data := range [][]byte{p, q} {
+		for off := 0; off < len(data); off += aes.BlockSize {
+			for j := range r {
+				r[j] ^= data[off+j]
+			}
+			f.block.Encrypt(r, r)
+		}
+	}
+	return r
+}
+
+// expand stretches the round output r to d bytes: r, then the encryptions
+// of r XOR 1, r XOR 2, ... as 16-byte counters, for inputs long enough that
+// d exceeds a block.
+func (f *FF1) expand(r []byte, d int) []byte {
+	s := r
+	block := make([]byte, aes.BlockSize)
+	for j := uint64(1); len(s) < d; j++ {
+		copy(block, r)
+		var counter [aes.BlockSize]byte
+		binary.BigEndian.PutUint64(counter[8:], j)
+		for k := range block {
+			block[k] ^= counter[k]
+		}
+		f.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
+
+// num is the number the digits spell.
+func num(digits string) *big.Int {
+	n, _ := new(big.Int).SetString(digits, 10)
+	return n
+}
+
+// str spells n in m digits, with leading zeros.
+func str(n *big.Int, m int) string {
+	s := n.String()
+	return strings.Repeat("0", m-len(s)) + s
+}
+
+func pow10(e int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(radix), big.NewInt(int64(e)), nil)
+}
+
+func mod(a, m int) int {
+	return ((a % m) + m) % m
+}
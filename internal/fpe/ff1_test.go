@@ -0,0 +1,91 @@
+package fpe
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// NIST SP 800-38G FF1 samples with radix 10
+var ff1Samples = []struct {
+	name, key, tweak, plaintext, ciphertext string
+}{
+	{"sample 1", "2B7E151628AED2A6ABF7158809CF4F3C", "", "0123456789", "2433477484"},
+	{"sample 2", "2B7E151628AED2A6ABF7158809CF4F3C", "39383736353433323130", "0123456789", "6124200773"},
+	{"sample 4", "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "", "0123456789", "2830668132"},
+	{"sample 5", "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "39383736353433323130", "0123456789", "2496655549"},
+	{"sample 7", "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "", "0123456789", "6657667009"},
+	{"sample 8", "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "39383736353433323130", "0123456789", "1001623463"},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFF1Samples(t *testing.T) {
+	for _, tc := range ff1Samples {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFF1(mustHex(t, tc.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tweak := mustHex(t, tc.tweak)
+			got, err := f.Encrypt(tc.plaintext, tweak)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.ciphertext {
+				t.Errorf("Encrypt = %s, want %s", got, tc.ciphertext)
+			}
+			back, err := f.Decrypt(tc.ciphertext, tweak)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if back != tc.plaintext {
+				t.Errorf("Decrypt = %s, want %s", back, tc.plaintext)
+			}
+		})
+	}
+}
+
+func TestFF1RoundTripsEverySupportedLength(t *testing.T) {
+	f, err := NewFF1(mustHex(t, ff1Samples[0].key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak := []byte("account")
+	for n := MinLength; n <= MaxLength; n++ {
+		x := strings.Repeat("0123456789", MaxLength/10+1)[:n]
+		enc, err := f.Encrypt(x, tweak)
+		if err != nil {
+			t.Fatalf("Encrypt %d digits: %v", n, err)
+		}
+		if len(enc) != n || strings.Trim(enc, "0123456789") != "" {
+			t.Fatalf("Encrypt %d digits = %q, want %d digits", n, enc, n)
+		}
+		dec, err := f.Decrypt(enc, tweak)
+		if err != nil {
+			t.Fatalf("Decrypt %d digits: %v", n, err)
+		}
+		if dec != x {
+			t.Errorf("%d digits: Decrypt(Encrypt(%s)) = %s", n, x, dec)
+		}
+	}
+}
+
+func TestFF1RejectsInvalidInput(t *testing.T) {
+	f, err := NewFF1(mustHex(t, ff1Samples[0].key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []string{"12345", strings.Repeat("1", MaxLength+1), "12345a"} {
+		if _, err := f.Encrypt(x, nil); err != ErrInvalidInput {
+			t.Errorf("Encrypt(%q): err = %v, want ErrInvalidInput", x, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/banking/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Defaults for settings a service's health_check leaves unset.
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+	defaultRise     = 2
+	defaultFall     = 3
+	defaultPath     = "/health"
+)
+
+// Instances returns the current instances (base URLs) of a service.
+type Instances func(name string, svc config.Service) []string
+
+// InstanceStatus is the health of one instance of a service.
+type InstanceStatus struct {
+	Service   string    `json:"service"`
+	Instance  string    `json:"instance"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+	// Passes and Failures are the current streaks; one resets the other.
+	Passes   int `json:"passes"`
+	Failures int `json:"failures"`
+}
+
+// Checker actively probes the instances of services with a health_check on
+// their interval. An instance turns unhealthy after Fall consecutive failed
+// probes and healthy again after Rise passes; instances not yet probed count
+// as healthy so a restart never takes a service out of rotation.
+type Checker struct {
+	services  map[string]config.Service // with health checks, by name
+	instances Instances
+	http      *http.Client
+	grpc      *http.Client
+	dialer    *net.Dialer
+	logger    *zap.Logger
+
+	mu     sync.RWMutex
+	states map[string]map[string]*InstanceStatus // by service, then instance
+}
+
+func NewChecker(cfg *config.Config, instances Instances, logger *zap.Logger) *Checker {
+	services := make(map[string]config.Service)
+	for name, svc := range cfg.Services {
+		if svc.HealthCheck.Type == "" {
+			continue
+		}
+		svc.HealthCheck = withDefaults(svc.HealthCheck)
+		services[name] = svc
+	}
+
+	tlsConfig := fips.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	// gRPC needs HTTP/2, in cleartext (h2c) for http:// instances
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	noRedirects := func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	return &Checker{
+		services:  services,
+		instances: instances,
+		http: &http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsConfig, MaxIdleConnsPerHost: 2, IdleConnTimeout: 90 * time.Second},
+			CheckRedirect: noRedirects,
+		},
+		grpc: &http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsConfig.Clone(), Protocols: protocols, IdleConnTimeout: 90 * time.Second},
+			CheckRedirect: noRedirects,
+		},
+		dialer: &net.Dialer{KeepAlive: -1},
+		logger: logger,
+		states: make(map[string]map[string]*InstanceStatus),
+	}
+}
+
+func withDefaults(hc config.HealthCheckConfig) config.HealthCheckConfig {
+	if hc.Interval <= 0 {
+		hc.Interval = defaultInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = defaultTimeout
+	}
+	if hc.Rise <= 0 {
+		hc.Rise = defaultRise
+	}
+	if hc.Fall <= 0 {
+		hc.Fall = defaultFall
+	}
+	if hc.Path == "" {
+		hc.Path = defaultPath
+	}
+	if len(hc.Statuses) == 0 {
+		hc.Statuses = []string{"2xx"}
+	}
+	return hc
+}
+
+// Start probes every service on its interval until ctx is cancelled.
+func (c *Checker) Start(ctx context.Context) {
+	for name, svc := range c.services {
+		go func() {
+			ticker := time.NewTicker(svc.HealthCheck.Interval)
+			defer ticker.Stop()
+			for {
+				c.checkService(ctx, name, svc)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// checkService probes every current instance of a service at once.
+func (c *Checker) checkService(ctx context.Context, name string, svc config.Service) {
+	instances := c.instances(name, svc)
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, svc.HealthCheck.Timeout)
+			defer cancel()
+			errs[i] = c.probe(probeCtx, svc.HealthCheck, instance)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	states := c.states[name]
+	if states == nil {
+		states = make(map[string]*InstanceStatus)
+		c.states[name] = states
+	}
+	current := make(map[string]bool, len(instances))
+	for i, instance := range instances {
+		current[instance] = true
+		st, ok := states[instance]
+		if !ok {
+			st = &InstanceStatus{Service: name, Instance: instance, Healthy: true}
+			states[instance] = st
+		}
+		c.record(st, svc.HealthCheck, errs[i], now)
+	}
+	// Instances gone from discovery or the configuration
+	for instance := range states {
+		if !current[instance] {
+			delete(states, instance)
+			metrics.UpstreamHealthy.DeleteLabelValues(name, instance)
+		}
+	}
+}
+
+// record applies one probe result to an instance. Callers hold mu.
+func (c *Checker) record(st *InstanceStatus, hc config.HealthCheckConfig, err error, at time.Time) {
+	st.LastCheck = at
+	if err == nil {
+		metrics.UpstreamHealthChecks.WithLabelValues(st.Service, "pass").Inc()
+		st.Passes++
+		st.Failures = 0
+		st.LastError = ""
+		if !st.Healthy && st.Passes >= hc.Rise {
+			st.Healthy = true
+			c.logger.Info("Upstream instance healthy again",
+				zap.String("service", st.Service),
+				zap.String("instance", st.Instance),
+				zap.Int("passes", st.Passes),
+			)
+		}
+	} else {
+		metrics.UpstreamHealthChecks.WithLabelValues(st.Service, "fail").Inc()
+		st.Failures++
+		st.Passes = 0
+		st.LastError = err.Error()
+		if st.Healthy && st.Failures >= hc.Fall {
+			st.Healthy = false
+			c.logger.Warn("Upstream instance unhealthy",
+				zap.String("service", st.Service),
+				zap.String("instance", st.Instance),
+				zap.String("check", hc.Type),
+				zap.Int("failures", st.Failures),
+				zap.Error(err),
+			)
+		}
+	}
+	healthy := 0.0
+	if st.Healthy {
+		healthy = 1
+	}
+	metrics.UpstreamHealthy.WithLabelValues(st.Service, st.Instance).Set(healthy)
+}
+
+// Healthy reports whether an instance of a service is healthy. Instances of
+// services without health checks, or not probed yet, are.
+func (c *Checker) Healthy(service, instance string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st, ok := c.states[service][instance]
+	return !ok || st.Healthy
+}
+
+// Statuses returns the health of every probed instance, by service and
+// instance.
+func (c *Checker) Statuses() []InstanceStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var statuses []InstanceStatus
+	for _, states := range c.states {
+		for _, st := range states {
+			statuses = append(statuses, *st)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Service != statuses[j].Service {
+			return statuses[i].Service < statuses[j].Service
+		}
+		return statuses[i].Instance < statuses[j].Instance
+	})
+	return statuses
+}
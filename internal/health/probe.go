@@ -0,0 +1,227 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// maxProbeBody bounds how much of a health response is read.
+const maxProbeBody = 64 << 10
+
+// grpcServing is SERVING in grpc.health.v1.HealthCheckResponse.
+const grpcServing = 1
+
+var grpcStatusNames = map[uint64]string{0: "UNKNOWN", 1: "SERVING", 2: "NOT_SERVING", 3: "SERVICE_UNKNOWN"}
+
+// probe runs one check against an instance; nil means it passed.
+func (c *Checker) probe(ctx context.Context, hc config.HealthCheckConfig, instance string) error {
+	base, err := url.Parse(instance)
+	if err != nil || base.Host == "" {
+		return fmt.Errorf("invalid instance URL %q", instance)
+	}
+	switch hc.Type {
+	case "tcp":
+		return c.probeTCP(ctx, base)
+	case "grpc":
+		return c.probeGRPC(ctx, base, hc.GRPCService)
+	}
+	return c.probeHTTP(ctx, base, hc)
+}
+
+func (c *Checker) probeTCP(ctx context.Context, base *url.URL) error {
+	port := base.Port()
+	if port == "" {
+		port = "80"
+		if base.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(base.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTP GETs the health path and checks the status code and, for json
+// checks, the body.
+func (c *Checker) probeHTTP(ctx context.Context, base *url.URL, hc config.HealthCheckConfig) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.JoinPath(hc.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+	if hc.Type == "json" {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return err
+	}
+	if !statusPasses(hc.Statuses, resp.StatusCode) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if hc.Type == "json" {
+		return matchJSON(body, hc.JSONExpect)
+	}
+	return nil
+}
+
+// statusPasses reports whether code matches one of the patterns, exact
+// ("204") or by class ("2xx").
+func statusPasses(patterns []string, code int) bool {
+	s := strconv.Itoa(code)
+	for _, p := range patterns {
+		if p == s || (strings.HasSuffix(p, "xx") && p[0] == s[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchJSON checks "field=value" predicates against a JSON body, following
+// dotted paths into nested objects. Values compare as text, so "UP", "true"
+// and "1" match strings, booleans and numbers alike.
+func matchJSON(body []byte, expect []string) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("body is not JSON: %w", err)
+	}
+	for _, e := range expect {
+		field, want, _ := strings.Cut(e, "=")
+		v := doc
+		for _, key := range strings.Split(field, ".") {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = obj[key]
+		}
+		if v == nil {
+			return fmt.Errorf("%s is missing, want %s", field, want)
+		}
+		if got := fmt.Sprint(v); got != want {
+			return fmt.Errorf("%s is %s, want %s", field, got, want)
+		}
+	}
+	return nil
+}
+
+// probeGRPC calls grpc.health.v1.Health/Check, in cleartext HTTP/2 for
+// http:// instances, and passes on SERVING. The protocol is small enough to
+// speak directly: a length-prefixed HealthCheckRequest with the service name
+// in field 1, and a HealthCheckResponse with the status enum in field 1.
+func (c *Checker) probeGRPC(ctx context.Context, base *url.URL, service string) error {
+	var msg []byte
+	if service != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(service)))...)
+		msg = append(msg, service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.JoinPath("/grpc.health.v1.Health/Check").String(), bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := c.grpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	// Errors may come as trailers-only responses, in the headers
+	code := resp.Trailer.Get("Grpc-Status")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+	}
+	if code != "0" {
+		msg := resp.Trailer.Get("Grpc-Message")
+		if msg == "" {
+			msg = resp.Header.Get("Grpc-Message")
+		}
+		return fmt.Errorf("grpc-status %s: %s", code, msg)
+	}
+
+	status, err := grpcHealthStatus(body)
+	if err != nil {
+		return err
+	}
+	if status != grpcServing {
+		name := grpcStatusNames[status]
+		if name == "" {
+			name = strconv.FormatUint(status, 10)
+		}
+		return fmt.Errorf("grpc health status %s", name)
+	}
+	return nil
+}
+
+// grpcHealthStatus reads the status of a length-prefixed HealthCheckResponse.
+// An absent status field is UNKNOWN (0), as in proto3.
+func grpcHealthStatus(frame []byte) (uint64, error) {
+	if len(frame) < 5 || frame[0] != 0 {
+		return 0, errors.New("malformed gRPC response")
+	}
+	n := binary.BigEndian.Uint32(frame[1:5])
+	if uint32(len(frame)-5) < n {
+		return 0, errors.New("truncated gRPC response")
+	}
+	msg := frame[5 : 5+n]
+	var status uint64
+	for len(msg) > 0 {
+		tag, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return 0, errors.New("malformed health response")
+		}
+		msg = msg[k:]
+		switch tag & 7 {
+		case 0: // varint
+			v, k := binary.Uvarint(msg)
+			if k <= 0 {
+				return 0, errors.New("malformed health response")
+			}
+			msg = msg[k:]
+			if tag>>3 == 1 {
+				status = v
+			}
+		case 2: // length-delimited
+			l, k := binary.Uvarint(msg)
+			if k <= 0 || uint64(len(msg)-k) < l {
+				return 0, errors.New("malformed health response")
+			}
+			msg = msg[k+int(l):]
+		default:
+			return 0, errors.New("unexpected field in health response")
+		}
+	}
+	return status, nil
+}
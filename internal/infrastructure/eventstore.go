@@ -0,0 +1,522 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Gateway event streams.
+const (
+	StreamAccess = "access"
+	StreamAudit  = "audit"
+	StreamError  = "error"
+)
+
+// requestIDField is the log field that ties an entry to a request.
+const requestIDField = "request_id"
+
+// StreamWrite is one entry queued for a capped, expiring stream.
+type StreamWrite struct {
+	Stream string
+	MaxLen int64
+	TTL    time.Duration
+	Values map[string]interface{}
+}
+
+// EventStore writes gateway events to capped Redis Streams and reads them back
+// through consumer groups. Writes are queued and pipelined in the background
+// so the request path never waits on Redis; when the queue is full events are
+// dropped and counted.
+type EventStore struct {
+	redis  *RedisClient
+	cfg    config.EventsConfig
+	logger *zap.Logger
+
+	// requestLog is set when request-scoped events are also kept per request
+	requestLog *config.RequestLogConfig
+
+	// mu guards writes against sends after Stop; background jobs may still
+	// log while shutting down
+	mu     sync.RWMutex
+	closed bool
+	writes chan StreamWrite
+	done   chan struct{}
+}
+
+func NewEventStore(redis *RedisClient, cfg config.EventsConfig, logger *zap.Logger) *EventStore {
+	return &EventStore{
+		redis:  redis,
+		cfg:    cfg,
+		logger: logger,
+		writes: make(chan StreamWrite, 8192),
+		done:   make(chan struct{}),
+	}
+}
+
+func eventStreamKey(stream string) string {
+	return "events:" + stream
+}
+
+// redactedStreamKey holds the entries of a gateway stream that have been
+// redacted, under their original IDs; stream entries cannot be changed in
+// place.
+func redactedStreamKey(stream string) string {
+	return eventStreamKey(stream) + ":redacted"
+}
+
+// Move entries to the redacted stream with the values given for them. An
+// entry the redacted stream already holds was moved by an interrupted pass
+// and only needs deleting.
+var redactEntriesScript = redis.NewScript(`
+	local moved = 0
+	for i = 2, #ARGV, 2 do
+		local added = redis.pcall("XADD", KEYS[2], ARGV[i], unpack(cjson.decode(ARGV[i + 1])))
+		if type(added) == "string" or string.find(added.err, "equal or smaller", 1, true) then
+			redis.call("XDEL", KEYS[1], ARGV[i])
+			moved = moved + 1
+		end
+	end
+	redis.call("PEXPIRE", KEYS[2], ARGV[1])
+	return moved
+`)
+
+// Start runs the background writer until Stop.
+func (s *EventStore) Start() {
+	go func() {
+		defer close(s.done)
+		batch := make([]StreamWrite, 0, 256)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case w, ok := <-s.writes:
+				if !ok {
+					s.flush(batch)
+					return
+				}
+				batch = append(batch, w)
+				if len(batch) == cap(batch) {
+					s.flush(batch)
+					batch = batch[:0]
+				}
+			case <-ticker.C:
+				if len(batch) > 0 {
+					s.flush(batch)
+					batch = batch[:0]
+				}
+			}
+		}
+	}()
+}
+
+// Stop flushes queued events and stops the writer.
+func (s *EventStore) Stop() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.writes)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// Emit queues a write without blocking.
+func (s *EventStore) Emit(w StreamWrite) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.writes <- w:
+	default:
+		metrics.EventsDropped.WithLabelValues(w.Stream).Inc()
+	}
+}
+
+// Publish queues an event on one of the gateway streams, if enabled.
+func (s *EventStore) Publish(stream string, values map[string]interface{}) {
+	if !s.streamEnabled(stream) {
+		return
+	}
+	s.Emit(StreamWrite{Stream: eventStreamKey(stream), MaxLen: s.cfg.MaxLen, TTL: s.cfg.Retention, Values: values})
+}
+
+func (s *EventStore) streamEnabled(stream string) bool {
+	if !s.cfg.Enabled {
+		return false
+	}
+	for _, name := range s.cfg.Streams {
+		if name == stream {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *EventStore) flush(batch []StreamWrite) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pipe := s.redis.client.Pipeline()
+	ttls := make(map[string]time.Duration)
+	for _, w := range batch {
+		key := s.redis.key(w.Stream)
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: key, MaxLen: w.MaxLen, Approx: true, Values: w.Values})
+		ttls[key] = s.redis.expiry(w.TTL)
+	}
+	for key, ttl := range ttls {
+		pipe.Expire(ctx, key, ttl)
+	}
+	// Failures are counted rather than logged: error logs are captured as
+	// events themselves and would loop back here
+	if _, err := pipe.Exec(ctx); err != nil {
+		metrics.EventsDropped.WithLabelValues("flush").Add(float64(len(batch)))
+	}
+}
+
+// Read returns up to count of the most recent entries of a gateway stream,
+// oldest first.
+func (s *EventStore) Read(ctx context.Context, stream string, count int64) ([]StreamEntry, error) {
+	msgs, err := s.redis.reader.XRevRangeN(ctx, s.redis.key(eventStreamKey(stream)), "+", "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, len(msgs))
+	for i, m := range msgs {
+		entries[len(msgs)-1-i] = StreamEntry{ID: m.ID, Values: m.Values}
+	}
+	return entries, nil
+}
+
+// Scan calls visit with every entry of a gateway stream, oldest first, a
+// page at a time.
+func (s *EventStore) Scan(ctx context.Context, stream string, visit func(StreamEntry)) error {
+	key := s.redis.key(eventStreamKey(stream))
+	start := "-"
+	for {
+		msgs, err := s.redis.reader.XRangeN(ctx, key, start, "+", 1000).Result()
+		if err != nil {
+			return err
+		}
+		for _, e := range toEntries(msgs) {
+			visit(e)
+		}
+		if len(msgs) < 1000 {
+			return nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// Delete removes entries from a gateway stream and returns how many it
+// removed.
+func (s *EventStore) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return s.redis.client.XDel(ctx, s.redis.key(eventStreamKey(stream)), ids...).Result()
+}
+
+// TrimBefore removes the entries of a gateway stream, redacted or not,
+// written before t and returns how many it removed.
+func (s *EventStore) TrimBefore(ctx context.Context, stream string, t time.Time) (int64, error) {
+	minID := strconv.FormatInt(t.UnixMilli(), 10) + "-0"
+	pipe := s.redis.client.Pipeline()
+	trims := []*redis.IntCmd{
+		pipe.XTrimMinID(ctx, s.redis.key(eventStreamKey(stream)), minID),
+		pipe.XTrimMinID(ctx, s.redis.key(redactedStreamKey(stream)), minID),
+	}
+	_, err := pipe.Exec(ctx)
+	return trims[0].Val() + trims[1].Val(), err
+}
+
+// RedactBefore moves the entries of a gateway stream written before t to its
+// redacted stream, after redact has changed their values, and returns how
+// many it moved. Consumer groups no longer see moved entries.
+func (s *EventStore) RedactBefore(ctx context.Context, stream string, t time.Time, redact func(map[string]interface{})) (int, error) {
+	key := s.redis.key(eventStreamKey(stream))
+	keys := []string{key, s.redis.key(redactedStreamKey(stream))}
+	end := "(" + strconv.FormatInt(t.UnixMilli(), 10) + "-0"
+	ttl := s.redis.expiry(s.cfg.Retention).Milliseconds()
+
+	moved := 0
+	for {
+		msgs, err := s.redis.client.XRangeN(ctx, key, "-", end, 500).Result()
+		if err != nil || len(msgs) == 0 {
+			return moved, err
+		}
+		args := []interface{}{ttl}
+		for _, m := range msgs {
+			redact(m.Values)
+			args = append(args, m.ID, scriptValues(m.Values))
+		}
+		n, err := redactEntriesScript.Run(ctx, s.redis.client, keys, args...).Int()
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+		if n == 0 {
+			return moved, errors.New("no entries of " + stream + " could be moved")
+		}
+	}
+}
+
+// Oldest returns the time of the oldest entry a gateway stream holds, and of
+// the oldest that is not redacted; zero when there is none.
+func (s *EventStore) Oldest(ctx context.Context, stream string) (oldest, unredacted time.Time, err error) {
+	pipe := s.redis.reader.Pipeline()
+	plain := pipe.XRangeN(ctx, s.redis.key(eventStreamKey(stream)), "-", "+", 1)
+	redacted := pipe.XRangeN(ctx, s.redis.key(redactedStreamKey(stream)), "-", "+", 1)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return time.Time{}, time.Time{}, err
+	}
+	if msgs := plain.Val(); len(msgs) > 0 {
+		unredacted = EntryTime(msgs[0].ID)
+		oldest = unredacted
+	}
+	if msgs := redacted.Val(); len(msgs) > 0 {
+		if t := EntryTime(msgs[0].ID); oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest, unredacted, nil
+}
+
+// scriptValues encodes entry values for XADD in a script, as a JSON array
+// of fields and values.
+func scriptValues(values map[string]interface{}) string {
+	fields := make([]string, 0, 2*len(values))
+	for k, v := range values {
+		fields = append(fields, k, fmt.Sprint(v))
+	}
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// EntryTime returns when a stream entry was added, from its ID.
+func EntryTime(id string) time.Time {
+	ms, _, _ := strings.Cut(id, "-")
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(n)
+}
+
+// EnsureGroup creates a consumer group on a gateway stream, starting at new
+// entries. An existing group is left as is.
+func (s *EventStore) EnsureGroup(ctx context.Context, stream, group string) error {
+	err := s.redis.client.XGroupCreateMkStream(ctx, s.redis.key(eventStreamKey(stream)), group, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// Consume returns up to count entries for consumer: first entries other
+// consumers left unacknowledged for longer than minIdle, then new ones,
+// waiting up to block for them.
+func (s *EventStore) Consume(ctx context.Context, stream, group, consumer string, count int64, block, minIdle time.Duration) ([]StreamEntry, error) {
+	key := s.redis.key(eventStreamKey(stream))
+
+	if minIdle > 0 {
+		msgs, _, err := s.redis.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   key,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    "0-0",
+			Count:    count,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			return toEntries(msgs), nil
+		}
+	}
+
+	res, err := s.redis.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{key, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []StreamEntry
+	for _, st := range res {
+		entries = append(entries, toEntries(st.Messages)...)
+	}
+	return entries, nil
+}
+
+// Ack marks entries as processed by the group.
+func (s *EventStore) Ack(ctx context.Context, stream, group string, ids ...string) error {
+	return s.redis.client.XAck(ctx, s.redis.key(eventStreamKey(stream)), group, ids...).Err()
+}
+
+// Subscribe consumes a gateway stream as consumer of group until ctx is done.
+// Entries are acknowledged when handle succeeds; failed entries stay pending
+// and are redelivered once idle for retryAfter, to this or another replica.
+func (s *EventStore) Subscribe(ctx context.Context, stream, group, consumer string, retryAfter time.Duration, handle func(context.Context, StreamEntry) error) {
+	go func() {
+		for ctx.Err() == nil {
+			if err := s.EnsureGroup(ctx, stream, group); err != nil {
+				s.logger.Warn("Failed to create event consumer group", zap.String("stream", stream), zap.String("group", group), zap.Error(err))
+				if !sleepCtx(ctx, 5*time.Second) {
+					return
+				}
+				continue
+			}
+			break
+		}
+
+		for ctx.Err() == nil {
+			entries, err := s.Consume(ctx, stream, group, consumer, 50, 5*time.Second, retryAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Warn("Event consume failed", zap.String("stream", stream), zap.String("group", group), zap.Error(err))
+					sleepCtx(ctx, time.Second)
+				}
+				continue
+			}
+			for _, e := range entries {
+				if err := handle(ctx, e); err != nil {
+					continue
+				}
+				if err := s.Ack(ctx, stream, group, e.ID); err != nil {
+					s.logger.Warn("Event ack failed", zap.String("stream", stream), zap.String("id", e.ID), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func toEntries(msgs []redis.XMessage) []StreamEntry {
+	entries := make([]StreamEntry, 0, len(msgs))
+	for _, m := range msgs {
+		entries = append(entries, StreamEntry{ID: m.ID, Values: m.Values})
+	}
+	return entries
+}
+
+// Attach returns logger with its entries also captured as events: audit
+// entries go to the audit stream, errors to the error stream and anything
+// tagged with a request ID to that request's log.
+func (s *EventStore) Attach(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &eventCore{LevelEnabler: core, store: s})
+	}))
+}
+
+type eventCore struct {
+	zapcore.LevelEnabler
+	store  *EventStore
+	fields []zapcore.Field
+}
+
+func (c *eventCore) With(fields []zapcore.Field) zapcore.Core {
+	return &eventCore{
+		LevelEnabler: c.LevelEnabler,
+		store:        c.store,
+		fields:       append(append([]zapcore.Field(nil), c.fields...), fields...),
+	}
+}
+
+func (c *eventCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *eventCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := append(append([]zapcore.Field(nil), c.fields...), fields...)
+
+	var requestID string
+	for _, f := range all {
+		if f.Key == requestIDField && f.Type == zapcore.StringType {
+			requestID = f.String
+		}
+	}
+
+	kind := "log"
+	switch {
+	case ent.LoggerName == "audit" || strings.HasSuffix(ent.LoggerName, ".audit"):
+		kind = StreamAudit
+	case ent.Level >= zapcore.ErrorLevel:
+		kind = StreamError
+	}
+
+	toRequest := requestID != "" && c.store.requestLog != nil
+	toStream := kind != "log" && c.store.streamEnabled(kind)
+	if !toRequest && !toStream {
+		return nil
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range all {
+		if f.Key != requestIDField {
+			f.AddTo(enc)
+		}
+	}
+	data, _ := json.Marshal(enc.Fields)
+
+	values := map[string]interface{}{
+		"kind":       kind,
+		"time":       ent.Time.UTC().Format(time.RFC3339Nano),
+		"level":      ent.Level.String(),
+		"logger":     ent.LoggerName,
+		"message":    ent.Message,
+		"request_id": requestID,
+		"fields":     string(data),
+	}
+	if toStream {
+		c.store.Publish(kind, values)
+	}
+	if toRequest {
+		c.store.Emit(StreamWrite{
+			Stream: requestLogKey(requestID),
+			MaxLen: c.store.requestLog.MaxEvents,
+			TTL:    c.store.requestLog.Retention,
+			Values: values,
+		})
+	}
+	return nil
+}
+
+func (c *eventCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package infrastructure
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// keyCategories are the first key segment (after the prefix) the scanner
+// reports on individually; everything else is grouped under "other".
+var keyCategories = []string{"ratelimit", "blacklist", "cache", "idempotency", "usage", "lock", "client", "reqlog", "events", "concurrency", "store"}
+
+// persistentKeys are written with SetPersistentHashField and hold operator
+// decisions, such as product subscription removals, migration rollbacks and
+// read-only freezes, that must not lapse; the scanner never gives them an
+// expiry.
+var persistentKeys = []string{"product_subscriptions", "migrations", "readonly"}
+
+// KeyspaceStats aggregates gateway key counts and memory for one category.
+type KeyspaceStats struct {
+	Keys           int64 `json:"keys"`
+	MemoryBytes    int64 `json:"memory_bytes"`
+	KeysWithoutTTL int64 `json:"keys_without_ttl"`
+}
+
+// ScanKeyspace walks up to maxKeys gateway keys and returns per-category
+// counts and memory usage. With redis.enforce_key_ttl, keys found without an
+// expiry are given the default TTL so that the gateway's footprint stays
+// bounded; that needs a key prefix, as the scan would otherwise match every
+// key in the instance.
+func (r *RedisClient) ScanKeyspace(ctx context.Context, maxKeys int) (map[string]*KeyspaceStats, error) {
+	stats := make(map[string]*KeyspaceStats)
+	for _, c := range append(keyCategories, "other") {
+		stats[c] = &KeyspaceStats{}
+	}
+
+	var cursor uint64
+	scanned := 0
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, r.prefix+"*", 500).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		pipe := r.client.Pipeline()
+		memCmds := make([]*redis.IntCmd, len(keys))
+		ttlCmds := make([]*redis.DurationCmd, len(keys))
+		for i, k := range keys {
+			memCmds[i] = pipe.MemoryUsage(ctx, k)
+			ttlCmds[i] = pipe.TTL(ctx, k)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+
+		for i, k := range keys {
+			st := stats[categorize(strings.TrimPrefix(k, r.prefix))]
+			st.Keys++
+			st.MemoryBytes += memCmds[i].Val()
+			if ttlCmds[i].Val() == -1 {
+				st.KeysWithoutTTL++
+				if r.enforceTTL && !slices.Contains(persistentKeys, strings.TrimPrefix(k, r.prefix)) {
+					if err := r.client.Expire(ctx, k, r.defaultTTL).Err(); err != nil {
+						r.logger.Warn("Failed to set expiry on Redis key", zap.String("key", k), zap.Error(err))
+					}
+				}
+			}
+		}
+
+		scanned += len(keys)
+		cursor = next
+		if cursor == 0 || (maxKeys > 0 && scanned >= maxKeys) {
+			break
+		}
+	}
+
+	return stats, nil
+}
+
+func categorize(key string) string {
+	head, _, _ := strings.Cut(key, ":")
+	for _, c := range keyCategories {
+		if head == c {
+			return c
+		}
+	}
+	return "other"
+}
+
+// StartKeyspaceScanner periodically scans the gateway keyspace and publishes
+// the results as metrics until ctx is cancelled.
+func (r *RedisClient) StartKeyspaceScanner(ctx context.Context, interval time.Duration, maxKeys int) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				scanCtx, cancel := context.WithTimeout(ctx, interval)
+				stats, err := r.ScanKeyspace(scanCtx, maxKeys)
+				cancel()
+				if err != nil {
+					r.logger.Warn("Redis keyspace scan failed", zap.Error(err))
+					continue
+				}
+				for category, st := range stats {
+					metrics.RedisKeys.WithLabelValues(category).Set(float64(st.Keys))
+					metrics.RedisMemoryBytes.WithLabelValues(category).Set(float64(st.MemoryBytes))
+					metrics.RedisKeysWithoutTTL.WithLabelValues(category).Set(float64(st.KeysWithoutTTL))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,307 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrLockNotAcquired is returned when the lock is held by someone else and
+	// could not be obtained before the context or retry budget ran out.
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	// ErrLockNotHeld is returned when refreshing or releasing a lock whose lease
+	// has already expired or been taken over by another holder.
+	ErrLockNotHeld = errors.New("lock not held")
+)
+
+// fenceRetention keeps fencing counters long after any lease using them could
+// still be alive, while still letting idle lock names expire.
+const fenceRetention = 7 * 24 * time.Hour
+
+// Acquire the lease and bump the fencing counter atomically so that every
+// successful holder observes a strictly larger token than the previous one.
+var acquireLockScript = redis.NewScript(`
+	if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+		local fence = redis.call("INCR", KEYS[2])
+		redis.call("EXPIRE", KEYS[2], ARGV[3])
+		return fence
+	end
+	return 0
+`)
+
+// Raise the fencing counter to the token a holder settled on across the
+// majority, so that the next majority, which shares an instance with this
+// one, counts on from it.
+var raiseFenceScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		if tonumber(redis.call("GET", KEYS[2]) or "0") < tonumber(ARGV[2]) then
+			redis.call("SET", KEYS[2], ARGV[2], "EX", ARGV[3])
+		end
+		return 1
+	end
+	return 0
+`)
+
+var refreshLockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+var releaseLockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// LockOptions controls how a distributed lock is obtained and held.
+type LockOptions struct {
+	// TTL is the lease duration. Defaults to 10s.
+	TTL time.Duration
+	// RetryInterval is the wait between acquisition attempts. Zero means a
+	// single attempt.
+	RetryInterval time.Duration
+	// AutoRenew extends the lease in the background at TTL/3 until released.
+	AutoRenew bool
+}
+
+// Lock is a lease on a named resource held on a majority of the lock
+// instances (Redlock). With a single instance it is a plain Redis lease.
+type Lock struct {
+	redis    *RedisClient
+	key      string
+	fenceKey string
+	token    string
+	fence    int64
+	ttl      time.Duration
+
+	stop     chan struct{}
+	lost     chan struct{}
+	stopOnce sync.Once
+	lostOnce sync.Once
+}
+
+// ObtainLock acquires the named lock, retrying until ctx is done when a retry
+// interval is configured. The returned lock carries a fencing token that
+// callers should pass to downstream writes to reject stale holders.
+//
+// The lease and its fencing counter share a hash tag ({name}), so the script
+// touching both runs on Redis Cluster.
+func (r *RedisClient) ObtainLock(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Second
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{
+		redis:    r,
+		key:      r.key("lock:{" + name + "}"),
+		fenceKey: r.key("lock:fence:{" + name + "}"),
+		token:    token,
+		ttl:      opts.TTL,
+		stop:     make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+
+	for {
+		held, err := lock.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if held {
+			if opts.AutoRenew {
+				go lock.renew()
+			}
+			return lock, nil
+		}
+
+		if opts.RetryInterval <= 0 {
+			return nil, ErrLockNotAcquired
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrLockNotAcquired
+		case <-time.After(opts.RetryInterval):
+		}
+	}
+}
+
+// acquire makes one attempt on every instance. The lease is held when a
+// majority granted it with time to spare after the attempt and clock drift;
+// otherwise whatever was granted is released again.
+func (l *Lock) acquire(ctx context.Context) (bool, error) {
+	start := time.Now()
+	var mu sync.Mutex
+	var fence int64
+	granted, err := l.redis.onLockInstances(ctx, l.ttl, func(ctx context.Context, c *redis.Client) (bool, error) {
+		n, err := acquireLockScript.Run(ctx, c, []string{l.key, l.fenceKey}, l.token, l.ttl.Milliseconds(), int(fenceRetention.Seconds())).Int64()
+		if err != nil || n == 0 {
+			return false, err
+		}
+		mu.Lock()
+		fence = max(fence, n)
+		mu.Unlock()
+		return true, nil
+	})
+
+	drift := l.ttl/100 + 2*time.Millisecond
+	if granted >= l.redis.lockQuorum() && time.Since(start)+drift < l.ttl {
+		if len(l.redis.locks) > 1 {
+			raised, err := l.redis.onLockInstances(ctx, l.ttl, func(ctx context.Context, c *redis.Client) (bool, error) {
+				n, err := raiseFenceScript.Run(ctx, c, []string{l.key, l.fenceKey}, l.token, fence, int(fenceRetention.Seconds())).Int64()
+				return n == 1, err
+			})
+			if raised < l.redis.lockQuorum() {
+				l.releaseAll(ctx)
+				return false, err
+			}
+		}
+		l.fence = fence
+		return true, nil
+	}
+
+	if granted > 0 {
+		l.releaseAll(ctx)
+	}
+	return false, err
+}
+
+// Fence returns the monotonically increasing fencing token for this lease.
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost is closed when background renewal fails and the lease can no longer
+// be assumed to be held.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Refresh extends the lease by the lock's TTL on every instance still
+// holding it; the lease stays held while a majority does.
+func (l *Lock) Refresh(ctx context.Context) error {
+	ok, err := l.redis.onLockInstances(ctx, l.ttl, func(ctx context.Context, c *redis.Client) (bool, error) {
+		n, err := refreshLockScript.Run(ctx, c, []string{l.key}, l.token, l.ttl.Milliseconds()).Int64()
+		return n == 1, err
+	})
+	if err != nil {
+		return err
+	}
+	if ok < l.redis.lockQuorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Release stops renewal and frees the lock on every instance still holding
+// it for this lease.
+func (l *Lock) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+
+	ok, err := l.releaseAll(ctx)
+	if err != nil {
+		return err
+	}
+	if ok < l.redis.lockQuorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) releaseAll(ctx context.Context) (int, error) {
+	return l.redis.onLockInstances(ctx, l.ttl, func(ctx context.Context, c *redis.Client) (bool, error) {
+		n, err := releaseLockScript.Run(ctx, c, []string{l.key}, l.token).Int64()
+		return n == 1, err
+	})
+}
+
+func (l *Lock) renew() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			err := l.Refresh(ctx)
+			cancel()
+			if err != nil {
+				l.redis.logger.Warn("Lost distributed lock lease", zap.String("key", l.key), zap.Error(err))
+				l.lostOnce.Do(func() { close(l.lost) })
+				return
+			}
+		}
+	}
+}
+
+// onLockInstances runs fn on every lock instance at once, each bounded by a
+// tenth of the lease so an unreachable instance cannot use it up. It returns
+// how many succeeded, and an error when failing instances alone, rather than
+// another holder, leave no majority.
+func (r *RedisClient) onLockInstances(ctx context.Context, ttl time.Duration, fn func(context.Context, *redis.Client) (bool, error)) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		ok       int
+		failed   int
+		firstErr error
+	)
+	for _, c := range r.locks {
+		wg.Add(1)
+		go func(c *redis.Client) {
+			defer wg.Done()
+			callCtx := ctx
+			if len(r.locks) > 1 {
+				var cancel context.CancelFunc
+				callCtx, cancel = context.WithTimeout(ctx, ttl/10)
+				defer cancel()
+			}
+			success, err := fn(callCtx, c)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			case success:
+				ok++
+			}
+		}(c)
+	}
+	wg.Wait()
+	if failed > len(r.locks)-r.lockQuorum() {
+		return ok, firstErr
+	}
+	return ok, nil
+}
+
+// lockQuorum is the majority of lock instances a lease must be held on.
+func (r *RedisClient) lockQuorum() int {
+	return len(r.locks)/2 + 1
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
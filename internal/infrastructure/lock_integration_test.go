@@ -0,0 +1,86 @@
+//go:build integration
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// Run against real Redis with
+//
+//	GATEWAY_TEST_REDIS_ADDR=localhost:6379 go test -tags integration ./internal/infrastructure/
+//
+// GATEWAY_TEST_REDIS_LOCK_ADDRS, a comma separated list of independent
+// instances, also runs the Redlock tests.
+func newIntegrationClient(t *testing.T, lockAddrs []string) *RedisClient {
+	t.Helper()
+	addr := os.Getenv("GATEWAY_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("GATEWAY_TEST_REDIS_ADDR not set")
+	}
+	cfg := &config.RedisConfig{
+		Address:       addr,
+		KeyPrefix:     "gw:integration:" + t.Name() + ":",
+		LockAddresses: lockAddrs,
+	}
+	client, err := NewRedisClient(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func testLockLifecycle(t *testing.T, client *RedisClient) {
+	ctx := context.Background()
+
+	first, err := client.ObtainLock(ctx, "job", LockOptions{TTL: 500 * time.Millisecond, AutoRenew: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Renewal keeps the lease past its TTL
+	time.Sleep(time.Second)
+	if _, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("second holder: err = %v, want ErrLockNotAcquired", err)
+	}
+	select {
+	case <-first.Lost():
+		t.Fatal("renewed lease reported lost")
+	default:
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := client.ObtainLock(ctx, "job", LockOptions{TTL: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Errorf("fence %d after %d, want it to increase", second.Fence(), first.Fence())
+	}
+	time.Sleep(300 * time.Millisecond)
+	if err := second.Refresh(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("refresh after expiry: err = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestIntegrationLock(t *testing.T) {
+	testLockLifecycle(t, newIntegrationClient(t, nil))
+}
+
+func TestIntegrationRedlock(t *testing.T) {
+	addrs := os.Getenv("GATEWAY_TEST_REDIS_LOCK_ADDRS")
+	if addrs == "" {
+		t.Skip("GATEWAY_TEST_REDIS_LOCK_ADDRS not set")
+	}
+	testLockLifecycle(t, newIntegrationClient(t, strings.Split(addrs, ",")))
+}
@@ -0,0 +1,175 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/banking/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// newLockTestClient connects to a fresh miniredis, taking locks on the
+// given instances when there are any.
+func newLockTestClient(t *testing.T, lockInstances ...*miniredis.Miniredis) (*RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := &config.RedisConfig{Address: mr.Addr(), KeyPrefix: "gw:test:"}
+	for _, m := range lockInstances {
+		cfg.LockAddresses = append(cfg.LockAddresses, m.Addr())
+	}
+	client, err := NewRedisClient(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
+
+func TestLockIsExclusiveWithIncreasingFence(t *testing.T) {
+	client, _ := newLockTestClient(t)
+	ctx := context.Background()
+
+	first, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("second holder: err = %v, want ErrLockNotAcquired", err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Errorf("fence %d after %d, want it to increase", second.Fence(), first.Fence())
+	}
+}
+
+func TestLockKeysShareHashSlot(t *testing.T) {
+	client, _ := newLockTestClient(t)
+	lock, err := client.ObtainLock(context.Background(), "job", LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{lock.key, lock.fenceKey} {
+		if !strings.Contains(key, "{job}") || strings.Index(key, "{") != strings.Index(key, "{job}") {
+			t.Errorf("key %q does not hash on {job}", key)
+		}
+	}
+}
+
+func TestLockExpiresWithoutRefresh(t *testing.T) {
+	client, mr := newLockTestClient(t)
+	ctx := context.Background()
+
+	lock, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Refresh(ctx); err != nil {
+		t.Fatalf("refresh while held: %v", err)
+	}
+	mr.FastForward(2 * time.Second)
+	if err := lock.Refresh(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("refresh after expiry: err = %v, want ErrLockNotHeld", err)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("release after expiry: err = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestLockRetriesUntilReleased(t *testing.T) {
+	client, _ := newLockTestClient(t)
+	ctx := context.Background()
+
+	first, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { first.Release(ctx) })
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := client.ObtainLock(waitCtx, "job", LockOptions{TTL: time.Second, RetryInterval: 10 * time.Millisecond}); err != nil {
+		t.Errorf("obtain after release: %v", err)
+	}
+}
+
+func TestRedlockNeedsMajority(t *testing.T) {
+	a, b, c := miniredis.RunT(t), miniredis.RunT(t), miniredis.RunT(t)
+	client, _ := newLockTestClient(t, a, b, c)
+	ctx := context.Background()
+
+	// Another holder on one instance does not keep a majority from locking
+	a.Set("gw:test:lock:{job}", "someone-else")
+	lock, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatalf("obtain with a majority free: %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Held on two of three, nobody else gets it
+	b.Set("gw:test:lock:{job}", "someone-else")
+	if _, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("obtain without a majority: err = %v, want ErrLockNotAcquired", err)
+	}
+	if c.Exists("gw:test:lock:{job}") {
+		t.Error("lease granted by a minority was not released")
+	}
+}
+
+func TestRedlockSurvivesMinorityFailure(t *testing.T) {
+	a, b, c := miniredis.RunT(t), miniredis.RunT(t), miniredis.RunT(t)
+	client, _ := newLockTestClient(t, a, b, c)
+	ctx := context.Background()
+
+	a.Close()
+	lock, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatalf("obtain with one instance down: %v", err)
+	}
+	if err := lock.Refresh(ctx); err != nil {
+		t.Errorf("refresh with one instance down: %v", err)
+	}
+
+	b.Close()
+	if err := lock.Refresh(ctx); err == nil || errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("refresh with a majority down: err = %v, want the connection error", err)
+	}
+}
+
+func TestRedlockFenceIncreasesAcrossMajorities(t *testing.T) {
+	a, b, c := miniredis.RunT(t), miniredis.RunT(t), miniredis.RunT(t)
+	client, _ := newLockTestClient(t, a, b, c)
+	ctx := context.Background()
+
+	// Counters drift apart when instances miss acquisitions
+	a.Set("gw:test:lock:fence:{job}", "5")
+	first, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next majority leaves out the instance that set the first token
+	a.Close()
+	second, err := client.ObtainLock(ctx, "job", LockOptions{TTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Errorf("fence %d after %d, want it to increase", second.Fence(), first.Fence())
+	}
+}
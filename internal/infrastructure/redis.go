@@ -2,47 +2,171 @@ package infrastructure
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/banking/api-gateway/internal/metrics"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+// RedisClient talks to Redis through two connection pools: writes (INCR,
+// blacklisting, streams, locks) use client, lookups (blacklist checks, cache
+// and counter reads) use reader, so a burst of rate-limit writes cannot
+// starve cache reads. reader may point at a replica.
 type RedisClient struct {
-	client *redis.Client
-	logger *zap.Logger
+	client     *redis.Client
+	reader     *redis.Client
+	logger     *zap.Logger
+	prefix     string
+	defaultTTL time.Duration
+	enforceTTL bool
+	// locks are the instances distributed locks are taken on: client, or
+	// the independent instances of redis.lock_addresses.
+	locks []*redis.Client
 }
 
 func NewRedisClient(cfg *config.RedisConfig, logger *zap.Logger) (*RedisClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Address,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+	tlsConfig, err := redisTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := newRedisPool(cfg, cfg.Address, cfg.WritePool, tlsConfig)
+	readAddress := cfg.Address
+	if cfg.ReplicaAddress != "" {
+		readAddress = cfg.ReplicaAddress
+	}
+	reader := newRedisPool(cfg, readAddress, cfg.ReadPool, tlsConfig)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		logger.Error("Failed to connect to Redis", zap.Error(err))
-		return nil, err
+	for _, c := range []*redis.Client{client, reader} {
+		if err := c.Ping(ctx).Err(); err != nil {
+			logger.Error("Failed to connect to Redis", zap.String("address", c.Options().Addr), zap.Error(err))
+			client.Close()
+			reader.Close()
+			return nil, err
+		}
 	}
+	watchRedisPool("write", client)
+	watchRedisPool("read", reader)
 
-	logger.Info("Redis connection established", zap.String("address", cfg.Address))
+	logger.Info("Redis connection established",
+		zap.String("address", cfg.Address),
+		zap.String("read_address", readAddress),
+		zap.Bool("tls", cfg.TLS),
+		zap.String("username", cfg.Username),
+	)
+
+	// Lock instances are not pinged: a lock only needs a majority of them
+	locks := []*redis.Client{client}
+	if len(cfg.LockAddresses) > 0 {
+		locks = make([]*redis.Client, len(cfg.LockAddresses))
+		for i, addr := range cfg.LockAddresses {
+			locks[i] = newRedisPool(cfg, addr, cfg.WritePool, tlsConfig)
+		}
+	}
 
 	return &RedisClient{
-		client: client,
-		logger: logger,
+		client:     client,
+		reader:     reader,
+		logger:     logger,
+		prefix:     cfg.KeyPrefix,
+		defaultTTL: cfg.DefaultKeyTTL,
+		enforceTTL: cfg.EnforceKeyTTL && cfg.KeyPrefix != "",
+		locks:      locks,
 	}, nil
 }
 
+// redisTLSConfig returns the TLS settings for cfg, or nil without TLS.
+func redisTLSConfig(cfg *config.RedisConfig) (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsConfig := fips.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.ServerName})
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("redis: read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis: no certificates in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("redis: load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newRedisPool connects to addr with one pool's sizing; zero values keep the
+// go-redis defaults.
+func newRedisPool(cfg *config.RedisConfig, addr string, pool config.RedisPoolConfig, tlsConfig *tls.Config) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		TLSConfig:    tlsConfig,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		PoolSize:     pool.Size,
+		MinIdleConns: pool.MinIdle,
+		PoolTimeout:  pool.Timeout,
+	})
+	client.AddHook(metricsHook{})
+	return client
+}
+
+func watchRedisPool(name string, client *redis.Client) {
+	metrics.WatchRedisPool(name, func() metrics.RedisPoolStats {
+		st := client.PoolStats()
+		return metrics.RedisPoolStats{
+			Hits:     st.Hits,
+			Misses:   st.Misses,
+			Timeouts: st.Timeouts,
+			Total:    st.TotalConns,
+			Idle:     st.IdleConns,
+			Stale:    st.StaleConns,
+		}
+	})
+}
+
+// key namespaces a gateway key with the configured environment/tenant prefix.
+func (r *RedisClient) key(k string) string {
+	return r.prefix + k
+}
+
+// expiry returns ttl, or the default key TTL when the caller did not provide
+// one, so that no gateway-created key lives forever.
+func (r *RedisClient) expiry(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return r.defaultTTL
+	}
+	return ttl
+}
+
 // IncrementWithExpiry increments a key and sets expiry ONLY if it's the new key (count == 1).
 // This ensures a fixed window rate limiting strategy.
 func (r *RedisClient) IncrementWithExpiry(ctx context.Context, key string, window time.Duration) (int64, error) {
 	script := `
 		local current = redis.call("INCR", KEYS[1])
-		if current == 1 then
+		if current == 1 or redis.call("TTL", KEYS[1]) == -1 then
 			redis.call("EXPIRE", KEYS[1], ARGV[1])
 		end
 		return current
@@ -53,7 +177,7 @@ func (r *RedisClient) IncrementWithExpiry(ctx context.Context, key string, windo
 		seconds = 1 // Ensure at least 1 second if window is very small
 	}
 
-	result, err := r.client.Eval(ctx, script, []string{key}, seconds).Int64()
+	result, err := r.client.Eval(ctx, script, []string{r.key(key)}, seconds).Int64()
 	if err != nil {
 		return 0, err
 	}
@@ -61,9 +185,52 @@ func (r *RedisClient) IncrementWithExpiry(ctx context.Context, key string, windo
 	return result, nil
 }
 
+// TokenBucket is the outcome of taking a token from a GCRA bucket.
+type TokenBucket struct {
+	Allowed bool
+	// Remaining is how many more requests the bucket would allow right now.
+	Remaining int64
+	// RetryAfter is how long until a rejected request would be allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// TakeToken applies the generic cell rate algorithm: the key holds the
+// theoretical arrival time (TAT) of the next request in milliseconds on the
+// Redis clock. A request is allowed while TAT is at most (burst-1) intervals
+// ahead of now, and moves TAT one interval on. Rejections change nothing.
+func (r *RedisClient) TakeToken(ctx context.Context, key string, interval time.Duration, burst int64) (TokenBucket, error) {
+	script := `
+		local t = redis.call("TIME")
+		local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+		local interval = tonumber(ARGV[1])
+		local burst = tonumber(ARGV[2])
+		local tat = math.max(tonumber(redis.call("GET", KEYS[1]) or now), now)
+		local allow_at = tat + interval - interval * burst
+		if now < allow_at then
+			return {0, 0, allow_at - now, tat - now}
+		end
+		tat = tat + interval
+		redis.call("SET", KEYS[1], tat, "PX", tat - now)
+		return {1, math.floor((now - (tat - interval * burst)) / interval), 0, tat - now}
+	`
+	ms := max(interval.Milliseconds(), 1)
+	res, err := r.client.Eval(ctx, script, []string{r.key(key)}, ms, burst).Int64Slice()
+	if err != nil {
+		return TokenBucket{}, err
+	}
+	return TokenBucket{
+		Allowed:    res[0] == 1,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		ResetAfter: time.Duration(res[3]) * time.Millisecond,
+	}, nil
+}
+
 // GetCount returns the current count for a key.
 func (r *RedisClient) GetCount(ctx context.Context, key string) (int64, error) {
-	val, err := r.client.Get(ctx, key).Int64()
+	val, err := r.reader.Get(ctx, r.key(key)).Int64()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -72,12 +239,166 @@ func (r *RedisClient) GetCount(ctx context.Context, key string) (int64, error) {
 
 // TTL returns the remaining time-to-live for a key.
 func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
-	return r.client.TTL(ctx, key).Result()
+	return r.reader.TTL(ctx, r.key(key)).Result()
+}
+
+// TouchFirstSeen records now as the first-seen time for a client if none is
+// stored yet and returns the stored value. Every touch extends the marker's
+// retention, so it only lapses once the client has been away that long.
+func (r *RedisClient) TouchFirstSeen(ctx context.Context, identity string, retention time.Duration) (time.Time, error) {
+	script := `
+		redis.call("SET", KEYS[1], ARGV[1], "NX")
+		redis.call("EXPIRE", KEYS[1], ARGV[2])
+		return redis.call("GET", KEYS[1])
+	`
+	now := time.Now().Unix()
+	result, err := r.client.Eval(ctx, script, []string{r.key("client:firstseen:" + identity)}, now, int(r.expiry(retention).Seconds())).Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(result, 0), nil
+}
+
+// ForgetFirstSeen removes the first-seen times recorded under the given
+// identities, reporting how many there were.
+func (r *RedisClient) ForgetFirstSeen(ctx context.Context, identities ...string) (int, error) {
+	keys := make([]string, len(identities))
+	for i, identity := range identities {
+		keys[i] = r.key("client:firstseen:" + identity)
+	}
+	n, err := r.client.Del(ctx, keys...).Result()
+	return int(n), err
+}
+
+// AcquireLease adds lease id to a sorted set of live leases when fewer than
+// limit are held, returning whether it was granted and the number held.
+// Leases expire after ttl on the Redis clock, so a crashed holder cannot
+// leak capacity.
+func (r *RedisClient) AcquireLease(ctx context.Context, key, id string, limit int, ttl time.Duration) (bool, int64, error) {
+	script := `
+		local t = redis.call("TIME")
+		local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+		redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+		local held = redis.call("ZCARD", KEYS[1])
+		if held >= tonumber(ARGV[2]) then
+			return {0, held}
+		end
+		redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+		redis.call("PEXPIRE", KEYS[1], ARGV[3])
+		return {1, held + 1}
+	`
+	res, err := r.client.Eval(ctx, script, []string{r.key(key)}, id, limit, r.expiry(ttl).Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, res[1], nil
+}
+
+// RenewLease extends a lease taken with AcquireLease by ttl, reporting false
+// when it had already expired.
+func (r *RedisClient) RenewLease(ctx context.Context, key, id string, ttl time.Duration) (bool, error) {
+	script := `
+		if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+			return 0
+		end
+		local t = redis.call("TIME")
+		local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+		redis.call("ZADD", KEYS[1], "XX", now + tonumber(ARGV[2]), ARGV[1])
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		return 1
+	`
+	renewed, err := r.client.Eval(ctx, script, []string{r.key(key)}, id, r.expiry(ttl).Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// ReleaseLease removes a lease taken with AcquireLease.
+func (r *RedisClient) ReleaseLease(ctx context.Context, key, id string) error {
+	return r.client.ZRem(ctx, r.key(key), id).Err()
+}
+
+// IncrementHashFields adds each delta to its hash field in a single pipeline
+// and refreshes the hash expiry.
+func (r *RedisClient) IncrementHashFields(ctx context.Context, key string, deltas map[string]int64, ttl time.Duration) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	key = r.key(key)
+	pipe := r.client.Pipeline()
+	for field, delta := range deltas {
+		pipe.HIncrBy(ctx, key, field, delta)
+	}
+	pipe.Expire(ctx, key, r.expiry(ttl))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetHashCounts returns all fields of a counter hash.
+func (r *RedisClient) GetHashCounts(ctx context.Context, key string) (map[string]int64, error) {
+	raw, err := r.reader.HGetAll(ctx, r.key(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(raw))
+	for field, v := range raw {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[field] = n
+	}
+	return counts, nil
+}
+
+// GetHash returns all fields of a hash.
+func (r *RedisClient) GetHash(ctx context.Context, key string) (map[string]string, error) {
+	return r.reader.HGetAll(ctx, r.key(key)).Result()
+}
+
+// SetHashField sets one hash field and refreshes the hash expiry.
+func (r *RedisClient) SetHashField(ctx context.Context, key, field, value string, ttl time.Duration) error {
+	key = r.key(key)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, field, value)
+	pipe.Expire(ctx, key, r.expiry(ttl))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetPersistentHashField sets one hash field and removes any expiry from the
+// hash, for operator decisions that must hold until an operator reverts them.
+func (r *RedisClient) SetPersistentHashField(ctx context.Context, key, field, value string) error {
+	key = r.key(key)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, field, value)
+	pipe.Persist(ctx, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeleteHashField removes one hash field.
+func (r *RedisClient) DeleteHashField(ctx context.Context, key, field string) error {
+	return r.client.HDel(ctx, r.key(key), field).Err()
+}
+
+// TakeHashField removes one hash field and returns its value, or "" if it
+// was not set.
+func (r *RedisClient) TakeHashField(ctx context.Context, key, field string) (string, error) {
+	key = r.key(key)
+	pipe := r.client.TxPipeline()
+	get := pipe.HGet(ctx, key, field)
+	pipe.HDel(ctx, key, field)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	return get.Val(), nil
 }
 
 // IsTokenBlacklisted checks if a token (JTI or full token hash) is in the blacklist.
 func (r *RedisClient) IsTokenBlacklisted(ctx context.Context, tokenIdentifier string) (bool, error) {
-	exists, err := r.client.Exists(ctx, "blacklist:"+tokenIdentifier).Result()
+	exists, err := r.reader.Exists(ctx, r.key("blacklist:"+tokenIdentifier)).Result()
 	if err != nil {
 		return false, err
 	}
@@ -86,15 +407,117 @@ func (r *RedisClient) IsTokenBlacklisted(ctx context.Context, tokenIdentifier st
 
 // BlacklistToken adds a token to the blacklist with an expiration.
 func (r *RedisClient) BlacklistToken(ctx context.Context, tokenIdentifier string, duration time.Duration) error {
-	return r.client.Set(ctx, "blacklist:"+tokenIdentifier, "revoked", duration).Err()
+	return r.client.Set(ctx, r.key("blacklist:"+tokenIdentifier), "revoked", r.expiry(duration)).Err()
 }
 
-// Close closes the Redis connection.
+// Publish sends a message on a pub/sub channel. Channels are shared with
+// other services and are not prefixed.
+func (r *RedisClient) Publish(ctx context.Context, channel, message string) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe calls handle for every message on channel until ctx is
+// cancelled, reconnecting as needed.
+func (r *RedisClient) Subscribe(ctx context.Context, channel string, handle func(payload string)) {
+	sub := r.client.Subscribe(ctx, channel)
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				handle(msg.Payload)
+			}
+		}
+	}()
+}
+
+// SetValue stores a string value with an expiration.
+func (r *RedisClient) SetValue(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.key(key), value, r.expiry(ttl)).Err()
+}
+
+// GetValue returns a string value, or "" if the key does not exist.
+func (r *RedisClient) GetValue(ctx context.Context, key string) (string, error) {
+	val, err := r.reader.Get(ctx, r.key(key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+// Expire refreshes the expiry of an existing key.
+func (r *RedisClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.client.Expire(ctx, r.key(key), r.expiry(ttl)).Err()
+}
+
+// DeleteKey removes a key.
+func (r *RedisClient) DeleteKey(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.key(key)).Err()
+}
+
+// DeleteMatching removes every key matching a glob pattern and returns how
+// many it removed. It scans the keyspace, so it is for admin operations only.
+func (r *RedisClient) DeleteMatching(ctx context.Context, pattern string) (int, error) {
+	deleted := 0
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, r.key(pattern), 500).Result()
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) > 0 {
+			n, err := r.client.Del(ctx, keys...).Result()
+			deleted += int(n)
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// StreamEntry is one entry read from a Redis stream.
+type StreamEntry struct {
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// ReadStream returns up to count entries of a stream, oldest first.
+func (r *RedisClient) ReadStream(ctx context.Context, key string, count int64) ([]StreamEntry, error) {
+	msgs, err := r.reader.XRangeN(ctx, r.key(key), "-", "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, 0, len(msgs))
+	for _, m := range msgs {
+		entries = append(entries, StreamEntry{ID: m.ID, Values: m.Values})
+	}
+	return entries, nil
+}
+
+// Close closes both connection pools.
 func (r *RedisClient) Close() error {
-	return r.client.Close()
+	errs := []error{r.client.Close(), r.reader.Close()}
+	for _, l := range r.locks {
+		if l != r.client {
+			errs = append(errs, l.Close())
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// HealthCheck pings Redis to check connection health.
+// HealthCheck pings Redis through both pools to check connection health.
 func (r *RedisClient) HealthCheck(ctx context.Context) error {
-	return r.client.Ping(ctx).Err()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	return r.reader.Ping(ctx).Err()
 }
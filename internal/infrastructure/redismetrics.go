@@ -0,0 +1,55 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// metricsHook times every Redis command and pipeline, and counts the
+// connections opened.
+type metricsHook struct{}
+
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.RedisDials.WithLabelValues(result).Inc()
+		return conn, err
+	}
+}
+
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeRedis(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeRedis("pipeline", start, err)
+		return err
+	}
+}
+
+func observeRedis(command string, start time.Time, err error) {
+	result := "ok"
+	if err != nil && !errors.Is(err, redis.Nil) {
+		result = "error"
+	}
+	metrics.RedisCommandDuration.WithLabelValues(command, result).Observe(time.Since(start).Seconds())
+}
+
+var _ redis.Hook = metricsHook{}
@@ -0,0 +1,130 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// RequestLog keeps the events of each request in a capped per-request Redis
+// stream (reqlog:<id>) that expires after the retention window. Entries are
+// captured from the event store's log core.
+type RequestLog struct {
+	store *EventStore
+	cfg   config.RequestLogConfig
+}
+
+// NewRequestLog enables per-request capture on store.
+func NewRequestLog(store *EventStore, cfg config.RequestLogConfig) *RequestLog {
+	store.requestLog = &cfg
+	return &RequestLog{store: store, cfg: cfg}
+}
+
+func requestLogKey(requestID string) string {
+	return "reqlog:" + requestID
+}
+
+// redactedField marks the events of a request log that has been redacted.
+const redactedField = "redacted"
+
+// Rewrite a request log with the events given, unless an event was added
+// since they were read, keeping its expiry.
+var rewriteLogScript = redis.NewScript(`
+	local last = redis.call("XREVRANGE", KEYS[1], "+", "-", "COUNT", 1)
+	if #last == 0 or last[1][1] ~= ARGV[1] then
+		return 0
+	end
+	local ttl = redis.call("PTTL", KEYS[1])
+	redis.call("DEL", KEYS[1])
+	for i = 2, #ARGV, 2 do
+		redis.call("XADD", KEYS[1], ARGV[i], unpack(cjson.decode(ARGV[i + 1])))
+	end
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[1], ttl)
+	end
+	return 1
+`)
+
+// RequestLogInfo describes the stored log of one request.
+type RequestLogInfo struct {
+	RequestID string
+	// Oldest is the time of its first event.
+	Oldest   time.Time
+	Redacted bool
+}
+
+// Events returns the recorded events of a request, oldest first.
+func (l *RequestLog) Events(ctx context.Context, requestID string) ([]StreamEntry, error) {
+	return l.store.redis.ReadStream(ctx, requestLogKey(requestID), l.cfg.MaxEvents)
+}
+
+// Forget removes the recorded events of the given requests and returns how
+// many requests had any.
+func (l *RequestLog) Forget(ctx context.Context, requestIDs ...string) (int64, error) {
+	if len(requestIDs) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, len(requestIDs))
+	for i, id := range requestIDs {
+		keys[i] = l.store.redis.key(requestLogKey(id))
+	}
+	return l.store.redis.client.Del(ctx, keys...).Result()
+}
+
+// Walk calls visit with every stored request log.
+func (l *RequestLog) Walk(ctx context.Context, visit func(RequestLogInfo)) error {
+	prefix := l.store.redis.key(requestLogKey(""))
+	var cursor uint64
+	for {
+		keys, next, err := l.store.redis.reader.Scan(ctx, cursor, prefix+"*", 500).Result()
+		if err != nil {
+			return err
+		}
+		pipe := l.store.redis.reader.Pipeline()
+		firsts := make([]*redis.XMessageSliceCmd, len(keys))
+		for i, k := range keys {
+			firsts[i] = pipe.XRangeN(ctx, k, "-", "+", 1)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for i, k := range keys {
+			msgs := firsts[i].Val()
+			if len(msgs) == 0 {
+				continue
+			}
+			_, redacted := msgs[0].Values[redactedField]
+			visit(RequestLogInfo{
+				RequestID: strings.TrimPrefix(k, prefix),
+				Oldest:    EntryTime(msgs[0].ID),
+				Redacted:  redacted,
+			})
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Redact rewrites the events of a request after redact has changed their
+// values, marking them redacted. It reports false when the log is gone or
+// received an event meanwhile, to be retried.
+func (l *RequestLog) Redact(ctx context.Context, requestID string, redact func(map[string]interface{})) (bool, error) {
+	key := l.store.redis.key(requestLogKey(requestID))
+	msgs, err := l.store.redis.client.XRange(ctx, key, "-", "+").Result()
+	if err != nil || len(msgs) == 0 {
+		return false, err
+	}
+	args := []interface{}{msgs[len(msgs)-1].ID}
+	for _, m := range msgs {
+		redact(m.Values)
+		m.Values[redactedField] = "true"
+		args = append(args, m.ID, scriptValues(m.Values))
+	}
+	done, err := rewriteLogScript.Run(ctx, l.store.redis.client, []string{key}, args...).Int()
+	return done == 1, err
+}
@@ -0,0 +1,131 @@
+package jsonutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// decimalPattern is a JSON number, optionally given as a string. Exponents
+// are limited to three digits so a hostile "1e999999999" cannot make exact
+// arithmetic allocate without bound.
+var decimalPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]{1,3})?$`)
+
+// ErrInvalidDecimal is returned for text that is not a decimal number.
+var ErrInvalidDecimal = errors.New("invalid decimal")
+
+// Decimal is an exact decimal number for amounts. It accepts a JSON number or
+// a numeric string and marshals back to the same text, so a value passing
+// through the gateway is never rounded.
+type Decimal struct {
+	text string
+}
+
+// ParseDecimal validates s as a decimal number.
+func ParseDecimal(s string) (Decimal, error) {
+	if !decimalPattern.MatchString(s) {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	return Decimal{text: s}, nil
+}
+
+// DecimalFromRat formats r with exactly scale fractional digits; it fails if
+// r does not fit that scale exactly.
+func DecimalFromRat(r *big.Rat, scale int) (Decimal, error) {
+	s := r.FloatString(scale)
+	if check, _ := new(big.Rat).SetString(s); check.Cmp(r) != 0 {
+		return Decimal{}, errors.New("decimal: value needs more than the allowed fractional digits")
+	}
+	return Decimal{text: s}, nil
+}
+
+// String returns the number exactly as it was given.
+func (d Decimal) String() string {
+	return d.text
+}
+
+// IsZero reports whether d was never set.
+func (d Decimal) IsZero() bool {
+	return d.text == ""
+}
+
+// Rat returns the exact value.
+func (d Decimal) Rat() *big.Rat {
+	r, ok := new(big.Rat).SetString(d.text)
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// Cmp compares d and other by value, so "1.50" equals "1.5".
+func (d Decimal) Cmp(other Decimal) int {
+	return d.Rat().Cmp(other.Rat())
+}
+
+// Scale is the number of fractional digits, trailing zeros included: 2 for
+// "10.50", 1 for "1.05e1".
+func (d Decimal) Scale() int {
+	mantissa, exp, _ := strings.Cut(strings.ToLower(d.text), "e")
+	scale := 0
+	if _, frac, ok := strings.Cut(mantissa, "."); ok {
+		scale = len(frac)
+	}
+	if exp != "" {
+		n, _ := strconv.Atoi(exp)
+		scale -= n
+	}
+	return max(scale, 0)
+}
+
+// MinorUnits returns d scaled by 10^exponent (e.g. cents for exponent 2). It
+// fails when d has more fractional digits than the exponent allows.
+func (d Decimal) MinorUnits(exponent int) (*big.Int, error) {
+	scaled := d.Rat()
+	scaled.Mul(scaled, new(big.Rat).SetInt(pow10(exponent)))
+	if !scaled.IsInt() {
+		return nil, fmt.Errorf("%s has more than %d fractional digits", d.text, exponent)
+	}
+	return scaled.Num(), nil
+}
+
+// DecimalFromMinorUnits is the inverse of MinorUnits, written with exactly
+// exponent fractional digits.
+func DecimalFromMinorUnits(units *big.Int, exponent int) Decimal {
+	r := new(big.Rat).SetFrac(units, pow10(exponent))
+	return Decimal{text: r.FloatString(exponent)}
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d.text == "" {
+		return []byte("null"), nil
+	}
+	return []byte(d.text), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	text := string(data)
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := ParseDecimal(text)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
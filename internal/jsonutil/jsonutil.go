@@ -0,0 +1,76 @@
+// Package jsonutil is the gateway's JSON handling for request and response
+// bodies. Numbers are never routed through float64, so monetary amounts such
+// as 12345678901234567.89 reach backends exactly as the client sent them.
+package jsonutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrTrailingData is returned for a body holding more than one JSON value.
+var ErrTrailingData = errors.New("unexpected data after JSON value")
+
+// Decode reads a single JSON value into v. Numbers in interface{} values
+// decode as json.Number, keeping their exact text.
+func Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// Unmarshal is Decode for a byte slice.
+func Unmarshal(data []byte, v interface{}) error {
+	return Decode(bytes.NewReader(data), v)
+}
+
+// Marshal encodes v canonically: object keys sorted, no insignificant
+// whitespace, no HTML escaping and numbers written exactly as decoded.
+// Unlike RFC 8785, numbers are not reformatted as IEEE 754 doubles, which
+// would round large amounts.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Serializer is the Echo JSON serializer: c.Bind decodes numbers without
+// float64 rounding, and c.JSON writes canonical JSON.
+type Serializer struct{}
+
+func (Serializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	enc := json.NewEncoder(c.Response())
+	enc.SetEscapeHTML(false)
+	if indent != "" {
+		enc.SetIndent("", indent)
+	}
+	return enc.Encode(i)
+}
+
+func (Serializer) Deserialize(c echo.Context, i interface{}) error {
+	err := Decode(c.Request().Body, i)
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		return echo.NewHTTPError(http.StatusBadRequest, "Unmarshal type error: expected="+typeErr.Type.String()+", got="+typeErr.Value+", field="+typeErr.Field).SetInternal(err)
+	case errors.As(err, &syntaxErr), errors.Is(err, ErrTrailingData):
+		return echo.NewHTTPError(http.StatusBadRequest, "Syntax error: "+err.Error()).SetInternal(err)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+// Package mesh secures forwarding between gateways with mutual TLS. Each
+// gateway is identified by the SPIFFE ID in its certificate (an X.509 SVID)
+// rather than a host name, so gateways in other regions are trusted for who
+// they are, wherever they run.
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+)
+
+const (
+	// PathHeader lists the SPIFFE IDs of the gateways a request went
+	// through, oldest first. It is only trusted on requests from peers.
+	PathHeader = "X-Gateway-Path"
+	// ClientCertHeader attests the peer a request came from to upstreams,
+	// in Envoy's X-Forwarded-Client-Cert format.
+	ClientCertHeader = "X-Forwarded-Client-Cert"
+)
+
+// Peer is a gateway whose certificate was verified on a mesh connection.
+type Peer struct {
+	ID string
+	// CertHash is the hex SHA-256 of its certificate.
+	CertHash string
+}
+
+type peerKey struct{}
+
+// WithPeer marks a context as serving a request from peer.
+func WithPeer(ctx context.Context, peer Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFrom returns the peer gateway a request came from over the mesh.
+func PeerFrom(r *http.Request) (Peer, bool) {
+	peer, ok := r.Context().Value(peerKey{}).(Peer)
+	return peer, ok
+}
+
+// Path returns the gateways listed in a request's PathHeader.
+func Path(h http.Header) []string {
+	var ids []string
+	for _, v := range h.Values(PathHeader) {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// SPIFFEID returns the SPIFFE ID in a certificate's URI SANs, or "".
+func SPIFFEID(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// material is a loaded SVID and trust bundle, with the file modification
+// times it was loaded at.
+type material struct {
+	cert    tls.Certificate
+	roots   *x509.CertPool
+	modTime [3]time.Time
+}
+
+// TLS provides the mesh's TLS settings for both ends of a connection.
+type TLS struct {
+	cfg config.MeshConfig
+
+	mu      sync.Mutex
+	current *material
+}
+
+// NewTLS loads the gateway's SVID and trust bundle and checks that the
+// certificate carries the configured SPIFFE ID.
+func NewTLS(cfg config.MeshConfig) (*TLS, error) {
+	t := &TLS{cfg: cfg}
+	m, err := t.material()
+	if err != nil {
+		return nil, err
+	}
+	if id := SPIFFEID(m.cert.Leaf); id != cfg.SPIFFEID {
+		return nil, fmt.Errorf("federation.mesh: certificate has SPIFFE ID %q, not %q", id, cfg.SPIFFEID)
+	}
+	return t, nil
+}
+
+// ID returns this gateway's SPIFFE ID.
+func (t *TLS) ID() string {
+	return t.cfg.SPIFFEID
+}
+
+// material returns the SVID and trust bundle, reloading them when a file
+// changed since they were read.
+func (t *TLS) material() (*material, error) {
+	var modTime [3]time.Time
+	for i, file := range []string{t.cfg.CertFile, t.cfg.KeyFile, t.cfg.CAFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("federation.mesh: %w", err)
+		}
+		modTime[i] = info.ModTime()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil && t.current.modTime == modTime {
+		return t.current, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("federation.mesh: %w", err)
+	}
+	bundle, err := os.ReadFile(t.cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("federation.mesh: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("federation.mesh: ca_file holds no PEM certificates")
+	}
+	t.current = &material{cert: cert, roots: roots, modTime: modTime}
+	return t.current, nil
+}
+
+// verify checks that the other end's certificate chains to the trust bundle
+// and carries the SPIFFE ID of a peer. Host names play no part.
+func (t *TLS) verify(state tls.ConnectionState, usage x509.ExtKeyUsage) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	m, err := t.material()
+	if err != nil {
+		return err
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: m.roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+		return err
+	}
+	if id := SPIFFEID(leaf); !slices.Contains(t.cfg.PeerIDs, id) {
+		return fmt.Errorf("SPIFFE ID %q is not a mesh peer", id)
+	}
+	return nil
+}
+
+// ServerConfig is the TLS configuration of the mesh port: peers must
+// present a certificate of one of peer_ids.
+func (t *TLS) ServerConfig() *tls.Config {
+	return fips.TLSConfig(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			m, err := t.material()
+			if err != nil {
+				return nil, err
+			}
+			return &m.cert, nil
+		},
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return t.verify(state, x509.ExtKeyUsageClientAuth)
+		},
+	})
+}
+
+// ClientConfig is the TLS configuration for forwarding to a peer: the peer
+// must present a certificate of one of peer_ids. Standard verification is
+// replaced, as SVIDs name no host.
+func (t *TLS) ClientConfig() *tls.Config {
+	return fips.TLSConfig(&tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			m, err := t.material()
+			if err != nil {
+				return nil, err
+			}
+			return &m.cert, nil
+		},
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return t.verify(state, x509.ExtKeyUsageServerAuth)
+		},
+	})
+}
+
+// PeerOf returns the verified peer of a mesh connection.
+func PeerOf(state *tls.ConnectionState) (Peer, bool) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return Peer{}, false
+	}
+	leaf := state.PeerCertificates[0]
+	sum := sha256.Sum256(leaf.Raw)
+	return Peer{ID: SPIFFEID(leaf), CertHash: hex.EncodeToString(sum[:])}, true
+}
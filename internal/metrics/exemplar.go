@@ -0,0 +1,14 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// ObserveWithTrace records v, attaching traceID as an exemplar when set so a
+// latency bucket links to an example trace. Exemplars are only exposed in
+// the OpenMetrics format.
+func ObserveWithTrace(o prometheus.Observer, v float64, traceID string) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && traceID != "" {
+		eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,474 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "gateway"
+
+// Registry holds every gateway collector plus Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Always 1; labels carry the version and build metadata of the running gateway.",
+	}, []string{"version", "commit", "build_date", "go_version"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve requests, by route pattern, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"route", "method", "code"})
+
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Time from forwarding a request to a service until its response completed, by service and status code (\"error\" for transport failures).",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"service", "code"})
+
+	UpstreamConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "connections",
+		Help:      "Upstream connections per service, by state (open, or idle in the pool).",
+	}, []string{"service", "state"})
+
+	UpstreamConnectionsOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "connections_opened_total",
+		Help:      "Upstream connections dialed per service, by result (ok or error).",
+	}, []string{"service", "result"})
+
+	UpstreamDialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "dial_duration_seconds",
+		Help:      "Time to establish a TCP connection to a service.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"service"})
+
+	UpstreamTLSHandshakeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "tls_handshake_duration_seconds",
+		Help:      "Time to complete the TLS handshake with a service, by result (ok or error).",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"service", "result"})
+
+	UpstreamTLSHandshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "tls_handshakes_total",
+		Help:      "Completed TLS handshakes with a service, by kind (full, or resumed from a cached session).",
+	}, []string{"service", "kind"})
+
+	UpstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "healthy",
+		Help:      "Active health check state per service instance: 1 healthy, 0 unhealthy.",
+	}, []string{"service", "instance"})
+
+	UpstreamHealthChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "health_checks_total",
+		Help:      "Active health check probes per service, by result (pass or fail).",
+	}, []string{"service", "result"})
+
+	RateLimitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ratelimit",
+		Name:      "rejected_total",
+		Help:      "Requests rejected by a rate limit policy, by policy and scope.",
+	}, []string{"policy", "scope"})
+
+	ProductRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "product",
+		Name:      "requests_total",
+		Help:      "Requests to API product routes, by product and result (allowed, unsubscribed, forbidden, over_quota).",
+	}, []string{"product", "result"})
+
+	CircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "circuit_breaker",
+		Name:      "state",
+		Help:      "Circuit breaker state per service: 0 closed, 1 half-open, 2 open.",
+	}, []string{"service"})
+
+	RedisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Redis command latency, by command (\"pipeline\" for pipelines) and result (ok or error).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "result"})
+
+	RedisDials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "dials_total",
+		Help:      "Connections opened to Redis, by result (ok or error).",
+	}, []string{"result"})
+
+	AuthTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "tokens_total",
+		Help:      "Bearer tokens verified, by issuer and result.",
+	}, []string{"issuer", "result"})
+
+	RedisKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "keys",
+		Help:      "Number of gateway keys in Redis per category, from the last keyspace scan.",
+	}, []string{"category"})
+
+	RedisMemoryBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "memory_bytes",
+		Help:      "Memory used by gateway keys in Redis per category, from the last keyspace scan.",
+	}, []string{"category"})
+
+	RedisKeysWithoutTTL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "keys_without_ttl",
+		Help:      "Gateway keys found without an expiry during the last keyspace scan.",
+	}, []string{"category"})
+
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "events",
+		Name:      "dropped_total",
+		Help:      "Events dropped because the Redis stream writer fell behind or failed.",
+	}, []string{"stream"})
+
+	ShieldShed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "shield",
+		Name:      "shed_total",
+		Help:      "Requests shed with 429 by the emergency global rate limit on this replica.",
+	})
+
+	ShieldActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "shield",
+		Name:      "active",
+		Help:      "1 while the emergency global rate limit is on, else 0.",
+	})
+
+	TLSFingerprintDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tls_fingerprint",
+		Name:      "denied_total",
+		Help:      "Requests whose TLS fingerprint is on the denylist, by action (block, step_up).",
+	}, []string{"action"})
+
+	ClientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Requests by app platform, app version (major.minor) and route, to find apps still using deprecated flows.",
+	}, []string{"platform", "version", "route"})
+
+	ClientUpgradeRequired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "client",
+		Name:      "upgrade_required_total",
+		Help:      "Requests turned away because the app is older than the route's minimum version.",
+	}, []string{"platform", "route"})
+
+	DarkTrafficRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dark_traffic",
+		Name:      "requests_total",
+		Help:      "Synthetic requests sent by dark traffic runs, by target route and response status.",
+	}, []string{"route", "code"})
+
+	ConcurrencyRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "concurrency",
+		Name:      "rejected_total",
+		Help:      "Requests rejected for exceeding a concurrent request limit, by scope (ip, client) and level (replica, cluster).",
+	}, []string{"scope", "level"})
+
+	WebSocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "connections",
+		Help:      "WebSocket connections currently tunnelled by this replica, by route.",
+	}, []string{"route"})
+
+	WebSocketRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "rejected_total",
+		Help:      "WebSocket upgrades rejected for exceeding a per-user connection limit, by level (replica, cluster).",
+	}, []string{"level"})
+
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Response cache lookups, by route and result (hit, miss, stale, stale_if_error, bypass).",
+	}, []string{"route", "result"})
+
+	CacheMemoryBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "memory_bytes",
+		Help:      "Bytes of keys and responses held by the in-memory response cache.",
+	})
+
+	IntegrityChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "integrity",
+		Name:      "checks_total",
+		Help:      "Upstream response digest checks, by route and result (ok, mismatch, missing, truncated, unverified).",
+	}, []string{"route", "result"})
+
+	MigrationRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "migration",
+		Name:      "requests_total",
+		Help:      "Requests matching a strangler migration rule, by rule and backend (target or legacy).",
+	}, []string{"rule", "backend"})
+
+	DeadlineRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "deadline",
+		Name:      "requests_total",
+		Help:      "Requests carrying X-Request-Deadline, by outcome (applied, capped, expired, exceeded, invalid or untrusted).",
+	}, []string{"outcome"})
+
+	MoneyConversions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "money",
+		Name:      "conversions_total",
+		Help:      "Bodies on money routes, by direction (request or response) and result (converted or rejected).",
+	}, []string{"direction", "result"})
+
+	FieldEncryptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "field_encryption",
+		Name:      "fields_total",
+		Help:      "Protected request body fields, by service and result (tokenized, restored or rejected).",
+	}, []string{"service", "result"})
+
+	PCICardData = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pci",
+		Name:      "card_data_total",
+		Help:      "Card numbers found in requests to services outside PCI scope, by where they were found and the action taken (blocked or masked).",
+	}, []string{"location", "action"})
+
+	TransformStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "transform",
+		Name:      "step_duration_seconds",
+		Help:      "Time spent in request transformation and validation steps, by stage.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25},
+	}, []string{"stage"})
+
+	TransformBudgetExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "transform",
+		Name:      "budget_exceeded_total",
+		Help:      "Requests rejected for exceeding their transformation budget, by stage and limit (wall_time, processing_time or memory).",
+	}, []string{"stage", "limit"})
+
+	FederationRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "requests_total",
+		Help:      "Unmatched requests forwarded to the peer gateway, by outcome (2xx, 3xx, 4xx, 5xx, error or loop).",
+	}, []string{"outcome"})
+
+	FederationMeshRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "mesh_requests_total",
+		Help:      "Requests received from peer gateways over the mesh, by peer SPIFFE ID and result (accepted or loop).",
+	}, []string{"peer", "result"})
+
+	BodyBufferBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "body_buffer",
+		Name:      "bytes",
+		Help:      "Request body bytes currently buffered by in-flight requests, by storage (memory or disk).",
+	}, []string{"storage"})
+
+	BodyBufferRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "body_buffer",
+		Name:      "requests_total",
+		Help:      "Request bodies buffered, by result (memory, disk, too_large, error).",
+	}, []string{"result"})
+
+	UpstreamEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "outlier_ejections_total",
+		Help:      "Instances ejected from rotation by outlier detection, per service.",
+	}, []string{"service"})
+
+	UpstreamGRPCResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "grpc_responses_total",
+		Help:      "Completed calls to gRPC services, by service and grpc-status code (\"unknown\" when the response carried none).",
+	}, []string{"service", "grpc_status"})
+
+	UpstreamExtraAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "extra_attempts_total",
+		Help:      "Retries and hedged requests per service, by kind (retry, hedge) and result (sent, over_budget).",
+	}, []string{"service", "kind", "result"})
+
+	UpstreamHedges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "hedges_total",
+		Help:      "Hedged requests per service, by the request whose response was used (primary, hedge, none).",
+	}, []string{"service", "winner"})
+
+	ClockOffsetSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "clock",
+		Name:      "offset_seconds",
+		Help:      "Offset of the local clock from NTP time at the last drift check.",
+	})
+
+	ClockWithinThreshold = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "clock",
+		Name:      "within_threshold",
+		Help:      "1 while the measured clock drift is within clock.max_drift, 0 otherwise.",
+	})
+
+	RevocationListEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "revocation",
+		Name:      "list_entries",
+		Help:      "Entries in the last exported revocation list, by kind (token, subject).",
+	}, []string{"kind"})
+
+	RetentionRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "removed_total",
+		Help:      "Stored entries removed by retention passes, by category.",
+	}, []string{"category"})
+
+	RetentionRedacted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "redacted_total",
+		Help:      "Stored entries redacted by retention passes, by category (request logs count once per request).",
+	}, []string{"category"})
+
+	RetentionOldestAgeSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "oldest_age_seconds",
+		Help:      "Age of the oldest entry held after the last retention pass, by category.",
+	}, []string{"category"})
+
+	RetentionCompliant = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "compliant",
+		Help:      "1 when nothing held after the last retention pass is older than the category's max_age, or unredacted past its redact_after; 0 otherwise.",
+	}, []string{"category"})
+
+	RetentionLastPass = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "retention",
+		Name:      "last_pass_timestamp_seconds",
+		Help:      "Unix time of the last completed retention pass, by category.",
+	}, []string{"category"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		BuildInfo,
+		RequestDuration,
+		RequestsTotal,
+		UpstreamDuration,
+		UpstreamConnections,
+		UpstreamConnectionsOpened,
+		UpstreamDialDuration,
+		UpstreamTLSHandshakeDuration,
+		UpstreamTLSHandshakes,
+		UpstreamHealthy,
+		UpstreamHealthChecks,
+		UpstreamEjections,
+		UpstreamGRPCResponses,
+		UpstreamExtraAttempts,
+		UpstreamHedges,
+		RateLimitRejected,
+		ProductRequests,
+		CircuitBreakerState,
+		RedisCommandDuration,
+		RedisDials,
+		redisPools,
+		AuthTokens,
+		RedisKeys,
+		RedisMemoryBytes,
+		RedisKeysWithoutTTL,
+		EventsDropped,
+		ConcurrencyRejected,
+		WebSocketConnections,
+		WebSocketRejected,
+		ShieldShed,
+		ShieldActive,
+		TLSFingerprintDenied,
+		ClientRequests,
+		ClientUpgradeRequired,
+		DarkTrafficRequests,
+		CacheRequests,
+		CacheMemoryBytes,
+		IntegrityChecks,
+		FederationRequests,
+		FederationMeshRequests,
+		MigrationRequests,
+		DeadlineRequests,
+		MoneyConversions,
+		FieldEncryptions,
+		PCICardData,
+		TransformStepDuration,
+		TransformBudgetExceeded,
+		BodyBufferBytes,
+		BodyBufferRequests,
+		ClockOffsetSeconds,
+		ClockWithinThreshold,
+		RevocationListEntries,
+		RetentionRemoved,
+		RetentionRedacted,
+		RetentionOldestAgeSeconds,
+		RetentionCompliant,
+		RetentionLastPass,
+	)
+}
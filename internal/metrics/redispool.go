@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RedisPoolStats is a snapshot of one Redis connection pool. Hits, Misses
+// and Timeouts are cumulative.
+type RedisPoolStats struct {
+	Hits, Misses, Timeouts uint32
+	Total, Idle, Stale     uint32
+}
+
+// WatchRedisPool exports the statistics of the named pool, read at scrape
+// time. Watching a name again replaces the previous pool.
+func WatchRedisPool(name string, stats func() RedisPoolStats) {
+	redisPools.mu.Lock()
+	defer redisPools.mu.Unlock()
+	redisPools.pools[name] = stats
+}
+
+var redisPools = &redisPoolCollector{pools: make(map[string]func() RedisPoolStats)}
+
+type redisPoolCollector struct {
+	mu    sync.Mutex
+	pools map[string]func() RedisPoolStats
+}
+
+var (
+	redisPoolConnections = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "redis_pool", "connections"),
+		"Redis connections per pool (read or write), by state (total, idle or stale).",
+		[]string{"pool", "state"}, nil)
+	redisPoolAcquired = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "redis_pool", "acquired_total"),
+		"Connections taken from each Redis pool, by result (hit: idle connection reused, miss: new connection, timeout: none free in time).",
+		[]string{"pool", "result"}, nil)
+)
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolConnections
+	ch <- redisPoolAcquired
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, stats := range c.pools {
+		s := stats()
+		ch <- prometheus.MustNewConstMetric(redisPoolConnections, prometheus.GaugeValue, float64(s.Total), name, "total")
+		ch <- prometheus.MustNewConstMetric(redisPoolConnections, prometheus.GaugeValue, float64(s.Idle), name, "idle")
+		ch <- prometheus.MustNewConstMetric(redisPoolConnections, prometheus.GaugeValue, float64(s.Stale), name, "stale")
+		ch <- prometheus.MustNewConstMetric(redisPoolAcquired, prometheus.CounterValue, float64(s.Hits), name, "hit")
+		ch <- prometheus.MustNewConstMetric(redisPoolAcquired, prometheus.CounterValue, float64(s.Misses), name, "miss")
+		ch <- prometheus.MustNewConstMetric(redisPoolAcquired, prometheus.CounterValue, float64(s.Timeouts), name, "timeout")
+	}
+}
@@ -0,0 +1,322 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// SharedAdminID identifies callers using the shared admin key rather than
+// an operator key or token.
+const SharedAdminID = "shared-key"
+
+// adminRoleRank orders admin roles; each includes the ones ranked below it.
+var adminRoleRank = map[string]int{
+	config.RoleViewer:        1,
+	config.RoleOperator:      2,
+	config.RoleSecurityAdmin: 3,
+}
+
+// securityAdminPaths need security-admin for any method: they manage
+// credentials, sessions and consents, and erase user data.
+var securityAdminPaths = []string{"/admin/api-keys", "/admin/sessions", "/admin/consents", "/admin/webhooks", "/admin/user-data"}
+
+// AdminAuth authenticates admin callers by the shared key, an operator key
+// (X-Admin-Key) or an operator JWT from the corporate IdP, restricts them to
+// the allowed networks and checks each endpoint's role. Token sessions and
+// every change are written to the audit log.
+type AdminAuth struct {
+	cfg     config.AdminConfig
+	sso     *issuerVerifier
+	allowed []*net.IPNet
+	roles   map[string]string
+	audit   *zap.Logger
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	sessions map[string]time.Time // by token ID, until expiry
+}
+
+func NewAdminAuth(cfg config.AdminConfig, logger *zap.Logger) (*AdminAuth, error) {
+	a := &AdminAuth{
+		cfg:      cfg,
+		roles:    make(map[string]string, len(cfg.EndpointRoles)),
+		audit:    logger.Named("audit"),
+		logger:   logger,
+		sessions: make(map[string]time.Time),
+	}
+	if cfg.SSO.Enabled {
+		v, err := newIssuerVerifier(cfg.SSO.Issuer, logger)
+		if err != nil {
+			return nil, fmt.Errorf("admin sso: %w", err)
+		}
+		a.sso = v
+	}
+	for _, cidr := range cfg.AllowedCIDRs {
+		// Validated when the configuration is loaded
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			a.allowed = append(a.allowed, network)
+		}
+	}
+	for _, er := range cfg.EndpointRoles {
+		a.roles[er.Operation] = er.Role
+	}
+	return a, nil
+}
+
+// Start keeps the IdP's key set refreshed until ctx is cancelled.
+func (a *AdminAuth) Start(ctx context.Context) {
+	if a.sso != nil && a.sso.jwks != nil {
+		a.sso.jwks.Start(ctx)
+	}
+}
+
+func (a *AdminAuth) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		op := req.Method + " " + c.Path()
+		if !a.allowedIP(req.RemoteAddr) {
+			a.audit.Warn("Admin access from disallowed network",
+				zap.String("ip", req.RemoteAddr),
+				zap.String("operation", op),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin access is not allowed from this network"})
+		}
+
+		id, role, err := a.authenticate(c)
+		if err != nil {
+			a.logger.Warn("Admin authentication failed",
+				zap.String("ip", req.RemoteAddr),
+				zap.String("request_id", RequestIDFrom(c)),
+				zap.Error(err),
+			)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid admin credentials"})
+		}
+
+		need := a.requiredRole(req.Method, c.Path())
+		if adminRoleRank[role] < adminRoleRank[need] {
+			a.audit.Warn("Admin request denied",
+				zap.String("by", id),
+				zap.String("role", role),
+				zap.String("required_role", need),
+				zap.String("operation", op),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Requires the " + need + " role"})
+		}
+
+		c.Set("admin_id", id)
+		c.Set("admin_role", role)
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			a.audit.Info("Admin request",
+				zap.String("by", id),
+				zap.String("role", role),
+				zap.String("operation", op),
+				zap.String("path", req.URL.Path),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+		}
+		return next(c)
+	}
+}
+
+func (a *AdminAuth) allowedIP(remoteAddr string) bool {
+	if len(a.allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredRole returns the role an admin endpoint needs.
+func (a *AdminAuth) requiredRole(method, path string) string {
+	if role, ok := a.roles[method+" "+path]; ok {
+		return role
+	}
+	for _, prefix := range securityAdminPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return config.RoleSecurityAdmin
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return config.RoleViewer
+	}
+	return config.RoleOperator
+}
+
+// authenticate returns the caller's identity and role from a bearer token
+// (when SSO is on) or an admin key.
+func (a *AdminAuth) authenticate(c echo.Context) (string, string, error) {
+	req := c.Request()
+	if raw, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && a.sso != nil {
+		return a.verifyToken(c, raw)
+	}
+
+	provided := []byte(req.Header.Get("X-Admin-Key"))
+	if len(provided) == 0 {
+		return "", "", errors.New("no admin credentials")
+	}
+	id, role := "", ""
+	if a.cfg.APIKey != "" && subtle.ConstantTimeCompare(provided, []byte(a.cfg.APIKey)) == 1 {
+		id, role = SharedAdminID, a.sharedKeyRole()
+	}
+	for name, op := range a.cfg.Operators {
+		if subtle.ConstantTimeCompare(provided, []byte(op.APIKey)) == 1 {
+			id, role = name, op.Role
+		}
+	}
+	if id == "" {
+		return "", "", errors.New("unknown admin key")
+	}
+	return id, role, nil
+}
+
+// sharedKeyRole is the role of the shared key: security-admin while it is
+// the only credential, viewer alongside operators or SSO, unless configured.
+func (a *AdminAuth) sharedKeyRole() string {
+	switch {
+	case a.cfg.SharedKeyRole != "":
+		return a.cfg.SharedKeyRole
+	case len(a.cfg.Operators) > 0 || a.cfg.SSO.Enabled:
+		return config.RoleViewer
+	default:
+		return config.RoleSecurityAdmin
+	}
+}
+
+// verifyToken checks an operator JWT: signature, issuer, audience and a
+// lifetime within MaxLifetime, and returns its subject and highest role.
+func (a *AdminAuth) verifyToken(c echo.Context, raw string) (string, string, error) {
+	sso := a.cfg.SSO
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(sso.Issuer.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if fips.Enabled() {
+		opts = append(opts, jwt.WithValidMethods(fips.JWTAlgorithms))
+	}
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		return a.sso.keyFor(c.Request().Context(), t)
+	}, opts...)
+	if err != nil {
+		return "", "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", errors.New("unexpected claims")
+	}
+	if err := a.sso.checkAudience(claims); err != nil {
+		return "", "", err
+	}
+
+	exp, _ := claims.GetExpirationTime()
+	iat, _ := claims.GetIssuedAt()
+	if iat == nil {
+		return "", "", errors.New("token has no iat")
+	}
+	if exp.Sub(iat.Time) > sso.MaxLifetime {
+		return "", "", fmt.Errorf("token lifetime exceeds %s", sso.MaxLifetime)
+	}
+
+	id, _ := claims[sso.SubjectClaim].(string)
+	if id == "" {
+		return "", "", fmt.Errorf("token has no %s claim", sso.SubjectClaim)
+	}
+	role := a.tokenRole(claims[sso.RolesClaim])
+	if role == "" {
+		return "", "", fmt.Errorf("operator %s has no admin role", id)
+	}
+
+	a.trackSession(c, raw, claims, id, role, exp.Time)
+	return id, role, nil
+}
+
+// tokenRole returns the highest gateway role among the roles claim values,
+// given as a list or a space-separated string.
+func (a *AdminAuth) tokenRole(claim interface{}) string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := ""
+	for _, v := range values {
+		role := v
+		if len(a.cfg.SSO.RoleMapping) > 0 {
+			// Viper lower-cases map keys
+			role = a.cfg.SSO.RoleMapping[strings.ToLower(v)]
+		}
+		if adminRoleRank[role] > adminRoleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// trackSession audits the first use of each operator token on this replica.
+func (a *AdminAuth) trackSession(c echo.Context, raw string, claims jwt.MapClaims, id, role string, expires time.Time) {
+	sid, _ := claims["jti"].(string)
+	if sid == "" {
+		sum := sha256.Sum256([]byte(raw))
+		sid = hex.EncodeToString(sum[:8])
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	_, seen := a.sessions[sid]
+	if !seen {
+		for k, until := range a.sessions {
+			if now.After(until) {
+				delete(a.sessions, k)
+			}
+		}
+		a.sessions[sid] = expires
+	}
+	a.mu.Unlock()
+
+	if !seen {
+		a.audit.Info("Admin session started",
+			zap.String("by", id),
+			zap.String("role", role),
+			zap.String("session", sid),
+			zap.String("ip", c.Request().RemoteAddr),
+			zap.String("user_agent", c.Request().UserAgent()),
+			zap.Time("expires_at", expires),
+		)
+	}
+}
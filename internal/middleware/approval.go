@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	approvalsKey = "approvals"
+	// approvalHeader carries the ID of an approved proposal when the
+	// operation is repeated to run it.
+	approvalHeader = "X-Approval-ID"
+	// maxApprovalBody bounds the request body kept with a proposal.
+	maxApprovalBody = 64 << 10
+)
+
+var (
+	ErrApprovalNotFound = errors.New("approval not found or expired")
+	ErrSelfApproval     = errors.New("an operation cannot be approved by its proposer")
+	ErrAlreadyApproved  = errors.New("operation is already approved")
+)
+
+// Approval is a proposed destructive admin operation. It runs once, after a
+// second operator has approved it and before it expires.
+type Approval struct {
+	ID         string     `json:"id"`
+	Operation  string     `json:"operation"`
+	Query      string     `json:"query,omitempty"`
+	Body       string     `json:"body,omitempty"`
+	ProposedBy string     `json:"proposed_by"`
+	ProposedAt time.Time  `json:"proposed_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+}
+
+// Approvals enforces two-person approval on the configured admin
+// operations. Proposals live in Redis so any replica can approve and run
+// them; without Redis they are kept in memory. Every step is written to the
+// audit log.
+type Approvals struct {
+	cfg    config.ApprovalsConfig
+	redis  *infrastructure.RedisClient
+	audit  *zap.Logger
+	logger *zap.Logger
+	ops    map[string]bool
+
+	mu    sync.Mutex
+	local map[string]Approval
+}
+
+func NewApprovals(cfg config.ApprovalsConfig, redis *infrastructure.RedisClient, logger *zap.Logger) *Approvals {
+	a := &Approvals{
+		cfg:    cfg,
+		redis:  redis,
+		audit:  logger.Named("audit"),
+		logger: logger,
+		ops:    make(map[string]bool, len(cfg.Operations)),
+		local:  make(map[string]Approval),
+	}
+	for _, op := range cfg.Operations {
+		a.ops[op] = true
+	}
+	return a
+}
+
+// Require holds back guarded operations: without X-Approval-ID the request
+// becomes a proposal (202), which the shared key may not make (403); with
+// the ID of an approved proposal for the same operation, query and body it
+// runs, using up the approval.
+func (a *Approvals) Require(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		op := req.Method + " " + req.URL.Path
+		if !a.ops[op] && !a.ops[req.Method+" "+c.Path()] {
+			return next(c)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxApprovalBody+1))
+		if err != nil || len(body) > maxApprovalBody {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Request body too large for approval"})
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		ctx := req.Context()
+		by := adminIDFrom(c)
+
+		id := req.Header.Get(approvalHeader)
+		if id == "" {
+			// The shared key identifies no one, so whoever holds it and an
+			// operator key could propose with one and approve with the other
+			if by == SharedAdminID {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Proposals need an operator key"})
+			}
+			p, err := a.propose(ctx, op, req.URL.RawQuery, string(body), by)
+			if err != nil {
+				a.logger.Error("Failed to record approval proposal", zap.String("operation", op), zap.Error(err))
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record proposal"})
+			}
+			return c.JSON(http.StatusAccepted, map[string]interface{}{
+				"message":  "Operation requires approval by a second operator",
+				"approval": p,
+			})
+		}
+
+		p, err := a.take(ctx, id)
+		if err != nil {
+			a.logger.Error("Failed to load approval", zap.String("id", id), zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load approval"})
+		}
+		var reason string
+		switch {
+		case p == nil:
+			return c.JSON(http.StatusForbidden, map[string]string{"error": ErrApprovalNotFound.Error()})
+		case p.Operation != op || p.Query != req.URL.RawQuery || p.Body != string(body):
+			reason = "Approval is for a different operation"
+		case p.ApprovedBy == "":
+			reason = "Operation is awaiting approval"
+		}
+		if reason != "" {
+			// Not used up: put it back for the right request
+			if err := a.save(ctx, *p); err != nil {
+				a.logger.Error("Failed to restore approval", zap.String("id", id), zap.Error(err))
+			}
+			return c.JSON(http.StatusConflict, map[string]string{"error": reason})
+		}
+
+		a.audit.Info("Approved admin operation executed",
+			zap.String("id", p.ID),
+			zap.String("operation", op),
+			zap.String("proposed_by", p.ProposedBy),
+			zap.String("approved_by", p.ApprovedBy),
+			zap.String("by", by),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return next(c)
+	}
+}
+
+func (a *Approvals) propose(ctx context.Context, op, query, body, by string) (*Approval, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	p := Approval{
+		ID:         hex.EncodeToString(raw),
+		Operation:  op,
+		Query:      query,
+		Body:       body,
+		ProposedBy: by,
+		ProposedAt: now,
+		ExpiresAt:  now.Add(a.cfg.TTL),
+	}
+	if err := a.save(ctx, p); err != nil {
+		return nil, err
+	}
+	a.audit.Info("Admin operation proposed",
+		zap.String("id", p.ID),
+		zap.String("operation", op),
+		zap.String("by", by),
+		zap.Time("expires_at", p.ExpiresAt),
+	)
+	return &p, nil
+}
+
+// Approve records by's approval of a pending proposal.
+func (a *Approvals) Approve(ctx context.Context, id, by string) (*Approval, error) {
+	p, err := a.get(ctx, id)
+	switch {
+	case err != nil:
+		return nil, err
+	case p == nil:
+		return nil, ErrApprovalNotFound
+	case p.ProposedBy == by:
+		return nil, ErrSelfApproval
+	case p.ApprovedBy != "":
+		return nil, ErrAlreadyApproved
+	}
+
+	now := time.Now().UTC()
+	p.ApprovedBy, p.ApprovedAt = by, &now
+	if err := a.save(ctx, *p); err != nil {
+		return nil, err
+	}
+	a.audit.Info("Admin operation approved",
+		zap.String("id", p.ID),
+		zap.String("operation", p.Operation),
+		zap.String("proposed_by", p.ProposedBy),
+		zap.String("by", by),
+	)
+	return p, nil
+}
+
+// Reject withdraws a proposal, approved or not.
+func (a *Approvals) Reject(ctx context.Context, id, by string) error {
+	p, err := a.take(ctx, id)
+	switch {
+	case err != nil:
+		return err
+	case p == nil:
+		return ErrApprovalNotFound
+	}
+	a.audit.Info("Admin operation rejected",
+		zap.String("id", p.ID),
+		zap.String("operation", p.Operation),
+		zap.String("proposed_by", p.ProposedBy),
+		zap.String("by", by),
+	)
+	return nil
+}
+
+// List returns the unexpired proposals, oldest first.
+func (a *Approvals) List(ctx context.Context) ([]Approval, error) {
+	var all map[string]string
+	if a.redis != nil {
+		var err error
+		if all, err = a.redis.GetHash(ctx, approvalsKey); err != nil {
+			return nil, err
+		}
+	} else {
+		a.mu.Lock()
+		all = make(map[string]string, len(a.local))
+		for id, p := range a.local {
+			raw, _ := json.Marshal(p)
+			all[id] = string(raw)
+		}
+		a.mu.Unlock()
+	}
+
+	now := time.Now()
+	out := make([]Approval, 0, len(all))
+	for _, raw := range all {
+		var p Approval
+		if json.Unmarshal([]byte(raw), &p) == nil && now.Before(p.ExpiresAt) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ProposedAt.Before(out[j].ProposedAt) })
+	return out, nil
+}
+
+func (a *Approvals) save(ctx context.Context, p Approval) error {
+	if a.redis == nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for id, old := range a.local {
+			if live(old) == nil {
+				delete(a.local, id)
+			}
+		}
+		a.local[p.ID] = p
+		return nil
+	}
+	raw, _ := json.Marshal(p)
+	return a.redis.SetHashField(ctx, approvalsKey, p.ID, string(raw), a.cfg.TTL)
+}
+
+func (a *Approvals) get(ctx context.Context, id string) (*Approval, error) {
+	if a.redis == nil {
+		a.mu.Lock()
+		p, ok := a.local[id]
+		a.mu.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		return live(p), nil
+	}
+	all, err := a.redis.GetHash(ctx, approvalsKey)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := all[id]
+	if !ok {
+		return nil, nil
+	}
+	var p Approval
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return nil, nil
+	}
+	return live(p), nil
+}
+
+// take removes and returns a proposal, so only one request can use it.
+func (a *Approvals) take(ctx context.Context, id string) (*Approval, error) {
+	if a.redis == nil {
+		a.mu.Lock()
+		p, ok := a.local[id]
+		delete(a.local, id)
+		a.mu.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		return live(p), nil
+	}
+	raw, err := a.redis.TakeHashField(ctx, approvalsKey, id)
+	if err != nil || raw == "" {
+		return nil, err
+	}
+	var p Approval
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return nil, nil
+	}
+	return live(p), nil
+}
+
+// live returns p unless it has expired.
+func live(p Approval) *Approval {
+	if !time.Now().Before(p.ExpiresAt) {
+		return nil
+	}
+	return &p
+}
+
+func adminIDFrom(c echo.Context) string {
+	id, _ := c.Get("admin_id").(string)
+	return id
+}
@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/banking/api-gateway/internal/clock"
 	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
 	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -16,25 +21,77 @@ type AuthMiddleware struct {
 	cfg         *config.Config
 	logger      *zap.Logger
 	redisClient *infrastructure.RedisClient
+	issuers     map[string]*issuerVerifier
+	audit       *zap.Logger
+	clock       *clock.Guard
+	revocations *Revocations
 }
 
-func NewAuthMiddleware(cfg *config.Config, logger *zap.Logger, redisClient *infrastructure.RedisClient) *AuthMiddleware {
-	return &AuthMiddleware{
+func NewAuthMiddleware(cfg *config.Config, logger *zap.Logger, redisClient *infrastructure.RedisClient) (*AuthMiddleware, error) {
+	m := &AuthMiddleware{
 		cfg:         cfg,
 		logger:      logger,
 		redisClient: redisClient,
+		issuers:     make(map[string]*issuerVerifier),
+		audit:       logger.Named("audit"),
 	}
+
+	for _, issuerCfg := range cfg.Security.Issuers {
+		v, err := newIssuerVerifier(issuerCfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		m.issuers[issuerCfg.Issuer] = v
+	}
+
+	return m, nil
+}
+
+// Start fetches the key sets of JWKS issuers and keeps them refreshed until
+// ctx is cancelled.
+func (m *AuthMiddleware) Start(ctx context.Context) {
+	for _, v := range m.issuers {
+		if v.jwks != nil {
+			v.jwks.Start(ctx)
+		}
+	}
+}
+
+// WarmUp fetches the key set of every JWKS issuer that has none yet.
+func (m *AuthMiddleware) WarmUp(ctx context.Context) error {
+	var errs []error
+	for _, v := range m.issuers {
+		if v.jwks != nil && !v.jwks.loaded() {
+			if err := v.jwks.fetch(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("issuer %s: %w", v.cfg.Issuer, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UseClock makes token time claims (exp, nbf, iat) follow the clock drift
+// guard's fail mode while the local clock is out of sync.
+func (m *AuthMiddleware) UseClock(g *clock.Guard) {
+	m.clock = g
+}
+
+// UseRevocations rejects tokens revoked by logout/revocation events.
+func (m *AuthMiddleware) UseRevocations(r *Revocations) {
+	m.revocations = r
 }
 
 func (m *AuthMiddleware) ValidateToken(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		authHeader := c.Request().Header.Get("Authorization")
 		if authHeader == "" {
+			Explain(c, "auth", "deny", "missing authorization header")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Missing authorization header"})
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
+			Explain(c, "auth", "deny", "invalid authorization format")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid authorization format"})
 		}
 		tokenString := parts[1]
@@ -55,11 +112,39 @@ func (m *AuthMiddleware) ValidateToken(next echo.HandlerFunc) echo.HandlerFunc {
 				// I'll proceed for now, as blacklist is an enhancement.
 			}
 			if isBlacklisted {
+				Explain(c, "auth", "deny", "token revoked")
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token has been revoked"})
 			}
 		}
 
+		var opts []jwt.ParserOption
+		if fips.Enabled() {
+			opts = append(opts, jwt.WithValidMethods(fips.JWTAlgorithms))
+		}
+		if m.clock != nil {
+			if drift, ok := m.clock.Drift(); !ok {
+				if m.clock.FailClosed() {
+					Explain(c, "auth", "deny", "clock drift "+drift.String()+" exceeds threshold")
+					return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Token expiry cannot be verified, gateway clock is out of sync"})
+				}
+				Explain(c, "auth", "warn", "clock drift "+drift.String()+" applied as leeway")
+				opts = append(opts, jwt.WithLeeway(drift))
+			}
+		}
+
+		var issuer *issuerVerifier
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if len(m.issuers) > 0 {
+				// Claims are decoded before the key is resolved, so iss selects the issuer
+				iss, _ := token.Claims.GetIssuer()
+				v, ok := m.issuers[iss]
+				if !ok {
+					return nil, fmt.Errorf("untrusted issuer: %q", iss)
+				}
+				issuer = v
+				return v.keyFor(c.Request().Context(), token)
+			}
+
 			// Validate Signing Method
 			// For this implementation, we assume HMAC (HS256) for simplicity via Shared Secret.
 			// Production should use RSA/ECDSA with Public Key.
@@ -67,20 +152,66 @@ func (m *AuthMiddleware) ValidateToken(next echo.HandlerFunc) echo.HandlerFunc {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
 			return []byte(m.cfg.Security.JWTSecret), nil
-		})
+		}, opts...)
+
+		issuerLabel := "default"
+		if issuer != nil {
+			issuerLabel = issuer.cfg.Issuer
+		}
 
 		if err != nil {
-			m.logger.Warn("Token validation failed", zap.Error(err))
+			m.logger.Warn("Token validation failed", zap.String("issuer", issuerLabel), zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+			metrics.AuthTokens.WithLabelValues(issuerLabel, "invalid").Inc()
+			Explain(c, "auth", "deny", "issuer "+issuerLabel+": "+err.Error())
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
 		}
 
 		if !token.Valid {
+			metrics.AuthTokens.WithLabelValues(issuerLabel, "invalid").Inc()
+			Explain(c, "auth", "deny", "token is invalid")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token is invalid"})
 		}
 
+		if issuer != nil {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				if err := issuer.checkAudience(claims); err != nil {
+					m.logger.Warn("Token audience rejected", zap.String("issuer", issuerLabel), zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+					metrics.AuthTokens.WithLabelValues(issuerLabel, "invalid_audience").Inc()
+					Explain(c, "auth", "deny", err.Error())
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token audience"})
+				}
+			}
+		}
+		if m.revocations != nil {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				jti, _ := claims["jti"].(string)
+				sub, _ := claims.GetSubject()
+				var iat int64
+				if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+					iat = issuedAt.Unix()
+				}
+				revoked, err := m.revocations.Check(c.Request().Context(), jti, sub, iat)
+				if err != nil {
+					// Same availability trade-off as the token blacklist above
+					m.logger.Error("Failed to check token revocation", zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+				}
+				if revoked {
+					metrics.AuthTokens.WithLabelValues(issuerLabel, "revoked").Inc()
+					Explain(c, "auth", "deny", "token revoked by logout/revocation event")
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token has been revoked"})
+				}
+			}
+		}
+		metrics.AuthTokens.WithLabelValues(issuerLabel, "valid").Inc()
+
 		// Extract Claims
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if err := m.checkDelegation(c, claims); err != nil {
+				Explain(c, "impersonation", "deny", err.Error())
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Delegated access denied"})
+			}
 			c.Set("user_claims", claims)
+			Explain(c, "auth", "allow", "issuer "+issuerLabel)
 			if sub, ok := claims["sub"].(string); ok {
 				c.Set("user_id", sub)
 				// Log user for traceability
@@ -91,3 +222,36 @@ func (m *AuthMiddleware) ValidateToken(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+// RequestIDFrom returns the request ID assigned by the RequestID middleware.
+func RequestIDFrom(c echo.Context) string {
+	return c.Response().Header().Get(echo.HeaderXRequestID)
+}
+
+// ClientIDFromClaims returns the OAuth client identifier of a token, falling
+// back to the subject for tokens issued directly to users.
+func ClientIDFromClaims(claims jwt.MapClaims) string {
+	for _, name := range []string{"client_id", "azp", "sub"} {
+		if v, ok := claims[name].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ScopesFromClaims returns the granted scopes from either a space separated
+// "scope" claim or a "scp" array claim.
+func ScopesFromClaims(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, v := range scp {
+			if s, ok := v.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const bufferedBodyContextKey = "buffered_body"
+
+// BodySpillPattern names the temporary files bodies spill to, in
+// body_buffer.temp_dir.
+const BodySpillPattern = "gateway-body-*"
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// BufferedBody is a fully read request body that can be replayed any number
+// of times while the request is in flight.
+type BufferedBody struct {
+	mem  []byte
+	file *os.File
+	size int64
+}
+
+// Size returns the body length in bytes.
+func (b *BufferedBody) Size() int64 {
+	return b.size
+}
+
+// Reader returns a fresh reader positioned at the start of the body.
+func (b *BufferedBody) Reader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.mem))
+}
+
+// BufferedBodyFrom returns the buffered body of the current request, if its
+// route opted in to buffering.
+func BufferedBodyFrom(c echo.Context) (*BufferedBody, bool) {
+	b, ok := c.Get(bufferedBodyContextKey).(*BufferedBody)
+	return b, ok
+}
+
+// BodyBuffer reads request bodies up front on routes with buffer_body so
+// they can be replayed. Small bodies stay in memory; large ones, or any body
+// arriving while the shared memory budget is used up, spill to a temporary
+// file removed when the request completes.
+type BodyBuffer struct {
+	cfg    *config.Config
+	logger *zap.Logger
+
+	// inMemory is the number of bytes held by in-flight requests
+	inMemory atomic.Int64
+}
+
+func NewBodyBuffer(cfg *config.Config, logger *zap.Logger) *BodyBuffer {
+	return &BodyBuffer{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (m *BodyBuffer) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route := m.cfg.Route(c.Path())
+		req := c.Request()
+		if route == nil || !route.BufferBody || req.Body == nil || req.Body == http.NoBody {
+			return next(c)
+		}
+
+		body, reserved, err := m.read(req)
+		req.Body.Close()
+		defer m.release(body, reserved)
+		if errors.Is(err, errBodyTooLarge) {
+			metrics.BodyBufferRequests.WithLabelValues("too_large").Inc()
+			Explain(c, "body_buffer", "deny", "body exceeds body_buffer.max_size")
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Request body too large"})
+		}
+		if err != nil {
+			metrics.BodyBufferRequests.WithLabelValues("error").Inc()
+			m.logger.Warn("Failed to buffer request body", zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+		}
+
+		storage := "memory"
+		if body.file != nil {
+			storage = "disk"
+		}
+		metrics.BodyBufferRequests.WithLabelValues(storage).Inc()
+		Explain(c, "body_buffer", "allow", storage)
+
+		req.Body = body.Reader()
+		req.GetBody = func() (io.ReadCloser, error) { return body.Reader(), nil }
+		req.ContentLength = body.size
+		req.TransferEncoding = nil
+		c.Set(bufferedBodyContextKey, body)
+		return next(c)
+	}
+}
+
+// read buffers req.Body, returning the memory reservation it holds against
+// the budget.
+func (m *BodyBuffer) read(req *http.Request) (*BufferedBody, int64, error) {
+	limits := m.cfg.BodyBuffer
+	if req.ContentLength > limits.MaxSize {
+		return nil, 0, errBodyTooLarge
+	}
+
+	// Reserve memory only when the body may fit; a known larger length goes
+	// straight to disk.
+	var allowance int64
+	if req.ContentLength <= limits.MemoryLimit {
+		allowance = limits.MemoryLimit
+		if req.ContentLength >= 0 {
+			allowance = req.ContentLength
+		}
+		if m.inMemory.Add(allowance) > limits.MemoryBudget {
+			m.inMemory.Add(-allowance)
+			allowance = 0
+		}
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, allowance+1))
+	if err != nil {
+		return nil, allowance, err
+	}
+	if int64(len(head)) <= allowance {
+		// Return the unused part of the reservation
+		m.inMemory.Add(int64(len(head)) - allowance)
+		metrics.BodyBufferBytes.WithLabelValues("memory").Add(float64(len(head)))
+		return &BufferedBody{mem: head, size: int64(len(head))}, int64(len(head)), nil
+	}
+	m.inMemory.Add(-allowance)
+
+	file, err := os.CreateTemp(limits.TempDir, BodySpillPattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	body := &BufferedBody{file: file}
+	n, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), io.LimitReader(req.Body, limits.MaxSize+1-int64(len(head)))))
+	body.size = n
+	metrics.BodyBufferBytes.WithLabelValues("disk").Add(float64(n))
+	if err == nil && n > limits.MaxSize {
+		err = errBodyTooLarge
+	}
+	return body, 0, err
+}
+
+// release returns the memory reservation and removes any spill file.
+func (m *BodyBuffer) release(body *BufferedBody, reserved int64) {
+	if body == nil {
+		m.inMemory.Add(-reserved)
+		return
+	}
+	if body.file != nil {
+		metrics.BodyBufferBytes.WithLabelValues("disk").Sub(float64(body.size))
+		body.file.Close()
+		if err := os.Remove(body.file.Name()); err != nil {
+			m.logger.Warn("Failed to remove body spill file", zap.String("file", body.file.Name()), zap.Error(err))
+		}
+		return
+	}
+	m.inMemory.Add(-reserved)
+	metrics.BodyBufferBytes.WithLabelValues("memory").Sub(float64(body.size))
+}
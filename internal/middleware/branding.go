@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const brandContextKey = "brand"
+
+// Brand is the white-label brand (tenant) a request was made under.
+type Brand struct {
+	Name    string
+	Headers http.Header
+	Support config.SupportContact
+}
+
+// BrandFrom returns the brand resolved for the request, or nil.
+func BrandFrom(c echo.Context) *Brand {
+	b, _ := c.Get(brandContextKey).(*Brand)
+	return b
+}
+
+// Branding resolves the brand of each request (by header, then Host, then
+// the default) for later middleware, and sets the brand's response headers.
+type Branding struct {
+	cfg    config.BrandingConfig
+	brands map[string]*Brand
+	hosts  map[string]*Brand
+	logger *zap.Logger
+}
+
+func NewBranding(cfg *config.Config, logger *zap.Logger) *Branding {
+	m := &Branding{
+		cfg:    cfg.Branding,
+		brands: make(map[string]*Brand, len(cfg.Branding.Brands)),
+		hosts:  make(map[string]*Brand),
+		logger: logger,
+	}
+	for name, bc := range cfg.Branding.Brands {
+		b := &Brand{Name: name, Headers: make(http.Header, len(bc.Headers)), Support: bc.Support}
+		for k, v := range bc.Headers {
+			b.Headers.Set(k, v)
+		}
+		m.brands[strings.ToLower(name)] = b
+		for _, host := range bc.Hosts {
+			m.hosts[strings.ToLower(host)] = b
+		}
+	}
+	return m
+}
+
+func (m *Branding) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		brand := m.resolve(c.Request())
+		if brand == nil {
+			return next(c)
+		}
+		c.Set(brandContextKey, brand)
+		Explain(c, "branding", "allow", "brand "+brand.Name)
+
+		if len(brand.Headers) > 0 {
+			res := c.Response()
+			res.Before(func() {
+				for k, v := range brand.Headers {
+					res.Header()[k] = v
+				}
+			})
+		}
+		return next(c)
+	}
+}
+
+// resolve returns the brand named by the brand header, else the one serving
+// the request's host, else the default brand (nil if there is none).
+func (m *Branding) resolve(req *http.Request) *Brand {
+	if m.cfg.Header != "" {
+		if b, ok := m.brands[strings.ToLower(req.Header.Get(m.cfg.Header))]; ok {
+			return b
+		}
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if b, ok := m.hosts[strings.ToLower(host)]; ok {
+		return b
+	}
+	return m.brands[strings.ToLower(m.cfg.Default)]
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const transformBudgetContextKey = "transform_budget"
+
+// budgetCheckInterval is how many units of work (tokens, values, nodes) a
+// step does between checks of its context.
+const budgetCheckInterval = 1024
+
+// budgetError reports which limit a transformation step ran into.
+type budgetError struct {
+	stage string
+	limit string
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("%s exceeded %s budget", e.stage, e.limit)
+}
+
+// transformBudget is what one request has used of its transformation budget.
+type transformBudget struct {
+	spent time.Duration
+	alloc int64
+}
+
+// runBudgeted runs one transformation step of the request. size is a soft
+// estimate of what the step allocates, usually the body it decodes; the step
+// itself should check ctx every budgetCheckInterval units of work and return
+// its error. Errors of the step pass through; a step over budget returns a
+// *budgetError, to be answered with rejectOverBudget.
+func runBudgeted(c echo.Context, cfg *config.Config, stage string, size int64, step func(ctx context.Context) error) error {
+	limits := cfg.Security.TransformBudget
+	if !limits.Enabled {
+		return step(c.Request().Context())
+	}
+	b, ok := c.Get(transformBudgetContextKey).(*transformBudget)
+	if !ok {
+		b = &transformBudget{}
+		c.Set(transformBudgetContextKey, b)
+	}
+
+	if limits.MaxAllocBytes > 0 && b.alloc+size > limits.MaxAllocBytes {
+		return overBudget(stage, "memory")
+	}
+	b.alloc += size
+
+	ctx := c.Request().Context()
+	if limits.WallTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.WallTime)
+		defer cancel()
+	}
+	start := time.Now()
+	err := step(ctx)
+	elapsed := time.Since(start)
+	b.spent += elapsed
+	metrics.TransformStepDuration.WithLabelValues(stage).Observe(elapsed.Seconds())
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && c.Request().Context().Err() == nil:
+		return overBudget(stage, "wall_time")
+	case err != nil:
+		return err
+	case limits.ProcessingTime > 0 && b.spent > limits.ProcessingTime:
+		return overBudget(stage, "processing_time")
+	}
+	return nil
+}
+
+func overBudget(stage, limit string) error {
+	metrics.TransformBudgetExceeded.WithLabelValues(stage, limit).Inc()
+	return &budgetError{stage: stage, limit: limit}
+}
+
+// rejectOverBudget answers a request whose transformation ran out of budget.
+// Such input fails the same way on a retry, hence 422 rather than 503.
+func rejectOverBudget(c echo.Context, logger *zap.Logger, err *budgetError) error {
+	Explain(c, err.stage, "deny", err.Error())
+	logger.Warn("Request exceeded transformation budget",
+		zap.String("path", c.Path()),
+		zap.String("stage", err.stage),
+		zap.String("limit", err.limit),
+		zap.String("request_id", RequestIDFrom(c)),
+	)
+	return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+		"error":  "Request too expensive to process",
+		"reason": err.Error(),
+	})
+}
+
+// checkBudget returns ctx's error on every budgetCheckInterval-th call,
+// counted by n, so tight loops check for cancellation cheaply.
+func checkBudget(ctx context.Context, n *int) error {
+	*n++
+	if *n%budgetCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,508 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCacheMaxBody = 1 << 20
+	// revalidateTimeout bounds background refreshes of stale entries.
+	revalidateTimeout = 30 * time.Second
+	// CacheStatusHeader tells clients how a response was served.
+	CacheStatusHeader = "X-Cache"
+
+	CacheScopeUser   = "user"
+	CacheScopeShared = "shared"
+
+	// cacheUserPrefix starts the keys of user-scoped entries.
+	cacheUserPrefix = "cache:user:"
+	// cachePurgeChannel tells the other replicas to drop a key prefix from
+	// their memory caches.
+	cachePurgeChannel = "gateway:cache:purge"
+)
+
+// forwardedContextKeys are copied onto background revalidation requests so
+// the proxy forwards the same identity.
+var forwardedContextKeys = []string{"user_id", "user_claims", "actor_id", "actor_chain"}
+
+// cacheEntry is a stored upstream response.
+type cacheEntry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt int64       `json:"stored_at"` // Unix milliseconds
+}
+
+func (e *cacheEntry) age() time.Duration {
+	return time.Since(time.UnixMilli(e.StoredAt))
+}
+
+// ResponseCache caches successful GET responses on routes with a cache
+// policy, in the route's backend, with stale-while-revalidate and
+// stale-if-error semantics. It must run directly before the proxy handler so
+// it sees the upstream response.
+type ResponseCache struct {
+	cfg    *config.Config
+	logger *zap.Logger
+	redis  *infrastructure.RedisClient
+	// backends by name; redis and tiered are missing without Redis
+	backends map[string]Cache
+
+	// revalidating holds keys with a background refresh in flight
+	revalidating sync.Map
+}
+
+func NewResponseCache(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) (*ResponseCache, error) {
+	for _, route := range cfg.Routes {
+		if route.Cache == nil {
+			continue
+		}
+		switch route.Cache.Key.Scope {
+		case "", CacheScopeUser, CacheScopeShared:
+		default:
+			return nil, fmt.Errorf("route %s: cache key scope must be %q or %q", route.Path, CacheScopeUser, CacheScopeShared)
+		}
+		switch route.Cache.Backend {
+		case "", CacheBackendRedis, CacheBackendMemory, CacheBackendTiered:
+		default:
+			return nil, fmt.Errorf("route %s: cache backend must be %q, %q or %q", route.Path, CacheBackendRedis, CacheBackendMemory, CacheBackendTiered)
+		}
+	}
+
+	memory := newMemoryCache(cfg.ResponseCache.MaxMemoryBytes)
+	backends := map[string]Cache{CacheBackendMemory: memory}
+	if redis != nil {
+		backends[CacheBackendRedis] = redisCache{redis: redis}
+		backends[CacheBackendTiered] = tieredCache{local: memory, shared: backends[CacheBackendRedis], localTTL: cfg.ResponseCache.LocalTTL}
+	}
+	return &ResponseCache{
+		cfg:      cfg,
+		logger:   logger,
+		redis:    redis,
+		backends: backends,
+	}, nil
+}
+
+// Start follows purges made on other replicas until ctx is cancelled.
+func (rc *ResponseCache) Start(ctx context.Context) {
+	if rc.redis == nil {
+		return
+	}
+	memory := rc.backends[CacheBackendMemory].(*memoryCache)
+	rc.redis.Subscribe(ctx, cachePurgeChannel, func(prefix string) {
+		if strings.HasPrefix(prefix, cacheUserPrefix) {
+			memory.deletePrefix(prefix)
+		}
+	})
+}
+
+// PurgeUser drops every cached response of a user-scoped route stored for
+// userID and returns how many entries this replica and Redis held. Other
+// replicas are told to drop theirs from memory.
+func (rc *ResponseCache) PurgeUser(ctx context.Context, userID string) (int, error) {
+	prefix := rc.userKeyPrefix(userID)
+	purged := rc.backends[CacheBackendMemory].(*memoryCache).deletePrefix(prefix)
+	if rc.redis == nil {
+		return purged, nil
+	}
+	n, err := rc.redis.DeleteMatching(ctx, prefix+"*")
+	purged += n
+	if err != nil {
+		return purged, err
+	}
+	return purged, rc.redis.Publish(ctx, cachePurgeChannel, prefix)
+}
+
+// userKeyPrefix starts the keys of a user's entries. It carries an HMAC of
+// the user ID, so the ID is not stored but its entries can be found.
+func (rc *ResponseCache) userKeyPrefix(userID string) string {
+	mac := hmac.New(sha256.New, []byte(rc.cfg.RateLimits.KeySecret))
+	mac.Write([]byte(userID))
+	return cacheUserPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12]) + ":"
+}
+
+// backend returns the cache of a route's policy, or nil when it needs Redis
+// and there is none. Tiered routes then keep to memory.
+func (rc *ResponseCache) backend(policy *config.CacheConfig) Cache {
+	switch policy.Backend {
+	case "":
+		if cache, ok := rc.backends[CacheBackendRedis]; ok {
+			return cache
+		}
+		return rc.backends[CacheBackendMemory]
+	case CacheBackendTiered:
+		if cache, ok := rc.backends[CacheBackendTiered]; ok {
+			return cache
+		}
+		return rc.backends[CacheBackendMemory]
+	}
+	return rc.backends[policy.Backend]
+}
+
+func (rc *ResponseCache) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route := rc.cfg.Route(c.Path())
+		if route == nil || route.Cache == nil || c.Request().Method != http.MethodGet {
+			return next(c)
+		}
+		policy := route.Cache
+		cache := rc.backend(policy)
+		if cache == nil {
+			return next(c)
+		}
+		if carded, _ := c.Get(cardDataContextKey).(bool); carded {
+			// Card data must not end up in a cache key or entry
+			rc.count(c, "bypass")
+			return next(c)
+		}
+		if strings.Contains(c.Request().Header.Get("Cache-Control"), "no-cache") {
+			rc.count(c, "bypass")
+			c.Response().Header().Set(CacheStatusHeader, "BYPASS")
+			return rc.fetch(c, next, cache, policy, rc.key(c, policy), nil)
+		}
+
+		key := rc.key(c, policy)
+		entry := rc.load(c.Request().Context(), cache, key)
+		if entry != nil {
+			age := entry.age()
+			switch {
+			case age < policy.TTL:
+				rc.count(c, "hit")
+				return rc.serve(c, policy, entry, "HIT")
+			case age < policy.TTL+policy.StaleWhileRevalidate:
+				rc.count(c, "stale")
+				rc.revalidate(c, next, cache, policy, key)
+				return rc.serve(c, policy, entry, "STALE")
+			case age >= policy.TTL+policy.StaleIfError:
+				entry = nil
+			}
+		}
+
+		rc.count(c, "miss")
+		c.Response().Header().Set(CacheStatusHeader, "MISS")
+		return rc.fetch(c, next, cache, policy, key, entry)
+	}
+}
+
+// fetch calls the upstream and stores a cacheable response. With a stale
+// entry available the response is held back until its status is known, so
+// the stale entry can be served instead of a 5xx.
+func (rc *ResponseCache) fetch(c echo.Context, next echo.HandlerFunc, cache Cache, policy *config.CacheConfig, key string, stale *cacheEntry) error {
+	res := c.Response()
+	addVary(res.Header(), policy.Key.VaryHeaders)
+	before := res.Header().Clone()
+	orig := res.Writer
+	rec := newCacheRecorder(orig, stale != nil, maxCacheBody(policy))
+	res.Writer = rec
+	err := next(c)
+	res.Writer = orig
+
+	status := res.Status
+	if stale != nil && (status >= http.StatusInternalServerError || err != nil) {
+		rc.count(c, "stale_if_error")
+		rc.logger.Warn("Serving stale response after upstream error",
+			zap.String("route", c.Path()),
+			zap.Int("status", status),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		res.Committed = false
+		return rc.serve(c, policy, stale, "STALE-IF-ERROR")
+	}
+	if stale != nil {
+		rec.flushTo(orig)
+	}
+	if err == nil {
+		rc.store(c.Request().Context(), cache, key, policy, status, newHeaders(before, rec.Header()), rec)
+	}
+	return err
+}
+
+// revalidate refreshes an entry in the background, once per key at a time.
+func (rc *ResponseCache) revalidate(c echo.Context, next echo.HandlerFunc, cache Cache, policy *config.CacheConfig, key string) {
+	if _, busy := rc.revalidating.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	req := c.Request().Clone(ctx)
+	rec := newCacheRecorder(nil, true, maxCacheBody(policy))
+	bc := c.Echo().NewContext(req, rec)
+	bc.SetPath(c.Path())
+	bc.SetParamNames(c.ParamNames()...)
+	bc.SetParamValues(c.ParamValues()...)
+	for _, k := range forwardedContextKeys {
+		if v := c.Get(k); v != nil {
+			bc.Set(k, v)
+		}
+	}
+
+	go func() {
+		defer cancel()
+		defer rc.revalidating.Delete(key)
+		defer func() {
+			// No client connection to abort here; just drop the response
+			if r := recover(); r != nil {
+				rc.logger.Warn("Cache revalidation aborted", zap.String("route", bc.Path()), zap.Any("reason", r))
+			}
+		}()
+		if err := next(bc); err != nil {
+			rc.logger.Warn("Cache revalidation failed", zap.String("route", bc.Path()), zap.Error(err))
+			return
+		}
+		rc.store(ctx, cache, key, policy, bc.Response().Status, rec.Header(), rec)
+	}()
+}
+
+func (rc *ResponseCache) serve(c echo.Context, policy *config.CacheConfig, e *cacheEntry, state string) error {
+	h := c.Response().Header()
+	for k, v := range e.Header {
+		if k == "Vary" {
+			addVary(h, v)
+			continue
+		}
+		h[k] = v
+	}
+	addVary(h, policy.Key.VaryHeaders)
+	h.Set("Age", strconv.Itoa(int(e.age().Seconds())))
+	h.Set(CacheStatusHeader, state)
+	Explain(c, "cache", "allow", strings.ToLower(state))
+	return c.Blob(e.Status, h.Get(echo.HeaderContentType), e.Body)
+}
+
+func (rc *ResponseCache) store(ctx context.Context, cache Cache, key string, policy *config.CacheConfig, status int, header http.Header, rec *cacheRecorder) {
+	if status != http.StatusOK || rec.overflow || !cacheable(header, policy.Key) {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{
+		Status:   status,
+		Header:   header,
+		Body:     rec.body.Bytes(),
+		StoredAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return
+	}
+	ttl := policy.TTL + max(policy.StaleWhileRevalidate, policy.StaleIfError)
+	if err := cache.Set(ctx, key, data, ttl); err != nil {
+		rc.logger.Warn("Failed to store cached response", zap.Error(err))
+	}
+}
+
+func (rc *ResponseCache) load(ctx context.Context, cache Cache, key string) *cacheEntry {
+	raw, err := cache.Get(ctx, key)
+	if err != nil {
+		rc.logger.Warn("Failed to read cached response", zap.Error(err))
+		return nil
+	}
+	if raw == nil {
+		return nil
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// key identifies a response by route, scope (user or shared), path, the
+// selected query parameters and the vary-by request headers. Keys of
+// user-scoped entries start with the user's prefix, so they can be purged.
+func (rc *ResponseCache) key(c echo.Context, policy *config.CacheConfig) string {
+	req := c.Request()
+	rules := policy.Key
+
+	scope, prefix := CacheScopeShared, "cache:"
+	if rules.Scope != CacheScopeShared {
+		userID, _ := c.Get("user_id").(string)
+		scope, prefix = CacheScopeUser+":"+userID, rc.userKeyPrefix(userID)
+	}
+
+	query := req.URL.Query()
+	for name := range query {
+		if (len(rules.IncludeQuery) > 0 && !matchesAny(rules.IncludeQuery, name)) || matchesAny(rules.ExcludeQuery, name) {
+			query.Del(name)
+		}
+	}
+
+	parts := []string{c.Path(), scope, req.URL.Path, query.Encode()}
+	for _, name := range rules.VaryHeaders {
+		parts = append(parts, http.CanonicalHeaderKey(name)+":"+strings.Join(req.Header.Values(name), ","))
+	}
+
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18])
+}
+
+func (rc *ResponseCache) count(c echo.Context, result string) {
+	metrics.CacheRequests.WithLabelValues(c.Path(), result).Inc()
+}
+
+func maxCacheBody(policy *config.CacheConfig) int {
+	if policy.MaxBodyBytes > 0 {
+		return policy.MaxBodyBytes
+	}
+	return defaultCacheMaxBody
+}
+
+// cacheable rejects responses the upstream marked as not storable, that set
+// cookies, or that vary on a request header the key does not include, so one
+// caller's entry is never served to another. Shared entries must also not be
+// private to the caller.
+func cacheable(h http.Header, rules config.CacheKeyConfig) bool {
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || h.Get("Set-Cookie") != "" {
+		return false
+	}
+	if rules.Scope == CacheScopeShared && strings.Contains(cc, "private") {
+		return false
+	}
+
+	keyed := make([]string, 0, len(rules.VaryHeaders))
+	for _, name := range rules.VaryHeaders {
+		keyed = append(keyed, http.CanonicalHeaderKey(name))
+	}
+	for _, name := range varyNames(h) {
+		if name == "*" || !slices.Contains(keyed, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// varyNames returns the canonical header names listed in Vary.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// addVary adds names to the Vary header unless already listed.
+func addVary(h http.Header, names []string) {
+	present := varyNames(h)
+	for _, v := range names {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !slices.Contains(present, name) {
+				h.Add("Vary", name)
+				present = append(present, name)
+			}
+		}
+	}
+}
+
+// newHeaders returns the headers added or changed since before, i.e. those
+// set by the upstream rather than by gateway middleware.
+func newHeaders(before, after http.Header) http.Header {
+	out := make(http.Header)
+	for k, v := range after {
+		if k == echo.HeaderXRequestID || k == "Trailer" {
+			continue
+		}
+		if prev, ok := before[k]; !ok || strings.Join(prev, "\x00") != strings.Join(v, "\x00") {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// cacheRecorder captures a response body up to limit. In held mode nothing
+// reaches the client until flushTo is called.
+type cacheRecorder struct {
+	w        http.ResponseWriter
+	held     bool
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func newCacheRecorder(w http.ResponseWriter, held bool, limit int) *cacheRecorder {
+	r := &cacheRecorder{w: w, held: held, limit: limit}
+	if held {
+		r.header = make(http.Header)
+	}
+	return r
+}
+
+func (r *cacheRecorder) Header() http.Header {
+	if r.held {
+		return r.header
+	}
+	return r.w.Header()
+}
+
+func (r *cacheRecorder) WriteHeader(status int) {
+	r.status = status
+	if !r.held {
+		r.w.WriteHeader(status)
+	}
+}
+
+func (r *cacheRecorder) Write(b []byte) (int, error) {
+	if r.held {
+		// Held responses are buffered whole; the limit only decides storing
+		r.body.Write(b)
+		r.overflow = r.body.Len() > r.limit
+		return len(b), nil
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > r.limit {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.w.Write(b)
+}
+
+func (r *cacheRecorder) Flush() {
+	if f, ok := r.w.(http.Flusher); ok && !r.held {
+		f.Flush()
+	}
+}
+
+func (r *cacheRecorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+// flushTo sends a held response to w.
+func (r *cacheRecorder) flushTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
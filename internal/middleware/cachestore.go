@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+)
+
+// Response cache backends, chosen per route.
+const (
+	// CacheBackendRedis shares entries between replicas.
+	CacheBackendRedis = "redis"
+	// CacheBackendMemory keeps entries in the replica, for deployments
+	// without Redis.
+	CacheBackendMemory = "memory"
+	// CacheBackendTiered reads through memory to Redis.
+	CacheBackendTiered = "tiered"
+)
+
+// Cache holds cached responses by key.
+type Cache interface {
+	// Get returns the value stored at key, or nil when there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// redisCache stores entries in Redis, shared by every replica.
+type redisCache struct {
+	redis *infrastructure.RedisClient
+}
+
+func (r redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	raw, err := r.redis.GetValue(ctx, key)
+	if err != nil || raw == "" {
+		return nil, err
+	}
+	return []byte(raw), nil
+}
+
+func (r redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.redis.SetValue(ctx, key, string(value), ttl)
+}
+
+// memoryCache is an in-process LRU bounded by the bytes of its keys and
+// values. Expired entries are dropped when read or evicted.
+type memoryCache struct {
+	maxBytes int64
+	now      func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *memoryEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	e := el.Value.(*memoryEntry)
+	if !m.now().Before(e.expires) {
+		m.remove(el)
+		return nil, nil
+	}
+	m.order.MoveToFront(el)
+	return e.value, nil
+}
+
+// Set stores a value, evicting the least recently used entries to make room.
+// Values larger than the whole cache are not stored.
+func (m *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := &memoryEntry{key: key, value: value, expires: m.now().Add(ttl)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	if e.size() > m.maxBytes {
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(e)
+	m.size += e.size()
+	for m.size > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+	metrics.CacheMemoryBytes.Set(float64(m.size))
+	return nil
+}
+
+// deletePrefix drops the entries whose keys start with prefix and returns how
+// many it dropped.
+func (m *memoryCache) deletePrefix(prefix string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for key, el := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// remove drops an entry. Callers hold mu.
+func (m *memoryCache) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.size -= e.size()
+	metrics.CacheMemoryBytes.Set(float64(m.size))
+}
+
+// tieredCache reads from memory first and from Redis on a miss, keeping what
+// it finds there in memory for up to localTTL. Replicas may thus serve an
+// entry up to localTTL older than the shared one.
+type tieredCache struct {
+	local    Cache
+	shared   Cache
+	localTTL time.Duration
+}
+
+func (t tieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, _ := t.local.Get(ctx, key); value != nil {
+		return value, nil
+	}
+	value, err := t.shared.Get(ctx, key)
+	if value != nil {
+		t.local.Set(ctx, key, value, t.localTTL)
+	}
+	return value, err
+}
+
+func (t tieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	t.local.Set(ctx, key, value, min(ttl, t.localTTL))
+	return t.shared.Set(ctx, key, value, ttl)
+}
@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// AppVersionHeader identifies the app as "<platform>/<version>", or a
+	// bare version with the platform taken from the User-Agent.
+	AppVersionHeader = "X-App-Version"
+	// UpgradeRequiredCode marks force-upgrade responses, so apps can show
+	// their update screen.
+	UpgradeRequiredCode = "CLIENT_UPGRADE_REQUIRED"
+	// maxVersionLabels bounds the app versions this replica reports in
+	// metrics; versions seen after that are counted as "other".
+	maxVersionLabels = 100
+)
+
+// AppClient is the app a request comes from.
+type AppClient struct {
+	Platform string
+	Version  string
+}
+
+// ClientPolicy identifies the app behind each request, answers apps older
+// than the route's minimum version with 426 and a force-upgrade code, and
+// counts requests by app version.
+type ClientPolicy struct {
+	cfg       config.ClientPolicyConfig
+	userAgent *regexp.Regexp
+	// minimums by route path, then platform; the "" route holds the defaults
+	minimums  map[string]map[string][]int
+	platforms map[string]bool
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	versions map[string]bool // version labels reported so far
+}
+
+func NewClientPolicy(cfg *config.Config, logger *zap.Logger) *ClientPolicy {
+	p := &ClientPolicy{
+		cfg:       cfg.ClientPolicy,
+		minimums:  make(map[string]map[string][]int),
+		platforms: make(map[string]bool),
+		logger:    logger,
+		versions:  make(map[string]bool),
+	}
+	if pattern := cfg.ClientPolicy.UserAgentPattern; pattern != "" {
+		// Validated when the configuration is loaded
+		p.userAgent, _ = regexp.Compile(pattern)
+	}
+	add := func(route string, mins map[string]string) {
+		if p.minimums[route] == nil {
+			p.minimums[route] = make(map[string][]int)
+		}
+		for platform, v := range mins {
+			platform = strings.ToLower(platform)
+			p.minimums[route][platform], _ = parseAppVersion(v)
+			p.platforms[platform] = true
+		}
+	}
+	add("", cfg.ClientPolicy.MinVersions)
+	for _, r := range cfg.Routes {
+		if len(r.MinClientVersions) > 0 {
+			add(r.Path, r.MinClientVersions)
+		}
+	}
+	for platform := range cfg.ClientPolicy.UpgradeURLs {
+		p.platforms[strings.ToLower(platform)] = true
+	}
+	return p
+}
+
+// Identify returns the app a request comes from, if it names one.
+func (p *ClientPolicy) Identify(req *http.Request) (AppClient, bool) {
+	var ua AppClient
+	if p.userAgent != nil {
+		if m := p.userAgent.FindStringSubmatch(req.UserAgent()); m != nil {
+			if i := p.userAgent.SubexpIndex("platform"); i >= 0 {
+				ua.Platform = strings.ToLower(m[i])
+			}
+			ua.Version = m[p.userAgent.SubexpIndex("version")]
+		}
+	}
+
+	header := strings.TrimSpace(req.Header.Get(AppVersionHeader))
+	if header == "" {
+		return ua, ua.Version != ""
+	}
+	app := AppClient{Platform: ua.Platform, Version: header}
+	if platform, version, ok := strings.Cut(header, "/"); ok {
+		app = AppClient{Platform: strings.ToLower(platform), Version: version}
+	}
+	return app, true
+}
+
+// Handle enforces the minimum version and counts the request.
+func (p *ClientPolicy) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app, ok := p.Identify(c.Request())
+		if !ok {
+			return next(c)
+		}
+		metrics.ClientRequests.WithLabelValues(p.platformLabel(app.Platform), p.versionLabel(app.Version), c.Path()).Inc()
+		return p.enforce(c, app, next)
+	}
+}
+
+// Enforce only enforces the minimum version, for dry runs.
+func (p *ClientPolicy) Enforce(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app, ok := p.Identify(c.Request())
+		if !ok {
+			return next(c)
+		}
+		return p.enforce(c, app, next)
+	}
+}
+
+// enforce answers apps older than the route's minimum version with 426 and
+// the store link to update from.
+func (p *ClientPolicy) enforce(c echo.Context, app AppClient, next echo.HandlerFunc) error {
+	c.Set("app_platform", app.Platform)
+	c.Set("app_version", app.Version)
+
+	floor, ok := p.minimums[c.Path()][app.Platform]
+	if !ok {
+		floor, ok = p.minimums[""][app.Platform]
+	}
+	if !ok {
+		return next(c)
+	}
+	version, valid := parseAppVersion(app.Version)
+	if !valid || compareAppVersions(version, floor) >= 0 {
+		return next(c)
+	}
+
+	minVersion := formatAppVersion(floor)
+	metrics.ClientUpgradeRequired.WithLabelValues(p.platformLabel(app.Platform), c.Path()).Inc()
+	Explain(c, "client_policy", "deny", app.Platform+" "+app.Version+" is older than "+minVersion)
+	p.logger.Info("Outdated app turned away",
+		zap.String("platform", app.Platform),
+		zap.String("version", app.Version),
+		zap.String("min_version", minVersion),
+		zap.String("route", c.Path()),
+		zap.String("request_id", RequestIDFrom(c)),
+	)
+	body := map[string]string{
+		"error":       "This app version is no longer supported, please update",
+		"code":        UpgradeRequiredCode,
+		"min_version": minVersion,
+	}
+	if link := p.cfg.UpgradeURLs[app.Platform]; link != "" {
+		body["upgrade_url"] = link
+	}
+	return c.JSON(http.StatusUpgradeRequired, body)
+}
+
+// platformLabel keeps platforms named in the configuration; others are
+// "other" so clients cannot grow the metric without bound.
+func (p *ClientPolicy) platformLabel(platform string) string {
+	switch {
+	case platform == "":
+		return "unknown"
+	case p.platforms[platform]:
+		return platform
+	}
+	return "other"
+}
+
+// versionLabel reduces a version to major.minor, up to maxVersionLabels
+// distinct values.
+func (p *ClientPolicy) versionLabel(raw string) string {
+	v, ok := parseAppVersion(raw)
+	if !ok {
+		return "invalid"
+	}
+	label := strconv.Itoa(v[0])
+	if len(v) > 1 {
+		label += "." + strconv.Itoa(v[1])
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.versions[label] {
+		if len(p.versions) >= maxVersionLabels {
+			return "other"
+		}
+		p.versions[label] = true
+	}
+	return label
+}
+
+// parseAppVersion parses a dotted numeric version such as 5.2.1.
+func parseAppVersion(s string) ([]int, bool) {
+	if s == "" {
+		return nil, false
+	}
+	parts := strings.Split(s, ".")
+	v := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// compareAppVersions compares a and b segment by segment, missing segments
+// counting as 0, so 5.2 equals 5.2.0.
+func compareAppVersions(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func formatAppVersion(v []int) string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
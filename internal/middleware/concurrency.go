@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// leaseReleaseTimeout bounds the Redis call releasing a cluster lease after
+// the request context is gone.
+const leaseReleaseTimeout = 2 * time.Second
+
+// ConcurrencyLimiter caps simultaneous in-flight requests per client IP and
+// per authenticated client, on each replica and, with Redis, across the
+// cluster.
+type ConcurrencyLimiter struct {
+	cfg    *config.Config
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func NewConcurrencyLimiter(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		cfg:      cfg,
+		redis:    redis,
+		logger:   logger,
+		inFlight: make(map[string]int),
+	}
+}
+
+// PerIP limits in-flight requests by client IP.
+func (l *ConcurrencyLimiter) PerIP(next echo.HandlerFunc) echo.HandlerFunc {
+	limits := l.cfg.Concurrency
+	return func(c echo.Context) error {
+		return l.limit(c, next, ScopeIP, c.RealIP(), limits.PerIP, limits.ClusterPerIP)
+	}
+}
+
+// PerClient limits in-flight requests by authenticated user ID. It must run
+// after the auth middleware; unauthenticated requests pass through.
+func (l *ConcurrencyLimiter) PerClient(next echo.HandlerFunc) echo.HandlerFunc {
+	limits := l.cfg.Concurrency
+	return func(c echo.Context) error {
+		userID, _ := c.Get("user_id").(string)
+		if userID == "" {
+			return next(c)
+		}
+		return l.limit(c, next, "client", userID, limits.PerClient, limits.ClusterPerClient)
+	}
+}
+
+func (l *ConcurrencyLimiter) limit(c echo.Context, next echo.HandlerFunc, scope, identity string, local, cluster int) error {
+	if IsWebSocketUpgrade(c.Request()) {
+		// Tunnels stay open for hours; websocket limits count them instead
+		return next(c)
+	}
+	key := scope + ":" + identity
+	if local > 0 {
+		if !l.acquireLocal(key, local) {
+			return l.reject(c, scope, "replica", local)
+		}
+		defer l.releaseLocal(key)
+	}
+
+	if cluster > 0 && l.redis != nil {
+		leaseKey := l.leaseKey(scope, identity)
+		leaseID := newLeaseID()
+		granted, held, err := l.redis.AcquireLease(c.Request().Context(), leaseKey, leaseID, cluster, l.cfg.Concurrency.LeaseTTL)
+		switch {
+		case err != nil:
+			// Replica limits still apply; do not fail requests on Redis errors
+			l.logger.Warn("Failed to acquire concurrency lease", zap.String("scope", scope), zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+		case !granted:
+			l.logger.Warn("Cluster concurrency limit reached", zap.String("scope", scope), zap.Int64("held", held), zap.String("request_id", RequestIDFrom(c)))
+			return l.reject(c, scope, "cluster", cluster)
+		default:
+			// Renewed so long requests, such as streams and slow uploads,
+			// keep counting
+			stop := holdLease(l.redis, l.logger, "concurrency", leaseKey, leaseID, l.cfg.Concurrency.LeaseTTL)
+			defer stop()
+		}
+	}
+
+	return next(c)
+}
+
+// holdLease keeps a cluster lease alive, renewing it every ttl/2, until the
+// returned stop is called, then releases it.
+func holdLease(redis *infrastructure.RedisClient, logger *zap.Logger, kind, key, id string, ttl time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+				renewed, err := redis.RenewLease(ctx, key, id, ttl)
+				cancel()
+				if err != nil || !renewed {
+					// The request goes on; it just stops counting
+					logger.Warn("Failed to renew "+kind+" lease", zap.Bool("expired", err == nil), zap.Error(err))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+		defer cancel()
+		if err := redis.ReleaseLease(ctx, key, id); err != nil {
+			logger.Warn("Failed to release "+kind+" lease", zap.Error(err))
+		}
+	}
+}
+
+func (l *ConcurrencyLimiter) reject(c echo.Context, scope, level string, limit int) error {
+	metrics.ConcurrencyRejected.WithLabelValues(scope, level).Inc()
+	Explain(c, "concurrency", "deny", scope+" "+level+" limit reached")
+	c.Response().Header().Set("Retry-After", "1")
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error": "Too many concurrent requests",
+		"scope": scope,
+		"limit": limit,
+	})
+}
+
+func (l *ConcurrencyLimiter) acquireLocal(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[key] >= limit {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *ConcurrencyLimiter) releaseLocal(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[key] <= 1 {
+		delete(l.inFlight, key)
+		return
+	}
+	l.inFlight[key]--
+}
+
+func (l *ConcurrencyLimiter) leaseKey(scope, identity string) string {
+	return leaseKey(l.cfg.RateLimits.KeySecret, scope, identity)
+}
+
+// leaseKey keys cluster leases by an HMAC of the identity so raw IPs and
+// user IDs are never stored in Redis.
+func leaseKey(secret, scope, identity string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(identity))
+	return "concurrency:" + scope + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+func newLeaseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
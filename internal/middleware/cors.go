@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+)
+
+// NewCORSMiddleware answers CORS preflights at the gateway, so they never
+// reach upstream services or count against rate limits. Routes with a cors
+// block in config get their own settings; everything else uses the globals.
+func NewCORSMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	global := corsWithConfig(cfg.Cors)
+
+	perRoute := make(map[string]echo.MiddlewareFunc)
+	for _, route := range cfg.Routes {
+		if route.Cors == nil {
+			continue
+		}
+		merged := *route.Cors
+		if len(merged.AllowOrigins) == 0 {
+			merged.AllowOrigins = cfg.Cors.AllowOrigins
+		}
+		if len(merged.AllowMethods) == 0 {
+			merged.AllowMethods = cfg.Cors.AllowMethods
+		}
+		if merged.MaxAge == 0 {
+			merged.MaxAge = cfg.Cors.MaxAge
+		}
+		perRoute[route.Path] = corsWithConfig(merged)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		globalHandler := global(next)
+		routeHandlers := make(map[string]echo.HandlerFunc, len(perRoute))
+		for path, mw := range perRoute {
+			routeHandlers[path] = mw(next)
+		}
+
+		return func(c echo.Context) error {
+			if h, ok := routeHandlers[c.Path()]; ok {
+				return h(c)
+			}
+			return globalHandler(c)
+		}
+	}
+}
+
+func corsWithConfig(cfg config.CorsConfig) echo.MiddlewareFunc {
+	return echoMiddleware.CORSWithConfig(echoMiddleware.CORSConfig{
+		AllowOrigins:     cfg.AllowOrigins,
+		AllowMethods:     cfg.AllowMethods,
+		AllowHeaders:     cfg.AllowHeaders,
+		ExposeHeaders:    cfg.ExposeHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	})
+}
+
+// HeadSynthesizer rewrites HEAD requests to GET before proxying on routes that
+// opt in. The original request is left as HEAD so net/http drops the body.
+func HeadSynthesizer(cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method != http.MethodHead {
+				return next(c)
+			}
+			if route := cfg.Route(c.Path()); route == nil || !route.SynthesizeHead {
+				return next(c)
+			}
+
+			get := req.Clone(req.Context())
+			get.Method = http.MethodGet
+			c.SetRequest(get)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// DeadlineHeader carries the client's end-to-end deadline, as RFC 3339 or
+	// Unix milliseconds. It is forwarded upstream as RFC 3339 after capping.
+	DeadlineHeader = "X-Request-Deadline"
+	// BudgetHeader tells the upstream how many milliseconds remain, for
+	// services that would rather not trust the gateway's clock.
+	BudgetHeader = "X-Request-Budget-Ms"
+)
+
+// DeadlineGuard bounds requests by the deadline a trusted client sends, so
+// work on requests the client has already given up on (e.g. timed-out
+// mobile retries) stops at the gateway or as soon as the deadline passes.
+type DeadlineGuard struct {
+	cfg     *config.Config
+	trusted []*net.IPNet
+	logger  *zap.Logger
+}
+
+func NewDeadlineGuard(cfg *config.Config, logger *zap.Logger) *DeadlineGuard {
+	g := &DeadlineGuard{cfg: cfg, logger: logger}
+	for _, cidr := range cfg.Deadline.TrustedCIDRs {
+		// Validated when the configuration is loaded
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			g.trusted = append(g.trusted, network)
+		}
+	}
+	return g
+}
+
+func (g *DeadlineGuard) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		// Only the gateway sets the budget upstreams rely on
+		req.Header.Del(BudgetHeader)
+		value := req.Header.Get(DeadlineHeader)
+		if value == "" {
+			return next(c)
+		}
+		if !g.isTrusted(req.RemoteAddr) {
+			req.Header.Del(DeadlineHeader)
+			metrics.DeadlineRequests.WithLabelValues("untrusted").Inc()
+			Explain(c, "deadline", "skip", "client not trusted to set a deadline")
+			return next(c)
+		}
+
+		deadline, ok := parseDeadline(value)
+		if !ok {
+			metrics.DeadlineRequests.WithLabelValues("invalid").Inc()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid " + DeadlineHeader + " header"})
+		}
+
+		now := time.Now()
+		if !deadline.After(now) {
+			metrics.DeadlineRequests.WithLabelValues("expired").Inc()
+			Explain(c, "deadline", "deny", "expired "+now.Sub(deadline).Round(time.Millisecond).String()+" ago")
+			g.logger.Info("Request deadline already passed",
+				zap.String("path", req.URL.Path),
+				zap.Time("deadline", deadline),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+			return c.JSON(http.StatusGatewayTimeout, map[string]string{"error": "Request deadline has already passed"})
+		}
+
+		outcome := "applied"
+		if limit := g.maxBudget(c.Path()); limit > 0 && deadline.Sub(now) > limit {
+			deadline = now.Add(limit)
+			outcome = "capped"
+		}
+		metrics.DeadlineRequests.WithLabelValues(outcome).Inc()
+		Explain(c, "deadline", "allow", deadline.Sub(now).Round(time.Millisecond).String()+" budget ("+outcome+")")
+
+		ctx, cancel := context.WithDeadline(req.Context(), deadline)
+		defer cancel()
+		req.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
+
+// maxBudget is the route's cap, or the global one.
+func (g *DeadlineGuard) maxBudget(path string) time.Duration {
+	if route := g.cfg.Route(path); route != nil && route.MaxDeadline > 0 {
+		return route.MaxDeadline
+	}
+	return g.cfg.Deadline.MaxBudget
+}
+
+func (g *DeadlineGuard) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range g.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDeadline(value string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// SetBudget sets the remaining budget of a request with a client deadline on
+// an outgoing request, and drops any budget the client sent itself.
+func SetBudget(c echo.Context, out *http.Request) {
+	out.Header.Del(BudgetHeader)
+	if out.Header.Get(DeadlineHeader) == "" {
+		return
+	}
+	if deadline, ok := c.Request().Context().Deadline(); ok {
+		out.Header.Set(BudgetHeader, strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 0), 10))
+	}
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/banking/api-gateway/internal/clock"
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type deprecationPolicy struct {
+	cfg           config.DeprecationConfig
+	since         time.Time
+	sunset        time.Time
+	brownoutStart time.Time
+}
+
+type DeprecationMiddleware struct {
+	logger   *zap.Logger
+	policies map[string]*deprecationPolicy
+	now      func() time.Time
+	clock    *clock.Guard
+}
+
+func NewDeprecationMiddleware(cfg *config.Config, logger *zap.Logger) (*DeprecationMiddleware, error) {
+	m := &DeprecationMiddleware{
+		logger:   logger,
+		policies: make(map[string]*deprecationPolicy),
+		now:      time.Now,
+	}
+
+	for _, route := range cfg.Routes {
+		if route.Deprecation == nil {
+			continue
+		}
+		d := route.Deprecation
+		p := &deprecationPolicy{cfg: *d}
+
+		var err error
+		if p.since, err = parseConfigDate(d.Since); err != nil {
+			return nil, fmt.Errorf("route %s: invalid deprecation since: %w", route.Path, err)
+		}
+		if p.sunset, err = parseConfigDate(d.Sunset); err != nil {
+			return nil, fmt.Errorf("route %s: invalid deprecation sunset: %w", route.Path, err)
+		}
+		if d.Brownout != nil {
+			if d.Brownout.Duration <= 0 || d.Brownout.Every < d.Brownout.Duration {
+				return nil, fmt.Errorf("route %s: brownout requires 0 < duration <= every", route.Path)
+			}
+			if p.brownoutStart, err = parseConfigDate(d.Brownout.Start); err != nil {
+				return nil, fmt.Errorf("route %s: invalid brownout start: %w", route.Path, err)
+			}
+		}
+		m.policies[route.Path] = p
+	}
+
+	return m, nil
+}
+
+// UseClock stops sunsets and brown-outs from being enforced on an untrusted
+// clock: they are skipped in fail-open mode and answered with 503 in
+// fail-closed mode.
+func (m *DeprecationMiddleware) UseClock(g *clock.Guard) {
+	m.clock = g
+}
+
+// Handle emits Deprecation, Sunset and Link headers for deprecated routes and
+// answers 410 Gone during scheduled brown-outs or after an enforced sunset.
+func (m *DeprecationMiddleware) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, ok := m.policies[c.Path()]
+		if !ok {
+			return next(c)
+		}
+
+		now := m.now()
+		h := c.Response().Header()
+		if !p.since.IsZero() {
+			h.Set("Deprecation", "@"+strconv.FormatInt(p.since.Unix(), 10))
+		} else {
+			h.Set("Deprecation", "true")
+		}
+		if !p.sunset.IsZero() {
+			h.Set("Sunset", p.sunset.UTC().Format(http.TimeFormat))
+		}
+		if p.cfg.Link != "" {
+			h.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, p.cfg.Link))
+		}
+		if p.cfg.Successor != "" {
+			h.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, p.cfg.Successor))
+		}
+
+		enforce := true
+		if m.clock != nil && p.timeBased() {
+			if drift, ok := m.clock.Drift(); !ok {
+				if m.clock.FailClosed() {
+					Explain(c, "deprecation", "deny", "clock drift "+drift.String()+" exceeds threshold")
+					return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Route schedule cannot be verified, gateway clock is out of sync"})
+				}
+				Explain(c, "deprecation", "warn", "sunset and brown-outs skipped, clock drift "+drift.String())
+				enforce = false
+			}
+		}
+
+		if enforce && p.cfg.EnforceSunset && !p.sunset.IsZero() && !now.Before(p.sunset) {
+			Explain(c, "deprecation", "deny", "retired at sunset")
+			return c.JSON(http.StatusGone, map[string]string{
+				"error":  "This endpoint has been retired",
+				"sunset": p.sunset.UTC().Format(time.RFC3339),
+			})
+		}
+
+		if enforce && p.inBrownout(now) {
+			Explain(c, "deprecation", "deny", "scheduled brown-out")
+			m.logger.Warn("Deprecated route brown-out", zap.String("path", c.Path()), zap.String("request_id", RequestIDFrom(c)))
+			return c.JSON(http.StatusGone, map[string]string{
+				"error":  "This endpoint is deprecated and temporarily unavailable (scheduled brown-out)",
+				"sunset": p.sunset.UTC().Format(time.RFC3339),
+			})
+		}
+
+		Explain(c, "deprecation", "warn", "deprecated route")
+		err := next(c)
+
+		// user_id is only known once the auth middleware further down has run
+		userID, _ := c.Get("user_id").(string)
+		m.logger.Info("Deprecated route called",
+			zap.String("path", c.Path()),
+			zap.String("user_id", userID),
+			zap.String("ip", c.RealIP()),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return err
+	}
+}
+
+// timeBased reports whether the policy can reject requests depending on the
+// current time.
+func (p *deprecationPolicy) timeBased() bool {
+	return p.cfg.Brownout != nil || (p.cfg.EnforceSunset && !p.sunset.IsZero())
+}
+
+func (p *deprecationPolicy) inBrownout(now time.Time) bool {
+	b := p.cfg.Brownout
+	if b == nil || now.Before(p.brownoutStart) {
+		return false
+	}
+	return now.Sub(p.brownoutStart)%b.Every < b.Duration
+}
+
+func parseConfigDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return parseQueryDate(value)
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// upstreamResponseKey marks a response written by a backend.
+const upstreamResponseKey = "upstream_response"
+
+// MarkUpstream records that the response comes from a backend, so the
+// gateway's error pages leave it as it is.
+func MarkUpstream(c echo.Context) {
+	c.Set(upstreamResponseKey, true)
+}
+
+// ErrorPages adds a localized message and support contacts to JSON error
+// responses produced by the gateway itself (rate limiting, authentication,
+// read-only switches, overload). The brand comes from Branding and the
+// locale from Accept-Language.
+type ErrorPages struct {
+	cfg    config.ErrorPagesConfig
+	brands map[string]*errorBrand
+	logger *zap.Logger
+}
+
+type errorBrand struct {
+	name     string
+	support  config.SupportContact
+	statuses map[int]bool
+	// messages by locale, then status
+	messages map[string]map[int]*template.Template
+}
+
+// errorPageData is what message templates are rendered with.
+type errorPageData struct {
+	Status     int
+	Error      string
+	Code       string
+	RetryAfter string
+	RequestID  string
+	Brand      string
+	Locale     string
+	Support    config.SupportContact
+}
+
+// NewErrorPages parses the brands' message templates; config.Load has
+// already rejected malformed ones.
+func NewErrorPages(cfg *config.Config, logger *zap.Logger) *ErrorPages {
+	p := &ErrorPages{
+		cfg:    cfg.ErrorPages,
+		brands: make(map[string]*errorBrand),
+		logger: logger,
+	}
+	for name, bc := range cfg.Branding.Brands {
+		b := &errorBrand{
+			name:     name,
+			support:  bc.Support,
+			statuses: make(map[int]bool),
+			messages: make(map[string]map[int]*template.Template, len(bc.Messages)),
+		}
+		for locale, messages := range bc.Messages {
+			byStatus := make(map[int]*template.Template, len(messages))
+			for status, text := range messages {
+				code, _ := strconv.Atoi(status)
+				byStatus[code] = template.Must(template.New(status).Parse(text))
+				b.statuses[code] = true
+			}
+			b.messages[strings.ToLower(locale)] = byStatus
+		}
+		p.brands[name] = b
+	}
+	return p
+}
+
+func (p *ErrorPages) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var brand *errorBrand
+		if b := BrandFrom(c); b != nil {
+			brand = p.brands[b.Name]
+		}
+		if brand == nil || strings.HasPrefix(c.Path(), "/admin") {
+			return next(c)
+		}
+
+		res := c.Response()
+		w := &errorPageWriter{ResponseWriter: res.Writer}
+		w.hold = func(code int) bool {
+			upstream, _ := c.Get(upstreamResponseKey).(bool)
+			return !upstream && brand.statuses[code]
+		}
+		res.Writer = w
+		defer func() { res.Writer = w.ResponseWriter }()
+
+		err := next(c)
+		var he *echo.HTTPError
+		if errors.As(err, &he) && !res.Committed && w.hold(he.Code) {
+			// Write the error now, while its body can still be rewritten
+			c.Error(err)
+			err = nil
+		}
+		if w.held {
+			if rerr := p.render(c, w, brand); rerr != nil {
+				return rerr
+			}
+		}
+		return err
+	}
+}
+
+// render sends the held error response with the brand's message and support
+// contacts added. Bodies that are not JSON objects are sent unchanged.
+func (p *ErrorPages) render(c echo.Context, w *errorPageWriter, brand *errorBrand) error {
+	body := w.buf.Bytes()
+	locale, tmpl := brand.message(c.Request().Header.Get("Accept-Language"), p.cfg.DefaultLocale, w.status)
+
+	var fields map[string]interface{}
+	if tmpl != nil && strings.Contains(w.Header().Get(echo.HeaderContentType), "json") &&
+		json.Unmarshal(body, &fields) == nil && fields != nil {
+		data := errorPageData{
+			Status:     w.status,
+			Code:       stringField(fields, "code"),
+			RetryAfter: w.Header().Get("Retry-After"),
+			RequestID:  RequestIDFrom(c),
+			Brand:      brand.name,
+			Locale:     locale,
+			Support:    brand.support,
+		}
+		if data.Error = stringField(fields, "error"); data.Error == "" {
+			data.Error = stringField(fields, "message")
+		}
+
+		var msg strings.Builder
+		if err := tmpl.Execute(&msg, data); err != nil {
+			p.logger.Warn("Failed to render error message",
+				zap.String("brand", brand.name),
+				zap.String("locale", locale),
+				zap.Int("status", w.status),
+				zap.String("request_id", data.RequestID),
+				zap.Error(err),
+			)
+		} else {
+			fields["message"] = msg.String()
+			if brand.support != (config.SupportContact{}) {
+				fields["support"] = brand.support
+			}
+			if out, err := json.Marshal(fields); err == nil {
+				body = out
+				w.Header().Set("Content-Language", locale)
+				w.Header().Set(echo.HeaderContentLength, strconv.Itoa(len(body)))
+			}
+		}
+	}
+
+	w.held = false
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	s, _ := fields[name].(string)
+	return s
+}
+
+// message returns the template for status in the first locale of the
+// Accept-Language header the brand has, trying each tag's base language
+// after it, or else in the default locale.
+func (b *errorBrand) message(acceptLanguage, defaultLocale string, status int) (string, *template.Template) {
+	for _, locale := range append(preferredLocales(acceptLanguage), strings.ToLower(defaultLocale)) {
+		if t := b.messages[locale][status]; t != nil {
+			return locale, t
+		}
+	}
+	return "", nil
+}
+
+// preferredLocales lists the lower-cased language tags of an Accept-Language
+// header by descending weight, each followed by its base language.
+func preferredLocales(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	locales := make([]string, 0, 2*len(tags))
+	for _, t := range tags {
+		locales = append(locales, t.tag)
+		if base, _, found := strings.Cut(t.tag, "-"); found {
+			locales = append(locales, base)
+		}
+	}
+	return locales
+}
+
+// errorPageWriter holds back error responses the brand has a message for,
+// passing everything else straight through.
+type errorPageWriter struct {
+	http.ResponseWriter
+	hold   func(code int) bool
+	held   bool
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.hold(code) {
+		w.held, w.status = true, code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if w.held {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.held {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// ExplainHeader carries the explain key on the request.
+	ExplainHeader = "X-Gateway-Explain"
+	// ExplainTraceHeader carries the JSON decision trace on the response.
+	ExplainTraceHeader = "X-Gateway-Explain-Trace"
+
+	explainKey         = "explain:enabled"
+	explainContextKey  = "explain_trace"
+	defaultExplainTTL  = 15 * time.Minute
+	maxExplainDuration = 4 * time.Hour
+)
+
+// Decision is one policy outcome recorded while explaining a request.
+type Decision struct {
+	Stage  string `json:"stage"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type explainTrace struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+// Explain records a policy decision for the current request. It is a no-op
+// unless the request is being explained.
+func Explain(c echo.Context, stage, result, detail string) {
+	t, ok := c.Get(explainContextKey).(*explainTrace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.decisions = append(t.decisions, Decision{Stage: stage, Result: result, Detail: detail})
+	t.mu.Unlock()
+}
+
+// ExplainMode returns a decision trace to callers presenting the explain key
+// while the mode is switched on. The switch lives in Redis with an expiry so
+// every replica follows it and it cannot be left on by accident.
+type ExplainMode struct {
+	key    string
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+	audit  *zap.Logger
+
+	// local switch used when Redis is unavailable
+	mu    sync.RWMutex
+	until time.Time
+}
+
+func NewExplainMode(key string, redis *infrastructure.RedisClient, logger *zap.Logger) *ExplainMode {
+	return &ExplainMode{
+		key:    key,
+		redis:  redis,
+		logger: logger,
+		audit:  logger.Named("audit"),
+	}
+}
+
+// Enable switches explain mode on for ttl (15 minutes by default, at most 4h).
+func (m *ExplainMode) Enable(ctx context.Context, ttl time.Duration, by string) (time.Time, error) {
+	if ttl <= 0 {
+		ttl = defaultExplainTTL
+	}
+	ttl = min(ttl, maxExplainDuration)
+	until := time.Now().Add(ttl)
+
+	if m.redis != nil {
+		if err := m.redis.SetValue(ctx, explainKey, by, ttl); err != nil {
+			return time.Time{}, err
+		}
+	}
+	m.mu.Lock()
+	m.until = until
+	m.mu.Unlock()
+
+	m.audit.Info("Explain mode enabled", zap.String("event", "explain_mode"), zap.String("set_by", by), zap.Time("until", until))
+	return until, nil
+}
+
+// Disable switches explain mode off.
+func (m *ExplainMode) Disable(ctx context.Context, by string) error {
+	if m.redis != nil {
+		if err := m.redis.DeleteKey(ctx, explainKey); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	m.until = time.Time{}
+	m.mu.Unlock()
+
+	m.audit.Info("Explain mode disabled", zap.String("event", "explain_mode"), zap.String("set_by", by))
+	return nil
+}
+
+// Active reports whether explain mode is on and, when known, until when.
+func (m *ExplainMode) Active(ctx context.Context) (bool, time.Duration) {
+	if m.redis != nil {
+		ttl, err := m.redis.TTL(ctx, explainKey)
+		if err == nil {
+			return ttl > 0, max(ttl, 0)
+		}
+		m.logger.Warn("Failed to read explain mode switch", zap.Error(err))
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	remaining := time.Until(m.until)
+	return remaining > 0, max(remaining, 0)
+}
+
+// Handle attaches a decision trace to requests carrying a valid explain key
+// and writes it to the response header just before the status is sent.
+func (m *ExplainMode) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		presented := c.Request().Header.Get(ExplainHeader)
+		if presented == "" || m.key == "" {
+			return next(c)
+		}
+		// Never forward the key upstream
+		c.Request().Header.Del(ExplainHeader)
+
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(m.key)) != 1 {
+			return next(c)
+		}
+		if on, _ := m.Active(c.Request().Context()); !on {
+			return next(c)
+		}
+
+		trace := &explainTrace{}
+		c.Set(explainContextKey, trace)
+		c.Response().Before(func() {
+			trace.mu.Lock()
+			defer trace.mu.Unlock()
+			if data, err := json.Marshal(trace.decisions); err == nil {
+				c.Response().Header().Set(ExplainTraceHeader, string(data))
+			}
+		})
+
+		m.logger.Info("Explaining request",
+			zap.String("path", c.Request().URL.Path),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return next(c)
+	}
+}
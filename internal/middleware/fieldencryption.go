@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fpe"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Protected field formats.
+const (
+	FieldFormatPAN    = "pan"
+	FieldFormatOpaque = "opaque"
+)
+
+// opaquePrefix marks a value encrypted whole.
+const opaquePrefix = "enc:v1:"
+
+// Card numbers tokenize with their last four digits kept, the digit before
+// them fixing the Luhn sum: a card's is 0 mod 10, a token's panTokenSum.
+const panTokenSum = 1
+
+// fieldError is a protected field that can be neither tokenized nor
+// restored.
+type fieldError struct {
+	path   string
+	reason string
+}
+
+func (e *fieldError) Error() string {
+	return e.path + ": " + e.reason
+}
+
+// FieldEncryption tokenizes card data in JSON request bodies before they
+// reach services outside PCI scope, and restores it for the services in
+// scope. Card numbers keep their format; other fields, e.g. security codes,
+// become opaque tokens. Nothing is stored: tokens decrypt with the key.
+type FieldEncryption struct {
+	cfg    *config.Config
+	pan    *fpe.FF1
+	aead   cipher.AEAD
+	logger *zap.Logger
+}
+
+func NewFieldEncryption(cfg *config.Config, logger *zap.Logger) (*FieldEncryption, error) {
+	f := &FieldEncryption{cfg: cfg, logger: logger}
+	if len(cfg.FieldEncryption.Fields) == 0 {
+		return f, nil
+	}
+	// Validated when the configuration is loaded
+	key, _ := base64.StdEncoding.DecodeString(cfg.FieldEncryption.Key)
+	pan, err := fpe.NewFF1(deriveFieldKey(key, FieldFormatPAN))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(deriveFieldKey(key, FieldFormatOpaque))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	f.pan, f.aead = pan, aead
+	return f, nil
+}
+
+// deriveFieldKey gives each format its own key.
+func deriveFieldKey(key []byte, format string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("field_encryption " + format))
+	return mac.Sum(nil)
+}
+
+// ForService returns middleware that tokenizes the protected fields of
+// requests to the named service, or restores them when the service has
+// pci_scope. A protected field holding neither card data nor a token is
+// rejected rather than forwarded.
+func (f *FieldEncryption) ForService(serviceName string) echo.MiddlewareFunc {
+	restore := f.cfg.Services[serviceName].PCIScope
+	result := "tokenized"
+	if restore {
+		result = "restored"
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if len(f.cfg.FieldEncryption.Fields) == 0 {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody || !isJSONContent(req.Header.Get(echo.HeaderContentType)) {
+				return next(c)
+			}
+
+			raw, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+			}
+
+			var out []byte
+			var changed int
+			err = runBudgeted(c, f.cfg, "field_encryption", 2*int64(len(raw)), func(ctx context.Context) (err error) {
+				out, changed, err = f.rewrite(ctx, raw, restore)
+				return err
+			})
+			var budgetErr *budgetError
+			var fieldErr *fieldError
+			switch {
+			case errors.As(err, &budgetErr):
+				return rejectOverBudget(c, f.logger, budgetErr)
+			case errors.As(err, &fieldErr):
+				metrics.FieldEncryptions.WithLabelValues(serviceName, "rejected").Inc()
+				Explain(c, "field_encryption", "deny", fieldErr.Error())
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid card data",
+					"field": fieldErr.path,
+				})
+			case errors.Is(err, errMalformedBody):
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Malformed JSON body"})
+			case err != nil:
+				return err
+			}
+			if changed == 0 {
+				req.Body = io.NopCloser(bytes.NewReader(raw))
+				return next(c)
+			}
+
+			metrics.FieldEncryptions.WithLabelValues(serviceName, result).Add(float64(changed))
+			Explain(c, "field_encryption", "allow", fmt.Sprintf("%d fields %s for %s", changed, result, serviceName))
+			req.Body = io.NopCloser(bytes.NewReader(out))
+			if req.GetBody != nil {
+				// Replays (buffered bodies) must send the rewritten body too
+				req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(out)), nil }
+			}
+			req.ContentLength = int64(len(out))
+			req.Header.Del(echo.HeaderContentLength)
+			return next(c)
+		}
+	}
+}
+
+// rewrite tokenizes or restores every protected field of a JSON document,
+// returning it with the number of fields changed.
+func (f *FieldEncryption) rewrite(ctx context.Context, raw []byte, restore bool) ([]byte, int, error) {
+	var doc interface{}
+	if err := jsonutil.Unmarshal(raw, &doc); err != nil {
+		return nil, 0, errMalformedBody
+	}
+
+	var visited, changed int
+	for _, field := range f.cfg.FieldEncryption.Fields {
+		err := walkFields(doc, strings.Split(field.Path, "."), func(obj map[string]interface{}, key string) error {
+			if err := checkBudget(ctx, &visited); err != nil {
+				return err
+			}
+			s, ok := obj[key].(string)
+			if !ok {
+				return &fieldError{path: field.Path, reason: "must be a string"}
+			}
+			var out string
+			var err error
+			switch {
+			case field.Format == FieldFormatPAN && restore:
+				out, err = f.restorePAN(s)
+			case field.Format == FieldFormatPAN:
+				out, err = f.tokenizePAN(s)
+			case restore:
+				out, err = f.restoreOpaque(s, field.Path)
+			default:
+				out, err = f.tokenizeOpaque(s, field.Path)
+			}
+			if err != nil {
+				return &fieldError{path: field.Path, reason: err.Error()}
+			}
+			if out != s {
+				obj[key] = out
+				changed++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if changed == 0 {
+		return raw, 0, nil
+	}
+	out, err := jsonutil.Marshal(doc)
+	return out, changed, err
+}
+
+// tokenizePAN encrypts a card number but for its last four digits, which
+// tweak the encryption, and sets the digit before them so the token fails
+// the Luhn check. Tokens pass unchanged.
+func (f *FieldEncryption) tokenizePAN(s string) (string, error) {
+	pan, ok := cardDigits(s)
+	switch {
+	case !ok:
+		return "", errors.New("not a card number")
+	case luhnSum(pan)%10 == panTokenSum:
+		return s, nil
+	case luhnSum(pan)%10 != 0:
+		return "", errors.New("not a card number")
+	}
+	n := len(pan)
+	last4 := pan[n-4:]
+	head, err := f.pan.Encrypt(pan[:n-5], []byte(last4))
+	if err != nil {
+		return "", err
+	}
+	// The fifth digit from the right is not doubled by Luhn
+	sum := luhnSum(head + "0" + last4)
+	return head + strconv.Itoa((10+panTokenSum-sum%10)%10) + last4, nil
+}
+
+// restorePAN decrypts a card number tokenized by tokenizePAN. Card numbers
+// pass unchanged.
+func (f *FieldEncryption) restorePAN(s string) (string, error) {
+	token, ok := cardDigits(s)
+	switch {
+	case !ok:
+		return "", errors.New("not a card number")
+	case luhnSum(token)%10 == 0:
+		return s, nil
+	case luhnSum(token)%10 != panTokenSum:
+		return "", errors.New("not a card number")
+	}
+	n := len(token)
+	last4 := token[n-4:]
+	head, err := f.pan.Decrypt(token[:n-5], []byte(last4))
+	if err != nil {
+		return "", err
+	}
+	sum := luhnSum(head + "0" + last4)
+	return head + strconv.Itoa((10-sum%10)%10) + last4, nil
+}
+
+// tokenizeOpaque encrypts a value, bound to its field's path. Tokens pass
+// unchanged once they are known to decrypt, so plain text cannot pass as
+// one.
+func (f *FieldEncryption) tokenizeOpaque(s, path string) (string, error) {
+	if strings.HasPrefix(s, opaquePrefix) {
+		if _, err := f.restoreOpaque(s, path); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	nonce := make([]byte, f.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := f.aead.Seal(nonce, nonce, []byte(s), []byte(path))
+	return opaquePrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// restoreOpaque decrypts a value encrypted by tokenizeOpaque. Other values
+// pass unchanged.
+func (f *FieldEncryption) restoreOpaque(s, path string) (string, error) {
+	encoded, ok := strings.CutPrefix(s, opaquePrefix)
+	if !ok {
+		return s, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < f.aead.NonceSize() {
+		return "", errors.New("malformed token")
+	}
+	size := f.aead.NonceSize()
+	plain, err := f.aead.Open(nil, sealed[:size], sealed[size:], []byte(path))
+	if err != nil {
+		return "", errors.New("token does not decrypt")
+	}
+	return string(plain), nil
+}
+
+// cardDigits returns a card number's digits without spaces or dashes, and
+// whether it has the 12 to 19 digits of one.
+func cardDigits(s string) (string, bool) {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(digits) < 12 || len(digits) > 19 || strings.Trim(digits, "0123456789") != "" {
+		return "", false
+	}
+	return digits, true
+}
+
+// luhnSum is the Luhn checksum of digits before the final mod 10.
+func luhnSum(digits string) int {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum
+}
@@ -0,0 +1,473 @@
+package middleware
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// JA3Header and JA4Header carry the client's fingerprints to upstreams
+	// for their own risk checks; client-sent values are dropped.
+	JA3Header = "X-TLS-JA3"
+	JA4Header = "X-TLS-JA4"
+	// StepUpHeader asks the upstream to require step-up authentication
+	// before serving the request, naming the reason.
+	StepUpHeader = "X-Step-Up-Required"
+
+	FingerprintBlock  = "block"
+	FingerprintStepUp = "step_up"
+
+	fingerprintDenyKey = "tls_fingerprint:deny"
+	// fingerprintDenyTTL keeps the denylist alive while no replica is
+	// running; every change extends it.
+	fingerprintDenyTTL = 90 * 24 * time.Hour
+)
+
+var ja4Pattern = regexp.MustCompile(`^[tqd](s3|1[0-3]|00)[di]\d{4}[0-9a-z]{2}_[0-9a-f]{12}_[0-9a-f]{12}$`)
+
+// DeniedFingerprint is a denylist entry, matching a JA3 or JA4 fingerprint.
+type DeniedFingerprint struct {
+	Fingerprint string    `json:"fingerprint"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason,omitempty"`
+	SetBy       string    `json:"set_by,omitempty"`
+	SetAt       time.Time `json:"set_at"`
+}
+
+// clientHello holds the fingerprints of one TLS connection.
+type clientHello struct {
+	ja3, ja4 string
+}
+
+type connContextKey struct{}
+
+// TLSFingerprints computes JA3 and JA4 fingerprints of TLS clients, passes
+// them to upstreams and the access log, and blocks or flags for step-up the
+// clients whose fingerprint is on the denylist, e.g. known attack tools.
+// The denylist lives in Redis so every replica follows it; each replica
+// refreshes its local copy on an interval.
+type TLSFingerprints struct {
+	cfg     config.TLSFingerprintConfig
+	trusted []*net.IPNet
+	redis   *infrastructure.RedisClient
+	logger  *zap.Logger
+
+	// fingerprints of live connections on the gateway's TLS listener
+	hellos sync.Map // net.Conn -> clientHello
+
+	mu   sync.RWMutex
+	deny map[string]DeniedFingerprint
+}
+
+func NewTLSFingerprints(cfg config.TLSFingerprintConfig, redis *infrastructure.RedisClient, logger *zap.Logger) *TLSFingerprints {
+	f := &TLSFingerprints{
+		cfg:    cfg,
+		redis:  redis,
+		logger: logger,
+		deny:   make(map[string]DeniedFingerprint),
+	}
+	for _, cidr := range cfg.TrustedCIDRs {
+		// Validated when the configuration is loaded
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			f.trusted = append(f.trusted, network)
+		}
+	}
+	return f
+}
+
+// Start polls Redis for denylist changes until ctx is cancelled.
+func (f *TLSFingerprints) Start(ctx context.Context, interval time.Duration) {
+	if f.redis == nil {
+		return
+	}
+	f.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (f *TLSFingerprints) refresh(ctx context.Context) {
+	raw, err := f.redis.GetHash(ctx, fingerprintDenyKey)
+	if err != nil {
+		f.logger.Warn("Failed to refresh TLS fingerprint denylist", zap.Error(err))
+		return
+	}
+
+	deny := make(map[string]DeniedFingerprint, len(raw))
+	for fp, v := range raw {
+		var entry DeniedFingerprint
+		if err := json.Unmarshal([]byte(v), &entry); err == nil {
+			deny[fp] = entry
+		}
+	}
+
+	f.mu.Lock()
+	f.deny = deny
+	f.mu.Unlock()
+}
+
+// Denylist returns the denied fingerprints, sorted.
+func (f *TLSFingerprints) Denylist() []DeniedFingerprint {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	out := make([]DeniedFingerprint, 0, len(f.deny))
+	for _, entry := range f.deny {
+		out = append(out, entry)
+	}
+	slices.SortFunc(out, func(a, b DeniedFingerprint) int { return strings.Compare(a.Fingerprint, b.Fingerprint) })
+	return out
+}
+
+// Deny adds or updates a denylist entry.
+func (f *TLSFingerprints) Deny(ctx context.Context, entry DeniedFingerprint) (DeniedFingerprint, error) {
+	if !ValidFingerprint(entry.Fingerprint) {
+		return entry, fmt.Errorf("%q is not a JA3 or JA4 fingerprint", entry.Fingerprint)
+	}
+	if entry.Action != FingerprintBlock && entry.Action != FingerprintStepUp {
+		return entry, fmt.Errorf("action must be %q or %q", FingerprintBlock, FingerprintStepUp)
+	}
+	entry.SetAt = time.Now().UTC()
+	if f.redis != nil {
+		data, _ := json.Marshal(entry)
+		if err := f.redis.SetHashField(ctx, fingerprintDenyKey, entry.Fingerprint, string(data), fingerprintDenyTTL); err != nil {
+			return entry, err
+		}
+	}
+
+	f.mu.Lock()
+	f.deny[entry.Fingerprint] = entry
+	f.mu.Unlock()
+
+	f.logger.Warn("TLS fingerprint denied",
+		zap.String("fingerprint", entry.Fingerprint),
+		zap.String("action", entry.Action),
+		zap.String("reason", entry.Reason),
+		zap.String("set_by", entry.SetBy),
+	)
+	return entry, nil
+}
+
+// Allow removes a fingerprint from the denylist, reporting whether it was on it.
+func (f *TLSFingerprints) Allow(ctx context.Context, fingerprint, by string) (bool, error) {
+	f.mu.RLock()
+	_, ok := f.deny[fingerprint]
+	f.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if f.redis != nil {
+		if err := f.redis.DeleteHashField(ctx, fingerprintDenyKey, fingerprint); err != nil {
+			return true, err
+		}
+	}
+
+	f.mu.Lock()
+	delete(f.deny, fingerprint)
+	f.mu.Unlock()
+
+	f.logger.Warn("TLS fingerprint allowed again", zap.String("fingerprint", fingerprint), zap.String("by", by))
+	return true, nil
+}
+
+// ValidFingerprint reports whether fp is a JA3 (MD5 hex) or JA4 fingerprint.
+func ValidFingerprint(fp string) bool {
+	if len(fp) == md5.Size*2 {
+		_, err := hex.DecodeString(fp)
+		return err == nil && strings.ToLower(fp) == fp
+	}
+	return ja4Pattern.MatchString(fp)
+}
+
+// TLSConfig returns a copy of base recording the fingerprints of every
+// ClientHello, for serving the gateway's TLS listener. The server must also
+// use ConnContext and ConnState.
+func (f *TLSFingerprints) TLSConfig(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		f.hellos.Store(hello.Conn, clientHello{ja3: ja3(hello), ja4: ja4(hello)})
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return cfg
+}
+
+// ConnContext keeps each connection in its requests' context, so Handle can
+// find the connection's fingerprints.
+func (f *TLSFingerprints) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ConnState forgets a connection's fingerprints once it is closed.
+func (f *TLSFingerprints) ConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		f.hellos.Delete(tc.NetConn())
+	}
+}
+
+// fingerprints returns the request's fingerprints: from its TLS connection
+// to the gateway, else from the headers of a trusted load balancer.
+func (f *TLSFingerprints) fingerprints(req *http.Request) (string, string) {
+	if tc, ok := req.Context().Value(connContextKey{}).(*tls.Conn); ok {
+		if v, ok := f.hellos.Load(tc.NetConn()); ok {
+			h := v.(clientHello)
+			return h.ja3, h.ja4
+		}
+	}
+	if !f.isTrusted(req.RemoteAddr) {
+		return "", ""
+	}
+	var fp3, fp4 string
+	if f.cfg.JA3Header != "" {
+		fp3 = strings.ToLower(strings.TrimSpace(req.Header.Get(f.cfg.JA3Header)))
+	}
+	if f.cfg.JA4Header != "" {
+		fp4 = strings.ToLower(strings.TrimSpace(req.Header.Get(f.cfg.JA4Header)))
+	}
+	return fp3, fp4
+}
+
+func (f *TLSFingerprints) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		fp3, fp4 := f.fingerprints(req)
+		for _, h := range []string{JA3Header, JA4Header, StepUpHeader, f.cfg.JA3Header, f.cfg.JA4Header} {
+			if h != "" {
+				req.Header.Del(h)
+			}
+		}
+		if fp3 != "" {
+			c.Set("tls_ja3", fp3)
+			req.Header.Set(JA3Header, fp3)
+		}
+		if fp4 != "" {
+			c.Set("tls_ja4", fp4)
+			req.Header.Set(JA4Header, fp4)
+		}
+
+		f.mu.RLock()
+		entry, denied := f.deny[fp4]
+		if !denied {
+			entry, denied = f.deny[fp3]
+		}
+		f.mu.RUnlock()
+		if !denied || (fp3 == "" && fp4 == "") {
+			return next(c)
+		}
+
+		metrics.TLSFingerprintDenied.WithLabelValues(entry.Action).Inc()
+		if entry.Action == FingerprintStepUp {
+			Explain(c, "tls_fingerprint", "step_up", entry.Fingerprint)
+			req.Header.Set(StepUpHeader, "tls_fingerprint")
+			return next(c)
+		}
+
+		Explain(c, "tls_fingerprint", "deny", entry.Fingerprint)
+		f.logger.Warn("Request from denied TLS fingerprint blocked",
+			zap.String("fingerprint", entry.Fingerprint),
+			zap.String("ip", c.RealIP()),
+			zap.String("path", req.URL.Path),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Request blocked"})
+	}
+}
+
+func (f *TLSFingerprints) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range f.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701), which clients
+// send at random and fingerprints ignore.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ja3 computes the JA3 fingerprint: the MD5 of the ClientHello's version,
+// cipher suites, extensions, curves and point formats, in the order sent.
+func ja3(hello *tls.ClientHelloInfo) string {
+	list := func(values []uint16) string {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			if !isGREASE(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+
+	raw := strings.Join([]string{
+		strconv.Itoa(int(legacyVersion(hello))),
+		list(hello.CipherSuites),
+		list(hello.Extensions),
+		list(curves),
+		list(points),
+	}, ",")
+	sum := md5.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// legacyVersion recovers the ClientHello's legacy_version field: TLS 1.3
+// clients must send TLS 1.2 there and list their versions in the
+// supported_versions extension; older clients send their highest version.
+func legacyVersion(hello *tls.ClientHelloInfo) uint16 {
+	const supportedVersions = 43
+	if slices.Contains(hello.Extensions, supportedVersions) {
+		return tls.VersionTLS12
+	}
+	var v uint16
+	for _, sv := range hello.SupportedVersions {
+		if !isGREASE(sv) {
+			v = max(v, sv)
+		}
+	}
+	return v
+}
+
+// ja4 computes the JA4 fingerprint (TLS over TCP): protocol, version, SNI,
+// counts and first ALPN value, then truncated hashes of the sorted cipher
+// suites and of the sorted extensions with the signature algorithms.
+func ja4(hello *tls.ClientHelloInfo) string {
+	const (
+		extSNI  = 0x0000
+		extALPN = 0x0010
+	)
+
+	var top uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) {
+			top = max(top, v)
+		}
+	}
+	version := map[uint16]string{
+		tls.VersionTLS13: "13",
+		tls.VersionTLS12: "12",
+		tls.VersionTLS11: "11",
+		tls.VersionTLS10: "10",
+		0x0300:           "s3",
+	}[top]
+	if version == "" {
+		version = "00"
+	}
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+
+	var ciphers, exts []string
+	extCount := 0
+	for _, c := range hello.CipherSuites {
+		if !isGREASE(c) {
+			ciphers = append(ciphers, fmt.Sprintf("%04x", c))
+		}
+	}
+	for _, e := range hello.Extensions {
+		if isGREASE(e) {
+			continue
+		}
+		extCount++
+		if e != extSNI && e != extALPN {
+			exts = append(exts, fmt.Sprintf("%04x", e))
+		}
+	}
+	slices.Sort(ciphers)
+	slices.Sort(exts)
+
+	alpn := "00"
+	if len(hello.SupportedProtos) > 0 && hello.SupportedProtos[0] != "" {
+		p := hello.SupportedProtos[0]
+		first, last := p[0], p[len(p)-1]
+		if isAlnum(first) && isAlnum(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			h := hex.EncodeToString([]byte(p))
+			alpn = string([]byte{h[0], h[len(h)-1]})
+		}
+	}
+
+	var sigs []string
+	for _, s := range hello.SignatureSchemes {
+		if !isGREASE(uint16(s)) {
+			sigs = append(sigs, fmt.Sprintf("%04x", uint16(s)))
+		}
+	}
+	extPart := strings.Join(exts, ",")
+	if len(sigs) > 0 {
+		extPart += "_" + strings.Join(sigs, ",")
+	}
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s",
+		version, sni, min(len(ciphers), 99), min(extCount, 99), alpn,
+		truncatedHash(strings.Join(ciphers, ",")), truncatedHash(extPart))
+}
+
+// truncatedHash is the first 12 hex digits of the SHA-256 of s, or zeros
+// for an empty list.
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func isAlnum(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
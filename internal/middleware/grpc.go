@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// grpcErrorBodyLimit bounds the error body kept for grpc-message.
+const grpcErrorBodyLimit = 4 << 10
+
+// IsGRPC reports whether r is a gRPC call.
+func IsGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get(echo.HeaderContentType), "application/grpc")
+}
+
+// GRPCPaths routes gRPC calls made without the /api prefix, which gRPC
+// clients have no way to add, as if they had it. Use it with Echo#Pre.
+func GRPCPaths(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if IsGRPC(req) && !strings.HasPrefix(req.URL.Path, "/api/") {
+			req.URL.Path = "/api" + req.URL.Path
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = "/api" + req.URL.RawPath
+			}
+		}
+		return next(c)
+	}
+}
+
+// GRPCErrors answers gRPC calls the gateway itself turns away (rate limits,
+// authentication, unreachable services) the way gRPC clients expect: HTTP
+// 200 with the status in grpc-status and grpc-message and no body, a
+// trailers-only response, instead of an HTTP error they would only map to a
+// generic code. Backend responses pass unchanged. Access logs and metrics
+// still see the HTTP status.
+func GRPCErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !IsGRPC(c.Request()) {
+			return next(c)
+		}
+
+		res := c.Response()
+		w := &grpcErrorWriter{ResponseWriter: res.Writer}
+		w.hold = func(code int) bool {
+			upstream, _ := c.Get(upstreamResponseKey).(bool)
+			return !upstream && code >= http.StatusBadRequest
+		}
+		res.Writer = w
+		defer func() { res.Writer = w.ResponseWriter }()
+
+		err := next(c)
+		if err != nil && !res.Committed {
+			// Write the error now, so it is held and converted
+			c.Error(err)
+			err = nil
+		}
+		if w.held {
+			w.send()
+		}
+		return err
+	}
+}
+
+type grpcErrorWriter struct {
+	http.ResponseWriter
+	hold   func(code int) bool
+	held   bool
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *grpcErrorWriter) WriteHeader(code int) {
+	if w.hold(code) {
+		w.held, w.status = true, code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *grpcErrorWriter) Write(p []byte) (int, error) {
+	if w.held {
+		if room := grpcErrorBodyLimit - w.buf.Len(); room > 0 {
+			w.buf.Write(p[:min(len(p), room)])
+		}
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *grpcErrorWriter) Flush() {
+	if w.held {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *grpcErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// send writes the held error as a trailers-only gRPC response, with the
+// error body's message when it has one.
+func (w *grpcErrorWriter) send() {
+	message := http.StatusText(w.status)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(w.buf.Bytes(), &body) == nil && body.Error != "" {
+		message = body.Error
+	}
+
+	h := w.ResponseWriter.Header()
+	h.Del(echo.HeaderContentLength)
+	h.Set(echo.HeaderContentType, "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcStatus(w.status)))
+	h.Set("Grpc-Message", encodeGRPCMessage(message))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+// grpcStatus maps an HTTP status to the closest gRPC status code.
+func grpcStatus(httpStatus int) int {
+	switch httpStatus {
+	case http.StatusOK:
+		return 0 // OK
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return 3 // INVALID_ARGUMENT
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return 4 // DEADLINE_EXCEEDED
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 12 // UNIMPLEMENTED
+	case http.StatusForbidden:
+		return 7 // PERMISSION_DENIED
+	case http.StatusTooManyRequests:
+		return 8 // RESOURCE_EXHAUSTED
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return 9 // FAILED_PRECONDITION
+	case http.StatusUnauthorized:
+		return 16 // UNAUTHENTICATED
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return 14 // UNAVAILABLE
+	case http.StatusInternalServerError:
+		return 13 // INTERNAL
+	}
+	return 2 // UNKNOWN
+}
+
+// encodeGRPCMessage percent-encodes a grpc-message value as the gRPC HTTP/2
+// protocol requires: everything but printable ASCII, and '%' itself.
+func encodeGRPCMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,297 @@
+package middleware
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const inspectionContextKey = "inspection"
+
+// redactedHeaders are always masked in captured samples.
+var redactedHeaders = []string{
+	echo.HeaderAuthorization,
+	"Proxy-Authorization",
+	echo.HeaderCookie,
+	echo.HeaderSetCookie,
+	"X-Admin-Key",
+	"X-Api-Key",
+	ExplainHeader,
+}
+
+// Inspection is one sampled request.
+type Inspection struct {
+	RequestID       string              `json:"request_id"`
+	At              time.Time           `json:"at"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Route           string              `json:"route"`
+	QueryParams     []string            `json:"query_params,omitempty"`
+	ClientIP        string              `json:"client_ip"`
+	UserID          string              `json:"user_id,omitempty"`
+	Status          int                 `json:"status"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	// Timings are in milliseconds, measured on the monotonic clock.
+	Timings   map[string]float64 `json:"timings_ms"`
+	Decisions []Decision         `json:"decisions,omitempty"`
+}
+
+// InspectionSettings are the sampling parameters currently in force.
+type InspectionSettings struct {
+	SampleRate float64  `json:"sample_rate"`
+	Clients    []string `json:"clients"`
+}
+
+type inspection struct {
+	start   time.Time
+	mu      sync.Mutex
+	timings map[string]float64
+}
+
+// Timing records a named duration for the current request. It is a no-op
+// unless the request is being sampled by the inspector.
+func Timing(c echo.Context, name string, d time.Duration) {
+	in, ok := c.Get(inspectionContextKey).(*inspection)
+	if !ok {
+		return
+	}
+	in.mu.Lock()
+	in.timings[name] = float64(d) / float64(time.Millisecond)
+	in.mu.Unlock()
+}
+
+// Inspector captures sanitized headers, timings and policy decisions for a
+// sampled fraction of requests into a ring buffer, so intermittent partner
+// issues can be investigated without turning on debug logging. Samples and
+// runtime sampling changes are local to each replica.
+type Inspector struct {
+	logger *zap.Logger
+	audit  *zap.Logger
+	redact map[string]bool
+
+	mu       sync.RWMutex
+	settings InspectionSettings
+	samples  []Inspection
+	next     int
+	full     bool
+}
+
+func NewInspector(cfg config.InspectionConfig, logger *zap.Logger) *Inspector {
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = 500
+	}
+	redact := make(map[string]bool)
+	for _, h := range append(redactedHeaders, cfg.RedactHeaders...) {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+	return &Inspector{
+		logger:   logger,
+		audit:    logger.Named("audit"),
+		redact:   redact,
+		settings: InspectionSettings{SampleRate: cfg.SampleRate, Clients: cfg.Clients},
+		samples:  make([]Inspection, size),
+	}
+}
+
+// Settings returns the sampling parameters in force.
+func (i *Inspector) Settings() InspectionSettings {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.settings
+}
+
+// SetSampling changes the sample rate and client filter on this replica.
+func (i *Inspector) SetSampling(s InspectionSettings, by string) {
+	i.mu.Lock()
+	i.settings = s
+	i.mu.Unlock()
+
+	i.audit.Info("Inspection sampling changed",
+		zap.String("event", "inspection_sampling"),
+		zap.Float64("sample_rate", s.SampleRate),
+		zap.Strings("clients", s.Clients),
+		zap.String("set_by", by),
+	)
+}
+
+// Samples returns up to limit captured requests, newest first, optionally
+// only those of one user.
+func (i *Inspector) Samples(limit int, userID string) []Inspection {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	n := i.next
+	if i.full {
+		n = len(i.samples)
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	out := make([]Inspection, 0, limit)
+	for k := 1; k <= n && len(out) < limit; k++ {
+		s := i.samples[(i.next-k+len(i.samples))%len(i.samples)]
+		if userID != "" && s.UserID != userID {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Clear empties the buffer.
+func (i *Inspector) Clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.samples = make([]Inspection, len(i.samples))
+	i.next, i.full = 0, false
+}
+
+// Forget drops the captured requests of one user and returns how many there
+// were.
+func (i *Inspector) Forget(userID string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	n := i.next
+	start := 0
+	if i.full {
+		n, start = len(i.samples), i.next
+	}
+	kept := make([]Inspection, 0, n)
+	for k := 0; k < n; k++ {
+		if s := i.samples[(start+k)%len(i.samples)]; s.UserID != userID {
+			kept = append(kept, s)
+		}
+	}
+	forgotten := n - len(kept)
+	if forgotten == 0 {
+		return 0
+	}
+	i.samples = make([]Inspection, len(i.samples))
+	i.next, i.full = copy(i.samples, kept), false
+	return forgotten
+}
+
+func (i *Inspector) store(s Inspection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.samples[i.next] = s
+	i.next = (i.next + 1) % len(i.samples)
+	if i.next == 0 {
+		i.full = true
+	}
+}
+
+// Handle samples requests. Without a client filter the decision is made up
+// front; with one, the request is captured and kept only if the
+// authenticated user matches, since the user is known only after auth.
+func (i *Inspector) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		settings := i.Settings()
+		filtered := len(settings.Clients) > 0
+		if settings.SampleRate <= 0 || (!filtered && rand.Float64() >= settings.SampleRate) {
+			return next(c)
+		}
+
+		req := c.Request()
+		in := &inspection{start: time.Now(), timings: make(map[string]float64)}
+		c.Set(inspectionContextKey, in)
+		trace, ok := c.Get(explainContextKey).(*explainTrace)
+		if !ok {
+			trace = &explainTrace{}
+			c.Set(explainContextKey, trace)
+		}
+		reqHeaders := i.sanitize(req.Header)
+
+		err := next(c)
+
+		userID, _ := c.Get("user_id").(string)
+		if filtered && (!slices.Contains(settings.Clients, userID) || rand.Float64() >= settings.SampleRate) {
+			return err
+		}
+
+		var params []string
+		for name := range req.URL.Query() {
+			name, _ = MaskCardNumbers(name)
+			params = append(params, name)
+		}
+		slices.Sort(params)
+
+		in.mu.Lock()
+		in.timings["total"] = float64(time.Since(in.start)) / float64(time.Millisecond)
+		if upstream, ok := in.timings["upstream"]; ok {
+			in.timings["gateway"] = in.timings["total"] - upstream
+		}
+		timings := in.timings
+		in.mu.Unlock()
+
+		trace.mu.Lock()
+		decisions := slices.Clone(trace.decisions)
+		trace.mu.Unlock()
+
+		status := c.Response().Status
+		if err != nil {
+			var he *echo.HTTPError
+			if errors.As(err, &he) {
+				status = he.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		path, _ := MaskCardNumbers(req.URL.Path)
+		i.store(Inspection{
+			RequestID:       RequestIDFrom(c),
+			At:              in.start.UTC(),
+			Method:          req.Method,
+			Path:            path,
+			Route:           c.Path(),
+			QueryParams:     params,
+			ClientIP:        c.RealIP(),
+			UserID:          userID,
+			Status:          status,
+			RequestHeaders:  reqHeaders,
+			ResponseHeaders: i.sanitize(c.Response().Header()),
+			Timings:         timings,
+			Decisions:       decisions,
+		})
+		return err
+	}
+}
+
+// sanitize copies headers, masking credentials, configured headers and card
+// numbers. The Authorization scheme is kept since it is often what a partner
+// gets wrong.
+func (i *Inspector) sanitize(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		if !i.redact[name] {
+			out[name] = make([]string, len(values))
+			for k, v := range values {
+				out[name][k], _ = MaskCardNumbers(v)
+			}
+			continue
+		}
+		masked := make([]string, len(values))
+		for k, v := range values {
+			masked[k] = "[redacted]"
+			if name == echo.HeaderAuthorization {
+				if scheme, _, ok := strings.Cut(v, " "); ok {
+					masked[k] = scheme + " [redacted]"
+				}
+			}
+		}
+		out[name] = masked
+	}
+	return out
+}
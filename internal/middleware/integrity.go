@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const contentDigestHeader = "Content-Digest"
+
+// IntegrityVerifier checks GET responses on routes with an integrity policy
+// against the digest sent by the upstream, so a download truncated or
+// corrupted by a backend is never delivered as complete.
+type IntegrityVerifier struct {
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+func NewIntegrityVerifier(cfg *config.Config, logger *zap.Logger) *IntegrityVerifier {
+	return &IntegrityVerifier{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (m *IntegrityVerifier) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		route := m.cfg.Route(c.Path())
+		if route == nil || route.Integrity == nil || c.Request().Method != http.MethodGet {
+			return next(c)
+		}
+
+		res := c.Response()
+		before := res.Header().Clone()
+		w := &integrityWriter{ResponseWriter: res.Writer, policy: route.Integrity}
+		res.Writer = w
+		defer func() { res.Writer = w.ResponseWriter }()
+		defer func() {
+			// The reverse proxy aborts when the upstream body breaks off; a
+			// response not yet sent can still be failed cleanly.
+			if r := recover(); r != nil {
+				if r != http.ErrAbortHandler || !w.pending() {
+					panic(r)
+				}
+				err = m.fail(c, w, before, "truncated")
+			}
+		}()
+
+		err = next(c)
+		if ferr := m.finish(c, w, before); ferr != nil {
+			return ferr
+		}
+		return err
+	}
+}
+
+func (m *IntegrityVerifier) finish(c echo.Context, w *integrityWriter, before http.Header) error {
+	if !w.active {
+		return nil
+	}
+	if w.rejected {
+		return m.fail(c, w, before, "missing")
+	}
+
+	sum := w.hash.Sum(nil)
+	switch {
+	case w.expected == nil:
+		metrics.IntegrityChecks.WithLabelValues(c.Path(), "unverified").Inc()
+		if w.policy.Attach {
+			w.Header().Set(contentDigestHeader, w.algo+"=:"+base64.StdEncoding.EncodeToString(sum)+":")
+		}
+	case subtle.ConstantTimeCompare(sum, w.expected) != 1:
+		return m.fail(c, w, before, "mismatch")
+	default:
+		metrics.IntegrityChecks.WithLabelValues(c.Path(), "ok").Inc()
+		Explain(c, "integrity", "allow", w.algo+" verified")
+	}
+
+	if w.held {
+		return w.release()
+	}
+	return nil
+}
+
+// fail reports a response that did not match its digest. A held response is
+// replaced with a 502; one already streaming has its connection aborted so
+// the client sees an incomplete download.
+func (m *IntegrityVerifier) fail(c echo.Context, w *integrityWriter, before http.Header, reason string) error {
+	metrics.IntegrityChecks.WithLabelValues(c.Path(), reason).Inc()
+	Explain(c, "integrity", "deny", reason)
+	m.logger.Error("Upstream response failed integrity check",
+		zap.String("route", c.Path()),
+		zap.String("path", c.Request().URL.Path),
+		zap.String("reason", reason),
+		zap.Int64("bytes", w.size),
+		zap.String("request_id", RequestIDFrom(c)),
+	)
+
+	if !w.pending() {
+		panic(http.ErrAbortHandler)
+	}
+
+	// Drop everything the upstream set, keeping the gateway's own headers
+	h := w.Header()
+	for k := range h {
+		if _, ok := before[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range before {
+		h[k] = v
+	}
+	h.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.Response().Status = http.StatusBadGateway
+	w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+	return json.NewEncoder(w.ResponseWriter).Encode(map[string]string{"error": "Upstream response failed integrity check"})
+}
+
+// integrityWriter hashes a 200 response body on its way to the client,
+// holding it back while it fits in the policy's buffer.
+type integrityWriter struct {
+	http.ResponseWriter
+	policy *config.IntegrityConfig
+
+	active   bool // a 200 response is being checked
+	rejected bool // required digest missing: the body is discarded
+	held     bool
+	status   int
+	algo     string
+	expected []byte
+	hash     hash.Hash
+	buf      bytes.Buffer
+	size     int64
+}
+
+// pending reports whether nothing of the checked response reached the client.
+func (w *integrityWriter) pending() bool {
+	return w.held || w.rejected
+}
+
+func (w *integrityWriter) WriteHeader(code int) {
+	w.status = code
+	if code != http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.active = true
+
+	header := w.policy.Header
+	if header == "" {
+		header = contentDigestHeader
+	}
+	w.algo, w.expected = parseDigest(w.Header().Get(header))
+	if w.expected == nil && w.policy.Require {
+		w.rejected = true
+		return
+	}
+	if w.algo == "sha-512" {
+		w.hash = sha512.New()
+	} else {
+		w.algo, w.hash = "sha-256", sha256.New()
+	}
+
+	if limit := w.policy.MaxBufferBytes; limit > 0 {
+		length, err := strconv.ParseInt(w.Header().Get(echo.HeaderContentLength), 10, 64)
+		if err != nil || length <= limit {
+			w.held = true
+			return
+		}
+	}
+	w.stream()
+}
+
+// stream sends the headers; a computed digest then follows as a trailer.
+func (w *integrityWriter) stream() {
+	w.held = false
+	if w.expected == nil && w.policy.Attach {
+		w.Header().Del(echo.HeaderContentLength)
+		w.Header().Add("Trailer", contentDigestHeader)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *integrityWriter) Write(p []byte) (int, error) {
+	switch {
+	case !w.active:
+		return w.ResponseWriter.Write(p)
+	case w.rejected:
+		return len(p), nil
+	}
+
+	w.hash.Write(p)
+	w.size += int64(len(p))
+	if !w.held {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf.Write(p)
+	if int64(w.buf.Len()) > w.policy.MaxBufferBytes {
+		// Unknown length and too large to hold: verify while streaming
+		w.stream()
+		if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		w.buf.Reset()
+	}
+	return len(p), nil
+}
+
+// release sends a held, verified response.
+func (w *integrityWriter) release() error {
+	w.held = false
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *integrityWriter) Flush() {
+	if w.active && w.pending() {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *integrityWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// parseDigest reads a SHA-256 or SHA-512 digest from a Content-Digest
+// ("sha-256=:<base64>:"), Digest ("SHA-256=<base64>") or bare hex/base64
+// SHA-256 header value.
+func parseDigest(value string) (string, []byte) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		name, encoded, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			continue
+		}
+		algo, size := strings.ToLower(name), 0
+		switch algo {
+		case "sha-256":
+			size = sha256.Size
+		case "sha-512":
+			size = sha512.Size
+		default:
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(strings.Trim(encoded, ":")); err == nil && len(sum) == size {
+			return algo, sum
+		}
+	}
+	if sum, err := hex.DecodeString(value); err == nil && len(sum) == sha256.Size {
+		return "sha-256", sum
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == sha256.Size {
+		return "sha-256", sum
+	}
+	return "", nil
+}
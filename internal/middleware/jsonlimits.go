@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type jsonFrame struct {
+	object    bool
+	count     int
+	expectKey bool
+}
+
+type JSONLimitMiddleware struct {
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+func NewJSONLimitMiddleware(cfg *config.Config, logger *zap.Logger) *JSONLimitMiddleware {
+	return &JSONLimitMiddleware{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// mergeJSONLimits applies a route's limits over the global ones; the route
+// overrides only the limits it sets.
+func mergeJSONLimits(global, route config.JSONLimits) config.JSONLimits {
+	if route.MaxDepth != 0 {
+		global.MaxDepth = route.MaxDepth
+	}
+	if route.MaxKeys != 0 {
+		global.MaxKeys = route.MaxKeys
+	}
+	if route.MaxArrayLength != 0 {
+		global.MaxArrayLength = route.MaxArrayLength
+	}
+	if route.MaxStringLength != 0 {
+		global.MaxStringLength = route.MaxStringLength
+	}
+	return global
+}
+
+// Enforce walks JSON request bodies token by token and rejects the request as
+// soon as a structural limit is exceeded, before the body reaches the upstream.
+func (m *JSONLimitMiddleware) Enforce(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Body == nil || req.Body == http.NoBody || !isJSONContent(req.Header.Get(echo.HeaderContentType)) {
+			return next(c)
+		}
+
+		limits := m.cfg.Security.JSONLimits
+		if route := m.cfg.Route(c.Path()); route != nil && route.JSONLimits != nil {
+			limits = mergeJSONLimits(limits, *route.JSONLimits)
+		}
+
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+		}
+		err = runBudgeted(c, m.cfg, "json_limits", int64(len(raw)), func(ctx context.Context) error {
+			return checkJSONStructure(ctx, bytes.NewReader(raw), limits)
+		})
+		var budgetErr *budgetError
+		if errors.As(err, &budgetErr) {
+			return rejectOverBudget(c, m.logger, budgetErr)
+		}
+		if err != nil {
+			Explain(c, "json_limits", "deny", err.Error())
+			m.logger.Warn("JSON body rejected",
+				zap.String("path", c.Path()),
+				zap.String("request_id", RequestIDFrom(c)),
+				zap.Error(err),
+			)
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":  "Request body rejected",
+				"reason": err.Error(),
+			})
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		req.ContentLength = int64(len(raw))
+		Explain(c, "json_limits", "allow", "")
+		return next(c)
+	}
+}
+
+func isJSONContent(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == echo.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+func checkJSONStructure(ctx context.Context, r io.Reader, limits config.JSONLimits) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var stack []*jsonFrame
+	onValue := func() error {
+		if len(stack) == 0 {
+			return nil
+		}
+		top := stack[len(stack)-1]
+		if top.object {
+			top.expectKey = true
+			return nil
+		}
+		top.count++
+		if limits.MaxArrayLength > 0 && top.count > limits.MaxArrayLength {
+			return fmt.Errorf("array length exceeds %d", limits.MaxArrayLength)
+		}
+		return nil
+	}
+
+	var tokens int
+	for {
+		if err := checkBudget(ctx, &tokens); err != nil {
+			return err
+		}
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("malformed JSON")
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				if err := onValue(); err != nil {
+					return err
+				}
+				stack = append(stack, &jsonFrame{object: v == '{', expectKey: v == '{'})
+				if limits.MaxDepth > 0 && len(stack) > limits.MaxDepth {
+					return fmt.Errorf("nesting depth exceeds %d", limits.MaxDepth)
+				}
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+			}
+		case string:
+			if limits.MaxStringLength > 0 && len(v) > limits.MaxStringLength {
+				return fmt.Errorf("string length exceeds %d", limits.MaxStringLength)
+			}
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.object && top.expectKey {
+					top.expectKey = false
+					top.count++
+					if limits.MaxKeys > 0 && top.count > limits.MaxKeys {
+						return fmt.Errorf("object key count exceeds %d", limits.MaxKeys)
+					}
+					continue
+				}
+			}
+			if err := onValue(); err != nil {
+				return err
+			}
+		default:
+			if err := onValue(); err != nil {
+				return err
+			}
+		}
+	}
+}
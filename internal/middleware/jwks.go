@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultJWKSRefresh is how often key sets are re-fetched when the issuer
+	// does not set jwks_refresh.
+	defaultJWKSRefresh = 5 * time.Minute
+	// jwksMinRefetch bounds on-demand fetches triggered by unknown key IDs, so
+	// tokens with made-up kids cannot hammer the issuer.
+	jwksMinRefetch   = 30 * time.Second
+	jwksFetchTimeout = 10 * time.Second
+	// jwksMaxBytes bounds the size of a key set document.
+	jwksMaxBytes = 1 << 20
+)
+
+// jwk is one JSON Web Key (RFC 7517); only public RSA and EC keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksKey is a parsed verification key.
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// jwksCache holds an issuer's JSON Web Key Set, refreshed in the background
+// and on demand when a token names a key ID it has not seen, so rotated keys
+// are picked up without a restart.
+type jwksCache struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	logger  *zap.Logger
+
+	mu   sync.RWMutex
+	keys map[string]jwksKey
+	// fetchMu serialises fetches; lastFetch is when one was last attempted
+	fetchMu   sync.Mutex
+	lastFetch time.Time
+}
+
+func newJWKSCache(url string, refresh time.Duration, logger *zap.Logger) *jwksCache {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	return &jwksCache{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: jwksFetchTimeout},
+		logger:  logger,
+		keys:    make(map[string]jwksKey),
+	}
+}
+
+// Start fetches the key set, then refreshes it until ctx is cancelled. A
+// failed fetch keeps the previous keys.
+func (j *jwksCache) Start(ctx context.Context) {
+	if err := j.fetch(ctx); err != nil {
+		j.logger.Warn("Failed to fetch JWKS, tokens from this issuer are rejected until it succeeds", zap.String("url", j.url), zap.Error(err))
+	}
+
+	go func() {
+		ticker := time.NewTicker(j.refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := j.fetch(ctx); err != nil {
+					j.logger.Warn("Failed to refresh JWKS", zap.String("url", j.url), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// loaded reports whether a key set has been fetched.
+func (j *jwksCache) loaded() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return len(j.keys) > 0
+}
+
+// key returns the verification key named by the token's kid. A token without
+// a kid is accepted only while the set holds a single key.
+func (j *jwksCache) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k, ok := j.lookup(kid)
+	if !ok && kid != "" {
+		// Possibly a freshly rotated key
+		j.fetchMu.Lock()
+		if time.Since(j.lastFetch) >= jwksMinRefetch {
+			if err := j.fetchLocked(ctx); err != nil {
+				j.logger.Warn("Failed to refresh JWKS for unknown key ID", zap.String("url", j.url), zap.String("kid", kid), zap.Error(err))
+			}
+		}
+		j.fetchMu.Unlock()
+		k, ok = j.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no JWKS key for kid %q", kid)
+	}
+	if k.alg != "" && k.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("JWKS key %q is for %s, token is signed with %s", kid, k.alg, token.Method.Alg())
+	}
+	return k.key, nil
+}
+
+func (j *jwksCache) lookup(kid string) (jwksKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if kid == "" {
+		if len(j.keys) != 1 {
+			return jwksKey{}, false
+		}
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+func (j *jwksCache) fetch(ctx context.Context) error {
+	j.fetchMu.Lock()
+	defer j.fetchMu.Unlock()
+	return j.fetchLocked(ctx)
+}
+
+func (j *jwksCache) fetchLocked(ctx context.Context) error {
+	j.lastFetch = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxBytes)).Decode(&set); err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]jwksKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			j.logger.Warn("Skipping JWKS key", zap.String("url", j.url), zap.String("kid", k.Kid), zap.Error(err))
+			continue
+		}
+		keys[k.Kid] = jwksKey{alg: k.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS contains no usable signing keys")
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// publicKey decodes an RSA or EC public key.
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		key := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if err := fips.CheckRSAKey(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
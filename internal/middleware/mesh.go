@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/banking/api-gateway/internal/mesh"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// MeshGuard trusts the gateway path header only on requests from mesh
+// peers, and attests their identity to upstreams in X-Forwarded-Client-Cert.
+// A peer's request that already went through this gateway is answered with
+// 508 Loop Detected.
+func MeshGuard(selfID string, logger *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			req.Header.Del(mesh.ClientCertHeader)
+			peer, ok := mesh.PeerFrom(req)
+			if !ok {
+				req.Header.Del(mesh.PathHeader)
+				return next(c)
+			}
+
+			if path := mesh.Path(req.Header); slices.Contains(path, selfID) {
+				metrics.FederationMeshRequests.WithLabelValues(peer.ID, "loop").Inc()
+				logger.Warn("Mesh loop detected",
+					zap.String("peer", peer.ID),
+					zap.Strings("path", path),
+					zap.String("request_id", RequestIDFrom(c)),
+				)
+				return c.JSON(http.StatusLoopDetected, map[string]string{"error": "Request loops between gateways"})
+			}
+			metrics.FederationMeshRequests.WithLabelValues(peer.ID, "accepted").Inc()
+			req.Header.Set(mesh.ClientCertHeader, "By="+selfID+";Hash="+peer.CertHash+";URI="+peer.ID)
+			return next(c)
+		}
+	}
+}
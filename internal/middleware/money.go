@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Money representations.
+const (
+	MoneyDecimal = "decimal"
+	MoneyMinor   = "minor"
+)
+
+// currencyExponents lists ISO 4217 currencies whose minor unit is not 1/100.
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLF": 4, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3, "UYW": 4,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+const defaultCurrencyExponent = 2
+
+// MoneyConverter rewrites amounts between decimal strings and integer minor
+// units on routes that declare a money schema, so clients and backends that
+// disagree on the representation cannot be off by a factor of 100.
+type MoneyConverter struct {
+	cfg       *config.Config
+	exponents map[string]int
+	logger    *zap.Logger
+}
+
+func NewMoneyConverter(cfg *config.Config, logger *zap.Logger) (*MoneyConverter, error) {
+	for _, route := range cfg.Routes {
+		m := route.Money
+		if m == nil {
+			continue
+		}
+		for _, side := range []string{m.Client, m.Backend} {
+			if side != MoneyDecimal && side != MoneyMinor {
+				return nil, fmt.Errorf("route %s: money client and backend must be %q or %q", route.Path, MoneyDecimal, MoneyMinor)
+			}
+		}
+		if len(m.Fields) == 0 {
+			return nil, fmt.Errorf("route %s: money fields must not be empty", route.Path)
+		}
+		for _, field := range m.Fields {
+			// A bare list of amounts has no currency next to it
+			if field == "" || strings.HasSuffix(field, "[]") {
+				return nil, fmt.Errorf("route %s: money field %q must end at an object field", route.Path, field)
+			}
+		}
+	}
+
+	exponents := make(map[string]int, len(currencyExponents)+len(cfg.CurrencyExponents))
+	for code, exp := range currencyExponents {
+		exponents[code] = exp
+	}
+	for code, exp := range cfg.CurrencyExponents {
+		// Viper lower-cases map keys
+		exponents[strings.ToUpper(code)] = exp
+	}
+	return &MoneyConverter{cfg: cfg, exponents: exponents, logger: logger}, nil
+}
+
+func (m *MoneyConverter) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route := m.cfg.Route(c.Path())
+		if route == nil || route.Money == nil || route.Money.Client == route.Money.Backend {
+			return next(c)
+		}
+		policy := route.Money
+
+		req := c.Request()
+		if req.Body != nil && req.Body != http.NoBody && isJSONContent(req.Header.Get(echo.HeaderContentType)) {
+			raw, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+			}
+			var converted []byte
+			err = runBudgeted(c, m.cfg, "money", 2*int64(len(raw)), func(ctx context.Context) (err error) {
+				converted, err = m.convert(ctx, raw, policy, policy.Client, policy.Backend)
+				return err
+			})
+			var budgetErr *budgetError
+			if errors.As(err, &budgetErr) {
+				return rejectOverBudget(c, m.logger, budgetErr)
+			}
+			if err != nil {
+				metrics.MoneyConversions.WithLabelValues("request", "rejected").Inc()
+				Explain(c, "money", "deny", err.Error())
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error":  "Invalid amount",
+					"reason": err.Error(),
+				})
+			}
+			metrics.MoneyConversions.WithLabelValues("request", "converted").Inc()
+			req.Body = io.NopCloser(bytes.NewReader(converted))
+			if req.GetBody != nil {
+				// Replays (buffered bodies) must send the converted body too
+				req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(converted)), nil }
+			}
+			req.ContentLength = int64(len(converted))
+			req.Header.Del(echo.HeaderContentLength)
+		}
+		Explain(c, "money", "allow", policy.Client+" <-> "+policy.Backend)
+
+		// Responses are rewritten whole, so ask for them uncompressed
+		req.Header.Del("Accept-Encoding")
+		res := c.Response()
+		w := &moneyWriter{ResponseWriter: res.Writer}
+		res.Writer = w
+		err := next(c)
+		res.Writer = w.ResponseWriter
+		if !w.written {
+			return err
+		}
+		if ferr := m.finishResponse(c, w, policy); ferr != nil {
+			return ferr
+		}
+		return err
+	}
+}
+
+// finishResponse converts a held JSON response back to the client's
+// representation and sends it. A backend amount that cannot be represented
+// is a 502 rather than a silently wrong number.
+func (m *MoneyConverter) finishResponse(c echo.Context, w *moneyWriter, policy *config.MoneyConfig) error {
+	body := w.buf.Bytes()
+	h := w.Header()
+	if isJSONContent(h.Get(echo.HeaderContentType)) && h.Get(echo.HeaderContentEncoding) == "" && len(bytes.TrimSpace(body)) > 0 {
+		var converted []byte
+		err := runBudgeted(c, m.cfg, "money", 2*int64(len(body)), func(ctx context.Context) (err error) {
+			converted, err = m.convert(ctx, body, policy, policy.Backend, policy.Client)
+			return err
+		})
+		if err != nil {
+			metrics.MoneyConversions.WithLabelValues("response", "rejected").Inc()
+			msg := "Upstream response has an invalid amount"
+			var budgetErr *budgetError
+			if errors.As(err, &budgetErr) {
+				msg = "Upstream response too expensive to convert"
+			}
+			m.logger.Error(msg,
+				zap.String("route", c.Path()),
+				zap.Error(err),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+			h.Del(echo.HeaderContentLength)
+			h.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+			c.Response().Status = http.StatusBadGateway
+			return json.NewEncoder(w.ResponseWriter).Encode(map[string]string{"error": msg})
+		}
+		metrics.MoneyConversions.WithLabelValues("response", "converted").Inc()
+		body = converted
+	}
+
+	h.Set(echo.HeaderContentLength, strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
+
+// convert rewrites every amount field of a JSON document from one
+// representation to the other.
+func (m *MoneyConverter) convert(ctx context.Context, raw []byte, policy *config.MoneyConfig, from, to string) ([]byte, error) {
+	var doc interface{}
+	if err := jsonutil.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.New("malformed JSON body")
+	}
+
+	currencyField := policy.CurrencyField
+	if currencyField == "" {
+		currencyField = "currency"
+	}
+	var amounts int
+	for _, field := range policy.Fields {
+		err := walkFields(doc, strings.Split(field, "."), func(obj map[string]interface{}, key string) error {
+			if err := checkBudget(ctx, &amounts); err != nil {
+				return err
+			}
+			currency, _ := obj[currencyField].(string)
+			if currency == "" {
+				currency = policy.Currency
+			}
+			if currency == "" {
+				return fmt.Errorf("%s: no currency", field)
+			}
+			exp, ok := m.exponents[strings.ToUpper(currency)]
+			if !ok {
+				exp = defaultCurrencyExponent
+			}
+
+			v, err := convertAmount(obj[key], exp, from, to)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			obj[key] = v
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jsonutil.Marshal(doc)
+}
+
+// walkFields calls fn with the object holding each field at path, such as
+// the amounts of a money route.
+func walkFields(v interface{}, path []string, fn func(obj map[string]interface{}, key string) error) error {
+	name, each := strings.CutSuffix(path[0], "[]")
+	if name == "" && each {
+		// "[]" alone: the current value is the array
+		items, _ := v.([]interface{})
+		for _, item := range items {
+			if err := walkFields(item, path[1:], fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, ok := obj[name]
+	if !ok || child == nil {
+		return nil
+	}
+	switch {
+	case each:
+		items, _ := child.([]interface{})
+		for _, item := range items {
+			if err := walkFields(item, path[1:], fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(path) == 1:
+		return fn(obj, name)
+	}
+	return walkFields(child, path[1:], fn)
+}
+
+// convertAmount turns a decimal amount into minor units or back.
+func convertAmount(v interface{}, exponent int, from, to string) (interface{}, error) {
+	var text string
+	switch t := v.(type) {
+	case json.Number:
+		text = t.String()
+	case string:
+		text = t
+	default:
+		return nil, errors.New("amount must be a number or numeric string")
+	}
+	d, err := jsonutil.ParseDecimal(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == MoneyDecimal && to == MoneyMinor {
+		units, err := d.MinorUnits(exponent)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(units.String()), nil
+	}
+
+	units, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a whole number of minor units", text)
+	}
+	return jsonutil.DecimalFromMinorUnits(units, exponent).String(), nil
+}
+
+// moneyWriter holds a response until its amounts have been converted.
+type moneyWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+	buf     bytes.Buffer
+}
+
+func (w *moneyWriter) WriteHeader(code int) {
+	w.status = code
+	w.written = true
+}
+
+func (w *moneyWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush is a no-op: nothing is sent before the body is complete.
+func (w *moneyWriter) Flush() {}
+
+func (w *moneyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,369 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// cardNumberPattern matches 13 to 19 digits, run together or in the groups
+// cards are printed in, separated by spaces or dashes.
+var cardNumberPattern = regexp.MustCompile(`\b(?:\d{13,19}|\d{4}[ -]\d{4}[ -]\d{4}[ -]\d{1,7}|\d{4}[ -]\d{6}[ -]\d{4,5})\b`)
+
+// cardDataContextKey marks a request that carries card data, which must not
+// be cached.
+const cardDataContextKey = "card_data"
+
+// MaskCardNumbers masks every card number in s but for its first six and
+// last four digits, which PCI DSS allows to be shown, and returns how many
+// it masked. A digit run counts as a card number when it passes the Luhn
+// check and starts with 2 to 6 like the card networks' numbers, which leaves
+// out most identifiers and every millisecond timestamp. Tokens from field
+// encryption fail the Luhn check and are left alone.
+func MaskCardNumbers(s string) (string, int) {
+	digits := 0
+	for i := 0; i < len(s) && digits < 13; i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits++
+		}
+	}
+	if digits < 13 {
+		return s, 0
+	}
+
+	n := 0
+	masked := cardNumberPattern.ReplaceAllStringFunc(s, func(m string) string {
+		pan, _ := cardDigits(m)
+		if pan[0] < '2' || pan[0] > '6' || luhnSum(pan)%10 != 0 {
+			return m
+		}
+		n++
+		out := []byte(m)
+		seen := 0
+		for i, b := range out {
+			if b < '0' || b > '9' {
+				continue
+			}
+			if seen >= 6 && seen < len(pan)-4 {
+				out[i] = '*'
+			}
+			seen++
+		}
+		return string(out)
+	})
+	return masked, n
+}
+
+// MaskCardNumbersInLogs returns logger with card numbers masked in messages,
+// string fields and errors, so the gateway never logs one whatever a request
+// or an upstream error carries.
+func MaskCardNumbersInLogs(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &cardMaskingCore{Core: core}
+	}))
+}
+
+type cardMaskingCore struct {
+	zapcore.Core
+}
+
+func (c *cardMaskingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &cardMaskingCore{Core: c.Core.With(maskCardFields(fields))}
+}
+
+func (c *cardMaskingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *cardMaskingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message, _ = MaskCardNumbers(ent.Message)
+	return c.Core.Write(ent, maskCardFields(fields))
+}
+
+// maskCardFields returns fields with card numbers masked, copying them only
+// when one is found.
+func maskCardFields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		var masked string
+		var n int
+		switch f.Type {
+		case zapcore.StringType:
+			masked, n = MaskCardNumbers(f.String)
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && err != nil {
+				masked, n = MaskCardNumbers(err.Error())
+			}
+		case zapcore.StringerType:
+			if s, ok := f.Interface.(interface{ String() string }); ok && s != nil {
+				masked, n = MaskCardNumbers(s.String())
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]zapcore.Field(nil), fields...)
+		}
+		out[i] = zap.String(f.Key, masked)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// PCIGuard keeps card numbers out of services outside PCI scope: requests to
+// them carrying one are rejected or forwarded with it masked, and each is
+// reported to the audit stream. Requests to services in scope may carry card
+// data but are kept out of the response cache.
+type PCIGuard struct {
+	cfg    *config.Config
+	audit  *zap.Logger
+	logger *zap.Logger
+}
+
+func NewPCIGuard(cfg *config.Config, logger *zap.Logger) *PCIGuard {
+	return &PCIGuard{
+		cfg:    cfg,
+		audit:  logger.Named("audit"),
+		logger: logger,
+	}
+}
+
+// ForService returns middleware that guards requests to the named service.
+// It must run after field encryption, whose tokens it lets through.
+func (g *PCIGuard) ForService(serviceName string) echo.MiddlewareFunc {
+	inScope := g.cfg.Services[serviceName].PCIScope
+	action := g.cfg.Security.PCIGuard.Action
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if !g.cfg.Security.PCIGuard.Enabled {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			req := c.Request()
+			if inScope {
+				query, _ := url.QueryUnescape(req.URL.RawQuery)
+				if _, n := MaskCardNumbers(req.URL.Path + "?" + query); n > 0 {
+					c.Set(cardDataContextKey, true)
+				}
+				return next(c)
+			}
+
+			// Everything found is masked in place, so nothing after this
+			// sees a card number even when the request is rejected
+			var found []string
+			if _, n := MaskCardNumbers(req.URL.Path); n > 0 {
+				found = append(found, "path")
+			}
+			if masked, n := maskCardValues(req.URL.Query()); n > 0 {
+				req.URL.RawQuery = masked.Encode()
+				found = append(found, "query")
+			}
+			if maskCardHeaders(req.Header) > 0 {
+				found = append(found, "header")
+			}
+			n, err := g.maskBody(c)
+			var budgetErr *budgetError
+			switch {
+			case errors.As(err, &budgetErr):
+				return rejectOverBudget(c, g.logger, budgetErr)
+			case err != nil:
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+			case n > 0:
+				found = append(found, "body")
+			}
+			if len(found) == 0 {
+				return next(c)
+			}
+
+			result := "masked"
+			if action == "block" || found[0] == "path" {
+				result = "blocked"
+			}
+			for _, location := range found {
+				metrics.PCICardData.WithLabelValues(location, result).Inc()
+			}
+			g.audit.Warn("Card data detected outside PCI scope",
+				zap.String("event", "pci_card_data"),
+				zap.String("service", serviceName),
+				zap.String("route", c.Path()),
+				zap.Strings("locations", found),
+				zap.String("action", result),
+				zap.String("ip", c.RealIP()),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+			if result == "blocked" {
+				Explain(c, "pci_guard", "deny", "card data in "+strings.Join(found, ","))
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Card data is not accepted on this route"})
+			}
+			Explain(c, "pci_guard", "mask", "card data in "+strings.Join(found, ","))
+			c.Set(cardDataContextKey, true)
+			return next(c)
+		}
+	}
+}
+
+// maskBody masks card numbers in a JSON, form or text body and returns how
+// many it masked. Other bodies are not scanned.
+func (g *PCIGuard) maskBody(c echo.Context) (int, error) {
+	req := c.Request()
+	if req.Body == nil || req.Body == http.NoBody {
+		return 0, nil
+	}
+	contentType := req.Header.Get(echo.HeaderContentType)
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	isForm := mediaType == echo.MIMEApplicationForm
+	isJSON := isJSONContent(contentType)
+	if !isForm && !isJSON && !strings.HasPrefix(mediaType, "text/") &&
+		mediaType != echo.MIMEApplicationXML && !strings.HasSuffix(mediaType, "+xml") {
+		return 0, nil
+	}
+
+	raw, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	out := raw
+	var n int
+	err = runBudgeted(c, g.cfg, "pci_guard", 2*int64(len(raw)), func(ctx context.Context) error {
+		var doc interface{}
+		if isJSON && jsonutil.Unmarshal(raw, &doc) == nil {
+			var nodes int
+			var err error
+			if doc, n, err = maskCardJSON(ctx, doc, &nodes); err != nil || n == 0 {
+				return err
+			}
+			out, err = jsonutil.Marshal(doc)
+			return err
+		}
+		if isForm {
+			if values, err := url.ParseQuery(string(raw)); err == nil {
+				if values, n = maskCardValues(values); n > 0 {
+					out = []byte(values.Encode())
+				}
+				return nil
+			}
+		}
+		// Text, or a malformed body the service will reject
+		masked, m := MaskCardNumbers(string(raw))
+		out, n = []byte(masked), m
+		return nil
+	})
+	if err != nil || n == 0 {
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		return 0, err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(out))
+	if req.GetBody != nil {
+		// Replays (buffered bodies) must send the masked body too
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(out)), nil }
+	}
+	req.ContentLength = int64(len(out))
+	req.Header.Del(echo.HeaderContentLength)
+	return n, nil
+}
+
+// maskCardJSON masks card numbers in the keys and values of a decoded JSON
+// document, turning masked numbers into strings. nodes counts the values
+// visited for budget checks.
+func maskCardJSON(ctx context.Context, v interface{}, nodes *int) (interface{}, int, error) {
+	if err := checkBudget(ctx, nodes); err != nil {
+		return nil, 0, err
+	}
+	switch t := v.(type) {
+	case string:
+		masked, n := MaskCardNumbers(t)
+		return masked, n, nil
+	case json.Number:
+		if masked, n := MaskCardNumbers(t.String()); n > 0 {
+			return masked, n, nil
+		}
+		return t, 0, nil
+	case map[string]interface{}:
+		total := 0
+		for k, child := range t {
+			masked, n, err := maskCardJSON(ctx, child, nodes)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, m := MaskCardNumbers(k)
+			if m > 0 {
+				delete(t, k)
+			}
+			t[key] = masked
+			total += n + m
+		}
+		return t, total, nil
+	case []interface{}:
+		total := 0
+		for i, child := range t {
+			masked, n, err := maskCardJSON(ctx, child, nodes)
+			if err != nil {
+				return nil, 0, err
+			}
+			t[i] = masked
+			total += n
+		}
+		return t, total, nil
+	}
+	return v, 0, nil
+}
+
+// maskCardValues masks card numbers in query or form values, in place, and
+// returns them with how many it masked.
+func maskCardValues(values url.Values) (url.Values, int) {
+	total := 0
+	for key, vs := range values {
+		for i, v := range vs {
+			masked, n := MaskCardNumbers(v)
+			vs[i] = masked
+			total += n
+		}
+		if masked, n := MaskCardNumbers(key); n > 0 {
+			delete(values, key)
+			values[masked] = vs
+			total += n
+		}
+	}
+	return values, total
+}
+
+// maskCardHeaders masks card numbers in header values, in place, and returns
+// how many it masked. Authorization carries credentials, never card data, and
+// masking could only break them.
+func maskCardHeaders(h http.Header) int {
+	total := 0
+	for name, values := range h {
+		if name == echo.HeaderAuthorization {
+			continue
+		}
+		for i, v := range values {
+			masked, n := MaskCardNumbers(v)
+			values[i] = masked
+			total += n
+		}
+	}
+	return total
+}
@@ -0,0 +1,386 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// subscriptionsKey holds runtime subscriptions and removals without an
+	// expiry, so a removed client never regains access by the hash lapsing.
+	subscriptionsKey = "product_subscriptions"
+	// defaultQuotaPeriod applies to products without quota_period.
+	defaultQuotaPeriod = 30 * 24 * time.Hour
+)
+
+// ErrUnknownProduct is returned for a product that is not configured.
+var ErrUnknownProduct = errors.New("unknown API product")
+
+// Subscription is a client's subscription to a product, or its removal, made
+// at runtime. It takes precedence over the product's configured subscribers.
+type Subscription struct {
+	Product    string    `json:"product"`
+	Client     string    `json:"client"`
+	Subscribed bool      `json:"subscribed"`
+	SetBy      string    `json:"set_by,omitempty"`
+	SetAt      time.Time `json:"set_at"`
+}
+
+// Product is an API product with the clients currently subscribed to it.
+type Product struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	DocsURL     string   `json:"docs_url,omitempty"`
+	Routes      []string `json:"routes"`
+	Scopes      []string `json:"scopes,omitempty"`
+	Quota       int64    `json:"quota,omitempty"`
+	QuotaPeriod string   `json:"quota_period"`
+	Subscribers []string `json:"subscribers"`
+}
+
+// ProductUsage is a subscriber's requests to a product in the current quota
+// period.
+type ProductUsage struct {
+	Client    string     `json:"client"`
+	Requests  int64      `json:"requests"`
+	Quota     int64      `json:"quota,omitempty"`
+	Remaining *int64     `json:"remaining,omitempty"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+// Products enforces and meters API products: bundles of routes that only
+// subscribed clients may call, with the scopes the product requires, within
+// the product's quota. Every request to a product's routes is counted per
+// subscriber, whether or not the product has a quota. Runtime subscription
+// changes live in Redis so every replica follows them; each replica refreshes
+// its copy on an interval.
+type Products struct {
+	products map[string]config.ProductConfig // by name
+	routes   map[string]string               // product name by route
+	redis    *infrastructure.RedisClient
+	logger   *zap.Logger
+
+	mu        sync.RWMutex
+	overrides map[string]map[string]Subscription // by product, then client
+}
+
+func NewProducts(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *Products {
+	p := &Products{
+		products:  make(map[string]config.ProductConfig, len(cfg.Products)),
+		routes:    make(map[string]string),
+		redis:     redis,
+		logger:    logger,
+		overrides: make(map[string]map[string]Subscription),
+	}
+	for _, product := range cfg.Products {
+		if product.QuotaPeriod <= 0 {
+			product.QuotaPeriod = defaultQuotaPeriod
+		}
+		p.products[product.Name] = product
+		for _, route := range product.Routes {
+			p.routes[route] = product.Name
+		}
+	}
+	return p
+}
+
+// Start polls Redis for subscription changes until ctx is cancelled.
+func (p *Products) Start(ctx context.Context, interval time.Duration) {
+	if p.redis == nil || len(p.products) == 0 {
+		return
+	}
+	p.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (p *Products) refresh(ctx context.Context) {
+	raw, err := p.redis.GetHash(ctx, subscriptionsKey)
+	if err != nil {
+		p.logger.Warn("Failed to refresh product subscriptions", zap.Error(err))
+		return
+	}
+
+	overrides := make(map[string]map[string]Subscription)
+	for _, v := range raw {
+		var s Subscription
+		if err := json.Unmarshal([]byte(v), &s); err != nil {
+			continue
+		}
+		if overrides[s.Product] == nil {
+			overrides[s.Product] = make(map[string]Subscription)
+		}
+		overrides[s.Product][s.Client] = s
+	}
+
+	p.mu.Lock()
+	p.overrides = overrides
+	p.mu.Unlock()
+}
+
+// Handle enforces the product of the matched route, if any. It must run after
+// ValidateToken.
+func (p *Products) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, ok := p.routes[c.Path()]
+		if !ok {
+			return next(c)
+		}
+		product := p.products[name]
+		claims, _ := c.Get("user_claims").(jwt.MapClaims)
+		client := ClientIDFromClaims(claims)
+		c.Set("api_product", name)
+
+		if client == "" || !p.Subscribed(name, client) {
+			metrics.ProductRequests.WithLabelValues(name, "unsubscribed").Inc()
+			Explain(c, "product", "deny", fmt.Sprintf("client %q is not subscribed to %s", client, name))
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error":             "Not subscribed to this API product",
+				"product":           name,
+				"documentation_url": product.DocsURL,
+			})
+		}
+
+		granted := ScopesFromClaims(claims)
+		var missing []string
+		for _, scope := range product.Scopes {
+			if !slices.Contains(granted, scope) {
+				missing = append(missing, scope)
+			}
+		}
+		if len(missing) > 0 {
+			metrics.ProductRequests.WithLabelValues(name, "forbidden").Inc()
+			Explain(c, "product", "deny", fmt.Sprintf("%s needs scopes %v", name, missing))
+			return c.JSON(http.StatusForbidden, map[string]interface{}{
+				"error":             "Insufficient scope for this API product",
+				"product":           name,
+				"missing_scopes":    missing,
+				"documentation_url": product.DocsURL,
+			})
+		}
+
+		return p.meter(c, product, client, next)
+	}
+}
+
+// meter counts the request against the client's use of the product and
+// answers requests over the quota with 429. Without Redis, or when it fails,
+// requests are neither counted nor limited.
+func (p *Products) meter(c echo.Context, product config.ProductConfig, client string, next echo.HandlerFunc) error {
+	if p.redis == nil {
+		metrics.ProductRequests.WithLabelValues(product.Name, "allowed").Inc()
+		Explain(c, "product", "allow", product.Name+": not metered without Redis")
+		return next(c)
+	}
+	ctx := c.Request().Context()
+	key := quotaKey(product.Name, client)
+	count, err := p.redis.IncrementWithExpiry(ctx, key, product.QuotaPeriod)
+	if err != nil {
+		metrics.ProductRequests.WithLabelValues(product.Name, "allowed").Inc()
+		Explain(c, "product", "allow", "fail open: Redis error")
+		p.logger.Error("Product quota Redis error", zap.String("product", product.Name), zap.Error(err))
+		return next(c)
+	}
+	if product.Quota == 0 {
+		metrics.ProductRequests.WithLabelValues(product.Name, "allowed").Inc()
+		Explain(c, "product", "allow", fmt.Sprintf("%s: %d requests this period", product.Name, count))
+		return next(c)
+	}
+
+	ttl, _ := p.redis.TTL(ctx, key)
+	if ttl <= 0 {
+		ttl = product.QuotaPeriod
+	}
+	resetAt := time.Now().Add(ttl)
+	c.Response().Header().Set("X-Product-Quota-Limit", strconv.FormatInt(product.Quota, 10))
+	c.Response().Header().Set("X-Product-Quota-Remaining", strconv.FormatInt(max(0, product.Quota-count), 10))
+	c.Response().Header().Set("X-Product-Quota-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+	if count > product.Quota {
+		retryAfter := max(int(ttl.Seconds()), 1)
+		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		metrics.ProductRequests.WithLabelValues(product.Name, "over_quota").Inc()
+		Explain(c, "product", "deny", fmt.Sprintf("%s: quota of %d used, retry after %ds", product.Name, product.Quota, retryAfter))
+		p.logger.Warn("Product quota exceeded",
+			zap.String("product", product.Name),
+			zap.String("client", client),
+			zap.Int64("quota", product.Quota),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+			"error":             "API product quota exceeded",
+			"product":           product.Name,
+			"quota":             product.Quota,
+			"retry_after":       retryAfter,
+			"reset_at":          resetAt.UTC().Format(time.RFC3339),
+			"documentation_url": product.DocsURL,
+		})
+	}
+
+	metrics.ProductRequests.WithLabelValues(product.Name, "allowed").Inc()
+	Explain(c, "product", "allow", fmt.Sprintf("%s: %d of %d", product.Name, count, product.Quota))
+	return next(c)
+}
+
+func quotaKey(product, client string) string {
+	return "product_quota:" + product + ":" + client
+}
+
+// Subscribed reports whether a client is subscribed to a product.
+func (p *Products) Subscribed(name, client string) bool {
+	p.mu.RLock()
+	s, ok := p.overrides[name][client]
+	p.mu.RUnlock()
+	if ok {
+		return s.Subscribed
+	}
+	return slices.Contains(p.products[name].Subscribers, client)
+}
+
+// subscribers returns the clients subscribed to a product, sorted.
+func (p *Products) subscribers(name string) []string {
+	set := make(map[string]bool)
+	for _, client := range p.products[name].Subscribers {
+		set[client] = true
+	}
+	p.mu.RLock()
+	for client, s := range p.overrides[name] {
+		set[client] = s.Subscribed
+	}
+	p.mu.RUnlock()
+
+	clients := []string{}
+	for client, subscribed := range set {
+		if subscribed {
+			clients = append(clients, client)
+		}
+	}
+	sort.Strings(clients)
+	return clients
+}
+
+// Catalog returns every product with its current subscribers, by name.
+func (p *Products) Catalog() []Product {
+	names := make([]string, 0, len(p.products))
+	for name := range p.products {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]Product, 0, len(names))
+	for _, name := range names {
+		product := p.products[name]
+		out = append(out, Product{
+			Name:        name,
+			Description: product.Description,
+			DocsURL:     product.DocsURL,
+			Routes:      product.Routes,
+			Scopes:      product.Scopes,
+			Quota:       product.Quota,
+			QuotaPeriod: product.QuotaPeriod.String(),
+			Subscribers: p.subscribers(name),
+		})
+	}
+	return out
+}
+
+// Usage returns each subscriber's requests to a product in its current quota
+// period.
+func (p *Products) Usage(ctx context.Context, name string) ([]ProductUsage, error) {
+	product, ok := p.products[name]
+	if !ok {
+		return nil, ErrUnknownProduct
+	}
+	if p.redis == nil {
+		return nil, errors.New("product metering requires Redis")
+	}
+
+	out := []ProductUsage{}
+	for _, client := range p.subscribers(name) {
+		key := quotaKey(name, client)
+		count, err := p.redis.GetCount(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		u := ProductUsage{Client: client, Requests: count}
+		if product.Quota > 0 {
+			remaining := max(0, product.Quota-count)
+			u.Quota, u.Remaining = product.Quota, &remaining
+		}
+		if ttl, err := p.redis.TTL(ctx, key); err == nil && ttl > 0 {
+			reset := time.Now().Add(ttl).UTC()
+			u.ResetAt = &reset
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// Subscribe subscribes a client to a product on every replica.
+func (p *Products) Subscribe(ctx context.Context, name, client, by string) error {
+	return p.set(ctx, name, client, true, by)
+}
+
+// Unsubscribe removes a client's subscription to a product on every replica,
+// including one from configuration.
+func (p *Products) Unsubscribe(ctx context.Context, name, client, by string) error {
+	return p.set(ctx, name, client, false, by)
+}
+
+func (p *Products) set(ctx context.Context, name, client string, subscribed bool, by string) error {
+	if _, ok := p.products[name]; !ok {
+		return ErrUnknownProduct
+	}
+	if client == "" {
+		return errors.New("client is required")
+	}
+
+	s := Subscription{Product: name, Client: client, Subscribed: subscribed, SetBy: by, SetAt: time.Now().UTC()}
+	if p.redis != nil {
+		data, _ := json.Marshal(s)
+		if err := p.redis.SetPersistentHashField(ctx, subscriptionsKey, name+"|"+client, string(data)); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	if p.overrides[name] == nil {
+		p.overrides[name] = make(map[string]Subscription)
+	}
+	p.overrides[name][client] = s
+	p.mu.Unlock()
+
+	p.logger.Warn("Product subscription changed",
+		zap.String("product", name),
+		zap.String("client", client),
+		zap.Bool("subscribed", subscribed),
+		zap.String("set_by", by),
+	)
+	return nil
+}
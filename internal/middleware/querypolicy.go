@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type compiledQueryPolicy struct {
+	policy   config.QueryPolicy
+	allowed  map[string]bool
+	params   map[string]config.QueryParam
+	patterns map[string]*regexp.Regexp
+}
+
+type QueryPolicyMiddleware struct {
+	cfg      *config.Config
+	logger   *zap.Logger
+	policies map[string]*compiledQueryPolicy
+}
+
+func NewQueryPolicyMiddleware(cfg *config.Config, logger *zap.Logger) (*QueryPolicyMiddleware, error) {
+	m := &QueryPolicyMiddleware{
+		cfg:      cfg,
+		logger:   logger,
+		policies: make(map[string]*compiledQueryPolicy),
+	}
+
+	for _, route := range cfg.Routes {
+		p := &compiledQueryPolicy{
+			policy:   route.Query,
+			allowed:  make(map[string]bool),
+			params:   make(map[string]config.QueryParam),
+			patterns: make(map[string]*regexp.Regexp),
+		}
+		for _, name := range route.Query.Allow {
+			p.allowed[name] = true
+		}
+		for _, param := range route.Query.Params {
+			p.allowed[param.Name] = true
+			p.params[param.Name] = param
+			if param.Pattern != "" {
+				re, err := regexp.Compile(param.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: invalid pattern for query param %q: %w", route.Path, param.Name, err)
+				}
+				p.patterns[param.Name] = re
+			}
+		}
+		m.policies[route.Path] = p
+	}
+
+	return m, nil
+}
+
+// Enforce strips, validates and allowlists query parameters according to the
+// policy configured for the matched route. Routes without a policy pass through.
+func (m *QueryPolicyMiddleware) Enforce(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, ok := m.policies[c.Path()]
+		if !ok {
+			return next(c)
+		}
+
+		req := c.Request()
+		query := req.URL.Query()
+
+		for name := range query {
+			if matchesAny(p.policy.Strip, name) {
+				Explain(c, "query_policy", "strip", name)
+				query.Del(name)
+			}
+		}
+
+		for name := range query {
+			if p.allowed[name] {
+				continue
+			}
+			if p.policy.RejectUnknown {
+				Explain(c, "query_policy", "deny", "unexpected parameter "+name)
+				m.logger.Warn("Rejected unexpected query parameter",
+					zap.String("path", c.Path()),
+					zap.String("param", name),
+					zap.String("request_id", RequestIDFrom(c)),
+				)
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Unexpected query parameter",
+					"param": name,
+				})
+			}
+			if len(p.allowed) > 0 {
+				Explain(c, "query_policy", "strip", "not allowlisted: "+name)
+				query.Del(name)
+			}
+		}
+
+		for name, param := range p.params {
+			if _, present := query[name]; !present && param.Required {
+				Explain(c, "query_policy", "deny", "missing required parameter "+name)
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Missing required query parameter",
+					"param": name,
+				})
+			}
+		}
+
+		// Patterns are operator-supplied, so value checks run under the
+		// request's transformation budget
+		var invalid string
+		var invalidErr error
+		err := runBudgeted(c, m.cfg, "query_policy", int64(len(req.URL.RawQuery)), func(ctx context.Context) error {
+			for name, param := range p.params {
+				for _, v := range query[name] {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					if err := validateQueryValue(param, p.patterns[name], v); err != nil {
+						invalid, invalidErr = name, err
+						return nil
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			var budgetErr *budgetError
+			if errors.As(err, &budgetErr) {
+				return rejectOverBudget(c, m.logger, budgetErr)
+			}
+			return err
+		}
+		if invalidErr != nil {
+			Explain(c, "query_policy", "deny", invalid+": "+invalidErr.Error())
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":  "Invalid query parameter",
+				"param":  invalid,
+				"reason": invalidErr.Error(),
+			})
+		}
+
+		for _, r := range p.policy.DateRanges {
+			if err := validateDateRange(query, r); err != nil {
+				Explain(c, "query_policy", "deny", err.Error())
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error":  "Invalid date range",
+					"reason": err.Error(),
+				})
+			}
+		}
+
+		req.URL.RawQuery = query.Encode()
+		Explain(c, "query_policy", "allow", "")
+		return next(c)
+	}
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validateQueryValue(param config.QueryParam, re *regexp.Regexp, value string) error {
+	if param.MaxLength > 0 && len(value) > param.MaxLength {
+		return fmt.Errorf("exceeds max length %d", param.MaxLength)
+	}
+
+	switch param.Type {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		if param.Min != nil && n < *param.Min {
+			return fmt.Errorf("must be >= %d", *param.Min)
+		}
+		if param.Max != nil && n > *param.Max {
+			return fmt.Errorf("must be <= %d", *param.Max)
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return fmt.Errorf("must be a UUID")
+		}
+	case "date":
+		if _, err := parseQueryDate(value); err != nil {
+			return fmt.Errorf("must be a date (YYYY-MM-DD or RFC3339)")
+		}
+	case "enum":
+		found := false
+		for _, allowed := range param.Values {
+			if value == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unsupported value")
+		}
+	}
+
+	if re != nil && !re.MatchString(value) {
+		return fmt.Errorf("does not match required format")
+	}
+	return nil
+}
+
+func validateDateRange(query url.Values, r config.QueryDateRange) error {
+	fromRaw, toRaw := query.Get(r.From), query.Get(r.To)
+	if fromRaw == "" || toRaw == "" {
+		return nil
+	}
+	from, err := parseQueryDate(fromRaw)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid date", r.From)
+	}
+	to, err := parseQueryDate(toRaw)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid date", r.To)
+	}
+	if to.Before(from) {
+		return fmt.Errorf("%s must not be before %s", r.To, r.From)
+	}
+	if r.MaxDays > 0 && to.Sub(from) > time.Duration(r.MaxDays)*24*time.Hour {
+		return fmt.Errorf("range exceeds %d days", r.MaxDays)
+	}
+	return nil
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
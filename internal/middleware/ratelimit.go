@@ -1,46 +1,118 @@
 package middleware
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/banking/api-gateway/internal/config"
 	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
+const (
+	ScopeIP   = "ip"
+	ScopeUser = "user"
+)
+
+// Rate limit strategies.
+const (
+	StrategyFixedWindow = "fixed_window"
+	StrategyTokenBucket = "token_bucket"
+)
+
+// RateLimitPolicies names the rate limit policies routes may reference,
+// sorted; "none" leaves a route unlimited.
+func RateLimitPolicies(cfg *config.Config) []string {
+	names := []string{"none"}
+	for name := range cfg.RateLimits.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// firstSeenRetention is how long a client first-seen marker is kept after
+// the client was last seen.
+const firstSeenRetention = 180 * 24 * time.Hour
+
 type RateLimitConfig struct {
+	Name   string
 	Limit  int64
 	Window time.Duration
+	// Key is what requests are counted by, ScopeIP or ScopeUser.
+	Key string
+	// Strategy is StrategyFixedWindow or StrategyTokenBucket; a token bucket
+	// refills at Limit per Window and holds up to Burst requests.
+	Strategy string
+	Burst    int64
+}
+
+// interval is how long a token bucket takes to refill one request.
+func (cfg RateLimitConfig) interval() time.Duration {
+	return cfg.Window / time.Duration(cfg.Limit)
+}
+
+// Capacity is the most requests the policy admits at once: the window limit,
+// or the burst of a token bucket.
+func (cfg RateLimitConfig) Capacity() int64 {
+	if cfg.Strategy == StrategyTokenBucket {
+		return cfg.Burst
+	}
+	return cfg.Limit
+}
+
+// limitKind is the key segment for a policy's counters. Token buckets keep
+// keys of their own, so changing a policy's strategy starts afresh.
+func limitKind(kind string, cfg RateLimitConfig) string {
+	if cfg.Strategy == StrategyTokenBucket {
+		return "bucket-" + kind
+	}
+	return kind
+}
+
+// rateLimitPolicies resolves the configured policies by name.
+func rateLimitPolicies(cfg *config.Config) map[string]RateLimitConfig {
+	policies := make(map[string]RateLimitConfig, len(cfg.RateLimits.Policies))
+	for name, p := range cfg.RateLimits.Policies {
+		strategy := p.Strategy
+		if strategy == "" {
+			strategy = StrategyFixedWindow
+		}
+		policies[name] = RateLimitConfig{Name: name, Limit: p.Limit, Window: p.Window, Key: p.Key, Strategy: strategy, Burst: p.Burst}
+	}
+	return policies
 }
 
 type RateLimiter struct {
+	cfg    *config.Config
 	redis  *infrastructure.RedisClient
 	logger *zap.Logger
-	// Default limits per endpoint category
-	authLimit     RateLimitConfig
-	transferLimit RateLimitConfig
-	defaultLimit  RateLimitConfig
+
+	policies map[string]RateLimitConfig
+
+	saltMu    sync.Mutex
+	salt      []byte
+	saltEpoch int64
 }
 
-func NewRateLimiter(redis *infrastructure.RedisClient, logger *zap.Logger) *RateLimiter {
+func NewRateLimiter(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *RateLimiter {
 	return &RateLimiter{
-		redis:  redis,
-		logger: logger,
-		authLimit: RateLimitConfig{
-			Limit:  5,
-			Window: 1 * time.Minute,
-		},
-		transferLimit: RateLimitConfig{
-			Limit:  100,
-			Window: 1 * time.Hour,
-		},
-		defaultLimit: RateLimitConfig{
-			Limit:  1000,
-			Window: 1 * time.Hour,
-		},
+		cfg:      cfg,
+		redis:    redis,
+		logger:   logger,
+		policies: rateLimitPolicies(cfg),
 	}
 }
 
@@ -51,9 +123,9 @@ func (r *RateLimiter) RateLimitByIP(cfg RateLimitConfig) echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			ip := c.RealIP()
 			path := c.Path()
-			key := fmt.Sprintf("ratelimit:ip:%s:%s", ip, path)
+			key := r.limitKey(limitKind("ip", cfg), ip, path)
 
-			return r.checkLimit(c, next, key, cfg)
+			return r.checkLimit(c, next, key, ScopeIP, ip, cfg)
 		}
 	}
 }
@@ -63,39 +135,228 @@ func (r *RateLimiter) RateLimitByIP(cfg RateLimitConfig) echo.MiddlewareFunc {
 func (r *RateLimiter) RateLimitByUser(cfg RateLimitConfig) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			scope := ScopeUser
 			userID, ok := c.Get("user_id").(string)
 			if !ok || userID == "" {
 				// Fallback to IP if user not authenticated
 				userID = c.RealIP()
+				scope = ScopeIP
 			}
 			path := c.Path()
-			key := fmt.Sprintf("ratelimit:user:%s:%s", userID, path)
+			key := r.limitKey(limitKind("user", cfg), userID, path)
 
-			return r.checkLimit(c, next, key, cfg)
+			return r.checkLimit(c, next, key, scope, userID, cfg)
 		}
 	}
 }
 
-// AuthRateLimiter returns middleware configured for auth endpoints (5/min by IP).
+// limitKey builds a fixed-length Redis key from an HMAC of the identity and
+// route, so raw IPs and user IDs are never stored and crafted paths cannot
+// inject key separators. The HMAC salt rotates every KeyRotation; counters
+// restart at a rotation boundary.
+func (r *RateLimiter) limitKey(kind, identity, path string) string {
+	epoch := int64(0)
+	if rotation := r.cfg.RateLimits.KeyRotation; rotation > 0 {
+		epoch = time.Now().UnixNano() / int64(rotation)
+	}
+
+	r.saltMu.Lock()
+	if r.salt == nil || r.saltEpoch != epoch {
+		mac := hmac.New(sha256.New, []byte(r.cfg.RateLimits.KeySecret))
+		binary.Write(mac, binary.BigEndian, epoch)
+		r.salt = mac.Sum(nil)
+		r.saltEpoch = epoch
+	}
+	salt := r.salt
+	r.saltMu.Unlock()
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(identity))
+	mac.Write([]byte{0})
+	mac.Write([]byte(path))
+	return "ratelimit:" + kind + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// FirstSeenIdentity is the HMAC a client's first-seen marker is stored
+// under. Unlike limitKey it does not rotate, so a client's age survives key
+// rotation.
+func FirstSeenIdentity(secret, identity string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(identity))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// AuthRateLimiter returns the "auth" policy's middleware, for auth endpoints.
 func (r *RateLimiter) AuthRateLimiter() echo.MiddlewareFunc {
-	return r.RateLimitByIP(r.authLimit)
+	return r.ForPolicy("auth")
 }
 
-// TransferRateLimiter returns middleware configured for transfer endpoints (100/hr by user).
+// TransferRateLimiter returns the "transfer" policy's middleware, for transfer endpoints.
 func (r *RateLimiter) TransferRateLimiter() echo.MiddlewareFunc {
-	return r.RateLimitByUser(r.transferLimit)
+	return r.ForPolicy("transfer")
 }
 
-// DefaultRateLimiter returns middleware for general endpoints (1000/hr by user).
+// DefaultRateLimiter returns the "default" policy's middleware, for general endpoints.
 func (r *RateLimiter) DefaultRateLimiter() echo.MiddlewareFunc {
-	return r.RateLimitByUser(r.defaultLimit)
+	return r.ForPolicy("default")
+}
+
+// ForPolicy returns the limiter for a configured policy, or nil for "none"
+// and unknown names.
+func (r *RateLimiter) ForPolicy(name string) echo.MiddlewareFunc {
+	cfg, ok := r.policies[name]
+	if !ok {
+		return nil
+	}
+	if cfg.Key == ScopeIP {
+		return r.RateLimitByIP(cfg)
+	}
+	return r.RateLimitByUser(cfg)
 }
 
-func (r *RateLimiter) checkLimit(c echo.Context, next echo.HandlerFunc, key string, cfg RateLimitConfig) error {
+// Peek returns the named policy and the number of requests already counted in
+// the current window for the caller of c, without counting this request. For
+// a token bucket it is the number of tokens in use, out of Burst.
+func (r *RateLimiter) Peek(c echo.Context, policy string) (RateLimitConfig, int64, error) {
+	cfg, ok := r.policies[policy]
+	if !ok {
+		return cfg, 0, fmt.Errorf("unknown rate limit policy %q", policy)
+	}
+
+	kind, identity := "ip", c.RealIP()
+	if cfg.Key == ScopeUser {
+		kind = "user"
+		if userID, ok := c.Get("user_id").(string); ok && userID != "" {
+			identity = userID
+		}
+	}
+
+	count, err := r.redis.GetCount(c.Request().Context(), r.limitKey(limitKind(kind, cfg), identity, c.Path()))
+	if err != nil {
+		return cfg, 0, err
+	}
+	return cfg, cfg.used(count), nil
+}
+
+// used converts the stored value of a policy's key to requests counted: the
+// window count, or the tokens in use for a token bucket.
+func (cfg RateLimitConfig) used(stored int64) int64 {
+	if cfg.Strategy != StrategyTokenBucket {
+		return stored
+	}
+	// The bucket key holds its theoretical arrival time in milliseconds
+	ahead := time.Duration(stored-time.Now().UnixMilli()) * time.Millisecond
+	used := int64(math.Ceil(float64(ahead) / float64(cfg.interval())))
+	return min(max(used, 0), cfg.Burst)
+}
+
+// LimitState is the rate-limit state of one client on one route.
+type LimitState struct {
+	Route    string `json:"route"`
+	Policy   string `json:"policy"`
+	Strategy string `json:"strategy"`
+	Key      string `json:"key"`
+	Used     int64  `json:"used"`
+	Capacity int64  `json:"capacity"`
+	Blocked  bool   `json:"blocked"`
+	// TTLSeconds is how long until the counter expires (a fixed window
+	// resets, or a token bucket is full again).
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+// Inspect returns the counters held for a client on the given routes, each
+// mapped to its policy. scope says whether identity is an IP or a user ID:
+// IP-keyed policies only count IPs, and user-keyed ones count IPs for
+// unauthenticated requests. Routes without a counter are left out.
+func (r *RateLimiter) Inspect(ctx context.Context, scope, identity string, routes map[string]string) ([]LimitState, error) {
+	states := []LimitState{}
+	for _, t := range r.targets(scope, identity, routes) {
+		stored, err := r.redis.GetCount(ctx, t.key)
+		if err != nil {
+			return nil, err
+		}
+		if stored == 0 {
+			continue
+		}
+		ttl, err := r.redis.TTL(ctx, t.key)
+		if err != nil {
+			return nil, err
+		}
+		used := t.cfg.used(stored)
+		states = append(states, LimitState{
+			Route:      t.route,
+			Policy:     t.cfg.Name,
+			Strategy:   t.cfg.Strategy,
+			Key:        t.key,
+			Used:       used,
+			Capacity:   t.cfg.Capacity(),
+			Blocked:    used >= t.cfg.Capacity(),
+			TTLSeconds: int64(max(ttl, 0).Seconds()),
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Route != states[j].Route {
+			return states[i].Route < states[j].Route
+		}
+		return states[i].Policy < states[j].Policy
+	})
+	return states, nil
+}
+
+// Reset clears a client's counters on the given routes (see Inspect) and
+// returns how many there were.
+func (r *RateLimiter) Reset(ctx context.Context, scope, identity string, routes map[string]string) (int, error) {
+	cleared := 0
+	for _, t := range r.targets(scope, identity, routes) {
+		stored, err := r.redis.GetCount(ctx, t.key)
+		if err != nil {
+			return cleared, err
+		}
+		if stored == 0 {
+			continue
+		}
+		if err := r.redis.DeleteKey(ctx, t.key); err != nil {
+			return cleared, err
+		}
+		cleared++
+	}
+	return cleared, nil
+}
+
+type limitTarget struct {
+	route string
+	cfg   RateLimitConfig
+	key   string
+}
+
+// targets lists the counter keys a client may have on routes, by route
+// pattern and policy name.
+func (r *RateLimiter) targets(scope, identity string, routes map[string]string) []limitTarget {
+	var targets []limitTarget
+	for route, policy := range routes {
+		cfg, ok := r.policies[policy]
+		if !ok || (cfg.Key == ScopeIP && scope != ScopeIP) {
+			continue
+		}
+		kind := "user"
+		if cfg.Key == ScopeIP {
+			kind = "ip"
+		}
+		targets = append(targets, limitTarget{route: route, cfg: cfg, key: r.limitKey(limitKind(kind, cfg), identity, route)})
+	}
+	return targets
+}
+
+func (r *RateLimiter) checkLimit(c echo.Context, next echo.HandlerFunc, key, scope, identity string, cfg RateLimitConfig) error {
+	if cfg.Strategy == StrategyTokenBucket {
+		return r.checkTokenBucket(c, next, key, scope, cfg)
+	}
 	ctx := c.Request().Context()
+	c.Set("ratelimit_key", key)
 
 	count, err := r.redis.IncrementWithExpiry(ctx, key, cfg.Window)
 	if err != nil {
+		Explain(c, "rate_limit", "allow", "fail open: Redis error")
 		r.logger.Error("Rate limiter Redis error", zap.Error(err))
 		// Fail open: allow request if Redis is down (graceful degradation)
 		return next(c)
@@ -104,6 +365,43 @@ func (r *RateLimiter) checkLimit(c echo.Context, next echo.HandlerFunc, key stri
 	// Set rate limit headers
 	c.Response().Header().Set("X-RateLimit-Limit", strconv.FormatInt(cfg.Limit, 10))
 	c.Response().Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(0, cfg.Limit-count), 10))
+	c.Response().Header().Set("X-RateLimit-Policy", fmt.Sprintf("%s;scope=%s;limit=%d;w=%d", cfg.Name, scope, cfg.Limit, int(cfg.Window.Seconds())))
+
+	// Record when this client was first seen, once per window
+	if count == 1 && scope == ScopeUser && r.cfg.RateLimits.GraceBurstRatio > 0 {
+		if _, err := r.redis.TouchFirstSeen(ctx, FirstSeenIdentity(r.cfg.RateLimits.KeySecret, identity), firstSeenRetention); err != nil {
+			r.logger.Warn("Failed to record client first-seen time", zap.Error(err))
+		}
+	}
+
+	softLimit := int64(math.Ceil(float64(cfg.Limit) * r.cfg.RateLimits.SoftLimitRatio))
+	if softLimit > 0 && count >= softLimit && count <= cfg.Limit {
+		c.Response().Header().Set("X-RateLimit-Warning", fmt.Sprintf("%d of %d requests used in current window", count, cfg.Limit))
+		if count == softLimit {
+			r.logger.Warn("Rate limit soft threshold reached",
+				zap.String("key", key),
+				zap.String("policy", cfg.Name),
+				zap.String("scope", scope),
+				zap.Int64("count", count),
+				zap.Int64("limit", cfg.Limit),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+		}
+	}
+
+	if count > cfg.Limit && r.withinGrace(ctx, scope, identity, count, cfg) {
+		c.Response().Header().Set("X-RateLimit-Warning", fmt.Sprintf("limit of %d exceeded, grace allowance in use", cfg.Limit))
+		c.Response().Header().Set("X-RateLimit-Grace", "true")
+		Explain(c, "rate_limit", "grace", fmt.Sprintf("policy %s: %d of %d, grace allowance", cfg.Name, count, cfg.Limit))
+		r.logger.Warn("Rate limit grace allowance used",
+			zap.String("key", key),
+			zap.String("policy", cfg.Name),
+			zap.Int64("count", count),
+			zap.Int64("limit", cfg.Limit),
+			zap.String("request_id", RequestIDFrom(c)),
+		)
+		return next(c)
+	}
 
 	if count > cfg.Limit {
 		ttl, _ := r.redis.TTL(ctx, key)
@@ -111,20 +409,93 @@ func (r *RateLimiter) checkLimit(c echo.Context, next echo.HandlerFunc, key stri
 		if retryAfter <= 0 {
 			retryAfter = int(cfg.Window.Seconds())
 		}
-
-		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
-
-		r.logger.Warn("Rate limit exceeded",
-			zap.String("key", key),
+		return r.reject(c, key, scope, cfg, retryAfter,
+			fmt.Sprintf("policy %s (%s): %d of %d, retry after %ds", cfg.Name, scope, count, cfg.Limit, retryAfter),
 			zap.Int64("count", count),
-			zap.Int64("limit", cfg.Limit),
 		)
+	}
 
-		return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
-			"error":       "Rate limit exceeded",
-			"retry_after": retryAfter,
-		})
+	Explain(c, "rate_limit", "allow", fmt.Sprintf("policy %s (%s): %d of %d", cfg.Name, scope, count, cfg.Limit))
+	return next(c)
+}
+
+// checkTokenBucket limits with a GCRA token bucket: a sustained Limit per
+// Window plus bursts of up to Burst requests. Soft warnings and the grace
+// allowance belong to fixed windows and do not apply.
+func (r *RateLimiter) checkTokenBucket(c echo.Context, next echo.HandlerFunc, key, scope string, cfg RateLimitConfig) error {
+	c.Set("ratelimit_key", key)
+
+	bucket, err := r.redis.TakeToken(c.Request().Context(), key, cfg.interval(), cfg.Burst)
+	if err != nil {
+		Explain(c, "rate_limit", "allow", "fail open: Redis error")
+		r.logger.Error("Rate limiter Redis error", zap.Error(err))
+		return next(c)
 	}
 
+	c.Response().Header().Set("X-RateLimit-Limit", strconv.FormatInt(cfg.Burst, 10))
+	c.Response().Header().Set("X-RateLimit-Remaining", strconv.FormatInt(bucket.Remaining, 10))
+	c.Response().Header().Set("X-RateLimit-Policy", fmt.Sprintf("%s;scope=%s;limit=%d;w=%d;burst=%d", cfg.Name, scope, cfg.Limit, int(cfg.Window.Seconds()), cfg.Burst))
+
+	if !bucket.Allowed {
+		retryAfter := max(int(math.Ceil(bucket.RetryAfter.Seconds())), 1)
+		return r.reject(c, key, scope, cfg, retryAfter,
+			fmt.Sprintf("policy %s (%s): token bucket empty, retry after %ds", cfg.Name, scope, retryAfter),
+		)
+	}
+
+	Explain(c, "rate_limit", "allow", fmt.Sprintf("policy %s (%s): token bucket, %d of %d left", cfg.Name, scope, bucket.Remaining, cfg.Burst))
 	return next(c)
 }
+
+// reject answers a request over its policy's limit with 429.
+func (r *RateLimiter) reject(c echo.Context, key, scope string, cfg RateLimitConfig, retryAfter int, detail string, fields ...zap.Field) error {
+	resetAt := time.Now().Add(time.Duration(retryAfter) * time.Second)
+
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	c.Response().Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+	Explain(c, "rate_limit", "deny", detail)
+	metrics.RateLimitRejected.WithLabelValues(cfg.Name, scope).Inc()
+
+	r.logger.Warn("Rate limit exceeded", append([]zap.Field{
+		zap.String("key", key),
+		zap.String("policy", cfg.Name),
+		zap.String("scope", scope),
+		zap.Int64("limit", cfg.Limit),
+		zap.String("request_id", RequestIDFrom(c)),
+	}, fields...)...)
+
+	body := map[string]interface{}{
+		"error":             "Rate limit exceeded",
+		"retry_after":       retryAfter,
+		"policy":            cfg.Name,
+		"scope":             scope,
+		"limit":             cfg.Limit,
+		"window_seconds":    int(cfg.Window.Seconds()),
+		"reset_at":          resetAt.UTC().Format(time.RFC3339),
+		"documentation_url": r.cfg.RateLimits.DocsURL,
+	}
+	if cfg.Strategy == StrategyTokenBucket {
+		body["burst"] = cfg.Burst
+	}
+	return c.JSON(http.StatusTooManyRequests, body)
+}
+
+// withinGrace reports whether an established authenticated client may exceed
+// the hard limit by the configured grace burst.
+func (r *RateLimiter) withinGrace(ctx context.Context, scope, identity string, count int64, cfg RateLimitConfig) bool {
+	ratio := r.cfg.RateLimits.GraceBurstRatio
+	if ratio <= 0 || scope != ScopeUser {
+		return false
+	}
+
+	burst := int64(math.Ceil(float64(cfg.Limit) * ratio))
+	if count > cfg.Limit+burst {
+		return false
+	}
+
+	firstSeen, err := r.redis.TouchFirstSeen(ctx, FirstSeenIdentity(r.cfg.RateLimits.KeySecret, identity), firstSeenRetention)
+	if err != nil {
+		return false
+	}
+	return time.Since(firstSeen) >= r.cfg.RateLimits.GraceMinClientAge
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"math"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// RateLimitScenario is hypothetical traffic spread evenly over Clients.
+type RateLimitScenario struct {
+	RPS      float64
+	Clients  int
+	Duration time.Duration
+	// Policy limits the simulation to one policy; empty simulates all.
+	Policy string
+	// Established clients are old enough to use the grace allowance.
+	Established bool
+}
+
+// RateLimitSimulation is how one policy would treat a scenario.
+type RateLimitSimulation struct {
+	Policy        string  `json:"policy"`
+	Scope         string  `json:"scope"`
+	Strategy      string  `json:"strategy"`
+	Limit         int64   `json:"limit"`
+	WindowSeconds int     `json:"window_seconds"`
+	Burst         int64   `json:"burst,omitempty"`
+	Grace         int64   `json:"grace"`
+	ClientRPS     float64 `json:"client_rps"`
+	Requests      int64   `json:"requests"`
+	Allowed       int64   `json:"allowed"`
+	Rejected      int64   `json:"rejected"`
+	RejectedRatio float64 `json:"rejected_ratio"`
+	// Offsets into each client's window, empty when never reached.
+	SoftWarningAfter string `json:"soft_warning_after,omitempty"`
+	FirstRejectAfter string `json:"first_rejection_after,omitempty"`
+}
+
+// SimulateRateLimits works out, per policy, how many of a scenario's
+// requests the fixed-window limiter would admit. Each client sends
+// RPS/Clients requests per second from the start of its first window; the
+// grace burst is only counted for user-scoped policies and established
+// clients, as in checkLimit. Token buckets are replayed request by request.
+func SimulateRateLimits(cfg *config.Config, s RateLimitScenario) []RateLimitSimulation {
+	var out []RateLimitSimulation
+	policies := rateLimitPolicies(cfg)
+	for _, name := range RateLimitPolicies(cfg) {
+		policy, ok := policies[name]
+		if !ok || (s.Policy != "" && s.Policy != name) {
+			continue
+		}
+		out = append(out, simulatePolicy(cfg.RateLimits, policy, s))
+	}
+	return out
+}
+
+func simulatePolicy(rl config.RateLimitsConfig, policy RateLimitConfig, s RateLimitScenario) RateLimitSimulation {
+	rate := s.RPS / float64(s.Clients)
+	sim := RateLimitSimulation{
+		Policy:        policy.Name,
+		Scope:         policy.Key,
+		Strategy:      policy.Strategy,
+		Limit:         policy.Limit,
+		WindowSeconds: int(policy.Window.Seconds()),
+		ClientRPS:     rate,
+	}
+	if policy.Strategy == StrategyTokenBucket {
+		return simulateTokenBucket(policy, s, sim)
+	}
+	if policy.Key == ScopeUser && s.Established && rl.GraceBurstRatio > 0 {
+		sim.Grace = int64(math.Ceil(float64(policy.Limit) * rl.GraceBurstRatio))
+	}
+	admitted := policy.Limit + sim.Grace
+
+	// sent is how many requests one client has made before offset t
+	sent := func(t time.Duration) int64 {
+		return int64(math.Ceil(t.Seconds() * rate))
+	}
+	// at is the offset of a client's n-th request, counting from zero
+	at := func(n int64) time.Duration {
+		return time.Duration(float64(n) / rate * float64(time.Second))
+	}
+
+	var perClient, allowed int64
+	for start := time.Duration(0); start < s.Duration; start += policy.Window {
+		n := sent(min(start+policy.Window, s.Duration)) - sent(start)
+		perClient += n
+		allowed += min(n, admitted)
+	}
+	clients := int64(s.Clients)
+	sim.Requests = perClient * clients
+	sim.Allowed = allowed * clients
+	sim.Rejected = sim.Requests - sim.Allowed
+	if sim.Requests > 0 {
+		sim.RejectedRatio = float64(sim.Rejected) / float64(sim.Requests)
+	}
+
+	firstWindow := min(policy.Window, s.Duration)
+	if soft := int64(math.Ceil(float64(policy.Limit) * rl.SoftLimitRatio)); soft > 0 && at(soft-1) < firstWindow {
+		sim.SoftWarningAfter = at(soft - 1).String()
+	}
+	if at(admitted) < firstWindow {
+		sim.FirstRejectAfter = at(admitted).String()
+	}
+	return sim
+}
+
+// simulateTokenBucket replays one client's requests through the GCRA of
+// TakeToken; every client behaves the same.
+func simulateTokenBucket(policy RateLimitConfig, s RateLimitScenario, sim RateLimitSimulation) RateLimitSimulation {
+	sim.Burst = policy.Burst
+	interval := policy.interval()
+	tolerance := interval * time.Duration(policy.Burst-1)
+
+	n := int64(math.Ceil(s.Duration.Seconds() * sim.ClientRPS))
+	var allowed int64
+	var tat time.Duration
+	for i := int64(0); i < n; i++ {
+		at := time.Duration(float64(i) / sim.ClientRPS * float64(time.Second))
+		tat = max(tat, at)
+		if tat-at > tolerance {
+			if sim.FirstRejectAfter == "" {
+				sim.FirstRejectAfter = at.String()
+			}
+			continue
+		}
+		tat += interval
+		allowed++
+	}
+
+	clients := int64(s.Clients)
+	sim.Requests = n * clients
+	sim.Allowed = allowed * clients
+	sim.Rejected = sim.Requests - sim.Allowed
+	if sim.Requests > 0 {
+		sim.RejectedRatio = float64(sim.Rejected) / float64(sim.Requests)
+	}
+	return sim
+}
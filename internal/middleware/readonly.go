@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// readOnlyKey holds the switches without an expiry: a freeze stays on
+	// until an operator lifts it.
+	readOnlyKey    = "readonly"
+	readOnlyGlobal = "global"
+)
+
+// ReadOnlyState describes an active read-only switch.
+type ReadOnlyState struct {
+	IncidentCode string    `json:"incident_code"`
+	Reason       string    `json:"reason,omitempty"`
+	SetBy        string    `json:"set_by,omitempty"`
+	SetAt        time.Time `json:"set_at"`
+}
+
+// ReadOnlyGuard rejects non-read methods while a global or per-service
+// read-only switch is on. Switches live in Redis so every replica follows
+// them; each replica refreshes its local copy on an interval.
+type ReadOnlyGuard struct {
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	states map[string]ReadOnlyState
+}
+
+func NewReadOnlyGuard(redis *infrastructure.RedisClient, logger *zap.Logger) *ReadOnlyGuard {
+	return &ReadOnlyGuard{
+		redis:  redis,
+		logger: logger,
+		states: make(map[string]ReadOnlyState),
+	}
+}
+
+// Start polls Redis for switch changes until ctx is cancelled.
+func (g *ReadOnlyGuard) Start(ctx context.Context, interval time.Duration) {
+	if g.redis == nil {
+		return
+	}
+	g.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (g *ReadOnlyGuard) refresh(ctx context.Context) {
+	raw, err := g.redis.GetHash(ctx, readOnlyKey)
+	if err != nil {
+		g.logger.Warn("Failed to refresh read-only switches", zap.Error(err))
+		return
+	}
+
+	states := make(map[string]ReadOnlyState, len(raw))
+	for target, v := range raw {
+		var st ReadOnlyState
+		if err := json.Unmarshal([]byte(v), &st); err == nil {
+			states[target] = st
+		}
+	}
+
+	g.mu.Lock()
+	g.states = states
+	g.mu.Unlock()
+}
+
+// States returns the active switches keyed by "global" or service name.
+func (g *ReadOnlyGuard) States() map[string]ReadOnlyState {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	out := make(map[string]ReadOnlyState, len(g.states))
+	for k, v := range g.states {
+		out[k] = v
+	}
+	return out
+}
+
+// Enable turns on read-only mode for target ("global" or a service name).
+func (g *ReadOnlyGuard) Enable(ctx context.Context, target string, st ReadOnlyState) error {
+	st.SetAt = time.Now().UTC()
+	if g.redis != nil {
+		data, _ := json.Marshal(st)
+		if err := g.redis.SetPersistentHashField(ctx, readOnlyKey, target, string(data)); err != nil {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	g.states[target] = st
+	g.mu.Unlock()
+
+	g.logger.Warn("Read-only mode enabled",
+		zap.String("target", target),
+		zap.String("incident_code", st.IncidentCode),
+		zap.String("set_by", st.SetBy),
+	)
+	return nil
+}
+
+// Disable turns off read-only mode for target.
+func (g *ReadOnlyGuard) Disable(ctx context.Context, target, by string) error {
+	if g.redis != nil {
+		if err := g.redis.DeleteHashField(ctx, readOnlyKey, target); err != nil {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	delete(g.states, target)
+	g.mu.Unlock()
+
+	g.logger.Warn("Read-only mode disabled", zap.String("target", target), zap.String("by", by))
+	return nil
+}
+
+// ForService returns middleware enforcing the global and service switches.
+func (g *ReadOnlyGuard) ForService(serviceName string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+
+			g.mu.RLock()
+			st, ok := g.states[readOnlyGlobal]
+			if !ok {
+				st, ok = g.states[serviceName]
+			}
+			g.mu.RUnlock()
+
+			if !ok {
+				return next(c)
+			}
+			Explain(c, "read_only", "deny", "incident "+st.IncidentCode)
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error": "Service is temporarily read-only",
+				"code":  st.IncidentCode,
+			})
+		}
+	}
+}
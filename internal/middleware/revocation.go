@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"go.uber.org/zap"
+)
+
+const (
+	subjectRevocationKey = "blacklist:subject:"
+	revocationSweepEvery = time.Minute
+)
+
+// Revocation is a logout/revocation event from the auth service. It revokes
+// either one token (JTI, until Exp) or every token of Subject issued before
+// RevokeBefore. Times are Unix seconds.
+type Revocation struct {
+	JTI          string `json:"jti,omitempty"`
+	Exp          int64  `json:"exp,omitempty"`
+	Subject      string `json:"sub,omitempty"`
+	RevokeBefore int64  `json:"revoke_before,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// Validate checks that the event names a token or a subject.
+func (ev Revocation) Validate() error {
+	if ev.JTI == "" && (ev.Subject == "" || ev.RevokeBefore <= 0) {
+		return errors.New("revocation needs jti, or sub with revoke_before")
+	}
+	return nil
+}
+
+type revocationEntry struct {
+	revoked bool
+	before  int64
+	until   time.Time
+}
+
+// Revocations keeps a per-replica cache of token revocation lookups that is
+// invalidated by logout/revocation events, so replicas stop accepting a
+// revoked token as soon as the event is published instead of relying on the
+// auth service populating the blacklist consistently.
+type Revocations struct {
+	cfg      config.RevocationConfig
+	tokenTTL time.Duration
+	redis    *infrastructure.RedisClient
+	logger   *zap.Logger
+	audit    *zap.Logger
+	// signer signs the exported revocation list
+	signer ListSigner
+	// fetchers are the networks the exported list is served to
+	fetchers []*net.IPNet
+	list     atomic.Pointer[SignedRevocationList]
+
+	mu       sync.Mutex
+	jtis     map[string]revocationEntry
+	subjects map[string]revocationEntry
+}
+
+func NewRevocations(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *Revocations {
+	r := &Revocations{
+		cfg:      cfg.Security.Revocation,
+		tokenTTL: cfg.Security.TokenExpiration,
+		redis:    redis,
+		logger:   logger,
+		audit:    logger.Named("audit"),
+		jtis:     make(map[string]revocationEntry),
+		subjects: make(map[string]revocationEntry),
+	}
+	for _, cidr := range cfg.Security.Revocation.Export.AllowedCIDRs {
+		// Validated when the configuration is loaded
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			r.fetchers = append(r.fetchers, network)
+		}
+	}
+	return r
+}
+
+// Start subscribes to the revocation channel, prunes expired cache entries
+// and, with export, keeps the exported list current until ctx is cancelled.
+func (r *Revocations) Start(ctx context.Context) {
+	if r.redis != nil {
+		r.redis.Subscribe(ctx, r.cfg.Channel, r.handleMessage)
+	}
+	if r.exporting() {
+		r.startExport(ctx)
+	}
+
+	go func() {
+		ticker := time.NewTicker(revocationSweepEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.sweep()
+			}
+		}
+	}()
+}
+
+func (r *Revocations) handleMessage(payload string) {
+	var ev Revocation
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil || ev.Validate() != nil {
+		r.logger.Warn("Ignoring malformed revocation event", zap.String("channel", r.cfg.Channel))
+		return
+	}
+	r.apply(ev)
+	if err := r.record(context.Background(), ev); err != nil {
+		r.logger.Warn("Failed to record revocation for export", zap.Error(err))
+	}
+	r.logger.Info("Revocation event received",
+		zap.String("jti", ev.JTI),
+		zap.String("sub", ev.Subject),
+		zap.String("reason", ev.Reason),
+	)
+}
+
+// Revoke persists a revocation to the Redis blacklist and publishes it to
+// every replica.
+func (r *Revocations) Revoke(ctx context.Context, ev Revocation, source string) error {
+	if err := ev.Validate(); err != nil {
+		return err
+	}
+	r.apply(ev)
+
+	if r.redis != nil {
+		if ev.JTI != "" {
+			if err := r.redis.BlacklistToken(ctx, ev.JTI, r.jtiTTL(ev)); err != nil {
+				return err
+			}
+		}
+		if ev.Subject != "" && ev.RevokeBefore > 0 {
+			if err := r.redis.SetValue(ctx, subjectRevocationKey+ev.Subject, strconv.FormatInt(ev.RevokeBefore, 10), r.tokenTTL); err != nil {
+				return err
+			}
+		}
+		if err := r.record(ctx, ev); err != nil {
+			return err
+		}
+		data, _ := json.Marshal(ev)
+		if err := r.redis.Publish(ctx, r.cfg.Channel, string(data)); err != nil {
+			return err
+		}
+	}
+
+	r.audit.Info("Token revoked",
+		zap.String("event", "token_revoked"),
+		zap.String("jti", ev.JTI),
+		zap.String("sub", ev.Subject),
+		zap.Int64("revoke_before", ev.RevokeBefore),
+		zap.String("reason", ev.Reason),
+		zap.String("source", source),
+	)
+	return nil
+}
+
+// Check reports whether the token with jti, issued to sub at iat, has been
+// revoked. Lookups are cached for CacheTTL; events update the cache at once.
+func (r *Revocations) Check(ctx context.Context, jti, sub string, iat int64) (bool, error) {
+	now := time.Now()
+
+	if jti != "" {
+		r.mu.Lock()
+		e, ok := r.jtis[jti]
+		r.mu.Unlock()
+		if !ok || now.After(e.until) {
+			revoked := false
+			if r.redis != nil {
+				var err error
+				if revoked, err = r.redis.IsTokenBlacklisted(ctx, jti); err != nil {
+					return false, err
+				}
+			}
+			e = revocationEntry{revoked: revoked, until: now.Add(r.cfg.CacheTTL)}
+			r.mu.Lock()
+			r.jtis[jti] = e
+			r.mu.Unlock()
+		}
+		if e.revoked {
+			return true, nil
+		}
+	}
+
+	if sub != "" {
+		r.mu.Lock()
+		e, ok := r.subjects[sub]
+		r.mu.Unlock()
+		if !ok || now.After(e.until) {
+			var before int64
+			if r.redis != nil {
+				raw, err := r.redis.GetValue(ctx, subjectRevocationKey+sub)
+				if err != nil {
+					return false, err
+				}
+				before, _ = strconv.ParseInt(raw, 10, 64)
+			}
+			e = revocationEntry{before: max(before, e.before), until: now.Add(r.cfg.CacheTTL)}
+			r.mu.Lock()
+			r.subjects[sub] = e
+			r.mu.Unlock()
+		}
+		if e.before > 0 && iat < e.before {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply records an event in the local cache for as long as the revoked
+// tokens can still be presented.
+func (r *Revocations) apply(ev Revocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev.JTI != "" {
+		r.jtis[ev.JTI] = revocationEntry{revoked: true, until: time.Now().Add(r.jtiTTL(ev))}
+	}
+	if ev.Subject != "" && ev.RevokeBefore > 0 {
+		before := max(ev.RevokeBefore, r.subjects[ev.Subject].before)
+		r.subjects[ev.Subject] = revocationEntry{before: before, until: time.Now().Add(r.tokenTTL)}
+	}
+}
+
+// jtiTTL is how long a revoked token ID must be remembered: until the token
+// expires, or a full token lifetime when the event does not say.
+func (r *Revocations) jtiTTL(ev Revocation) time.Duration {
+	if ev.Exp > 0 {
+		if ttl := time.Until(time.Unix(ev.Exp, 0)); ttl > 0 {
+			return ttl
+		}
+	}
+	return r.tokenTTL
+}
+
+func (r *Revocations) sweep() {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, e := range r.jtis {
+		if now.After(e.until) {
+			delete(r.jtis, k)
+		}
+	}
+	for k, e := range r.subjects {
+		if now.After(e.until) {
+			delete(r.subjects, k)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// revocationListKey holds the revocations to export, one field per token
+// ("jti:<jti>") or subject ("sub:<sub>"), each a JSON entry with its expiry.
+const revocationListKey = "revocation_list"
+
+// ListSigner signs the exported revocation list, e.g. the gateway signing
+// key.
+type ListSigner interface {
+	// Detached returns a compact JWS over payload with the payload left out.
+	Detached(ctx context.Context, payload []byte) (string, error)
+}
+
+// RevocationList is the exported document: every token and subject still
+// revoked, sorted, with when it was issued and when the next one is due.
+// Times are Unix seconds; an entry can be dropped once Exp has passed.
+type RevocationList struct {
+	IssuedAt   int64            `json:"issued_at"`
+	NextUpdate int64            `json:"next_update"`
+	Tokens     []RevokedToken   `json:"tokens"`
+	Subjects   []RevokedSubject `json:"subjects"`
+}
+
+// RevokedToken is a token revoked by ID until it expires.
+type RevokedToken struct {
+	JTI string `json:"jti"`
+	Exp int64  `json:"exp"`
+}
+
+// RevokedSubject revokes every token of Subject issued before RevokeBefore,
+// until the last of them has expired.
+type RevokedSubject struct {
+	Subject      string `json:"sub"`
+	RevokeBefore int64  `json:"revoke_before"`
+	Exp          int64  `json:"exp"`
+}
+
+// SignedRevocationList is the exported list as served.
+type SignedRevocationList struct {
+	Body []byte
+	// JWS is the detached signature of Body.
+	JWS  string
+	ETag string
+}
+
+// UseSigner signs the exported revocation list with signer. Without one the
+// list is not exported.
+func (r *Revocations) UseSigner(signer ListSigner) {
+	r.signer = signer
+}
+
+// List returns the last exported revocation list, or nil before the first.
+func (r *Revocations) List() *SignedRevocationList {
+	return r.list.Load()
+}
+
+// MayFetch reports whether a client at remoteAddr may fetch the list.
+func (r *Revocations) MayFetch(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.fetchers {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// exporting reports whether revocations are exported.
+func (r *Revocations) exporting() bool {
+	return r.cfg.Export.Enabled && r.signer != nil
+}
+
+// startExport exports the revocation list now and every interval until ctx
+// is cancelled.
+func (r *Revocations) startExport(ctx context.Context) {
+	go func() {
+		r.export(ctx)
+		ticker := time.NewTicker(r.cfg.Export.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.export(ctx)
+			}
+		}
+	}()
+}
+
+// record adds an event to the revocations to export.
+func (r *Revocations) record(ctx context.Context, ev Revocation) error {
+	if !r.exporting() || r.redis == nil {
+		return nil
+	}
+	if ev.JTI != "" {
+		data, _ := json.Marshal(RevokedToken{JTI: ev.JTI, Exp: time.Now().Add(r.jtiTTL(ev)).Unix()})
+		if err := r.redis.SetHashField(ctx, revocationListKey, "jti:"+ev.JTI, string(data), r.tokenTTL); err != nil {
+			return err
+		}
+	}
+	if ev.Subject != "" && ev.RevokeBefore > 0 {
+		r.mu.Lock()
+		before := max(ev.RevokeBefore, r.subjects[ev.Subject].before)
+		r.mu.Unlock()
+		data, _ := json.Marshal(r.revokedSubject(ev.Subject, before))
+		if err := r.redis.SetHashField(ctx, revocationListKey, "sub:"+ev.Subject, string(data), r.tokenTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokedSubject is a subject revocation lasting until the tokens issued
+// before it have expired.
+func (r *Revocations) revokedSubject(sub string, before int64) RevokedSubject {
+	return RevokedSubject{Subject: sub, RevokeBefore: before, Exp: time.Unix(before, 0).Add(r.tokenTTL).Unix()}
+}
+
+// export compacts the revocations, dropping expired entries, and signs the
+// list of those left. The previous list is served until this succeeds.
+func (r *Revocations) export(ctx context.Context) {
+	now := time.Now()
+	list := RevocationList{
+		IssuedAt:   now.Unix(),
+		NextUpdate: now.Add(r.cfg.Export.Interval).Unix(),
+		Tokens:     []RevokedToken{},
+		Subjects:   []RevokedSubject{},
+	}
+
+	if r.redis != nil {
+		raw, err := r.redis.GetHash(ctx, revocationListKey)
+		if err != nil {
+			r.logger.Warn("Failed to read revocations for export", zap.Error(err))
+			return
+		}
+		var expired []string
+		for field, v := range raw {
+			switch {
+			case strings.HasPrefix(field, "jti:"):
+				var t RevokedToken
+				if json.Unmarshal([]byte(v), &t) == nil && t.Exp > list.IssuedAt {
+					list.Tokens = append(list.Tokens, t)
+					continue
+				}
+			case strings.HasPrefix(field, "sub:"):
+				var s RevokedSubject
+				if json.Unmarshal([]byte(v), &s) == nil && s.Exp > list.IssuedAt {
+					list.Subjects = append(list.Subjects, s)
+					continue
+				}
+			}
+			// Expired or malformed
+			expired = append(expired, field)
+		}
+		for _, field := range expired {
+			if err := r.redis.DeleteHashField(ctx, revocationListKey, field); err != nil {
+				r.logger.Warn("Failed to drop expired revocation", zap.String("field", field), zap.Error(err))
+				break
+			}
+		}
+	} else {
+		// Without Redis, what this replica has seen
+		r.mu.Lock()
+		for jti, e := range r.jtis {
+			if e.revoked && now.Before(e.until) {
+				list.Tokens = append(list.Tokens, RevokedToken{JTI: jti, Exp: e.until.Unix()})
+			}
+		}
+		for sub, e := range r.subjects {
+			if s := r.revokedSubject(sub, e.before); e.before > 0 && s.Exp > list.IssuedAt {
+				list.Subjects = append(list.Subjects, s)
+			}
+		}
+		r.mu.Unlock()
+	}
+	sort.Slice(list.Tokens, func(i, j int) bool { return list.Tokens[i].JTI < list.Tokens[j].JTI })
+	sort.Slice(list.Subjects, func(i, j int) bool { return list.Subjects[i].Subject < list.Subjects[j].Subject })
+
+	body, _ := json.Marshal(list)
+	jws, err := r.signer.Detached(ctx, body)
+	if err != nil {
+		r.logger.Error("Failed to sign revocation list", zap.Error(err))
+		return
+	}
+	sum := sha256.Sum256(body)
+	r.list.Store(&SignedRevocationList{Body: body, JWS: jws, ETag: `"` + hex.EncodeToString(sum[:16]) + `"`})
+	metrics.RevocationListEntries.WithLabelValues("token").Set(float64(len(list.Tokens)))
+	metrics.RevocationListEntries.WithLabelValues("subject").Set(float64(len(list.Subjects)))
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var errMalformedBody = errors.New("malformed JSON body")
+
+// defaultDangerousKeys are always filtered when sanitization is enabled.
+var defaultDangerousKeys = []string{"__proto__", "constructor", "prototype"}
+
+type BodySanitizer struct {
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+func NewBodySanitizer(cfg *config.Config, logger *zap.Logger) *BodySanitizer {
+	return &BodySanitizer{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// ForService returns middleware that applies the body sanitization policy of
+// the named service. Services without a policy pass through untouched.
+func (s *BodySanitizer) ForService(serviceName string) echo.MiddlewareFunc {
+	policy := s.cfg.Services[serviceName].BodySanitization
+	keys := make(map[string]bool)
+	for _, k := range append(defaultDangerousKeys, policy.Keys...) {
+		keys[k] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if policy.Mode == "" {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody || !isJSONContent(req.Header.Get(echo.HeaderContentType)) {
+				return next(c)
+			}
+
+			raw, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+			}
+
+			var body interface{}
+			var found []string
+			err = runBudgeted(c, s.cfg, "body_sanitization", 2*int64(len(raw)), func(ctx context.Context) error {
+				if err := jsonutil.Unmarshal(raw, &body); err != nil {
+					return errMalformedBody
+				}
+				var nodes int
+				found, err = findDangerousKeys(ctx, body, keys, policy.BlockOperators, policy.Mode == "strip", &nodes)
+				return err
+			})
+			var budgetErr *budgetError
+			switch {
+			case errors.As(err, &budgetErr):
+				return rejectOverBudget(c, s.logger, budgetErr)
+			case errors.Is(err, errMalformedBody):
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Malformed JSON body"})
+			case err != nil:
+				return err
+			}
+			if len(found) == 0 {
+				req.Body = io.NopCloser(bytes.NewReader(raw))
+				return next(c)
+			}
+
+			s.logger.Warn("Dangerous keys detected in request body",
+				zap.String("service", serviceName),
+				zap.String("mode", policy.Mode),
+				zap.Strings("keys", found),
+				zap.String("request_id", RequestIDFrom(c)),
+			)
+
+			if policy.Mode != "strip" {
+				Explain(c, "body_sanitization", "deny", "forbidden keys: "+strings.Join(found, ","))
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Request body contains forbidden keys",
+				})
+			}
+
+			sanitized, err := jsonutil.Marshal(body)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to sanitize request body"})
+			}
+			Explain(c, "body_sanitization", "strip", strings.Join(found, ","))
+			req.Body = io.NopCloser(bytes.NewReader(sanitized))
+			if req.GetBody != nil {
+				// Replays (buffered bodies) must send the sanitized body too
+				req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(sanitized)), nil }
+			}
+			req.ContentLength = int64(len(sanitized))
+			req.Header.Del(echo.HeaderContentLength)
+			return next(c)
+		}
+	}
+}
+
+// findDangerousKeys walks a decoded JSON value and returns the offending keys,
+// deleting them in place when strip is true. nodes counts the values visited
+// for budget checks.
+func findDangerousKeys(ctx context.Context, v interface{}, keys map[string]bool, operators, strip bool, nodes *int) ([]string, error) {
+	if err := checkBudget(ctx, nodes); err != nil {
+		return nil, err
+	}
+	var found []string
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if keys[k] || (operators && strings.HasPrefix(k, "$")) {
+				found = append(found, k)
+				if strip {
+					delete(t, k)
+				}
+				continue
+			}
+			more, err := findDangerousKeys(ctx, child, keys, operators, strip, nodes)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, more...)
+		}
+	case []interface{}:
+		for _, child := range t {
+			more, err := findDangerousKeys(ctx, child, keys, operators, strip, nodes)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, more...)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	shieldKey = "shield"
+	// shieldChannel tells the other replicas to pick up a change at once.
+	shieldChannel = "gateway:shield"
+	// shieldTTL keeps the switch on across a long attack; every change
+	// refreshes it.
+	shieldTTL = 7 * 24 * time.Hour
+)
+
+// shieldExempt are path prefixes the shield never sheds, so probes keep
+// working and operators can still reach the admin API.
+var shieldExempt = []string{"/health", "/metrics", "/admin"}
+
+// ShieldState describes an active shield.
+type ShieldState struct {
+	RPS    float64   `json:"rps"`
+	Burst  int       `json:"burst"`
+	Reason string    `json:"reason,omitempty"`
+	SetBy  string    `json:"set_by,omitempty"`
+	SetAt  time.Time `json:"set_at"`
+}
+
+// Shield is the emergency global rate limit. While on, each replica admits
+// at most RPS requests per second from a local token bucket and sheds the
+// rest with 429, without touching Redis on the request path. The switch
+// lives in Redis so every replica follows it; each replica refreshes its
+// local copy on an interval.
+type Shield struct {
+	cfg    config.ShieldConfig
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	limiter atomic.Pointer[rate.Limiter]
+
+	mu    sync.Mutex
+	state *ShieldState
+}
+
+func NewShield(cfg config.ShieldConfig, redis *infrastructure.RedisClient, logger *zap.Logger) *Shield {
+	return &Shield{cfg: cfg, redis: redis, logger: logger}
+}
+
+// Start follows switch changes made on other replicas, as announced and by
+// polling Redis, until ctx is cancelled.
+func (s *Shield) Start(ctx context.Context, interval time.Duration) {
+	if s.redis == nil {
+		return
+	}
+	s.refresh(ctx)
+	s.redis.Subscribe(ctx, shieldChannel, func(string) { s.refresh(ctx) })
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (s *Shield) refresh(ctx context.Context) {
+	raw, err := s.redis.GetValue(ctx, shieldKey)
+	if err != nil {
+		s.logger.Warn("Failed to refresh shield switch", zap.Error(err))
+		return
+	}
+	if raw == "" {
+		s.apply(nil)
+		return
+	}
+	var st ShieldState
+	if err := json.Unmarshal([]byte(raw), &st); err == nil {
+		s.apply(&st)
+	}
+}
+
+// apply switches to st, or off for nil. The bucket is kept while the
+// ceiling is unchanged.
+func (s *Shield) apply(st *ShieldState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case st == nil:
+		s.limiter.Store(nil)
+		metrics.ShieldActive.Set(0)
+	case s.state == nil || s.state.RPS != st.RPS || s.state.Burst != st.Burst:
+		s.limiter.Store(rate.NewLimiter(rate.Limit(st.RPS), st.Burst))
+		metrics.ShieldActive.Set(1)
+	}
+	s.state = st
+}
+
+// State returns the active shield, or nil.
+func (s *Shield) State() *ShieldState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil
+	}
+	st := *s.state
+	return &st
+}
+
+// Enable switches the shield on, with the configured ceiling where st does
+// not set one.
+func (s *Shield) Enable(ctx context.Context, st ShieldState) (ShieldState, error) {
+	if st.RPS <= 0 {
+		st.RPS = s.cfg.RPS
+	}
+	if st.Burst < 1 {
+		st.Burst = s.cfg.Burst
+	}
+	st.SetAt = time.Now().UTC()
+	if s.redis != nil {
+		data, _ := json.Marshal(st)
+		if err := s.redis.SetValue(ctx, shieldKey, string(data), shieldTTL); err != nil {
+			return st, err
+		}
+	}
+	s.apply(&st)
+	s.announce(ctx)
+
+	s.logger.Warn("Shield enabled",
+		zap.Float64("rps", st.RPS),
+		zap.Int("burst", st.Burst),
+		zap.String("reason", st.Reason),
+		zap.String("set_by", st.SetBy),
+	)
+	return st, nil
+}
+
+// Disable switches the shield off.
+func (s *Shield) Disable(ctx context.Context, by string) error {
+	if s.redis != nil {
+		if err := s.redis.DeleteKey(ctx, shieldKey); err != nil {
+			return err
+		}
+	}
+	s.apply(nil)
+	s.announce(ctx)
+
+	s.logger.Warn("Shield disabled", zap.String("by", by))
+	return nil
+}
+
+// announce tells the other replicas about a change; they poll for it anyway.
+func (s *Shield) announce(ctx context.Context) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Publish(ctx, shieldChannel, "changed"); err != nil {
+		s.logger.Warn("Failed to announce shield change", zap.Error(err))
+	}
+}
+
+// Handle sheds requests over the ceiling while the shield is on. Shed
+// requests are only counted: logging each would add to the load.
+func (s *Shield) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limiter := s.limiter.Load()
+		if limiter == nil {
+			return next(c)
+		}
+		path := c.Request().URL.Path
+		for _, prefix := range shieldExempt {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return next(c)
+			}
+		}
+		if limiter.Allow() {
+			return next(c)
+		}
+
+		metrics.ShieldShed.Inc()
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many requests, try again shortly"})
+	}
+}
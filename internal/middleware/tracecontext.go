@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/tracing"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader carries W3C trace context.
+const TraceparentHeader = "traceparent"
+
+// ParseTraceparent reads a W3C traceparent ("00-<trace-id>-<parent-id>-<flags>")
+// and reports its trace ID and whether the trace is sampled.
+func ParseTraceparent(value string) (traceID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || !isLowerHex(parts[1]) || !isLowerHex(parts[2]) ||
+		parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", false, false
+	}
+	return parts[1], flags[0]&1 == 1, true
+}
+
+// Tracing starts a server span for each request, continuing the caller's
+// trace from its traceparent/tracestate headers. The span is carried in the
+// request context, so upstream calls become its children.
+func Tracing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(req.URL.Path),
+				semconv.ClientAddress(c.RealIP()),
+			),
+		)
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+		span.SetAttributes(attribute.String("gateway.request_id", RequestIDFrom(c)))
+
+		err := next(c)
+		if err != nil {
+			// Let Echo write the error response so the status is known
+			c.Error(err)
+			span.RecordError(err)
+		}
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+		return nil
+	}
+}
+
+// SampledTraceID returns the trace ID of a request that belongs to a sampled
+// trace, or "" when there is none (an unsampled trace is never stored, so
+// nothing should link to it). The gateway's own span is preferred, since it
+// also covers traces the gateway started.
+func SampledTraceID(c echo.Context) string {
+	if sc := trace.SpanContextFromContext(c.Request().Context()); sc.IsValid() {
+		if !sc.IsSampled() {
+			return ""
+		}
+		return sc.TraceID().String()
+	}
+	traceID, sampled, ok := ParseTraceparent(c.Request().Header.Get(TraceparentHeader))
+	if !ok || !sampled {
+		return ""
+	}
+	return traceID
+}
+
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
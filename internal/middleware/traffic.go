@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/traffic"
+	"github.com/labstack/echo/v4"
+)
+
+// TrafficRecorder feeds every completed request into the traffic tracker used
+// by the admin top-talkers report.
+func TrafficRecorder(tracker *traffic.Tracker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+			}
+
+			userID, _ := c.Get("user_id").(string)
+			rateLimitKey, _ := c.Get("ratelimit_key").(string)
+			tracker.Record(traffic.Observation{
+				ClientID:     userID,
+				IP:           c.RealIP(),
+				Route:        c.Request().Method + " " + c.Path(),
+				Status:       status,
+				Bytes:        c.Request().ContentLength,
+				RateLimitKey: rateLimitKey,
+			})
+
+			return err
+		}
+	}
+}
+
+// DarkTrafficGuard drops the dark traffic header from requests not sent by a
+// dark traffic run, so customer requests cannot pass as synthetic ones.
+func DarkTrafficGuard(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if req := c.Request(); !traffic.IsDark(req) {
+			req.Header.Del(traffic.DarkHeader)
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/banking/api-gateway/internal/analytics"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// UsageRecorder records which client called which route with which token
+// scopes. It must run after ValidateToken.
+func UsageRecorder(tracker *analytics.UsageTracker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if claims, ok := c.Get("user_claims").(jwt.MapClaims); ok {
+				tracker.Record(ClientIDFromClaims(claims), c.Path(), ScopesFromClaims(claims))
+			}
+			return next(c)
+		}
+	}
+}
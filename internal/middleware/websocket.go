@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// IsWebSocketUpgrade reports whether r asks to switch to the WebSocket
+// protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSockets holds a user's WebSocket connections to the configured limits
+// for as long as they stay open, and closes each when the token it was
+// opened with expires: the upgrade is the only request a connection
+// authenticates.
+type WebSockets struct {
+	cfg    *config.Config
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	open map[string]int // by user
+}
+
+func NewWebSockets(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) *WebSockets {
+	return &WebSockets{
+		cfg:    cfg,
+		redis:  redis,
+		logger: logger,
+		open:   make(map[string]int),
+	}
+}
+
+// Handle limits WebSocket upgrades to routes with websocket by authenticated
+// user; other requests pass through. It must run after ValidateToken.
+func (w *WebSockets) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	limits := w.cfg.WebSocket
+	return func(c echo.Context) error {
+		userID, _ := c.Get("user_id").(string)
+		route := w.cfg.Route(c.Path())
+		if !IsWebSocketUpgrade(c.Request()) || route == nil || !route.WebSocket || userID == "" {
+			return next(c)
+		}
+
+		if limit := limits.PerUser; limit > 0 {
+			if !w.acquireLocal(userID, limit) {
+				return w.reject(c, "replica", limit)
+			}
+			defer w.releaseLocal(userID)
+		}
+		if limit := limits.ClusterPerUser; limit > 0 && w.redis != nil {
+			key := leaseKey(w.cfg.RateLimits.KeySecret, "websocket", userID)
+			id := newLeaseID()
+			granted, held, err := w.redis.AcquireLease(c.Request().Context(), key, id, limit, limits.LeaseTTL)
+			switch {
+			case err != nil:
+				// Replica limits still apply; do not fail upgrades on Redis errors
+				w.logger.Warn("Failed to acquire websocket lease", zap.String("request_id", RequestIDFrom(c)), zap.Error(err))
+			case !granted:
+				w.logger.Warn("Cluster websocket limit reached", zap.Int64("held", held), zap.String("request_id", RequestIDFrom(c)))
+				return w.reject(c, "cluster", limit)
+			default:
+				stop := holdLease(w.redis, w.logger, "websocket", key, id, limits.LeaseTTL)
+				defer stop()
+			}
+		}
+
+		req := c.Request()
+		claims, _ := c.Get("user_claims").(jwt.MapClaims)
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			ctx, cancel := context.WithDeadline(req.Context(), exp.Time)
+			defer cancel()
+			c.SetRequest(req.WithContext(ctx))
+			Explain(c, "websocket", "allow", fmt.Sprintf("open until the token expires at %s", exp.UTC().Format(time.RFC3339)))
+		} else {
+			Explain(c, "websocket", "allow", "token without expiry")
+		}
+
+		gauge := metrics.WebSocketConnections.WithLabelValues(c.Path())
+		gauge.Inc()
+		defer gauge.Dec()
+		return next(c)
+	}
+}
+
+func (w *WebSockets) reject(c echo.Context, level string, limit int) error {
+	metrics.WebSocketRejected.WithLabelValues(level).Inc()
+	Explain(c, "websocket", "deny", level+" connection limit reached")
+	c.Response().Header().Set("Retry-After", "1")
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error": "Too many WebSocket connections",
+		"limit": limit,
+	})
+}
+
+func (w *WebSockets) acquireLocal(userID string, limit int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.open[userID] >= limit {
+		return false
+	}
+	w.open[userID]++
+	return true
+}
+
+func (w *WebSockets) releaseLocal(userID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.open[userID] <= 1 {
+		delete(w.open, userID)
+		return
+	}
+	w.open[userID]--
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"math"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/sony/gobreaker"
+)
+
+// balancer spreads one service's requests over its instances, round-robin,
+// to the least loaded or by consistent hash, in proportion to their weights. With circuit_breaker each instance has its
+// own breaker, so a failing instance is skipped while the others keep
+// serving.
+type balancer struct {
+	mu        sync.RWMutex
+	instances []string
+	breakers  map[string]*gobreaker.CircuitBreaker // by instance
+	// current is each instance's running weight in the rotation
+	current map[string]int
+	// loads and turn serve the least_conn and least_latency strategies
+	loads map[string]*load
+	turn  int
+	// hashRing serves consistent_hash, built on first use
+	hashRing *hashRing
+	// outliers tracks failures for outlier_detection, by instance
+	outliers map[string]*outlier
+}
+
+// load is an instance's requests in flight from this replica and its
+// latency average in milliseconds (0 until measured).
+type load struct {
+	inflight int
+	latency  float64
+}
+
+const (
+	// latencyDecay weighs each response in an instance's latency average,
+	// so it follows roughly the last ten.
+	latencyDecay = 0.2
+	// failureLatency is the least a failed request counts for.
+	failureLatency = time.Second
+)
+
+// balanced reports whether a service is spread over several instances
+// rather than sent to its single URL.
+func balanced(svc config.Service) bool {
+	return len(svc.URLs) > 0 || svc.Discovery != ""
+}
+
+// breakerName names an instance's breaker in metrics and the status page.
+func breakerName(service, instance string) string {
+	if u, err := url.Parse(instance); err == nil && u.Host != "" {
+		return service + "/" + u.Host
+	}
+	return service + "/" + instance
+}
+
+// balancer returns the service's balancer, following its current instances:
+// the breakers and rotation state of instances that are gone are dropped.
+func (h *ProxyHandler) balancer(name string, svc config.Service, instances []string) *balancer {
+	h.mu.RLock()
+	b, ok := h.balancers[name]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if b, ok = h.balancers[name]; !ok {
+			b = &balancer{breakers: make(map[string]*gobreaker.CircuitBreaker), current: make(map[string]int), loads: make(map[string]*load), outliers: make(map[string]*outlier)}
+			h.balancers[name] = b
+		}
+		h.mu.Unlock()
+	}
+
+	b.mu.RLock()
+	current := slices.Equal(b.instances, instances)
+	b.mu.RUnlock()
+	if current {
+		return b
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for instance := range b.breakers {
+		if !slices.Contains(instances, instance) {
+			delete(b.breakers, instance)
+			metrics.CircuitBreakerState.DeleteLabelValues(breakerName(name, instance))
+			h.backoff.forget(breakerName(name, instance))
+		}
+	}
+	for instance := range b.current {
+		if !slices.Contains(instances, instance) {
+			delete(b.current, instance)
+		}
+	}
+	for instance := range b.loads {
+		if !slices.Contains(instances, instance) {
+			delete(b.loads, instance)
+		}
+	}
+	for instance := range b.outliers {
+		if !slices.Contains(instances, instance) {
+			delete(b.outliers, instance)
+		}
+	}
+	if svc.CircuitBreaker {
+		for _, instance := range instances {
+			if _, ok := b.breakers[instance]; !ok {
+				b.breakers[instance] = h.createCircuitBreaker(breakerName(name, instance))
+			}
+		}
+	}
+	b.instances = slices.Clone(instances)
+	return b
+}
+
+// pick returns the instance the service's strategy chooses among those
+// whose breaker lets requests through, with that breaker (nil without
+// circuit_breaker). Instances with an open breaker, failing their health
+// checks or ejected as outliers are left out, the others sharing their load
+// in proportion to their weights; those weighted 0 are only used while no
+// weighted instance is available. When every instance with a closed breaker
+// is unhealthy or ejected they are all used anyway (failOpen is true), as a
+// broken health endpoint is likelier than a whole service down. ok is false when every instance's breaker is
+// open; key is the request's hash key, for consistent_hash.
+func (b *balancer) pick(svc config.Service, key string, weight func(instance string) int, healthy func(instance string) bool) (instance string, cb *gobreaker.CircuitBreaker, failOpen, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	weights := b.candidates(weight, func(instance string) bool {
+		return healthy(instance) && !b.ejected(instance, now)
+	})
+	if len(weights) == 0 {
+		weights = b.candidates(weight, func(string) bool { return true })
+		failOpen = len(weights) > 0
+	}
+	if len(weights) == 0 {
+		return "", nil, false, false
+	}
+
+	switch svc.Balancing {
+	case "least_conn", "least_latency":
+		instance = b.leastLoaded(svc.Balancing, weights)
+	case "consistent_hash":
+		loadFactor := svc.HashLoadFactor
+		if loadFactor == 0 {
+			loadFactor = defaultHashLoadFactor
+		}
+		instance = b.consistentHash(key, loadFactor, weight, weights)
+	default:
+		instance = b.roundRobin(weights)
+	}
+	return instance, b.breakers[instance], failOpen, true
+}
+
+// candidates returns the eligible instances with a closed breaker, by
+// weight: those weighted above 0, or else the drained ones evenly. Callers
+// hold mu.
+func (b *balancer) candidates(weight func(instance string) int, eligible func(instance string) bool) map[string]int {
+	weights := make(map[string]int, len(b.instances))
+	var drained []string
+	for _, instance := range b.instances {
+		if cb := b.breakers[instance]; (cb != nil && cb.State() == gobreaker.StateOpen) || !eligible(instance) {
+			continue
+		}
+		if w := weight(instance); w > 0 {
+			weights[instance] = w
+		} else {
+			drained = append(drained, instance)
+		}
+	}
+	if len(weights) == 0 {
+		for _, instance := range drained {
+			weights[instance] = 1
+		}
+	}
+	return weights
+}
+
+// roundRobin takes instances in turn by weight, interleaved smoothly
+// (nginx's weighted round-robin) so a small slice never arrives in bursts.
+// Callers hold mu.
+func (b *balancer) roundRobin(weights map[string]int) string {
+	var instance string
+	total := 0
+	for _, candidate := range b.instances {
+		w, ok := weights[candidate]
+		if !ok {
+			continue
+		}
+		b.current[candidate] += w
+		total += w
+		if instance == "" || b.current[candidate] > b.current[instance] {
+			instance = candidate
+		}
+	}
+	b.current[instance] -= total
+	return instance
+}
+
+// leastLoaded takes the instance with the fewest requests in flight from
+// this replica or, for least_latency, the lowest latency average scaled by
+// them, relative to its weight. Instances not measured yet count as fast as
+// the fastest, so a new one gets its share straight away; ties rotate so
+// equal instances share the load. Callers hold mu.
+func (b *balancer) leastLoaded(strategy string, weights map[string]int) string {
+	fastest := math.Inf(1)
+	for candidate := range weights {
+		if l := b.loads[candidate]; l != nil && l.latency > 0 {
+			fastest = min(fastest, l.latency)
+		}
+	}
+	if math.IsInf(fastest, 1) {
+		fastest = 1
+	}
+
+	var instance string
+	best := math.Inf(1)
+	b.turn++
+	for i := range b.instances {
+		candidate := b.instances[(b.turn+i)%len(b.instances)]
+		w, ok := weights[candidate]
+		if !ok {
+			continue
+		}
+		var l load
+		if loaded := b.loads[candidate]; loaded != nil {
+			l = *loaded
+		}
+		score := float64(l.inflight+1) / float64(w)
+		if strategy == "least_latency" {
+			if l.latency == 0 {
+				l.latency = fastest
+			}
+			score *= l.latency
+		}
+		if score < best {
+			instance, best = candidate, score
+		}
+	}
+	return instance
+}
+
+// begin counts a request to an instance as in flight; the returned function
+// ends it, folding its latency into the instance's average. Failed requests
+// (5xx or no response) count as at least failureLatency, so an instance
+// answering errors quickly does not attract the traffic.
+func (b *balancer) begin(instance string) func(failed bool) {
+	start := time.Now()
+	b.mu.Lock()
+	l := b.loads[instance]
+	if l == nil {
+		l = &load{}
+		b.loads[instance] = l
+	}
+	l.inflight++
+	b.mu.Unlock()
+
+	return func(failed bool) {
+		elapsed := time.Since(start)
+		if failed {
+			elapsed = max(elapsed, failureLatency)
+		}
+		sample := float64(elapsed) / float64(time.Millisecond)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		l.inflight--
+		if l.latency == 0 {
+			l.latency = sample
+		} else {
+			l.latency += latencyDecay * (sample - l.latency)
+		}
+	}
+}
+
+// states returns the state of every instance breaker, by instance.
+func (b *balancer) states() map[string]gobreaker.State {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	states := make(map[string]gobreaker.State, len(b.breakers))
+	for instance, cb := range b.breakers {
+		states[instance] = cb.State()
+	}
+	return states
+}
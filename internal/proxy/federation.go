@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/mesh"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// PeerForwarder sends requests that match no local route to a peer gateway,
+// path unchanged. In mesh mode it connects with mutual TLS and adds itself to
+// the request's gateway path.
+type PeerForwarder struct {
+	cfg    config.FederationConfig
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+	logger *zap.Logger
+	via    string
+}
+
+// NewPeerForwarder forwards to the configured peer, over the mesh when
+// meshTLS is not nil.
+func NewPeerForwarder(cfg config.FederationConfig, meshTLS *mesh.TLS, logger *zap.Logger) (*PeerForwarder, error) {
+	target, err := url.Parse(cfg.PeerURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, errors.New("federation.peer_url must be an absolute URL")
+	}
+	if cfg.GatewayID == "" {
+		return nil, errors.New("federation.gateway_id must be set")
+	}
+
+	f := &PeerForwarder{
+		cfg:    cfg,
+		target: target,
+		logger: logger,
+		via:    "1.1 " + cfg.GatewayID,
+	}
+	f.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Host = target.Host
+			r.Out.Header.Add("Via", f.via)
+			// Identity headers are only ever set by the gateway, and this
+			// request was not authenticated here
+			r.Out.Header.Del("X-User-ID")
+			r.Out.Header.Del("X-Actor-ID")
+			r.Out.Header.Del("X-Actor-Chain")
+			r.Out.Header.Del(mesh.ClientCertHeader)
+			if meshTLS != nil {
+				r.Out.Header.Set(mesh.PathHeader, strings.Join(append(mesh.Path(r.In.Header), meshTLS.ID()), ", "))
+			}
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: cfg.Timeout,
+	}
+	if meshTLS != nil {
+		transport.TLSClientConfig = meshTLS.ClientConfig()
+	}
+	f.proxy.Transport = transport
+	return f, nil
+}
+
+// Accepts reports whether an unmatched request may go to the peer.
+func (f *PeerForwarder) Accepts(path string) bool {
+	if strings.HasPrefix(path, "/admin") {
+		return false
+	}
+	for _, prefix := range f.cfg.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forward proxies the request to the peer. A request that already passed
+// through this gateway is answered with 508 Loop Detected.
+func (f *PeerForwarder) Forward(c echo.Context) error {
+	req := c.Request()
+	for _, hop := range req.Header.Values("Via") {
+		for _, entry := range strings.Split(hop, ",") {
+			if strings.TrimSpace(entry) == f.via {
+				metrics.FederationRequests.WithLabelValues("loop").Inc()
+				f.logger.Warn("Federation loop detected",
+					zap.String("path", req.URL.Path),
+					zap.Strings("via", req.Header.Values("Via")),
+					zap.String("request_id", middleware.RequestIDFrom(c)),
+				)
+				return c.JSON(http.StatusLoopDetected, map[string]string{"error": "Request loops between gateways"})
+			}
+		}
+	}
+
+	proxy := *f.proxy
+	proxy.ModifyResponse = func(res *http.Response) error {
+		res.Header.Add("Via", f.via)
+		metrics.FederationRequests.WithLabelValues(strconv.Itoa(res.StatusCode/100) + "xx").Inc()
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		metrics.FederationRequests.WithLabelValues("error").Inc()
+		f.logger.Error("Peer gateway forwarding error", zap.String("peer", f.target.Host), zap.String("request_id", middleware.RequestIDFrom(c)), zap.Error(err))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"error":"Peer gateway unavailable"}`))
+	}
+
+	middleware.Explain(c, "federation", "allow", f.target.Host)
+	proxy.ServeHTTP(c.Response(), req)
+	return nil
+}
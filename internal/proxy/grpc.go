@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// grpcTimeoutHeader carries a gRPC call's remaining time.
+const grpcTimeoutHeader = "Grpc-Timeout"
+
+// grpcTimeoutUnits are the grpc-timeout units, finest first.
+var grpcTimeoutUnits = []struct {
+	unit byte
+	size time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// parseGRPCTimeout parses a grpc-timeout value: up to eight digits and a
+// unit.
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	for _, u := range grpcTimeoutUnits {
+		if u.unit == v[len(v)-1] {
+			if n > int64(math.MaxInt64/u.size) {
+				return math.MaxInt64, true
+			}
+			return time.Duration(n) * u.size, true
+		}
+	}
+	return 0, false
+}
+
+// formatGRPCTimeout formats d as a grpc-timeout value in the finest unit
+// that fits, rounding down so the service gives up no later than the
+// gateway.
+func formatGRPCTimeout(d time.Duration) string {
+	for _, u := range grpcTimeoutUnits {
+		if n := d / u.size; n <= 99999999 {
+			return strconv.FormatInt(int64(max(n, 1)), 10) + string(u.unit)
+		}
+	}
+	return "99999999H"
+}
+
+// rpcTimeout is a gRPC call's timeout: the client's grpc-timeout, within the
+// service's timeout. It is false when neither sets one.
+func rpcTimeout(r *http.Request, serviceTimeout time.Duration) (time.Duration, bool) {
+	client, ok := parseGRPCTimeout(r.Header.Get(grpcTimeoutHeader))
+	switch {
+	case ok && (serviceTimeout <= 0 || client < serviceTimeout):
+		return client, true
+	case serviceTimeout > 0:
+		return serviceTimeout, true
+	}
+	return 0, false
+}
+
+// extendCallDeadline gives a gRPC call until its deadline, or as long as
+// the client waits without one, to stream its request and response instead
+// of the server's read and write timeouts.
+func (h *ProxyHandler) extendCallDeadline(c echo.Context, deadline time.Time) {
+	rc := http.NewResponseController(c.Response())
+	err := errors.Join(rc.SetReadDeadline(deadline), rc.SetWriteDeadline(deadline))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("Failed to extend deadlines for gRPC call", zap.String("route", c.Path()), zap.Error(err))
+		return
+	}
+	detail := "no deadline"
+	if !deadline.IsZero() {
+		detail = "deadline in " + time.Until(deadline).Round(time.Millisecond).String()
+	}
+	middleware.Explain(c, "grpc", "allow", detail)
+}
+
+// recordGRPCStatus counts a completed gRPC call by the grpc-status in its
+// trailers, or in its headers for a trailers-only response.
+func recordGRPCStatus(service string, res *http.Response) {
+	status := res.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = res.Header.Get("Grpc-Status")
+	}
+	if _, err := strconv.Atoi(status); err != nil {
+		status = "unknown"
+	}
+	metrics.UpstreamGRPCResponses.WithLabelValues(service, status).Inc()
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ringPoints is the average number of points per instance on a hash
+	// ring; more spread load more evenly.
+	ringPoints = 160
+	// defaultHashLoadFactor bounds instances to 125% of their fair share of
+	// requests in flight.
+	defaultHashLoadFactor = 1.25
+)
+
+// hashRing places instances on a circle of hashes, each at a number of points
+// in proportion to its weight. A key belongs to the first instance at or
+// after its hash, so an instance joining or leaving moves only the keys next
+// to its points.
+type hashRing struct {
+	// signature identifies the instances and weights the ring was built for
+	signature string
+	points    []uint64
+	owners    []string // instance at each point
+}
+
+// ringHash is stable across replicas and restarts, so every replica sends a
+// key to the same instance.
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func newHashRing(signature string, instances []string, weights map[string]int) *hashRing {
+	total := 0
+	for _, instance := range instances {
+		total += weights[instance]
+	}
+	r := &hashRing{signature: signature}
+	for _, instance := range instances {
+		n := int(math.Round(float64(ringPoints*len(instances)*weights[instance]) / float64(total)))
+		for i := 0; i < max(n, 1); i++ {
+			r.points = append(r.points, ringHash(instance+"#"+strconv.Itoa(i)))
+			r.owners = append(r.owners, instance)
+		}
+	}
+	sort.Sort(r)
+	return r
+}
+
+func (r *hashRing) Len() int           { return len(r.points) }
+func (r *hashRing) Less(i, j int) bool { return r.points[i] < r.points[j] }
+func (r *hashRing) Swap(i, j int) {
+	r.points[i], r.points[j] = r.points[j], r.points[i]
+	r.owners[i], r.owners[j] = r.owners[j], r.owners[i]
+}
+
+// ring returns the balancer's hash ring over its instances with the given
+// weights, rebuilding it when they changed. Callers hold mu.
+func (b *balancer) ring(weight func(instance string) int) *hashRing {
+	weights := make(map[string]int, len(b.instances))
+	var sig strings.Builder
+	for _, instance := range b.instances {
+		// Drained instances keep a point so they can serve when nothing
+		// else is available
+		weights[instance] = max(weight(instance), 1)
+		sig.WriteString(instance + "=" + strconv.Itoa(weights[instance]) + ",")
+	}
+	if b.hashRing == nil || b.hashRing.signature != sig.String() {
+		b.hashRing = newHashRing(sig.String(), b.instances, weights)
+	}
+	return b.hashRing
+}
+
+// consistentHash returns the owner of key on the ring among the candidates,
+// walking on past instances already carrying more than loadFactor times
+// their fair share of this replica's requests in flight (consistent hashing
+// with bounded loads). Callers hold mu.
+func (b *balancer) consistentHash(key string, loadFactor float64, weight func(instance string) int, candidates map[string]int) string {
+	r := b.ring(weight)
+	inflight, total := 0, 0
+	for instance, w := range candidates {
+		if l := b.loads[instance]; l != nil {
+			inflight += l.inflight
+		}
+		total += w
+	}
+
+	h := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	var first string
+	for i := range r.points {
+		instance := r.owners[(start+i)%len(r.points)]
+		w, ok := candidates[instance]
+		if !ok {
+			continue
+		}
+		if first == "" {
+			first = instance
+		}
+		capacity := math.Ceil(loadFactor * float64((inflight+1)*w) / float64(total))
+		if l := b.loads[instance]; l == nil || float64(l.inflight) < capacity {
+			return instance
+		}
+	}
+	return first
+}
+
+// hashKey returns the key a request is hashed by for consistent_hash, by the
+// service's hash_on: the user ID (default), the token's session ID, the
+// client IP, or a header or cookie. Requests without one use the client IP.
+func hashKey(c echo.Context, hashOn string) string {
+	kind, name, _ := strings.Cut(hashOn, ":")
+	var key string
+	switch kind {
+	case "", "user", "session":
+		if kind == "session" {
+			if claims, ok := c.Get("user_claims").(jwt.MapClaims); ok {
+				key, _ = claims["sid"].(string)
+			}
+		}
+		if key == "" {
+			key, _ = c.Get("user_id").(string)
+		}
+	case "header":
+		key = c.Request().Header.Get(name)
+	case "cookie":
+		if cookie, err := c.Cookie(name); err == nil {
+			key = cookie.Value
+		}
+	}
+	if key == "" {
+		return c.RealIP()
+	}
+	return key
+}
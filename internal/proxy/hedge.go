@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+)
+
+// Defaults for settings a route's hedge leaves unset.
+const (
+	defaultHedgePercentile = 95
+	defaultHedgeMinDelay   = 10 * time.Millisecond
+	defaultHedgeMaxDelay   = time.Second
+	// hedgeSamples recent latencies make up a route's percentile.
+	hedgeSamples = 256
+	// hedgeMinSamples must be measured before the percentile is used.
+	hedgeMinSamples = 20
+	// hedgeRecompute new latencies refresh the percentile.
+	hedgeRecompute = 16
+)
+
+func hedgeDefaults(hc config.HedgeConfig) config.HedgeConfig {
+	if hc.Percentile <= 0 {
+		hc.Percentile = defaultHedgePercentile
+	}
+	if hc.MinDelay <= 0 {
+		hc.MinDelay = defaultHedgeMinDelay
+	}
+	if hc.MaxDelay <= 0 {
+		hc.MaxDelay = max(defaultHedgeMaxDelay, hc.MinDelay)
+	}
+	return hc
+}
+
+// latencyWindow holds the recent latencies of a route's first upstream
+// requests and the percentile hedging waits for.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration // ring of up to hedgeSamples
+	next    int
+	// fresh counts the samples since delay was computed
+	fresh int
+	delay time.Duration
+	ready bool
+}
+
+// observe adds a latency, recomputing the percentile now and then.
+func (w *latencyWindow) observe(d time.Duration, percentile float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < hedgeSamples {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % hedgeSamples
+	}
+	w.fresh++
+	if len(w.samples) < hedgeMinSamples || (w.ready && w.fresh < hedgeRecompute) {
+		return
+	}
+	sorted := slices.Clone(w.samples)
+	slices.Sort(sorted)
+	w.delay = sorted[min(int(float64(len(sorted))*percentile/100), len(sorted)-1)]
+	w.fresh, w.ready = 0, true
+}
+
+// wait returns how long a request waits before it is hedged.
+func (w *latencyWindow) wait(hc config.HedgeConfig) time.Duration {
+	w.mu.Lock()
+	delay, ready := w.delay, w.ready
+	w.mu.Unlock()
+	if !ready {
+		return hc.MaxDelay
+	}
+	return min(max(delay, hc.MinDelay), hc.MaxDelay)
+}
+
+// latencyWindow returns the latencies of a route's requests to a service.
+func (h *ProxyHandler) latencyWindow(route, service string) *latencyWindow {
+	key := route + " " + service
+	h.mu.RLock()
+	w, ok := h.latencies[key]
+	h.mu.RUnlock()
+	if ok {
+		return w
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if w, ok = h.latencies[key]; !ok {
+		w = &latencyWindow{}
+		h.latencies[key] = w
+	}
+	return w
+}
+
+// hedge is how a request is hedged: after how long, and to which instance.
+type hedge struct {
+	cfg    config.HedgeConfig
+	delay  time.Duration
+	target *url.URL
+	// bal tracks the hedged instance's load, for balanced services
+	bal      *balancer
+	instance string
+	window   *latencyWindow
+	budget   *retryBudget
+}
+
+// newHedge returns how a request to primary is hedged, or nil when its route
+// does not hedge, it is not a GET or HEAD without a body, it opens a
+// WebSocket, or the service has no other instance in rotation.
+func (h *ProxyHandler) newHedge(c echo.Context, serviceName string, svc config.Service, bal *balancer, primary string) *hedge {
+	route := h.cfg.Route(c.Path())
+	req := c.Request()
+	if route == nil || route.Hedge == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.ContentLength != 0 ||
+		middleware.IsWebSocketUpgrade(req) {
+		return nil
+	}
+	instance := svc.URL
+	if bal != nil {
+		instance = bal.alternate(primary, func(instance string) int {
+			return h.weights.Of(serviceName, svc, instance)
+		}, func(instance string) bool {
+			return h.healthy(serviceName, instance)
+		})
+		if instance == "" {
+			return nil
+		}
+	}
+	target, err := url.Parse(instance)
+	if err != nil {
+		return nil
+	}
+	cfg := hedgeDefaults(*route.Hedge)
+	window := h.latencyWindow(c.Path(), serviceName)
+	return &hedge{
+		cfg:      cfg,
+		delay:    window.wait(cfg),
+		target:   target,
+		bal:      bal,
+		instance: instance,
+		window:   window,
+		budget:   h.retries,
+	}
+}
+
+// alternate returns the least loaded instance in rotation other than
+// primary, or "" when there is none.
+func (b *balancer) alternate(primary string, weight func(instance string) int, healthy func(instance string) bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	weights := b.candidates(weight, func(instance string) bool {
+		return instance != primary && healthy(instance) && !b.ejected(instance, now)
+	})
+	if len(weights) == 0 {
+		return ""
+	}
+	return b.leastLoaded("least_conn", weights)
+}
+
+// hedgeResult is the outcome of one of a hedged request's round trips.
+type hedgeResult struct {
+	res    *http.Response
+	err    error
+	hedged bool
+	cancel context.CancelFunc
+}
+
+// roundTrip sends a request and, when no response arrived within the delay,
+// the same request to the hedge target, returning the first response. The
+// other request is cancelled; an error is only returned once both failed. A
+// request failing before the delay is not hedged, retries being the remedy
+// for failures.
+func (hg *hedge) roundTrip(a *upstreamAttempt, r *http.Request) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	start := time.Now()
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		res, err := a.transport.RoundTrip(r.WithContext(ctx))
+		results <- hedgeResult{res: res, err: err, cancel: cancel}
+	}()
+
+	pending, hedged := 1, false
+	cancels := []context.CancelFunc{cancel}
+	timer := time.NewTimer(hg.delay)
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hg.budget.spend(a.service, attemptHedge) {
+				middleware.Explain(a.c, "hedge", "deny", "retry budget of "+a.service+" is spent")
+				continue
+			}
+			middleware.Explain(a.c, "hedge", "allow", fmt.Sprintf("no response from %s after %s, hedged to %s", a.target.Host, hg.delay, hg.target.Host))
+			ctx, cancel := context.WithCancel(r.Context())
+			go hg.send(ctx, cancel, a, r, results)
+			cancels = append(cancels, cancel)
+			pending, hedged = pending+1, true
+
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				result.cancel()
+				err = result.err
+				continue
+			}
+			// When the hedge won, the first request took at least this long
+			hg.window.observe(time.Since(start), hg.cfg.Percentile)
+			winner := 0
+			if hedged {
+				label := "primary"
+				if result.hedged {
+					winner, label = 1, "hedge"
+				}
+				metrics.UpstreamHedges.WithLabelValues(a.service, label).Inc()
+			}
+			for i, cancel := range cancels {
+				if i != winner {
+					cancel()
+				}
+			}
+			go drain(results, pending)
+			result.res.Body = cancelBody{ReadCloser: result.res.Body, cancel: result.cancel}
+			return result.res, nil
+		}
+	}
+	if hedged {
+		metrics.UpstreamHedges.WithLabelValues(a.service, "none").Inc()
+	}
+	return nil, err
+}
+
+// send sends the hedged copy of a request to the hedge target.
+func (hg *hedge) send(ctx context.Context, cancel context.CancelFunc, a *upstreamAttempt, r *http.Request, results chan<- hedgeResult) {
+	req := r.Clone(ctx)
+	u := *a.c.Request().URL
+	upstreamURL(&u, hg.target)
+	req.URL, req.Host = &u, hg.target.Host
+
+	done := func(bool) {}
+	if hg.bal != nil {
+		done = hg.bal.begin(hg.instance)
+	}
+	res, err := a.transport.RoundTrip(req)
+	done((err != nil && !errors.Is(err, context.Canceled)) || (res != nil && res.StatusCode >= http.StatusInternalServerError))
+	results <- hedgeResult{res: res, err: err, hedged: true, cancel: cancel}
+}
+
+// drain closes the responses of the requests that lost.
+func drain(results <-chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.res != nil {
+			result.res.Body.Close()
+		}
+		result.cancel()
+	}
+}
+
+// cancelBody ends the winning request's context once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
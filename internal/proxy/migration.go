@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// migrationsKey holds runtime overrides without an expiry, so a rollback
+	// stays in place until an operator changes it.
+	migrationsKey = "migrations"
+)
+
+// ErrUnknownMigration is returned for a rule name not in the configuration.
+var ErrUnknownMigration = errors.New("unknown migration rule")
+
+// MigrationOverride is a traffic percentage set at runtime.
+type MigrationOverride struct {
+	Percent int       `json:"percent"`
+	SetBy   string    `json:"set_by,omitempty"`
+	SetAt   time.Time `json:"set_at"`
+}
+
+// MigrationStatus is a rule with the percentage currently in effect.
+type MigrationStatus struct {
+	Name              string             `json:"name"`
+	Service           string             `json:"service"`
+	PathPrefix        string             `json:"path_prefix"`
+	Methods           []string           `json:"methods,omitempty"`
+	Target            string             `json:"target"`
+	Percent           int                `json:"percent"`
+	ConfiguredPercent int                `json:"configured_percent"`
+	Override          *MigrationOverride `json:"override,omitempty"`
+}
+
+// Migrations applies strangler-pattern rules, sending a sticky share of
+// clients on matching paths to a new backend. Runtime overrides live in Redis
+// so every replica follows them; each replica refreshes its copy on an
+// interval.
+type Migrations struct {
+	rules  []config.MigrationRule
+	redis  *infrastructure.RedisClient
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	overrides map[string]MigrationOverride
+}
+
+func NewMigrations(cfg *config.Config, redis *infrastructure.RedisClient, logger *zap.Logger) (*Migrations, error) {
+	seen := make(map[string]bool)
+	for _, r := range cfg.Migrations {
+		switch {
+		case r.Name == "" || seen[r.Name]:
+			return nil, fmt.Errorf("migrations: rule names must be unique and non-empty, got %q", r.Name)
+		case r.Service == "" || r.PathPrefix == "":
+			return nil, fmt.Errorf("migration %s: service and path_prefix are required", r.Name)
+		case r.Percent < 0 || r.Percent > 100:
+			return nil, fmt.Errorf("migration %s: percent must be between 0 and 100", r.Name)
+		}
+		if _, ok := cfg.Services[r.Target]; !ok {
+			return nil, fmt.Errorf("migration %s: target service %q is not configured", r.Name, r.Target)
+		}
+		seen[r.Name] = true
+	}
+
+	return &Migrations{
+		rules:     cfg.Migrations,
+		redis:     redis,
+		logger:    logger,
+		overrides: make(map[string]MigrationOverride),
+	}, nil
+}
+
+// Start polls Redis for override changes until ctx is cancelled.
+func (m *Migrations) Start(ctx context.Context, interval time.Duration) {
+	if m.redis == nil || len(m.rules) == 0 {
+		return
+	}
+	m.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Migrations) refresh(ctx context.Context) {
+	raw, err := m.redis.GetHash(ctx, migrationsKey)
+	if err != nil {
+		m.logger.Warn("Failed to refresh migration overrides", zap.Error(err))
+		return
+	}
+
+	overrides := make(map[string]MigrationOverride, len(raw))
+	for name, v := range raw {
+		var o MigrationOverride
+		if err := json.Unmarshal([]byte(v), &o); err == nil {
+			overrides[name] = o
+		}
+	}
+
+	m.mu.Lock()
+	m.overrides = overrides
+	m.mu.Unlock()
+}
+
+// Statuses returns every rule with its effective percentage.
+func (m *Migrations) Statuses() []MigrationStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]MigrationStatus, 0, len(m.rules))
+	for _, r := range m.rules {
+		st := MigrationStatus{
+			Name:              r.Name,
+			Service:           r.Service,
+			PathPrefix:        r.PathPrefix,
+			Methods:           r.Methods,
+			Target:            r.Target,
+			Percent:           r.Percent,
+			ConfiguredPercent: r.Percent,
+		}
+		if o, ok := m.overrides[r.Name]; ok {
+			st.Percent = o.Percent
+			st.Override = &o
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// SetPercent overrides a rule's traffic share on every replica.
+func (m *Migrations) SetPercent(ctx context.Context, name string, percent int, by string) error {
+	if !m.exists(name) {
+		return ErrUnknownMigration
+	}
+	if percent < 0 || percent > 100 {
+		return errors.New("percent must be between 0 and 100")
+	}
+
+	o := MigrationOverride{Percent: percent, SetBy: by, SetAt: time.Now().UTC()}
+	if m.redis != nil {
+		data, _ := json.Marshal(o)
+		if err := m.redis.SetPersistentHashField(ctx, migrationsKey, name, string(data)); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.overrides[name] = o
+	m.mu.Unlock()
+
+	m.logger.Warn("Migration traffic changed", zap.String("rule", name), zap.Int("percent", percent), zap.String("set_by", by))
+	return nil
+}
+
+// Reset drops a runtime override, returning the rule to its configured share.
+func (m *Migrations) Reset(ctx context.Context, name, by string) error {
+	if !m.exists(name) {
+		return ErrUnknownMigration
+	}
+	if m.redis != nil {
+		if err := m.redis.DeleteHashField(ctx, migrationsKey, name); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	delete(m.overrides, name)
+	m.mu.Unlock()
+
+	m.logger.Warn("Migration override cleared", zap.String("rule", name), zap.String("by", by))
+	return nil
+}
+
+func (m *Migrations) exists(name string) bool {
+	return slices.ContainsFunc(m.rules, func(r config.MigrationRule) bool { return r.Name == name })
+}
+
+// Target returns the service that should serve a request bound for service.
+// Clients are assigned by a hash of the rule and their user ID (or IP), so
+// each keeps the same backend while the percentage is unchanged.
+func (m *Migrations) Target(c echo.Context, service string) string {
+	req := c.Request()
+	for _, r := range m.rules {
+		if r.Service != service || !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+			continue
+		}
+		if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+			continue
+		}
+
+		percent := r.Percent
+		m.mu.RLock()
+		if o, ok := m.overrides[r.Name]; ok {
+			percent = o.Percent
+		}
+		m.mu.RUnlock()
+
+		identity, _ := c.Get("user_id").(string)
+		if identity == "" {
+			identity = c.RealIP()
+		}
+		h := fnv.New32a()
+		h.Write([]byte(r.Name + "\x00" + identity))
+		if int(h.Sum32()%100) < percent {
+			metrics.MigrationRequests.WithLabelValues(r.Name, "target").Inc()
+			middleware.Explain(c, "migration", "allow", r.Name+": "+r.Target)
+			return r.Target
+		}
+		metrics.MigrationRequests.WithLabelValues(r.Name, "legacy").Inc()
+		middleware.Explain(c, "migration", "allow", r.Name+": "+service)
+		return service
+	}
+	return service
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// Defaults for settings an enabled outlier_detection leaves unset.
+const (
+	defaultOutlierInterval     = 10 * time.Second
+	defaultOutlierRatio        = 0.5
+	defaultOutlierMinRequests  = 10
+	defaultOutlierEjectionTime = 30 * time.Second
+	defaultOutlierMaxEjected   = 50
+	// maxEjectionMultiplier caps how much longer repeated ejections get.
+	maxEjectionMultiplier = 10
+)
+
+// outlier is an instance's requests and failures in the current window of
+// outlier detection, and its ejection.
+type outlier struct {
+	windowStart time.Time
+	requests    int
+	failures    int
+	// ejections counts recent ejections, each lengthening the next; a clean
+	// window forgives one.
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func outlierDefaults(od config.OutlierDetectionConfig) config.OutlierDetectionConfig {
+	if od.Interval <= 0 {
+		od.Interval = defaultOutlierInterval
+	}
+	if od.FailureRatio <= 0 {
+		od.FailureRatio = defaultOutlierRatio
+	}
+	if od.MinRequests <= 0 {
+		od.MinRequests = defaultOutlierMinRequests
+	}
+	if od.EjectionTime <= 0 {
+		od.EjectionTime = defaultOutlierEjectionTime
+	}
+	if od.MaxEjectedPercent <= 0 {
+		od.MaxEjectedPercent = defaultOutlierMaxEjected
+	}
+	return od
+}
+
+// ejected reports whether an instance is ejected at now. Callers hold mu.
+func (b *balancer) ejected(instance string, now time.Time) bool {
+	o := b.outliers[instance]
+	return o != nil && now.Before(o.ejectedUntil)
+}
+
+// observe records the outcome of a request to an instance. When the
+// instance's window closes with too many failures it is ejected, unless
+// max_ejected_percent of the instances already are; observe returns how long
+// for, or zero.
+func (b *balancer) observe(instance string, failed bool, od config.OutlierDetectionConfig, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	o := b.outliers[instance]
+	if o == nil {
+		o = &outlier{windowStart: now}
+		b.outliers[instance] = o
+	}
+	o.requests++
+	if failed {
+		o.failures++
+	}
+	if now.Sub(o.windowStart) < od.Interval {
+		return 0
+	}
+
+	requests, failures := o.requests, o.failures
+	o.windowStart, o.requests, o.failures = now, 0, 0
+	if requests < od.MinRequests || float64(failures) < od.FailureRatio*float64(requests) {
+		if o.ejections > 0 && !now.Before(o.ejectedUntil) {
+			o.ejections--
+		}
+		return 0
+	}
+	if now.Before(o.ejectedUntil) {
+		return 0
+	}
+	ejected := 0
+	for other := range b.outliers {
+		if b.ejected(other, now) {
+			ejected++
+		}
+	}
+	if ejected > 0 && (ejected+1)*100 > od.MaxEjectedPercent*len(b.instances) {
+		return 0
+	}
+
+	o.ejections = min(o.ejections+1, maxEjectionMultiplier)
+	d := od.EjectionTime * time.Duration(o.ejections)
+	o.ejectedUntil = now.Add(d)
+	return d
+}
@@ -1,38 +1,113 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/banking/api-gateway/internal/tracing"
 	"github.com/labstack/echo/v4"
 	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// Circuit breaker settings, shared by every service with circuit_breaker.
+const (
+	// breakerOpenTimeout is how long a tripped breaker stays open before
+	// letting probes through.
+	breakerOpenTimeout = 30 * time.Second
+	// breakerTripFailures consecutive failures open the breaker.
+	breakerTripFailures = 5
+	// breakerInterval resets the failure counts of a closed breaker.
+	breakerInterval = 10 * time.Second
+	// breakerHalfOpenRequests probes must succeed to close it again.
+	breakerHalfOpenRequests = 5
+)
+
 type ProxyHandler struct {
 	cfg      *config.Config
 	logger   *zap.Logger
 	breakers map[string]*gobreaker.CircuitBreaker
 	mu       sync.RWMutex
+	// routes resolves services registered at runtime by discovery sources
+	routes *routing.Table
+	// backoff feeds Retry-After hints for upstreams that omit one
+	backoff *backoff
+	// migrations moves matching traffic to new backends
+	migrations *Migrations
+	// weights splits balanced services' traffic over their instances
+	weights *Weights
+	// pools holds one shared, tuned transport per service
+	pools map[string]*pool
+	// resolvers find the instances of services with runtime discovery, by
+	// discovery kind
+	resolvers map[string]Resolver
+	// balancers spread services with several instances round-robin
+	balancers map[string]*balancer
+	// retries bounds the retries and hedged requests to each service
+	retries *retryBudget
+	// health takes instances failing active health checks out of rotation
+	health HealthChecker
+	// reverse forwards every upstream request but those of streaming routes
+	reverse *httputil.ReverseProxy
+	// streams forward streaming routes' requests, by flush interval
+	streams map[time.Duration]*httputil.ReverseProxy
+	// latencies of hedged routes, by route and service
+	latencies map[string]*latencyWindow
+}
+
+// HealthChecker reports the health of instances probed by active checks.
+type HealthChecker interface {
+	Healthy(service, instance string) bool
+}
+
+// Resolver returns the current instances of services with runtime discovery.
+type Resolver interface {
+	// Endpoints returns the base URLs of a service's ready instances.
+	Endpoints(service string) []string
 }
 
 func NewProxyHandler(cfg *config.Config, logger *zap.Logger) *ProxyHandler {
 	handler := &ProxyHandler{
-		cfg:      cfg,
-		logger:   logger,
-		breakers: make(map[string]*gobreaker.CircuitBreaker),
+		cfg:       cfg,
+		logger:    logger,
+		breakers:  make(map[string]*gobreaker.CircuitBreaker),
+		backoff:   newBackoff(),
+		pools:     make(map[string]*pool),
+		resolvers: make(map[string]Resolver),
+		balancers: make(map[string]*balancer),
+		retries:   newRetryBudget(cfg.RetryBudget),
+		latencies: make(map[string]*latencyWindow),
+	}
+	handler.reverse = handler.newReverseProxy(0)
+	handler.streams = make(map[time.Duration]*httputil.ReverseProxy)
+	for _, route := range cfg.Routes {
+		if route.Streaming != nil {
+			interval := flushInterval(route.Streaming)
+			if handler.streams[interval] == nil {
+				handler.streams[interval] = handler.newReverseProxy(interval)
+			}
+		}
 	}
 
-	// Initialize circuit breakers for each service
+	// Initialize circuit breakers for each service; balanced services get
+	// one per instance once their instances are known
 	for name, svc := range cfg.Services {
-		if svc.CircuitBreaker {
+		if svc.CircuitBreaker && !balanced(svc) {
 			handler.breakers[name] = handler.createCircuitBreaker(name)
 		}
 	}
@@ -43,14 +118,18 @@ func NewProxyHandler(cfg *config.Config, logger *zap.Logger) *ProxyHandler {
 func (h *ProxyHandler) createCircuitBreaker(serviceName string) *gobreaker.CircuitBreaker {
 	settings := gobreaker.Settings{
 		Name:        serviceName,
-		MaxRequests: 5,                // Requests allowed in half-open state
-		Interval:    10 * time.Second, // Reset failure count interval
-		Timeout:     30 * time.Second, // Time in open state before half-open
+		MaxRequests: breakerHalfOpenRequests, // Requests allowed in half-open state
+		Interval:    breakerInterval,         // Reset failure count interval
+		Timeout:     breakerOpenTimeout,      // Time in open state before half-open
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			// Open circuit after 5 consecutive failures
-			return counts.ConsecutiveFailures >= 5
+			// Open circuit after consecutive failures
+			return counts.ConsecutiveFailures >= breakerTripFailures
 		},
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			metrics.CircuitBreakerState.WithLabelValues(name).Set(breakerStateValue(to))
+			if to == gobreaker.StateOpen {
+				h.backoff.breakerOpened(name, time.Now())
+			}
 			h.logger.Warn("Circuit breaker state changed",
 				zap.String("service", name),
 				zap.String("from", from.String()),
@@ -58,105 +137,356 @@ func (h *ProxyHandler) createCircuitBreaker(serviceName string) *gobreaker.Circu
 			)
 		},
 	}
+	metrics.CircuitBreakerState.WithLabelValues(serviceName).Set(breakerStateValue(gobreaker.StateClosed))
 	return gobreaker.NewCircuitBreaker(settings)
 }
 
-func (h *ProxyHandler) Handle(serviceName string) echo.HandlerFunc {
+// breakerStateValue encodes a breaker state for the state gauge.
+func breakerStateValue(state gobreaker.State) float64 {
+	switch state {
+	case gobreaker.StateHalfOpen:
+		return 1
+	case gobreaker.StateOpen:
+		return 2
+	}
+	return 0
+}
+
+// UseRoutes lets the handler proxy to services registered in the dynamic
+// route table in addition to those in the static configuration.
+func (h *ProxyHandler) UseRoutes(table *routing.Table) {
+	h.routes = table
+}
+
+// UseHealthChecks leaves instances of balanced services that fail their
+// health checks out of rotation until they pass again.
+func (h *ProxyHandler) UseHealthChecks(hc HealthChecker) {
+	h.health = hc
+}
+
+// healthy reports whether an instance may take requests by its health
+// checks.
+func (h *ProxyHandler) healthy(service, instance string) bool {
+	return h.health == nil || h.health.Healthy(service, instance)
+}
+
+// UseWeights balances services' instances by their runtime weights.
+func (h *ProxyHandler) UseWeights(w *Weights) {
+	h.weights = w
+}
+
+// UseMigrations applies strangler migration rules before picking a service.
+func (h *ProxyHandler) UseMigrations(m *Migrations) {
+	h.migrations = m
+}
+
+// UseResolver sends requests for services with the given discovery kind to
+// the instances r finds.
+func (h *ProxyHandler) UseResolver(discovery string, r Resolver) {
+	h.resolvers[discovery] = r
+}
+
+// Instances returns the current instances of a balanced service: its URL
+// list, or those its discovery finds.
+func (h *ProxyHandler) Instances(name string, svc config.Service) []string {
+	if len(svc.URLs) > 0 {
+		return svc.URLs
+	}
+	if r, ok := h.resolvers[svc.Discovery]; ok {
+		return r.Endpoints(name)
+	}
+	return nil
+}
+
+// service resolves a service definition, static configuration first.
+func (h *ProxyHandler) service(name string) (config.Service, bool) {
+	if svc, ok := h.cfg.Services[name]; ok {
+		return svc, true
+	}
+	if h.routes != nil {
+		return h.routes.Service(name)
+	}
+	return config.Service{}, false
+}
+
+// breaker returns the circuit breaker for a service, creating it on first use
+// for dynamically registered services.
+func (h *ProxyHandler) breaker(name string, svc config.Service) (*gobreaker.CircuitBreaker, bool) {
+	h.mu.RLock()
+	cb, ok := h.breakers[name]
+	h.mu.RUnlock()
+	if ok || !svc.CircuitBreaker || balanced(svc) {
+		return cb, ok
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cb, ok = h.breakers[name]; !ok {
+		cb = h.createCircuitBreaker(name)
+		h.breakers[name] = cb
+	}
+	return cb, true
+}
+
+// BreakerStates returns the current circuit breaker state per service.
+// Balanced services have theirs per instance, in InstanceBreakerStates.
+func (h *ProxyHandler) BreakerStates() map[string]gobreaker.State {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	states := make(map[string]gobreaker.State, len(h.breakers))
+	for name, cb := range h.breakers {
+		states[name] = cb.State()
+	}
+	return states
+}
+
+// InstanceBreakerStates returns the circuit breaker state of every instance
+// of balanced services, by service and instance.
+func (h *ProxyHandler) InstanceBreakerStates() map[string]map[string]gobreaker.State {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	states := make(map[string]map[string]gobreaker.State, len(h.balancers))
+	for name, b := range h.balancers {
+		if instances := b.states(); len(instances) > 0 {
+			states[name] = instances
+		}
+	}
+	return states
+}
+
+func (h *ProxyHandler) Handle(routeService string) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		svcConfig, ok := h.cfg.Services[serviceName]
+		serviceName := routeService
+		if h.migrations != nil {
+			serviceName = h.migrations.Target(c, routeService)
+		}
+
+		svcConfig, ok := h.service(serviceName)
 		if !ok {
 			h.logger.Error("Service configuration not found", zap.String("service", serviceName))
 			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Service not configured"})
 		}
 
-		targetURL, err := url.Parse(svcConfig.URL)
-		if err != nil {
-			h.logger.Error("Invalid service URL", zap.String("service", serviceName), zap.String("url", svcConfig.URL), zap.Error(err))
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Configuration error"})
+		if middleware.IsWebSocketUpgrade(c.Request()) && !h.websocketAllowed(c) {
+			middleware.Explain(c, "websocket", "deny", "route "+c.Path()+" does not tunnel WebSocket connections")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "WebSocket is not supported on this route"})
 		}
 
-		// Get circuit breaker if enabled for this service
-		h.mu.RLock()
-		cb, hasBreaker := h.breakers[serviceName]
-		h.mu.RUnlock()
+		h.retries.request(serviceName)
+		retry := newRetryState(serviceName, svcConfig, c.Request())
+		for {
+			err := h.forward(c, serviceName, svcConfig, retry)
+			if !errors.Is(err, errRetry) {
+				return err
+			}
+			middleware.Explain(c, "retry", "allow", fmt.Sprintf("attempt %d of %d to %s after %s", retry.retried+1, retry.policy.MaxAttempts, serviceName, retry.delay))
+			if !retry.wait(c.Request()) {
+				return c.JSON(http.StatusGatewayTimeout, map[string]string{"error": "Request deadline exceeded"})
+			}
+		}
+	}
+}
 
-		if hasBreaker {
-			// Execute request through circuit breaker
-			_, err := cb.Execute(func() (interface{}, error) {
-				return nil, h.doProxy(c, targetURL, serviceName)
+// forward sends a request to one instance of a service, through its circuit
+// breaker. It returns errRetry, with nothing written, when retry decided to
+// try again.
+func (h *ProxyHandler) forward(c echo.Context, serviceName string, svcConfig config.Service, retry *retryState) error {
+	// Get circuit breaker if enabled for this service
+	target := svcConfig.URL
+	cb, hasBreaker := h.breaker(serviceName, svcConfig)
+	var bal *balancer
+	if balanced(svcConfig) {
+		instances := h.Instances(serviceName, svcConfig)
+		if len(instances) == 0 {
+			h.logger.Warn("No ready instances", zap.String("service", serviceName), zap.String("request_id", middleware.RequestIDFrom(c)))
+			middleware.Explain(c, "upstream", "deny", serviceName+" has no ready instances")
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error":   "Service temporarily unavailable",
+				"service": serviceName,
 			})
-
-			if err != nil {
-				if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
-					h.logger.Warn("Circuit breaker open",
+		}
+		bal = h.balancer(serviceName, svcConfig, instances)
+		var key string
+		if svcConfig.Balancing == "consistent_hash" {
+			key = hashKey(c, svcConfig.HashOn)
+		}
+		instance, instanceBreaker, failOpen, ok := bal.pick(svcConfig, key, func(instance string) int {
+			return h.weights.Of(serviceName, svcConfig, instance)
+		}, func(instance string) bool {
+			return h.healthy(serviceName, instance)
+		})
+		if !ok {
+			// Every instance's breaker is open: retry once the first lets
+			// probes through
+			wait := h.cfg.RetryAfter.Max
+			for _, instance := range instances {
+				wait = min(wait, h.backoff.breakerHint(breakerName(serviceName, instance), h.cfg.RetryAfter.Base))
+			}
+			middleware.Explain(c, "circuit_breaker", "deny", "every instance of "+serviceName+" is open")
+			h.logger.Warn("Circuit breaker open on every instance",
+				zap.String("service", serviceName),
+				zap.Int("instances", len(instances)),
+				zap.String("request_id", middleware.RequestIDFrom(c)),
+			)
+			return h.unavailable(c, serviceName, wait)
+		}
+		if failOpen {
+			middleware.Explain(c, "health_check", "allow", "every instance of "+serviceName+" is unhealthy or ejected, all are used")
+		}
+		target, cb, hasBreaker = instance, instanceBreaker, instanceBreaker != nil
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		h.logger.Error("Invalid service URL", zap.String("service", serviceName), zap.String("url", target), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Configuration error"})
+	}
+	upstream := h.pool(serviceName, svcConfig)
+	hedge := h.newHedge(c, serviceName, svcConfig, bal, target)
+	send := func() error {
+		return h.doProxy(c, targetURL, serviceName, upstream, retry, hedge)
+	}
+	if bal != nil {
+		// Track the instance's load for the least_* strategies and its
+		// failures for outlier detection
+		send = func() error {
+			done := bal.begin(target)
+			err := h.doProxy(c, targetURL, serviceName, upstream, retry, hedge)
+			failed := err != nil || c.Response().Status >= http.StatusInternalServerError
+			done(failed)
+			if od := svcConfig.OutlierDetection; od.Enabled {
+				if d := bal.observe(target, failed, outlierDefaults(od), time.Now()); d > 0 {
+					metrics.UpstreamEjections.WithLabelValues(serviceName).Inc()
+					h.logger.Warn("Upstream instance ejected as an outlier",
 						zap.String("service", serviceName),
-						zap.String("state", cb.State().String()),
+						zap.String("instance", target),
+						zap.Duration("for", d),
 					)
-					return c.JSON(http.StatusServiceUnavailable, map[string]string{
-						"error":   "Service temporarily unavailable",
-						"service": serviceName,
-					})
 				}
-				// Proxy error already handled in doProxy
-				return nil
 			}
-			return nil
+			return err
 		}
-
-		// No circuit breaker, direct proxy
-		return h.doProxy(c, targetURL, serviceName)
 	}
-}
 
-func (h *ProxyHandler) doProxy(c echo.Context, targetURL *url.URL, serviceName string) error {
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	middleware.Explain(c, "upstream", "allow", serviceName)
+	if hasBreaker {
+		// Execute request through circuit breaker
+		_, err := cb.Execute(func() (interface{}, error) {
+			return nil, send()
+		})
 
-	// Optimize Transport
-	proxy.Transport = &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
+		if err != nil {
+			if errors.Is(err, errRetry) {
+				return err
+			}
+			if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+				middleware.Explain(c, "circuit_breaker", "deny", cb.Name()+" is "+cb.State().String())
+				h.logger.Warn("Circuit breaker open",
+					zap.String("service", serviceName),
+					zap.String("breaker", cb.Name()),
+					zap.String("state", cb.State().String()),
+					zap.String("request_id", middleware.RequestIDFrom(c)),
+				)
+				wait := h.cfg.RetryAfter.Base
+				if errors.Is(err, gobreaker.ErrOpenState) {
+					wait = h.backoff.breakerHint(cb.Name(), wait)
+				}
+				return h.unavailable(c, serviceName, wait)
+			}
+			// Proxy error already handled in doProxy
+			return nil
+		}
+		return nil
 	}
 
-	var proxyErr error
-
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
+	// No circuit breaker, direct proxy
+	return send()
+}
 
-		// Path Rewriting: Strip /api prefix
-		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/api")
-		if req.URL.Path == "" || !strings.HasPrefix(req.URL.Path, "/") {
-			req.URL.Path = "/" + req.URL.Path
-		}
+// unavailable answers 503 for a service whose breakers turn requests away,
+// with a Retry-After of wait (capped).
+func (h *ProxyHandler) unavailable(c echo.Context, serviceName string, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", formatRetryAfter(min(wait, h.cfg.RetryAfter.Max)))
+	return c.JSON(http.StatusServiceUnavailable, map[string]string{
+		"error":   "Service temporarily unavailable",
+		"service": serviceName,
+	})
+}
 
-		req.Host = targetURL.Host
+func (h *ProxyHandler) doProxy(c echo.Context, targetURL *url.URL, serviceName string, upstream *pool, retry *retryState, hedge *hedge) error {
+	a := &upstreamAttempt{
+		c:         c,
+		target:    targetURL,
+		service:   serviceName,
+		transport: upstream.transport,
+		retry:     retry,
+		hedge:     hedge,
+		grpc:      upstream.protocol == "grpc" && middleware.IsGRPC(c.Request()),
+		start:     time.Now(),
+		code:      "error",
+	}
 
-		// Propagate Tracing Headers
-		if traceID := c.Request().Header.Get("X-Request-ID"); traceID != "" {
-			req.Header.Set("X-Request-ID", traceID)
-		}
-		// Forward user ID for backend authorization if present
-		if userID, ok := c.Get("user_id").(string); ok && userID != "" {
-			req.Header.Set("X-User-ID", userID)
+	ctx, span := tracing.Tracer().Start(c.Request().Context(), "proxy "+serviceName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gateway.service", serviceName),
+			semconv.ServerAddress(targetURL.Hostname()),
+		),
+	)
+	ctx, release := upstream.track(ctx)
+	var w http.ResponseWriter = c.Response()
+	reverse := h.reverse
+	stream := h.streaming(c)
+	switch {
+	case middleware.IsWebSocketUpgrade(c.Request()):
+		// A tunnel lasts as long as its connection; the timeout still bounds
+		// the handshake, through the transport's response header timeout
+		w = tunnelWriter{c.Response()}
+	case stream != nil:
+		// Likewise for streams, which may also outlast the write timeout
+		reverse = h.streams[flushInterval(stream)]
+		h.extendWriteDeadline(c, stream)
+	case a.grpc:
+		// A call's deadline bounds it instead, streaming calls included: the
+		// client's grpc-timeout within the service's timeout
+		if timeout, ok := rpcTimeout(c.Request(), upstream.timeout); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 		}
+		deadline, _ := ctx.Deadline()
+		h.extendCallDeadline(c, deadline)
+	case upstream.timeout > 0:
+		// The service's timeout bounds each attempt, body included
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, upstream.timeout)
+		defer cancel()
 	}
-
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		h.logger.Error("Proxy forwarding error", zap.String("service", serviceName), zap.Error(err))
-		proxyErr = err
-
-		// Return JSON error response check
-		if !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadGateway)
-			fmt.Fprintf(w, `{"error":"Service Unavailable"}`)
+	reverse.ServeHTTP(w, c.Request().WithContext(context.WithValue(ctx, attemptKey{}, a)))
+	release()
+	if a.res != nil {
+		recordGRPCStatus(serviceName, a.res)
+	}
+	if code, err := strconv.Atoi(a.code); err == nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, "HTTP "+a.code)
+		}
+	} else {
+		// No response: transport failure or the client's deadline ran out
+		if a.err != nil {
+			span.RecordError(a.err)
 		}
+		span.SetStatus(codes.Error, "no upstream response")
 	}
-
-	proxy.ServeHTTP(c.Response(), c.Request())
-	return proxyErr
+	span.End()
+	elapsed := time.Since(a.start)
+	middleware.Timing(c, "upstream", elapsed)
+	var traceID string
+	if h.cfg.Tracing.Enabled {
+		traceID = middleware.SampledTraceID(c)
+	}
+	metrics.ObserveWithTrace(metrics.UpstreamDuration.WithLabelValues(serviceName, a.code), elapsed.Seconds(), traceID)
+	return a.err
 }
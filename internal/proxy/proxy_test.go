@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// newBenchmarkGateway routes /api/accounts to an upstream that counts the
+// connections opened to it.
+func newBenchmarkGateway(b *testing.B) (*echo.Echo, *atomic.Int64) {
+	b.Helper()
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"accounts":[]}`))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	b.Cleanup(upstream.Close)
+
+	cfg := &config.Config{Services: map[string]config.Service{
+		"account-service": {Name: "account-service", URL: upstream.URL},
+	}}
+	e := echo.New()
+	e.GET("/api/accounts", NewProxyHandler(cfg, zap.NewNop()).Handle("account-service"))
+	return e, &conns
+}
+
+func reportConnections(b *testing.B, conns *atomic.Int64, maxConns int64) {
+	b.Helper()
+	opened := conns.Load()
+	b.ReportMetric(float64(opened), "conns")
+	b.ReportMetric(float64(opened)/float64(b.N), "conns/op")
+	if b.N > 100 && opened > maxConns {
+		b.Fatalf("%d upstream connections for %d requests: connections are not reused", opened, b.N)
+	}
+}
+
+// BenchmarkProxyReusesConnections sends requests one after another, which
+// should all travel over a single upstream connection.
+func BenchmarkProxyReusesConnections(b *testing.B) {
+	e, conns := newBenchmarkGateway(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/accounts", nil))
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	b.StopTimer()
+	reportConnections(b, conns, 1)
+}
+
+// BenchmarkProxyReusesConnectionsParallel sends concurrent requests; the
+// pool should open no more connections than requests ever in flight.
+func BenchmarkProxyReusesConnectionsParallel(b *testing.B) {
+	e, conns := newBenchmarkGateway(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/accounts", nil))
+			if rec.Code != http.StatusOK {
+				b.Errorf("status %d: %s", rec.Code, rec.Body)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	reportConnections(b, conns, int64(defaultMaxIdleConnsPerHost))
+}
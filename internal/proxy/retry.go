@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// Defaults for settings a service's retry policy leaves unset.
+const (
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+)
+
+var defaultRetryOn = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// errRetry marks an attempt whose failure was withheld from the client
+// because another attempt follows.
+var errRetry = errors.New("upstream attempt failed, retrying")
+
+// retryState is a request's retry policy and the retries it made.
+type retryState struct {
+	policy  config.RetryPolicy
+	service string
+	retried int
+	// delay is the backoff before the retry decided on last
+	delay time.Duration
+}
+
+// newRetryState returns the retry state of a request to a service, or nil
+// when the request may not be retried: the service has no retry policy, the
+// request is neither GET, HEAD nor carries an Idempotency-Key, or its body
+// cannot be sent again.
+func newRetryState(service string, svc config.Service, req *http.Request) *retryState {
+	p := svc.Retry
+	if p.MaxAttempts <= 1 {
+		return nil
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && req.Header.Get("Idempotency-Key") == "" {
+		return nil
+	}
+	if req.ContentLength != 0 && req.GetBody == nil {
+		return nil
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = max(defaultRetryMaxBackoff, p.Backoff)
+	}
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = defaultRetryOn
+	}
+	return &retryState{policy: p, service: service}
+}
+
+// again reports whether an attempt that failed with status, or 0 for a
+// connection error, is retried rather than answered. A retry must fit before
+// the request's deadline and is spent from the service's retry budget.
+func (r *retryState) again(ctx context.Context, status int, budget *retryBudget) bool {
+	if r == nil || r.retried+1 >= r.policy.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	if status != 0 && !slices.Contains(r.policy.RetryOn, status) {
+		return false
+	}
+	delay := r.backoff()
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return false
+	}
+	if !budget.spend(r.service, attemptRetry) {
+		return false
+	}
+	r.retried++
+	r.delay = delay
+	return true
+}
+
+// backoff is the delay before the next retry: the policy's backoff doubled
+// for each retry already made, capped, with the upper half jittered so
+// replicas retrying together spread out.
+func (r *retryState) backoff() time.Duration {
+	d := r.policy.Backoff
+	for i := 0; i < r.retried && d < r.policy.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, r.policy.MaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// wait sleeps through the backoff decided on and readies the request body to
+// be sent again, reporting false when the request ends first.
+func (r *retryState) wait(req *http.Request) bool {
+	t := time.NewTimer(r.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-req.Context().Done():
+		return false
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		req.Body = body
+	}
+	return true
+}
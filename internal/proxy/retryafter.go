@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backoff tracks per-service overload streaks and circuit breaker open times
+// to suggest a Retry-After when the upstream gives none.
+type backoff struct {
+	mu     sync.Mutex
+	streak map[string]int
+	opened map[string]time.Time
+}
+
+func newBackoff() *backoff {
+	return &backoff{
+		streak: make(map[string]int),
+		opened: make(map[string]time.Time),
+	}
+}
+
+// record counts consecutive overload responses (429, 503 or transport
+// errors) from a service; any other response resets the streak.
+func (b *backoff) record(service string, overloaded bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if overloaded {
+		b.streak[service]++
+	} else {
+		delete(b.streak, service)
+	}
+}
+
+func (b *backoff) breakerOpened(service string, at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.opened[service] = at
+}
+
+// forget drops the open time of a breaker that no longer exists, such as
+// that of an instance gone from a balanced service.
+func (b *backoff) forget(breaker string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.opened, breaker)
+}
+
+// hint doubles base for each consecutive overload after the first.
+func (b *backoff) hint(service string, base, limit time.Duration) time.Duration {
+	b.mu.Lock()
+	n := b.streak[service]
+	b.mu.Unlock()
+
+	d := base
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// breakerHint is the time left until an open breaker lets a probe through.
+func (b *backoff) breakerHint(service string, base time.Duration) time.Duration {
+	b.mu.Lock()
+	opened, ok := b.opened[service]
+	b.mu.Unlock()
+	if !ok {
+		return base
+	}
+	if left := breakerOpenTimeout - time.Since(opened); left > base {
+		return left
+	}
+	return base
+}
+
+// retryAfter normalizes the Retry-After of an overload response, or adds one
+// computed from the service's recent failures.
+func (h *ProxyHandler) retryAfter(header http.Header, status int, service string) {
+	overloaded := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+	h.backoff.record(service, overloaded)
+	if !overloaded {
+		return
+	}
+
+	limits := h.cfg.RetryAfter
+	d, ok := parseRetryAfter(header.Get("Retry-After"), time.Now())
+	if !ok {
+		d = h.backoff.hint(service, limits.Base, limits.Max)
+	}
+	header.Set("Retry-After", formatRetryAfter(min(d, limits.Max)))
+}
+
+// parseRetryAfter reads delta-seconds or an HTTP-date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// formatRetryAfter renders whole seconds, rounding up and never below one.
+func formatRetryAfter(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	return strconv.FormatInt(max(secs, 1), 10)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+)
+
+// Kinds of extra upstream attempts drawing on the retry budget.
+const (
+	attemptRetry = "retry"
+	attemptHedge = "hedge"
+)
+
+// retryBudget allows each service retries and hedged requests up to a share
+// of the requests it got over a sliding window, counted per second. Budgets
+// are local to the replica, as the load each replica adds is what they
+// bound.
+type retryBudget struct {
+	cfg config.RetryBudgetConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	services map[string][]budgetBucket // by service, one bucket per second
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	extra    int
+}
+
+func newRetryBudget(cfg config.RetryBudgetConfig) *retryBudget {
+	return &retryBudget{cfg: cfg, now: time.Now, services: make(map[string][]budgetBucket)}
+}
+
+// bucket returns the service's bucket for the current second, clearing it
+// when it last served an earlier one. Callers hold mu.
+func (r *retryBudget) bucket(service string, second int64) *budgetBucket {
+	buckets, ok := r.services[service]
+	if !ok {
+		buckets = make([]budgetBucket, max(int(r.cfg.Window/time.Second), 1))
+		r.services[service] = buckets
+	}
+	b := &buckets[second%int64(len(buckets))]
+	if b.second != second {
+		*b = budgetBucket{second: second}
+	}
+	return b
+}
+
+// request counts a request sent to a service, growing its budget.
+func (r *retryBudget) request(service string) {
+	second := r.now().Unix()
+	r.mu.Lock()
+	r.bucket(service, second).requests++
+	r.mu.Unlock()
+}
+
+// spend takes one extra attempt of kind from the service's budget, reporting
+// whether it may be sent.
+func (r *retryBudget) spend(service, kind string) bool {
+	second := r.now().Unix()
+	r.mu.Lock()
+	current := r.bucket(service, second)
+	var requests, extra int
+	buckets := r.services[service]
+	for _, b := range buckets {
+		if second-b.second < int64(len(buckets)) {
+			requests += b.requests
+			extra += b.extra
+		}
+	}
+	allowed := max(float64(requests)*r.cfg.Percent/100, r.cfg.MinPerSecond*float64(len(buckets)))
+	ok := float64(extra+1) <= allowed
+	if ok {
+		current.extra++
+	}
+	r.mu.Unlock()
+
+	result := "sent"
+	if !ok {
+		result = "over_budget"
+	}
+	metrics.UpstreamExtraAttempts.WithLabelValues(service, kind, result).Inc()
+	return ok
+}
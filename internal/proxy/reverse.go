@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+)
+
+// upstreamAttempt is one request sent through the handler's reverse proxy,
+// found in the outgoing request's context. The proxy is shared by every
+// service and instance; what differs per request lives here.
+type upstreamAttempt struct {
+	c         echo.Context
+	target    *url.URL
+	service   string
+	transport http.RoundTripper
+	retry     *retryState
+	// hedge sends the request to a second instance when the first is slow
+	hedge *hedge
+	// grpc marks a gRPC call to a grpc service; res is its response
+	grpc bool
+	res  *http.Response
+	// start times the upstream for sampled requests (monotonic)
+	start time.Time
+	// code is the upstream's status, or "error" without a response
+	code string
+	err  error
+}
+
+type attemptKey struct{}
+
+func attemptFrom(r *http.Request) *upstreamAttempt {
+	return r.Context().Value(attemptKey{}).(*upstreamAttempt)
+}
+
+// attemptTransport sends each request over its service's pooled transport,
+// hedging it when its route does.
+type attemptTransport struct{}
+
+func (attemptTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	a := attemptFrom(r)
+	if a.hedge != nil {
+		return a.hedge.roundTrip(a, r)
+	}
+	return a.transport.RoundTrip(r)
+}
+
+// newReverseProxy returns a reverse proxy upstream requests go through,
+// built once rather than per request, flushing responses every
+// flushInterval (see httputil.ReverseProxy.FlushInterval).
+func (h *ProxyHandler) newReverseProxy(flushInterval time.Duration) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:       h.direct,
+		FlushInterval:  flushInterval,
+		Transport:      attemptTransport{},
+		ErrorHandler:   h.proxyError,
+		ModifyResponse: h.modifyResponse,
+	}
+}
+
+// direct points a request at its attempt's target and sets the headers the
+// gateway owns.
+func (h *ProxyHandler) direct(req *http.Request) {
+	a := attemptFrom(req)
+	c := a.c
+	upstreamURL(req.URL, a.target)
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Go's default User-Agent is not sent on the client's behalf
+		req.Header.Set("User-Agent", "")
+	}
+	req.Host = a.target.Host
+
+	// Propagate Tracing Headers
+	if traceID := c.Request().Header.Get("X-Request-ID"); traceID != "" {
+		req.Header.Set("X-Request-ID", traceID)
+	}
+	// Remaining end-to-end budget for requests with a client deadline
+	middleware.SetBudget(c, req)
+	// gRPC services learn the call's deadline from grpc-timeout
+	if deadline, ok := req.Context().Deadline(); ok && a.grpc {
+		req.Header.Set(grpcTimeoutHeader, formatGRPCTimeout(time.Until(deadline)))
+	}
+	// W3C trace context of the upstream span
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	// Identity headers are only ever set by the gateway
+	req.Header.Del("X-User-ID")
+	req.Header.Del("X-Actor-ID")
+	req.Header.Del("X-Actor-Chain")
+
+	// Forward user ID for backend authorization if present
+	if userID, ok := c.Get("user_id").(string); ok && userID != "" {
+		req.Header.Set("X-User-ID", userID)
+	}
+	// Forward the real principal when a user is being impersonated
+	if actorID, ok := c.Get("actor_id").(string); ok && actorID != "" {
+		req.Header.Set("X-Actor-ID", actorID)
+		if chain, ok := c.Get("actor_chain").([]string); ok {
+			req.Header.Set("X-Actor-Chain", strings.Join(chain, ","))
+		}
+	}
+}
+
+// upstreamURL sends a request's URL u to target, without the gateway's /api
+// prefix.
+func upstreamURL(u, target *url.URL) {
+	rewriteURL(u, target)
+
+	// Path Rewriting: Strip /api prefix
+	u.Path = strings.TrimPrefix(u.Path, "/api")
+	if u.Path == "" || !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+}
+
+// rewriteURL sends u to target, with target's path and query first, as
+// httputil.NewSingleHostReverseProxy does.
+func rewriteURL(u, target *url.URL) {
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	u.Path, u.RawPath = joinURLPath(target, u)
+	if target.RawQuery == "" || u.RawQuery == "" {
+		u.RawQuery = target.RawQuery + u.RawQuery
+	} else {
+		u.RawQuery = target.RawQuery + "&" + u.RawQuery
+	}
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath, bpath := a.EscapedPath(), b.EscapedPath()
+	aslash, bslash := strings.HasSuffix(apath, "/"), strings.HasPrefix(bpath, "/")
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash, bslash := strings.HasSuffix(a, "/"), strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// proxyError answers an upstream request that got no usable response.
+func (h *ProxyHandler) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	a := attemptFrom(r)
+	c := a.c
+	if errors.Is(err, errRetry) {
+		// A failed response withheld for a retry
+		a.err = err
+		return
+	}
+	h.logger.Error("Proxy forwarding error", zap.String("service", a.service), zap.String("request_id", middleware.RequestIDFrom(c)), zap.Error(err))
+	// The client's deadline ran out; not a sign of upstream overload, so
+	// neither the breaker nor the backoff counts it
+	if errors.Is(err, context.DeadlineExceeded) && c.Request().Context().Err() != nil {
+		metrics.DeadlineRequests.WithLabelValues("exceeded").Inc()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		fmt.Fprintf(w, `{"error":"Request deadline exceeded"}`)
+		return
+	}
+	a.err = err
+	h.backoff.record(a.service, true)
+	if a.retry.again(c.Request().Context(), 0, h.retries) {
+		a.err = fmt.Errorf("%w: %w", errRetry, err)
+		return
+	}
+
+	// Return JSON error response check
+	if !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", formatRetryAfter(h.backoff.hint(a.service, h.cfg.RetryAfter.Base, h.cfg.RetryAfter.Max)))
+		if timedOut(err) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			fmt.Fprintf(w, `{"error":"Upstream timed out"}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, `{"error":"Service Unavailable"}`)
+	}
+}
+
+// timedOut reports whether an upstream request failed by running out of the
+// service's timeout, waiting for headers or overall.
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// modifyResponse records the upstream's response, withholding it when it is
+// to be retried.
+func (h *ProxyHandler) modifyResponse(res *http.Response) error {
+	a := attemptFrom(res.Request)
+	middleware.Timing(a.c, "upstream_headers", time.Since(a.start))
+	a.code = strconv.Itoa(res.StatusCode)
+	if a.grpc {
+		a.res = res
+	}
+	h.retryAfter(res.Header, res.StatusCode, a.service)
+	if a.retry.again(a.c.Request().Context(), res.StatusCode, h.retries) {
+		return errRetry
+	}
+	middleware.MarkUpstream(a.c)
+	return nil
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// maxTransitions bounds the state timeline returned by a simulation.
+const maxTransitions = 50
+
+// BreakerScenario is hypothetical traffic to one service.
+type BreakerScenario struct {
+	RPS         float64
+	FailureRate float64
+	Duration    time.Duration
+	// FailureFor ends the failures after this long, modelling an incident
+	// that resolves; zero keeps them for the whole run.
+	FailureFor time.Duration
+	Seed       uint64
+}
+
+// BreakerTransition is a state change at an offset into the run.
+type BreakerTransition struct {
+	After string `json:"after"`
+	State string `json:"state"`
+}
+
+// BreakerSimulation is how the circuit breaker would treat a scenario.
+type BreakerSimulation struct {
+	Requests  int    `json:"requests"`
+	Forwarded int    `json:"forwarded"`
+	Failed    int    `json:"failed"`
+	Rejected  int    `json:"rejected"`
+	Trips     int    `json:"trips"`
+	FirstTrip string `json:"first_trip_after,omitempty"`
+	// Open is the time spent open (requests rejected without trying).
+	Open       string              `json:"open_time"`
+	FinalState string              `json:"final_state"`
+	Timeline   []BreakerTransition `json:"timeline"`
+}
+
+// SimulateBreaker replays a scenario, one request every 1/RPS, through a
+// model of the gateway's breaker settings: trip after breakerTripFailures
+// consecutive failures, stay open for breakerOpenTimeout, then close after
+// breakerHalfOpenRequests successful probes (any failed probe reopens it).
+// Failures are drawn from a PRNG seeded by Seed, so runs are repeatable.
+func SimulateBreaker(s BreakerScenario) BreakerSimulation {
+	rng := rand.New(rand.NewPCG(s.Seed, s.Seed))
+	step := time.Duration(float64(time.Second) / s.RPS)
+	total := int(s.Duration.Seconds() * s.RPS)
+
+	out := BreakerSimulation{Requests: total, Timeline: []BreakerTransition{}}
+	state := gobreaker.StateClosed
+	var consecutiveFailures, probes, probeSuccesses int
+	var generationStart, openedAt time.Time
+	var openTime time.Duration
+	start := time.Time{}
+
+	transition := func(at time.Time, to gobreaker.State) {
+		if state == gobreaker.StateOpen {
+			openTime += at.Sub(openedAt)
+		}
+		state = to
+		consecutiveFailures, probes, probeSuccesses = 0, 0, 0
+		generationStart = at
+		if to == gobreaker.StateOpen {
+			openedAt = at
+			out.Trips++
+			if out.Trips == 1 {
+				out.FirstTrip = at.Sub(start).String()
+			}
+		}
+		if len(out.Timeline) < maxTransitions {
+			out.Timeline = append(out.Timeline, BreakerTransition{After: at.Sub(start).String(), State: to.String()})
+		}
+	}
+
+	for i := 0; i < total; i++ {
+		now := start.Add(time.Duration(i) * step)
+
+		switch state {
+		case gobreaker.StateOpen:
+			if now.Sub(openedAt) < breakerOpenTimeout {
+				out.Rejected++
+				continue
+			}
+			transition(openedAt.Add(breakerOpenTimeout), gobreaker.StateHalfOpen)
+		case gobreaker.StateClosed:
+			if now.Sub(generationStart) >= breakerInterval {
+				generationStart, consecutiveFailures = now, 0
+			}
+		}
+		if state == gobreaker.StateHalfOpen && probes >= breakerHalfOpenRequests {
+			out.Rejected++
+			continue
+		}
+
+		out.Forwarded++
+		if state == gobreaker.StateHalfOpen {
+			probes++
+		}
+		failing := s.FailureFor == 0 || now.Sub(start) < s.FailureFor
+		if failing && rng.Float64() < s.FailureRate {
+			out.Failed++
+			consecutiveFailures++
+			if state == gobreaker.StateHalfOpen || consecutiveFailures >= breakerTripFailures {
+				transition(now, gobreaker.StateOpen)
+			}
+			continue
+		}
+		consecutiveFailures = 0
+		if state == gobreaker.StateHalfOpen {
+			if probeSuccesses++; probeSuccesses >= breakerHalfOpenRequests {
+				transition(now, gobreaker.StateClosed)
+			}
+		}
+	}
+
+	if state == gobreaker.StateOpen {
+		openTime += start.Add(s.Duration).Sub(openedAt)
+	}
+	out.Open = openTime.String()
+	out.FinalState = state.String()
+	return out
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// streaming returns the streaming settings of the request's route, or nil
+// when it does not stream.
+func (h *ProxyHandler) streaming(c echo.Context) *config.StreamingConfig {
+	if route := h.cfg.Route(c.Path()); route != nil {
+		return route.Streaming
+	}
+	return nil
+}
+
+// flushInterval is the reverse proxy flush interval of a streaming route,
+// negative to flush after every write.
+func flushInterval(stream *config.StreamingConfig) time.Duration {
+	if stream.FlushInterval <= 0 {
+		return -1
+	}
+	return stream.FlushInterval
+}
+
+// extendWriteDeadline replaces the server's write timeout for a streamed
+// response with the route's max_duration, or lifts it.
+func (h *ProxyHandler) extendWriteDeadline(c echo.Context, stream *config.StreamingConfig) {
+	var deadline time.Time
+	if stream.MaxDuration > 0 {
+		deadline = time.Now().Add(stream.MaxDuration)
+	}
+	err := http.NewResponseController(c.Response()).SetWriteDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("Failed to extend write deadline for streaming", zap.String("route", c.Path()), zap.Error(err))
+		return
+	}
+	detail := "flushed after every write"
+	if stream.FlushInterval > 0 {
+		detail = "flushed every " + stream.FlushInterval.String()
+	}
+	if stream.MaxDuration > 0 {
+		detail += ", for up to " + stream.MaxDuration.String()
+	}
+	middleware.Explain(c, "streaming", "allow", detail)
+}
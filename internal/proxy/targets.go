@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// Target is an instance of a configured service and whether it takes
+// requests.
+type Target struct {
+	Service  string `json:"service"`
+	Instance string `json:"instance"`
+	// Breaker is the state of the circuit breaker guarding the instance, if
+	// any.
+	Breaker  string `json:"breaker,omitempty"`
+	Weight   int    `json:"weight"`
+	InFlight int    `json:"in_flight"`
+	Healthy  bool   `json:"healthy"`
+	// EjectedUntil is set while outlier detection keeps the instance out.
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	// InRotation is false while the instance's breaker is open or, for
+	// balanced services, while it fails its health checks, is ejected or is
+	// weighted 0.
+	InRotation bool `json:"in_rotation"`
+}
+
+// Targets returns every instance of the configured services, by service and
+// in instance order.
+func (h *ProxyHandler) Targets() []Target {
+	names := make([]string, 0, len(h.cfg.Services))
+	for name := range h.cfg.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	breakers := h.BreakerStates()
+	now := time.Now()
+
+	var targets []Target
+	for _, name := range names {
+		svc := h.cfg.Services[name]
+		if !balanced(svc) {
+			t := Target{Service: name, Instance: svc.URL, Weight: 1, Healthy: h.healthy(name, svc.URL), InRotation: true}
+			if state, ok := breakers[name]; ok {
+				t.Breaker = state.String()
+				t.InRotation = state != gobreaker.StateOpen
+			}
+			targets = append(targets, t)
+			continue
+		}
+
+		h.mu.RLock()
+		b := h.balancers[name]
+		h.mu.RUnlock()
+		for _, instance := range h.Instances(name, svc) {
+			t := Target{
+				Service:  name,
+				Instance: instance,
+				Weight:   h.weights.Of(name, svc, instance),
+				Healthy:  h.healthy(name, instance),
+			}
+			t.InRotation = t.Healthy && t.Weight > 0
+			if b != nil {
+				b.mu.RLock()
+				if cb := b.breakers[instance]; cb != nil {
+					t.Breaker = cb.State().String()
+					t.InRotation = t.InRotation && cb.State() != gobreaker.StateOpen
+				}
+				if l := b.loads[instance]; l != nil {
+					t.InFlight = l.inflight
+				}
+				if b.ejected(instance, now) {
+					until := b.outliers[instance].ejectedUntil.UTC()
+					t.EjectedUntil = &until
+					t.InRotation = false
+				}
+				b.mu.RUnlock()
+			}
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,402 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxTranscodedMessage bounds a transcoded response message, as gRPC's
+// default receive limit does.
+const maxTranscodedMessage = 4 << 20
+
+var errUnreadableBody = errors.New("unable to read request body")
+
+// Transcoder serves JSON routes by calling unary gRPC methods, through the
+// proxy handler, so browsers and other plain HTTP clients can use grpc
+// services without a separate backend for frontends.
+type Transcoder struct {
+	proxy  *ProxyHandler
+	logger *zap.Logger
+	// methods by rule RPC, resolved from the services' descriptors
+	methods map[string]protoreflect.MethodDescriptor
+}
+
+// NewTranscoder loads the descriptors of services with transcode rules and
+// resolves each rule's method, which must exist and be unary.
+func NewTranscoder(cfg *config.Config, proxy *ProxyHandler, logger *zap.Logger) (*Transcoder, error) {
+	t := &Transcoder{
+		proxy:   proxy,
+		logger:  logger,
+		methods: make(map[string]protoreflect.MethodDescriptor),
+	}
+	for name, svc := range cfg.Services {
+		if len(svc.Transcode) == 0 {
+			continue
+		}
+		files, err := loadDescriptor(svc.Descriptor)
+		if err != nil {
+			return nil, fmt.Errorf("services.%s.descriptor: %w", name, err)
+		}
+		for _, rule := range svc.Transcode {
+			serviceName, methodName, _ := strings.Cut(rule.RPC, "/")
+			d, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+			sd, ok := d.(protoreflect.ServiceDescriptor)
+			if err != nil || !ok {
+				return nil, fmt.Errorf("services.%s.transcode: no gRPC service %s in %s", name, serviceName, svc.Descriptor)
+			}
+			md := sd.Methods().ByName(protoreflect.Name(methodName))
+			switch {
+			case md == nil:
+				return nil, fmt.Errorf("services.%s.transcode: %s has no method %s", name, serviceName, methodName)
+			case md.IsStreamingClient() || md.IsStreamingServer():
+				return nil, fmt.Errorf("services.%s.transcode: %s is a streaming method; only unary methods are transcoded", name, rule.RPC)
+			}
+			t.methods[rule.RPC] = md
+		}
+	}
+	return t, nil
+}
+
+func loadDescriptor(path string) (interface {
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("not a FileDescriptorSet: %w", err)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// Handle returns the handler of a transcoded route of the named service.
+func (t *Transcoder) Handle(serviceName string, rule config.TranscodeRule) echo.HandlerFunc {
+	method := t.methods[rule.RPC]
+	forward := t.proxy.Handle(serviceName)
+
+	return func(c echo.Context) error {
+		msg, err := requestMessage(c, method.Input())
+		if errors.Is(err, errUnreadableBody) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+		}
+		if err != nil {
+			middleware.Explain(c, "transcode", "deny", err.Error())
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":  "Invalid request",
+				"reason": err.Error(),
+			})
+		}
+		payload, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		middleware.Explain(c, "transcode", "allow", "rpc "+rule.RPC)
+
+		// The call goes through the proxy as a gRPC request to the method's
+		// path; the client's request is restored afterwards
+		orig := c.Request()
+		req := orig.Clone(orig.Context())
+		req.Method = http.MethodPost
+		req.URL.Path, req.URL.RawPath, req.URL.RawQuery = "/api/"+rule.RPC, "", ""
+		body := grpcFrame(payload)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		req.ContentLength = int64(len(body))
+		req.Header.Del(echo.HeaderContentLength)
+		req.Header.Del(echo.HeaderAcceptEncoding)
+		req.Header.Set(echo.HeaderContentType, "application/grpc")
+		req.Header.Set("Te", "trailers")
+		c.SetRequest(req)
+
+		res := c.Response()
+		rec := &transcodeRecorder{ResponseWriter: res.Writer, header: make(http.Header)}
+		res.Writer = rec
+		err = forward(c)
+		res.Writer = rec.ResponseWriter
+		c.SetRequest(orig)
+		if err != nil && rec.status == 0 {
+			return err
+		}
+		res.Committed = false
+		return t.respond(c, method.Output(), rec)
+	}
+}
+
+// respond answers the client from the recorded gRPC response: the response
+// message as JSON, or the gRPC error as its HTTP status. Responses that are
+// not gRPC, the gateway's own errors among them, pass unchanged.
+func (t *Transcoder) respond(c echo.Context, output protoreflect.MessageDescriptor, rec *transcodeRecorder) error {
+	h := c.Response().Header()
+	if rec.status != http.StatusOK || !strings.HasPrefix(rec.header.Get(echo.HeaderContentType), "application/grpc") {
+		for k, v := range rec.header {
+			h[k] = v
+		}
+		return c.Blob(rec.status, rec.header.Get(echo.HeaderContentType), rec.body.Bytes())
+	}
+	for k, v := range rec.header {
+		if !strings.HasPrefix(k, "Grpc-") && !strings.HasPrefix(k, http.TrailerPrefix) &&
+			k != "Trailer" && k != echo.HeaderContentType && k != echo.HeaderContentLength {
+			h[k] = v
+		}
+	}
+
+	// Trailers, or headers for a trailers-only response
+	status := rec.header.Get(http.TrailerPrefix + "Grpc-Status")
+	message := rec.header.Get(http.TrailerPrefix + "Grpc-Message")
+	if status == "" {
+		status, message = rec.header.Get("Grpc-Status"), rec.header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return t.badResponse(c, "missing grpc-status")
+	}
+	if code != 0 {
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+		if message == "" {
+			message = "gRPC status " + status
+		}
+		return c.JSON(httpStatus(code), map[string]string{"error": message})
+	}
+	if rec.overflow {
+		return t.badResponse(c, "response message too large")
+	}
+
+	payload, err := readGRPCFrame(rec.body.Bytes())
+	if err != nil {
+		return t.badResponse(c, err.Error())
+	}
+	msg := dynamicpb.NewMessage(output)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return t.badResponse(c, "malformed response message: "+err.Error())
+	}
+	out, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, out)
+}
+
+// badResponse answers a gRPC response that cannot be transcoded.
+func (t *Transcoder) badResponse(c echo.Context, reason string) error {
+	t.logger.Warn("Untranscodable gRPC response",
+		zap.String("route", c.Path()),
+		zap.String("reason", reason),
+		zap.String("request_id", middleware.RequestIDFrom(c)),
+	)
+	return c.JSON(http.StatusBadGateway, map[string]string{"error": "Invalid upstream response"})
+}
+
+// requestMessage builds a method's request message from the JSON body of a
+// request that has one, then its path and query parameters.
+func requestMessage(c echo.Context, input protoreflect.MessageDescriptor) (proto.Message, error) {
+	msg := dynamicpb.NewMessage(input)
+	req := c.Request()
+	if req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch {
+		raw, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, errUnreadableBody
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			if err := protojson.Unmarshal(raw, msg); err != nil {
+				return nil, fmt.Errorf("body: %s", strings.TrimPrefix(err.Error(), "proto: "))
+			}
+		}
+	}
+
+	params := make(map[string]interface{})
+	query := req.URL.Query()
+	for name, values := range query {
+		if err := setParam(params, input, name, values); err != nil {
+			return nil, err
+		}
+	}
+	for i, name := range c.ParamNames() {
+		if err := setParam(params, input, name, []string{c.ParamValues()[i]}); err != nil {
+			return nil, err
+		}
+	}
+	if len(params) == 0 {
+		return msg, nil
+	}
+
+	// Parameters are set through their JSON form, which parses every field
+	// type from text, enums and well-known types included
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	fromParams := dynamicpb.NewMessage(input)
+	if err := protojson.Unmarshal(raw, fromParams); err != nil {
+		return nil, fmt.Errorf("parameters: %s", strings.TrimPrefix(err.Error(), "proto: "))
+	}
+	proto.Merge(msg, fromParams)
+	return msg, nil
+}
+
+// setParam places the values of a path or query parameter at the field its
+// dotted name selects, in the JSON object params.
+func setParam(params map[string]interface{}, md protoreflect.MessageDescriptor, name string, values []string) error {
+	parts := strings.Split(name, ".")
+	obj := params
+	for i, part := range parts {
+		fd := md.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(part)
+		}
+		if fd == nil || fd.IsMap() {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		key := string(fd.Name())
+		if i < len(parts)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() {
+				return fmt.Errorf("unknown parameter %q", name)
+			}
+			next, _ := obj[key].(map[string]interface{})
+			if next == nil {
+				next = make(map[string]interface{})
+				obj[key] = next
+			}
+			obj, md = next, fd.Message()
+			continue
+		}
+
+		parsed := make([]interface{}, len(values))
+		for j, v := range values {
+			parsed[j] = v
+			if fd.Kind() == protoreflect.BoolKind {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return fmt.Errorf("parameter %q is not a boolean", name)
+				}
+				parsed[j] = b
+			}
+		}
+		switch {
+		case fd.IsList():
+			obj[key] = parsed
+		case len(parsed) > 1:
+			return fmt.Errorf("parameter %q takes one value", name)
+		default:
+			obj[key] = parsed[0]
+		}
+	}
+	return nil
+}
+
+// grpcFrame frames an uncompressed gRPC message.
+func grpcFrame(payload []byte) []byte {
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return frame
+}
+
+// readGRPCFrame returns the message of a unary response body.
+func readGRPCFrame(body []byte) ([]byte, error) {
+	if len(body) < 5 {
+		return nil, errors.New("missing response message")
+	}
+	if body[0] != 0 {
+		// Nothing was offered in grpc-accept-encoding
+		return nil, errors.New("compressed response message")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) < uint64(n) {
+		return nil, errors.New("truncated response message")
+	}
+	return body[5 : 5+n], nil
+}
+
+// httpStatus maps a gRPC status code to the closest HTTP status, as gRPC's
+// HTTP mappings do.
+func httpStatus(code int) int {
+	switch code {
+	case 0: // OK
+		return http.StatusOK
+	case 1: // CANCELLED
+		return 499
+	case 3, 9, 11: // INVALID_ARGUMENT, FAILED_PRECONDITION, OUT_OF_RANGE
+		return http.StatusBadRequest
+	case 4: // DEADLINE_EXCEEDED
+		return http.StatusGatewayTimeout
+	case 5: // NOT_FOUND
+		return http.StatusNotFound
+	case 6, 10: // ALREADY_EXISTS, ABORTED
+		return http.StatusConflict
+	case 7: // PERMISSION_DENIED
+		return http.StatusForbidden
+	case 8: // RESOURCE_EXHAUSTED
+		return http.StatusTooManyRequests
+	case 12: // UNIMPLEMENTED
+		return http.StatusNotImplemented
+	case 14: // UNAVAILABLE
+		return http.StatusServiceUnavailable
+	case 16: // UNAUTHENTICATED
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError // UNKNOWN, INTERNAL, DATA_LOSS
+}
+
+// transcodeRecorder holds a gRPC response for transcoding, headers and
+// trailers included, instead of sending it.
+type transcodeRecorder struct {
+	http.ResponseWriter
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *transcodeRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *transcodeRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *transcodeRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n := len(p)
+	if room := maxTranscodedMessage + 5 - r.body.Len(); room < n {
+		r.overflow = true
+		p = p[:max(room, 0)]
+	}
+	r.body.Write(p)
+	return n, nil
+}
+
+// Flush holds the response back; it is sent once transcoded.
+func (r *transcodeRecorder) Flush() {}
+
+func (r *transcodeRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
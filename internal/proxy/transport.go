@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/banking/api-gateway/internal/metrics"
+)
+
+// Defaults for services that do not set them.
+const (
+	defaultMaxIdleConnsPerHost = 100
+	defaultTLSSessionCache     = 64
+)
+
+// poolStats counts one service's connections. They outlive transport
+// rebuilds, since connections of a replaced transport still close later.
+type poolStats struct {
+	service string
+	open    atomic.Int64
+	inUse   atomic.Int64
+}
+
+// publish updates the connection gauges. Idle is derived: with HTTP/1.1 a
+// connection is either carrying one request or waiting in the pool. HTTP/2
+// connections carry several, so for them it is a lower bound.
+func (s *poolStats) publish() {
+	open := s.open.Load()
+	metrics.UpstreamConnections.WithLabelValues(s.service, "open").Set(float64(open))
+	metrics.UpstreamConnections.WithLabelValues(s.service, "idle").Set(float64(max(0, open-s.inUse.Load())))
+}
+
+// pool is the shared transport of one service, so connections are reused
+// across requests and the service's pool settings apply.
+type pool struct {
+	settings config.TransportConfig
+	// timeout is the service's, bounding how long responses may take
+	timeout time.Duration
+	// protocol is the service's: http1, http2 or grpc
+	protocol  string
+	transport *http.Transport
+	stats     *poolStats
+}
+
+func newPool(settings config.TransportConfig, timeout time.Duration, protocol string, stats *poolStats) *pool {
+	maxIdle := settings.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	// Resumed sessions skip the certificate exchange on new connections.
+	// Go's TLS client never sends 0-RTT early data, so there is none to offer.
+	tlsConfig := fips.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	switch size := settings.TLSSessionCache; {
+	case size == 0:
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(defaultTLSSessionCache)
+	case size > 0:
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	}
+
+	p := &pool{
+		settings: settings,
+		timeout:  timeout,
+		protocol: protocol,
+		stats:    stats,
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				start := time.Now()
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					metrics.UpstreamConnectionsOpened.WithLabelValues(stats.service, "error").Inc()
+					return nil, err
+				}
+				metrics.UpstreamDialDuration.WithLabelValues(stats.service).Observe(time.Since(start).Seconds())
+				metrics.UpstreamConnectionsOpened.WithLabelValues(stats.service, "ok").Inc()
+				stats.open.Add(1)
+				stats.publish()
+				return &pooledConn{Conn: conn, stats: stats}, nil
+			},
+			TLSClientConfig:       tlsConfig,
+			MaxIdleConns:          maxIdle,
+			MaxIdleConnsPerHost:   maxIdle,
+			MaxConnsPerHost:       settings.MaxConnsPerHost,
+			DisableKeepAlives:     settings.DisableKeepAlives,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+	if protocol == "http2" || protocol == "grpc" {
+		// Prior knowledge (h2c) for http URLs, ALPN for https ones; a
+		// backend without HTTP/2 fails rather than falling back
+		p.transport.Protocols = new(http.Protocols)
+		p.transport.Protocols.SetHTTP2(true)
+		p.transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return p
+}
+
+// track instruments one upstream request: TLS handshakes on new connections
+// (full or resumed),
+// and the connection counted in use until the returned release is called.
+func (p *pool) track(ctx context.Context) (context.Context, func()) {
+	var acquired atomic.Int64
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			acquired.Add(1)
+			p.stats.inUse.Add(1)
+			p.stats.publish()
+		},
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			result := "ok"
+			if err != nil {
+				result = "error"
+			}
+			metrics.UpstreamTLSHandshakeDuration.WithLabelValues(p.stats.service, result).Observe(time.Since(handshakeStart).Seconds())
+			if err == nil {
+				kind := "full"
+				if state.DidResume {
+					kind = "resumed"
+				}
+				metrics.UpstreamTLSHandshakes.WithLabelValues(p.stats.service, kind).Inc()
+			}
+		},
+	}
+	release := func() {
+		if n := acquired.Swap(0); n > 0 {
+			p.stats.inUse.Add(-n)
+			p.stats.publish()
+		}
+	}
+	return httptrace.WithClientTrace(ctx, trace), release
+}
+
+// pooledConn uncounts its connection when the transport closes it.
+type pooledConn struct {
+	net.Conn
+	stats *poolStats
+	once  sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	c.once.Do(func() {
+		c.stats.open.Add(-1)
+		c.stats.publish()
+	})
+	return c.Conn.Close()
+}
+
+// pool returns the service's transport, rebuilding it when its settings have
+// changed (e.g. after a config reload or discovery update).
+func (h *ProxyHandler) pool(name string, svc config.Service) *pool {
+	h.mu.RLock()
+	p, ok := h.pools[name]
+	h.mu.RUnlock()
+	if ok && p.settings == svc.Transport && p.timeout == svc.Timeout && p.protocol == svc.Protocol {
+		return p
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok = h.pools[name]; ok && p.settings == svc.Transport && p.timeout == svc.Timeout && p.protocol == svc.Protocol {
+		return p
+	}
+	stats := &poolStats{service: name}
+	if ok {
+		stats = p.stats
+		p.transport.CloseIdleConnections()
+	}
+	p = newPool(svc.Transport, svc.Timeout, svc.Protocol, stats)
+	h.pools[name] = p
+	return p
+}
+
+// Warm resolves a service's host and opens conns pooled connections to it,
+// TLS included, by sending HEAD requests to its URL. Any HTTP response counts:
+// the connection is then idle in the pool, ready for the first real request.
+// Balanced services have the connections spread over their instances.
+func (h *ProxyHandler) Warm(ctx context.Context, name string, svc config.Service, conns int) error {
+	bases := []string{svc.URL}
+	if balanced(svc) {
+		bases = h.Instances(name, svc)
+		if len(bases) == 0 {
+			return fmt.Errorf("service %s: no ready instances", name)
+		}
+	}
+	targets := make([]*url.URL, len(bases))
+	for i, base := range bases {
+		target, err := url.Parse(base)
+		if err != nil || target.Host == "" {
+			return fmt.Errorf("service %s: invalid URL %q", name, base)
+		}
+		if _, err := net.DefaultResolver.LookupHost(ctx, target.Hostname()); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		targets[i] = target
+	}
+
+	transport := h.pool(name, svc).transport
+	errs := make([]error, max(conns, 1))
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target := targets[i%len(targets)]
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				errs[i] = fmt.Errorf("service %s: %w", name, err)
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// websocketAllowed reports whether the request's route tunnels WebSocket
+// connections.
+func (h *ProxyHandler) websocketAllowed(c echo.Context) bool {
+	route := h.cfg.Route(c.Path())
+	return route != nil && route.WebSocket
+}
+
+// tunnelWriter hands the client connection over to the reverse proxy for a
+// WebSocket tunnel. The hijack goes past any middleware wrapping the
+// response, which has nothing to hold or rewrite once protocols switch, and
+// lifts the server's read and write timeouts, which would otherwise cut the
+// tunnel short.
+type tunnelWriter struct {
+	*echo.Response
+}
+
+func (w tunnelWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.Response.Writer).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// The reverse proxy writes the 101 itself; record it for access logs
+	w.Response.Status, w.Response.Committed = http.StatusSwitchingProtocols, true
+	return conn, brw, nil
+}
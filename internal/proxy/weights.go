@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"go.uber.org/zap"
+)
+
+const (
+	weightsKey = "upstream_weights"
+	// weightsTTL keeps runtime weights for the length of a rollout; every
+	// change refreshes it.
+	weightsTTL = 30 * 24 * time.Hour
+)
+
+// ErrNotBalanced is returned for a service that is not spread over several
+// instances, so has no weights.
+var ErrNotBalanced = errors.New("service is not load balanced")
+
+// WeightOverride is a set of instance weights set at runtime. Instances it
+// leaves out keep their configured weight.
+type WeightOverride struct {
+	Weights map[string]int `json:"weights"`
+	SetBy   string         `json:"set_by,omitempty"`
+	SetAt   time.Time      `json:"set_at"`
+}
+
+// InstanceWeight is an instance with the weight currently in effect.
+type InstanceWeight struct {
+	Instance         string `json:"instance"`
+	Weight           int    `json:"weight"`
+	ConfiguredWeight int    `json:"configured_weight"`
+}
+
+// WeightStatus is a balanced service with the weights of its instances.
+type WeightStatus struct {
+	Service   string           `json:"service"`
+	Instances []InstanceWeight `json:"instances"`
+	Override  *WeightOverride  `json:"override,omitempty"`
+}
+
+// Weights holds the traffic weights of balanced services' instances, so a
+// canary can take a small slice of traffic and be dialled up. Runtime
+// overrides live in Redis so every replica follows them; each replica
+// refreshes its copy on an interval.
+type Weights struct {
+	services  map[string]config.Service // balanced, by name
+	instances func(name string, svc config.Service) []string
+	redis     *infrastructure.RedisClient
+	logger    *zap.Logger
+
+	mu        sync.RWMutex
+	overrides map[string]WeightOverride // by service
+}
+
+// NewWeights tracks the weights of every balanced service; instances returns
+// a service's current instances.
+func NewWeights(cfg *config.Config, redis *infrastructure.RedisClient, instances func(name string, svc config.Service) []string, logger *zap.Logger) *Weights {
+	services := make(map[string]config.Service)
+	for name, svc := range cfg.Services {
+		if balanced(svc) {
+			services[name] = svc
+		}
+	}
+	return &Weights{
+		services:  services,
+		instances: instances,
+		redis:     redis,
+		logger:    logger,
+		overrides: make(map[string]WeightOverride),
+	}
+}
+
+// Start polls Redis for override changes until ctx is cancelled.
+func (w *Weights) Start(ctx context.Context, interval time.Duration) {
+	if w.redis == nil || len(w.services) == 0 {
+		return
+	}
+	w.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (w *Weights) refresh(ctx context.Context) {
+	raw, err := w.redis.GetHash(ctx, weightsKey)
+	if err != nil {
+		w.logger.Warn("Failed to refresh upstream weights", zap.Error(err))
+		return
+	}
+
+	overrides := make(map[string]WeightOverride, len(raw))
+	for service, v := range raw {
+		var o WeightOverride
+		if err := json.Unmarshal([]byte(v), &o); err == nil {
+			overrides[service] = o
+		}
+	}
+
+	w.mu.Lock()
+	w.overrides = overrides
+	w.mu.Unlock()
+}
+
+// configured returns an instance's weight from the configuration: its entry
+// in weights, or 1.
+func configured(svc config.Service, instance string) int {
+	if i := slices.Index(svc.URLs, instance); i >= 0 && i < len(svc.Weights) {
+		return svc.Weights[i]
+	}
+	return 1
+}
+
+// Of returns the weight in effect for an instance of a service.
+func (w *Weights) Of(name string, svc config.Service, instance string) int {
+	if w != nil {
+		w.mu.RLock()
+		weight, ok := w.overrides[name].Weights[instance]
+		w.mu.RUnlock()
+		if ok {
+			return weight
+		}
+	}
+	return configured(svc, instance)
+}
+
+// Statuses returns every balanced service with its instances' weights.
+func (w *Weights) Statuses() []WeightStatus {
+	names := make([]string, 0, len(w.services))
+	for name := range w.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]WeightStatus, 0, len(names))
+	for _, name := range names {
+		svc := w.services[name]
+		st := WeightStatus{Service: name, Instances: []InstanceWeight{}}
+		for _, instance := range w.instances(name, svc) {
+			st.Instances = append(st.Instances, InstanceWeight{
+				Instance:         instance,
+				Weight:           w.Of(name, svc, instance),
+				ConfiguredWeight: configured(svc, instance),
+			})
+		}
+		w.mu.RLock()
+		if o, ok := w.overrides[name]; ok {
+			st.Override = &o
+		}
+		w.mu.RUnlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// Set overrides the weights of some instances of a service on every replica,
+// replacing any earlier override.
+func (w *Weights) Set(ctx context.Context, name string, weights map[string]int, by string) error {
+	svc, ok := w.services[name]
+	if !ok {
+		return ErrNotBalanced
+	}
+	if len(weights) == 0 {
+		return errors.New("weights are required")
+	}
+	instances := w.instances(name, svc)
+	for instance, weight := range weights {
+		if !slices.Contains(instances, instance) {
+			return fmt.Errorf("%s is not an instance of %s", instance, name)
+		}
+		if weight < 0 {
+			return errors.New("weights must not be negative")
+		}
+	}
+	total := 0
+	for _, instance := range instances {
+		if weight, ok := weights[instance]; ok {
+			total += weight
+		} else {
+			total += configured(svc, instance)
+		}
+	}
+	if total == 0 {
+		return errors.New("at least one instance must keep a positive weight")
+	}
+
+	o := WeightOverride{Weights: weights, SetBy: by, SetAt: time.Now().UTC()}
+	if w.redis != nil {
+		data, _ := json.Marshal(o)
+		if err := w.redis.SetHashField(ctx, weightsKey, name, string(data), weightsTTL); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	w.overrides[name] = o
+	w.mu.Unlock()
+
+	w.logger.Warn("Upstream weights changed", zap.String("service", name), zap.Any("weights", weights), zap.String("set_by", by))
+	return nil
+}
+
+// Reset drops a service's runtime weights, returning it to the configured
+// ones.
+func (w *Weights) Reset(ctx context.Context, name, by string) error {
+	if _, ok := w.services[name]; !ok {
+		return ErrNotBalanced
+	}
+	if w.redis != nil {
+		if err := w.redis.DeleteHashField(ctx, weightsKey, name); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	delete(w.overrides, name)
+	w.mu.Unlock()
+
+	w.logger.Warn("Upstream weights reset", zap.String("service", name), zap.String("by", by))
+	return nil
+}
@@ -0,0 +1,300 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/metrics"
+	"github.com/banking/api-gateway/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// lockName serializes the Redis passes of all replicas; spill files are
+// local to each.
+const lockName = "retention"
+
+// streams are the event stream of each stream category.
+var streams = map[string]string{
+	config.RetentionAccessEvents: infrastructure.StreamAccess,
+	config.RetentionAuditEvents:  infrastructure.StreamAudit,
+	config.RetentionErrorEvents:  infrastructure.StreamError,
+}
+
+// Manager runs retention passes: it removes the gateway's stored entries
+// once older than their category's max_age, redacts them once older than
+// redact_after, and reports per category how old the oldest entries left
+// are and whether that is within policy.
+type Manager struct {
+	cfg        *config.Config
+	redis      *infrastructure.RedisClient
+	events     *infrastructure.EventStore
+	requestLog *infrastructure.RequestLog
+	logger     *zap.Logger
+	audit      *zap.Logger
+}
+
+// NewManager returns a manager for the stores in use; events, requestLog
+// and redis may be nil.
+func NewManager(cfg *config.Config, redis *infrastructure.RedisClient, events *infrastructure.EventStore, requestLog *infrastructure.RequestLog, logger *zap.Logger) *Manager {
+	return &Manager{
+		cfg:        cfg,
+		redis:      redis,
+		events:     events,
+		requestLog: requestLog,
+		logger:     logger,
+		audit:      logger.Named("audit"),
+	}
+}
+
+// Start runs a pass now and every retention.interval until ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.cfg.Retention.Interval)
+		defer ticker.Stop()
+		for {
+			m.Run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run makes one pass over every category with a policy. Redis categories
+// are skipped while another replica holds the pass.
+func (m *Manager) Run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Retention.Interval)
+	defer cancel()
+
+	if p, ok := m.cfg.Retention.Policies[config.RetentionBodySpill]; ok {
+		m.report(config.RetentionBodySpill, p, m.spillFiles(ctx, p))
+	}
+	if m.redis == nil {
+		return
+	}
+	lock, err := m.redis.ObtainLock(ctx, lockName, infrastructure.LockOptions{TTL: 30 * time.Second, AutoRenew: true})
+	if errors.Is(err, infrastructure.ErrLockNotAcquired) {
+		return
+	}
+	if err != nil {
+		m.logger.Warn("Retention pass skipped", zap.Error(err))
+		return
+	}
+	defer lock.Release(context.Background())
+
+	for category, stream := range streams {
+		if p, ok := m.cfg.Retention.Policies[category]; ok && m.events != nil && slices.Contains(m.cfg.Events.Streams, stream) {
+			m.report(category, p, m.stream(ctx, stream, p))
+		}
+	}
+	if p, ok := m.cfg.Retention.Policies[config.RetentionRequestLogs]; ok && m.requestLog != nil {
+		m.report(config.RetentionRequestLogs, p, m.requestLogs(ctx, p))
+	}
+}
+
+// outcome is what a pass did in one category and what it left behind.
+type outcome struct {
+	removed, redacted int
+	// oldest and unredacted are the times of the oldest entry left, and of
+	// the oldest left unredacted; zero when there is none.
+	oldest, unredacted time.Time
+	err                error
+}
+
+// report publishes the outcome of a pass as metrics and, when it changed
+// anything, an audit record.
+func (m *Manager) report(category string, p config.RetentionPolicy, out outcome) {
+	metrics.RetentionRemoved.WithLabelValues(category).Add(float64(out.removed))
+	metrics.RetentionRedacted.WithLabelValues(category).Add(float64(out.redacted))
+	if out.err != nil {
+		m.logger.Warn("Retention pass failed", zap.String("category", category), zap.Error(out.err))
+		return
+	}
+
+	now := time.Now()
+	var age time.Duration
+	if !out.oldest.IsZero() {
+		age = now.Sub(out.oldest)
+	}
+	compliant := p.MaxAge <= 0 || age <= p.MaxAge
+	if p.RedactAfter > 0 && !out.unredacted.IsZero() && now.Sub(out.unredacted) > p.RedactAfter {
+		compliant = false
+	}
+	metrics.RetentionOldestAgeSeconds.WithLabelValues(category).Set(age.Seconds())
+	metrics.RetentionCompliant.WithLabelValues(category).Set(boolGauge(compliant))
+	metrics.RetentionLastPass.WithLabelValues(category).Set(float64(now.Unix()))
+
+	if out.removed > 0 || out.redacted > 0 {
+		m.audit.Info("Retention pass",
+			zap.String("event", "retention_pass"),
+			zap.String("category", category),
+			zap.Int("removed", out.removed),
+			zap.Int("redacted", out.redacted),
+			zap.Bool("compliant", compliant),
+		)
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// stream trims and redacts a gateway event stream.
+func (m *Manager) stream(ctx context.Context, stream string, p config.RetentionPolicy) outcome {
+	var out outcome
+	now := time.Now()
+	if p.MaxAge > 0 {
+		removed, err := m.events.TrimBefore(ctx, stream, now.Add(-p.MaxAge))
+		out.removed = int(removed)
+		if err != nil {
+			out.err = err
+			return out
+		}
+	}
+	if p.RedactAfter > 0 {
+		out.redacted, out.err = m.events.RedactBefore(ctx, stream, now.Add(-p.RedactAfter), redactor(p.RedactFields))
+		if out.err != nil {
+			return out
+		}
+	}
+	out.oldest, out.unredacted, out.err = m.events.Oldest(ctx, stream)
+	return out
+}
+
+// requestLogs removes and redacts whole request logs by their first event.
+func (m *Manager) requestLogs(ctx context.Context, p config.RetentionPolicy) outcome {
+	var out outcome
+	now := time.Now()
+	var expired []string
+	var aging []infrastructure.RequestLogInfo
+	err := m.requestLog.Walk(ctx, func(info infrastructure.RequestLogInfo) {
+		switch {
+		case p.MaxAge > 0 && now.Sub(info.Oldest) > p.MaxAge:
+			expired = append(expired, info.RequestID)
+			return
+		case info.Redacted:
+		case p.RedactAfter > 0 && now.Sub(info.Oldest) > p.RedactAfter:
+			aging = append(aging, info)
+		default:
+			out.unredacted = earliest(out.unredacted, info.Oldest)
+		}
+		out.oldest = earliest(out.oldest, info.Oldest)
+	})
+	if err != nil {
+		out.err = err
+		return out
+	}
+
+	for len(expired) > 0 && ctx.Err() == nil {
+		batch := expired[:min(len(expired), 500)]
+		expired = expired[len(batch):]
+		removed, err := m.requestLog.Forget(ctx, batch...)
+		out.removed += int(removed)
+		if err != nil {
+			out.err = err
+			return out
+		}
+	}
+	redact := redactor(p.RedactFields)
+	for _, info := range aging {
+		done, err := m.requestLog.Redact(ctx, info.RequestID, redact)
+		if err != nil {
+			out.err = err
+			return out
+		}
+		if done {
+			out.redacted++
+		} else {
+			// Gone, or written to meanwhile and left for the next pass
+			out.unredacted = earliest(out.unredacted, info.Oldest)
+		}
+	}
+	return out
+}
+
+// earliest returns the earlier of t and u, ignoring a zero t.
+func earliest(t, u time.Time) time.Time {
+	if t.IsZero() || u.Before(t) {
+		return u
+	}
+	return t
+}
+
+// spillFiles removes body spill files left behind longer than max_age. A
+// request still reading its file keeps it open, so removal is safe.
+func (m *Manager) spillFiles(ctx context.Context, p config.RetentionPolicy) outcome {
+	var out outcome
+	dir := m.cfg.BodyBuffer.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	files, err := filepath.Glob(filepath.Join(dir, middleware.BodySpillPattern))
+	if err != nil {
+		out.err = err
+		return out
+	}
+	now := time.Now()
+	for _, file := range files {
+		if ctx.Err() != nil {
+			out.err = ctx.Err()
+			return out
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if p.MaxAge > 0 && now.Sub(info.ModTime()) > p.MaxAge {
+			if err := os.Remove(file); err == nil || errors.Is(err, os.ErrNotExist) {
+				out.removed++
+				continue
+			}
+		}
+		out.oldest = earliest(out.oldest, info.ModTime())
+	}
+	return out
+}
+
+// redactor returns a function replacing the named fields of an entry with
+// "[redacted]": entry values, and log fields in the JSON "fields" value of
+// log events.
+func redactor(names []string) func(map[string]interface{}) {
+	return func(values map[string]interface{}) {
+		for _, name := range names {
+			if _, ok := values[name]; ok {
+				values[name] = "[redacted]"
+			}
+		}
+		raw, ok := values["fields"].(string)
+		if !ok || !strings.HasPrefix(raw, "{") {
+			return
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(raw), &fields) != nil {
+			return
+		}
+		changed := false
+		for _, name := range names {
+			if _, ok := fields[name]; ok {
+				fields[name] = "[redacted]"
+				changed = true
+			}
+		}
+		if changed {
+			data, _ := json.Marshal(fields)
+			values["fields"] = string(data)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package routing
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// Auth modes for dynamic routes.
+const (
+	AuthRequired = "required"
+	AuthPublic   = "public"
+)
+
+// Route maps a path prefix under /api to an upstream service registered at
+// runtime by a discovery source (Kubernetes, xDS, admin API).
+type Route struct {
+	Prefix    string         `json:"prefix"`
+	Service   config.Service `json:"service"`
+	Auth      string         `json:"auth"`
+	RateLimit string         `json:"rate_limit,omitempty"`
+	// Priority overrides longest-prefix precedence: higher values match
+	// first. Routes default to 0.
+	Priority int    `json:"priority,omitempty"`
+	Source   string `json:"source"`
+}
+
+// coveredBy reports whether every path r matches is also matched by other.
+func (r Route) coveredBy(other Route) bool {
+	return r.Prefix == other.Prefix || strings.HasPrefix(r.Prefix, strings.TrimSuffix(other.Prefix, "/")+"/")
+}
+
+// Conflict is a route that can never match because a route ahead of it in
+// precedence order covers every path it would serve.
+type Conflict struct {
+	Prefix           string `json:"prefix"`
+	Source           string `json:"source"`
+	ShadowedBy       string `json:"shadowed_by"`
+	ShadowedBySource string `json:"shadowed_by_source"`
+}
+
+// Table holds dynamic routes grouped by source. Each source owns its routes
+// and replaces them wholesale on every sync.
+type Table struct {
+	mu        sync.RWMutex
+	bySource  map[string][]Route
+	sorted    []Route
+	conflicts []Conflict
+	check     func(Route) error
+}
+
+func NewTable() *Table {
+	return &Table{bySource: make(map[string][]Route)}
+}
+
+// SetCheck installs a check every route must pass to enter the table, such
+// as the route linter; routes it rejects are left out.
+func (t *Table) SetCheck(check func(Route) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.check = check
+}
+
+// Replace swaps all routes owned by source and returns the conflicts in the
+// resulting table.
+func (t *Table) Replace(source string, routes []Route) []Conflict {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	accepted := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		r.Source = source
+		if t.check != nil && t.check(r) != nil {
+			continue
+		}
+		accepted = append(accepted, r)
+	}
+	routes = accepted
+
+	if len(routes) == 0 {
+		delete(t.bySource, source)
+	} else {
+		t.bySource[source] = routes
+	}
+
+	var all []Route
+	for _, rs := range t.bySource {
+		all = append(all, rs...)
+	}
+	sort.Slice(all, func(i, j int) bool { return precedes(all[i], all[j]) })
+	t.sorted = all
+
+	t.conflicts = nil
+	for i, r := range all {
+		for _, ahead := range all[:i] {
+			if r.coveredBy(ahead) {
+				t.conflicts = append(t.conflicts, Conflict{
+					Prefix:           r.Prefix,
+					Source:           r.Source,
+					ShadowedBy:       ahead.Prefix,
+					ShadowedBySource: ahead.Source,
+				})
+				break
+			}
+		}
+	}
+	return append([]Conflict(nil), t.conflicts...)
+}
+
+// precedes orders routes deterministically: higher priority first, then
+// longest prefix so Lookup returns the most specific match, then prefix and
+// source name to break ties.
+func precedes(a, b Route) bool {
+	switch {
+	case a.Priority != b.Priority:
+		return a.Priority > b.Priority
+	case len(a.Prefix) != len(b.Prefix):
+		return len(a.Prefix) > len(b.Prefix)
+	case a.Prefix != b.Prefix:
+		return a.Prefix < b.Prefix
+	}
+	return a.Source < b.Source
+}
+
+// Conflicts returns routes shadowed by a route with higher precedence.
+func (t *Table) Conflicts() []Conflict {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]Conflict(nil), t.conflicts...)
+}
+
+// Lookup returns the most specific route whose prefix matches path.
+func (t *Table) Lookup(path string) (Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range t.sorted {
+		if path == r.Prefix || strings.HasPrefix(path, strings.TrimSuffix(r.Prefix, "/")+"/") {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// Service returns the upstream definition of a dynamically registered service.
+func (t *Table) Service(name string) (config.Service, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range t.sorted {
+		if r.Service.Name == name {
+			return r.Service, true
+		}
+	}
+	return config.Service{}, false
+}
+
+// Routes returns a snapshot of all dynamic routes in precedence order.
+func (t *Table) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]Route(nil), t.sorted...)
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/banking/api-gateway/internal/health"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/proxy"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (s *Server) setupAdminRoutes() error {
+	if !s.adminEnabled() {
+		s.logger.Warn("Admin API key not configured, admin endpoints disabled")
+		return nil
+	}
+
+	// Shared or operator keys and IdP operator tokens, with per-endpoint roles
+	auth, err := middleware.NewAdminAuth(s.cfg.Admin, s.logger)
+	if err != nil {
+		return err
+	}
+	auth.Start(s.background)
+	s.adminAuth = auth
+
+	admin := s.echo.Group("/admin")
+	admin.Use(auth.Handle)
+
+	// Two-person approval of destructive operations
+	if s.cfg.Admin.Approvals.Enabled {
+		s.approvals = middleware.NewApprovals(s.cfg.Admin.Approvals, s.redisClient, s.logger)
+		admin.Use(s.approvals.Require)
+		s.setupApprovalRoutes(admin)
+	}
+
+	admin.GET("/traffic", s.handleTrafficReport)
+	admin.GET("/usage", s.handleUsageReport)
+
+	admin.GET("/readonly", s.handleReadOnlyStatus)
+	admin.PUT("/readonly/:target", s.handleReadOnlyEnable)
+	admin.DELETE("/readonly/:target", s.handleReadOnlyDisable)
+
+	admin.GET("/shield", s.handleShieldStatus)
+	admin.PUT("/shield", s.handleShieldEnable)
+	admin.DELETE("/shield", s.handleShieldDisable)
+
+	if s.fingerprint != nil {
+		s.setupFingerprintRoutes(admin)
+	}
+
+	admin.POST("/config/reload", s.handleConfigReload)
+	admin.GET("/config/changes", s.handleConfigChanges)
+
+	admin.GET("/migrations", s.handleMigrations)
+	admin.PUT("/migrations/:name", s.handleMigrationSet)
+	admin.DELETE("/migrations/:name", s.handleMigrationReset)
+
+	admin.GET("/upstreams", s.handleUpstreams)
+	admin.GET("/weights", s.handleWeights)
+	admin.PUT("/weights/:service", s.handleWeightsSet)
+	admin.DELETE("/weights/:service", s.handleWeightsReset)
+
+	// API products and their subscribers
+	s.setupProductRoutes(admin)
+
+	admin.GET("/routes", s.handleRoutes)
+	admin.GET("/routes/resolved", s.handleResolvedRoutes)
+	admin.GET("/routes/lint", s.handleRouteLint)
+	admin.POST("/trace", s.handleTrace)
+	admin.POST("/simulate", s.handleSimulate)
+
+	// Synthetic traffic to soft-launched routes
+	if s.cfg.DarkTraffic.Enabled {
+		s.setupDarkTrafficRoutes(admin)
+	}
+
+	admin.GET("/requests/:id/events", s.handleRequestEvents)
+	admin.GET("/events/:stream", s.handleEventStream)
+
+	// Per-client rate limit counters, past rejections and reset
+	s.setupRateLimitRoutes(admin)
+
+	admin.GET("/clock", s.handleClockStatus)
+
+	admin.GET("/inspections", s.handleInspections)
+	admin.DELETE("/inspections", s.handleInspectionsClear)
+	admin.PUT("/inspection", s.handleInspectionSampling)
+
+	admin.GET("/explain", s.handleExplainStatus)
+	admin.PUT("/explain", s.handleExplainEnable)
+	admin.DELETE("/explain", s.handleExplainDisable)
+
+	if s.store != nil {
+		s.setupStoreRoutes(admin)
+	}
+
+	// Erasure of a user's data, with a report for the DPO
+	admin.POST("/user-data/purge", s.handleUserDataPurge)
+	return nil
+}
+
+// adminEnabled reports whether any admin credential is configured.
+func (s *Server) adminEnabled() bool {
+	return s.cfg.Admin.APIKey != "" || len(s.cfg.Admin.Operators) > 0 || s.cfg.Admin.SSO.Enabled
+}
+
+// adminID returns the identity of the authenticated operator.
+func adminID(c echo.Context) string {
+	id, _ := c.Get("admin_id").(string)
+	return id
+}
+
+// handleTrafficReport returns top talkers, erroring routes, rate-limit hot keys
+// and largest payloads over the last ?minutes= minutes.
+func (s *Server) handleTrafficReport(c echo.Context) error {
+	window := s.cfg.Traffic.Retention
+	if raw := c.QueryParam("minutes"); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "minutes must be a positive integer"})
+		}
+		window = time.Duration(minutes) * time.Minute
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	return c.JSON(http.StatusOK, s.traffic.Report(window, limit))
+}
+
+// handleUsageReport returns per-client endpoint and scope usage over the last
+// ?days= days, including unused scopes and deprecated endpoint callers.
+func (s *Server) handleUsageReport(c echo.Context) error {
+	if s.usage == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Usage analytics disabled"})
+	}
+
+	days := 7
+	if raw := c.QueryParam("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 90 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 90"})
+		}
+		days = n
+	}
+
+	report, err := s.usage.Report(c.Request().Context(), days)
+	if err != nil {
+		s.logger.Error("Failed to build usage report", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build usage report"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func (s *Server) handleReadOnlyStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.readOnly.States())
+}
+
+func (s *Server) readOnlyTarget(c echo.Context) (string, bool) {
+	target := c.Param("target")
+	if target == "global" {
+		return target, true
+	}
+	_, ok := s.cfg.Services[target]
+	return target, ok
+}
+
+// handleReadOnlyEnable freezes writes globally or for one service.
+func (s *Server) handleReadOnlyEnable(c echo.Context) error {
+	target, ok := s.readOnlyTarget(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown service"})
+	}
+
+	var st middleware.ReadOnlyState
+	if err := c.Bind(&st); err != nil || st.IncidentCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "incident_code is required"})
+	}
+	st.SetBy = adminID(c)
+
+	if err := s.readOnly.Enable(c.Request().Context(), target, st); err != nil {
+		s.logger.Error("Failed to enable read-only mode", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enable read-only mode"})
+	}
+	return c.JSON(http.StatusOK, s.readOnly.States())
+}
+
+func (s *Server) handleReadOnlyDisable(c echo.Context) error {
+	target, ok := s.readOnlyTarget(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown service"})
+	}
+
+	if err := s.readOnly.Disable(c.Request().Context(), target, adminID(c)); err != nil {
+		s.logger.Error("Failed to disable read-only mode", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to disable read-only mode"})
+	}
+	return c.JSON(http.StatusOK, s.readOnly.States())
+}
+
+func (s *Server) handleShieldStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"active": s.shield.State() != nil, "shield": s.shield.State()})
+}
+
+// handleShieldEnable switches the emergency global rate limit on for every
+// replica, at the configured ceiling unless the body sets rps and burst.
+func (s *Server) handleShieldEnable(c echo.Context) error {
+	var st middleware.ShieldState
+	if err := c.Bind(&st); err != nil || st.RPS < 0 || st.Burst < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "rps and burst must be positive numbers"})
+	}
+	st.SetBy = adminID(c)
+
+	st, err := s.shield.Enable(c.Request().Context(), st)
+	if err != nil {
+		s.logger.Error("Failed to enable shield", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enable shield"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"active": true, "shield": st})
+}
+
+func (s *Server) handleShieldDisable(c echo.Context) error {
+	if err := s.shield.Disable(c.Request().Context(), adminID(c)); err != nil {
+		s.logger.Error("Failed to disable shield", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to disable shield"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"active": false})
+}
+
+// handleMigrations lists strangler migration rules and their current share.
+func (s *Server) handleMigrations(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.migrations.Statuses())
+}
+
+// handleMigrationSet changes the share of clients a migration rule sends to
+// its new backend; 0 rolls it back.
+func (s *Server) handleMigrationSet(c echo.Context) error {
+	var body struct {
+		Percent *int `json:"percent"`
+	}
+	if err := c.Bind(&body); err != nil || body.Percent == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "percent is required"})
+	}
+
+	err := s.migrations.SetPercent(c.Request().Context(), c.Param("name"), *body.Percent, adminID(c))
+	switch {
+	case errors.Is(err, proxy.ErrUnknownMigration):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown migration rule"})
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, s.migrations.Statuses())
+}
+
+// handleMigrationReset returns a migration rule to its configured share.
+func (s *Server) handleMigrationReset(c echo.Context) error {
+	err := s.migrations.Reset(c.Request().Context(), c.Param("name"), adminID(c))
+	switch {
+	case errors.Is(err, proxy.ErrUnknownMigration):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown migration rule"})
+	case err != nil:
+		s.logger.Error("Failed to reset migration override", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset migration override"})
+	}
+	return c.JSON(http.StatusOK, s.migrations.Statuses())
+}
+
+// upstreamTarget is a service instance with its last health check, for
+// services with health_check.
+type upstreamTarget struct {
+	proxy.Target
+	HealthCheck *health.InstanceStatus `json:"health_check,omitempty"`
+}
+
+// handleUpstreams lists every instance of the configured services and
+// whether it takes requests.
+func (s *Server) handleUpstreams(c echo.Context) error {
+	checks := make(map[[2]string]health.InstanceStatus)
+	if s.healthCheck != nil {
+		for _, st := range s.healthCheck.Statuses() {
+			checks[[2]string{st.Service, st.Instance}] = st
+		}
+	}
+	targets := s.proxy.Targets()
+	out := make([]upstreamTarget, 0, len(targets))
+	for _, t := range targets {
+		target := upstreamTarget{Target: t}
+		if st, ok := checks[[2]string{t.Service, t.Instance}]; ok {
+			target.HealthCheck = &st
+		}
+		out = append(out, target)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleWeights lists balanced services and the weights of their instances.
+func (s *Server) handleWeights(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.weights.Statuses())
+}
+
+// handleWeightsSet changes the traffic weights of a service's instances, e.g.
+// to dial a canary up or, with 0, drain it.
+func (s *Server) handleWeightsSet(c echo.Context) error {
+	var body struct {
+		Weights map[string]int `json:"weights"`
+	}
+	if err := c.Bind(&body); err != nil || len(body.Weights) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "weights are required"})
+	}
+
+	err := s.weights.Set(c.Request().Context(), c.Param("service"), body.Weights, adminID(c))
+	switch {
+	case errors.Is(err, proxy.ErrNotBalanced):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown or unbalanced service"})
+	case err != nil:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, s.weights.Statuses())
+}
+
+// handleWeightsReset returns a service to its configured weights.
+func (s *Server) handleWeightsReset(c echo.Context) error {
+	err := s.weights.Reset(c.Request().Context(), c.Param("service"), adminID(c))
+	switch {
+	case errors.Is(err, proxy.ErrNotBalanced):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown or unbalanced service"})
+	case err != nil:
+		s.logger.Error("Failed to reset upstream weights", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset upstream weights"})
+	}
+	return c.JSON(http.StatusOK, s.weights.Statuses())
+}
+
+func (s *Server) handleConfigReload(c echo.Context) error {
+	record, err := s.reloader.Reload(adminID(c), "admin-api")
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Configuration reload failed: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, record)
+}
+
+// handleConfigChanges returns the last ?limit= configuration change records.
+func (s *Server) handleConfigChanges(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	return c.JSON(http.StatusOK, s.reloader.Records(limit))
+}
+
+// handleClockStatus returns the last NTP drift check.
+func (s *Server) handleClockStatus(c echo.Context) error {
+	if s.clock == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Clock drift check disabled"})
+	}
+	return c.JSON(http.StatusOK, s.clock.Status())
+}
+
+// handleInspections returns up to ?limit= sampled requests from this replica,
+// newest first, optionally only those of ?user_id=.
+func (s *Server) handleInspections(c echo.Context) error {
+	if s.inspector == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Inspection disabled"})
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"settings": s.inspector.Settings(),
+		"samples":  s.inspector.Samples(limit, c.QueryParam("user_id")),
+	})
+}
+
+func (s *Server) handleInspectionsClear(c echo.Context) error {
+	if s.inspector == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Inspection disabled"})
+	}
+	s.inspector.Clear()
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleInspectionSampling changes the sample rate and client filter on this
+// replica until the next restart.
+func (s *Server) handleInspectionSampling(c echo.Context) error {
+	if s.inspector == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Inspection disabled"})
+	}
+	var settings middleware.InspectionSettings
+	if err := c.Bind(&settings); err != nil || settings.SampleRate < 0 || settings.SampleRate > 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "sample_rate must be between 0 and 1"})
+	}
+	s.inspector.SetSampling(settings, adminID(c))
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (s *Server) handleExplainStatus(c echo.Context) error {
+	on, remaining := s.explain.Active(c.Request().Context())
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"enabled":           on,
+		"remaining_seconds": int(remaining.Seconds()),
+		"key_configured":    s.cfg.Admin.ExplainKey != "",
+	})
+}
+
+// handleExplainEnable switches explain mode on for ?minutes= minutes.
+func (s *Server) handleExplainEnable(c echo.Context) error {
+	if s.cfg.Admin.ExplainKey == "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "admin.explain_key is not configured"})
+	}
+
+	var ttl time.Duration
+	if raw := c.QueryParam("minutes"); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "minutes must be a positive integer"})
+		}
+		ttl = time.Duration(minutes) * time.Minute
+	}
+
+	until, err := s.explain.Enable(c.Request().Context(), ttl, adminID(c))
+	if err != nil {
+		s.logger.Error("Failed to enable explain mode", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enable explain mode"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"enabled": true, "until": until.UTC()})
+}
+
+func (s *Server) handleExplainDisable(c echo.Context) error {
+	if err := s.explain.Disable(c.Request().Context(), adminID(c)); err != nil {
+		s.logger.Error("Failed to disable explain mode", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to disable explain mode"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"enabled": false})
+}
+
+// handleRequestEvents returns every log, audit and error event recorded for a
+// request ID within the request log retention window.
+func (s *Server) handleRequestEvents(c echo.Context) error {
+	if s.requestLog == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Request log disabled"})
+	}
+
+	id := c.Param("id")
+	events, err := s.requestLog.Events(c.Request().Context(), id)
+	if err != nil {
+		s.logger.Error("Failed to read request events", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read request events"})
+	}
+	if len(events) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No events recorded for this request ID"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"request_id": id, "events": events})
+}
+
+// handleEventStream returns the last ?count= entries of an event stream.
+func (s *Server) handleEventStream(c echo.Context) error {
+	if s.events == nil || !s.cfg.Events.Enabled {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Event streams disabled"})
+	}
+
+	count := int64(100)
+	if raw := c.QueryParam("count"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 || n > 1000 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "count must be between 1 and 1000"})
+		}
+		count = n
+	}
+
+	stream := c.Param("stream")
+	if !slices.Contains(s.cfg.Events.Streams, stream) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown event stream"})
+	}
+
+	entries, err := s.events.Read(c.Request().Context(), stream, count)
+	if err != nil {
+		s.logger.Error("Failed to read event stream", zap.String("stream", stream), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read event stream"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"stream": stream, "events": entries})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (s *Server) setupApprovalRoutes(admin *echo.Group) {
+	admin.GET("/approvals", s.handleApprovals)
+	admin.POST("/approvals/:id/approve", s.handleApprovalApprove)
+	admin.DELETE("/approvals/:id", s.handleApprovalReject)
+}
+
+// handleApprovals lists proposals awaiting approval or execution.
+func (s *Server) handleApprovals(c echo.Context) error {
+	approvals, err := s.approvals.List(c.Request().Context())
+	if err != nil {
+		s.logger.Error("Failed to list approvals", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list approvals"})
+	}
+	return c.JSON(http.StatusOK, approvals)
+}
+
+// handleApprovalApprove approves another operator's proposal. The shared key
+// cannot approve, as it does not identify anyone.
+func (s *Server) handleApprovalApprove(c echo.Context) error {
+	if adminID(c) == middleware.SharedAdminID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Approvals need an operator key"})
+	}
+	p, err := s.approvals.Approve(c.Request().Context(), c.Param("id"), adminID(c))
+	switch {
+	case errors.Is(err, middleware.ErrApprovalNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, middleware.ErrSelfApproval), errors.Is(err, middleware.ErrAlreadyApproved):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		s.logger.Error("Failed to approve operation", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to approve operation"})
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// handleApprovalReject withdraws a proposal.
+func (s *Server) handleApprovalReject(c echo.Context) error {
+	err := s.approvals.Reject(c.Request().Context(), c.Param("id"), adminID(c))
+	switch {
+	case errors.Is(err, middleware.ErrApprovalNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		s.logger.Error("Failed to reject operation", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reject operation"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
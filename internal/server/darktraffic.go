@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/traffic"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// defaultReplaySearch is how many of the latest access events a replay
+// searches when the request does not say.
+const defaultReplaySearch = 1000
+
+// darkTrafficRequest starts a run: the requests given, or recorded requests
+// of an existing route replayed against the new one.
+type darkTrafficRequest struct {
+	Route string  `json:"route"`
+	RPS   float64 `json:"rps"`
+	Count int     `json:"count"`
+	// Headers are added to every request, e.g. the token of a test account.
+	Headers  map[string]string     `json:"headers"`
+	Requests []traffic.DarkRequest `json:"requests"`
+	Replay   *darkTrafficReplay    `json:"replay"`
+}
+
+// darkTrafficReplay takes the requests of a run from the access stream. Only
+// GET and HEAD requests are replayed, by path alone: the stream keeps no
+// headers or bodies, and query strings are dropped.
+type darkTrafficReplay struct {
+	FromRoute string `json:"from_route"`
+	// Search is how many of the latest access events are searched.
+	Search int64 `json:"search"`
+}
+
+func (s *Server) setupDarkTrafficRoutes(admin *echo.Group) {
+	s.darkTraffic = traffic.NewDarkTraffic(s.cfg.DarkTraffic, s.echo, s.logger)
+
+	admin.GET("/dark-traffic", s.handleDarkTrafficRuns)
+	admin.POST("/dark-traffic", s.handleDarkTrafficStart)
+	admin.GET("/dark-traffic/:id", s.handleDarkTrafficRun)
+	admin.DELETE("/dark-traffic/:id", s.handleDarkTrafficCancel)
+}
+
+func (s *Server) handleDarkTrafficRuns(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.darkTraffic.Runs())
+}
+
+func (s *Server) handleDarkTrafficRun(c echo.Context) error {
+	run, ok := s.darkTraffic.Run(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Dark traffic run not found"})
+	}
+	return c.JSON(http.StatusOK, run)
+}
+
+func (s *Server) handleDarkTrafficCancel(c echo.Context) error {
+	if err := s.darkTraffic.Cancel(c.Param("id"), adminID(c)); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Dark traffic run not found"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleDarkTrafficStart checks that every request takes the target route
+// before starting the run, so a typo never sends synthetic traffic to a route
+// customers use.
+func (s *Server) handleDarkTrafficStart(c echo.Context) error {
+	var req darkTrafficRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dark traffic request"})
+	}
+	if !strings.HasPrefix(req.Route, "/") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "route must start with /"})
+	}
+
+	spec := traffic.DarkSpec{Route: req.Route, Source: "generated", RPS: req.RPS, Count: req.Count, Requests: req.Requests}
+	if req.Replay != nil {
+		if len(req.Requests) > 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Set requests or replay, not both"})
+		}
+		requests, status, msg := s.replayRequests(c, req.Route, *req.Replay)
+		if status != 0 {
+			return c.JSON(status, map[string]string{"error": msg})
+		}
+		spec.Source, spec.Requests = "replay", requests
+	}
+	if len(spec.Requests) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No requests to send"})
+	}
+
+	for i := range spec.Requests {
+		r := &spec.Requests[i]
+		r.Method = strings.ToUpper(r.Method)
+		if r.Method == "" {
+			r.Method = http.MethodGet
+		}
+		u, err := url.ParseRequestURI(r.Path)
+		if err != nil || !strings.HasPrefix(r.Path, "/") {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Request path " + r.Path + " must start with /"})
+		}
+		if route, _, _, _ := s.matchRoute(r.Method, u.Path); route != req.Route {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": r.Method + " " + u.Path + " takes route " + route + ", not " + req.Route})
+		}
+		headers := make(map[string]string, len(req.Headers)+len(r.Headers))
+		for k, v := range req.Headers {
+			headers[k] = v
+		}
+		for k, v := range r.Headers {
+			headers[k] = v
+		}
+		r.Headers = headers
+	}
+
+	run, err := s.darkTraffic.Start(s.background, spec, adminID(c))
+	if errors.Is(err, traffic.ErrDarkRunning) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A dark traffic run is already sending to this route"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// replayRequests turns the recorded GET and HEAD requests of from_route into
+// requests to route, moving their paths from one route's prefix to the
+// other's. A non-zero status reports why none could be taken.
+func (s *Server) replayRequests(c echo.Context, route string, replay darkTrafficReplay) ([]traffic.DarkRequest, int, string) {
+	if s.events == nil || !s.cfg.Events.Enabled || !slices.Contains(s.cfg.Events.Streams, infrastructure.StreamAccess) {
+		return nil, http.StatusServiceUnavailable, "Replay needs the access event stream"
+	}
+	if replay.Search <= 0 {
+		replay.Search = defaultReplaySearch
+	}
+	if replay.Search > int64(s.cfg.DarkTraffic.MaxRequests) {
+		replay.Search = int64(s.cfg.DarkTraffic.MaxRequests)
+	}
+
+	entries, err := s.events.Read(c.Request().Context(), infrastructure.StreamAccess, replay.Search)
+	if err != nil {
+		s.logger.Error("Failed to read access events for replay", zap.Error(err))
+		return nil, http.StatusInternalServerError, "Failed to read access events"
+	}
+	from := strings.TrimSuffix(replay.FromRoute, "*")
+	to := strings.TrimSuffix(route, "*")
+	var requests []traffic.DarkRequest
+	for _, e := range entries {
+		method, _ := e.Values["method"].(string)
+		uri, _ := e.Values["uri"].(string)
+		if e.Values["route"] != replay.FromRoute || (method != http.MethodGet && method != http.MethodHead) {
+			continue
+		}
+		u, err := url.ParseRequestURI(uri)
+		if err != nil || !strings.HasPrefix(u.Path, from) {
+			continue
+		}
+		requests = append(requests, traffic.DarkRequest{Method: method, Path: to + strings.TrimPrefix(u.Path, from)})
+	}
+	if len(requests) == 0 {
+		return nil, http.StatusBadRequest, "No recorded GET or HEAD requests of " + replay.FromRoute
+	}
+	return requests, 0, ""
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// problem is an RFC 9457 problem details body.
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	Instance   string `json:"instance"`
+	RequestID  string `json:"request_id,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// httpErrorHandler renders unmatched routes and unsupported methods as
+// problem+json and defers everything else to Echo's default handler.
+// Unmatched routes go to the peer gateway when federation is enabled.
+func (s *Server) httpErrorHandler(err error, c echo.Context) {
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || (he.Code != http.StatusNotFound && he.Code != http.StatusMethodNotAllowed) {
+		s.echo.DefaultHTTPErrorHandler(err, c)
+		return
+	}
+	if c.Response().Committed {
+		return
+	}
+
+	req := c.Request()
+	if he.Code == http.StatusNotFound && s.peer != nil && s.peer.Accepts(req.URL.Path) {
+		if err := s.forwardToPeer(c); err != nil {
+			s.logger.Error("Failed to forward to peer gateway", zap.Error(err))
+		}
+		return
+	}
+
+	p := problem{
+		Status:    he.Code,
+		Instance:  req.URL.Path,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+	if he.Code == http.StatusNotFound {
+		p.Type = s.cfg.Server.ProblemBaseURL + "route-not-found"
+		p.Title = "Route not found"
+		p.Detail = "No gateway route matches " + req.Method + " " + req.URL.Path
+		if s.cfg.Server.Environment != "production" {
+			p.Suggestion = s.suggestRoute(req.URL.Path)
+		}
+	} else {
+		p.Type = s.cfg.Server.ProblemBaseURL + "method-not-allowed"
+		p.Title = "Method not allowed"
+		p.Detail = "Method " + req.Method + " is not supported on " + req.URL.Path
+	}
+
+	if req.Method == http.MethodHead {
+		_ = c.NoContent(he.Code)
+		return
+	}
+	body, _ := json.Marshal(p)
+	if err := c.Blob(he.Code, "application/problem+json", body); err != nil {
+		s.logger.Error("Failed to write error response", zap.Error(err))
+	}
+}
+
+// suggestRoute returns the registered route whose static prefix is closest to
+// path by edit distance, or "" if nothing is reasonably close.
+func (s *Server) suggestRoute(path string) string {
+	best, bestDist := "", -1
+	seen := make(map[string]bool)
+	for _, r := range s.echo.Routes() {
+		if seen[r.Path] || r.Method == echo.RouteNotFound || strings.HasPrefix(r.Path, "/admin") {
+			continue
+		}
+		seen[r.Path] = true
+
+		prefix := strings.TrimSuffix(r.Path, "/*")
+		candidate := path
+		if strings.HasSuffix(r.Path, "/*") {
+			candidate = truncateSegments(path, strings.Count(prefix, "/"))
+		}
+
+		d := levenshtein(candidate, prefix)
+		if bestDist == -1 || d < bestDist {
+			best, bestDist = r.Path, d
+		}
+	}
+
+	if bestDist < 0 || bestDist > max(2, len(path)/4) {
+		return ""
+	}
+	return strings.TrimSuffix(best, "*")
+}
+
+func truncateSegments(path string, n int) string {
+	count := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			count++
+			if count > n {
+				return path[:i]
+			}
+		}
+	}
+	return path
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
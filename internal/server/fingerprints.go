@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (s *Server) setupFingerprintRoutes(admin *echo.Group) {
+	admin.GET("/tls-fingerprints", s.handleFingerprintDenylist)
+	admin.PUT("/tls-fingerprints/:fingerprint", s.handleFingerprintDeny)
+	admin.DELETE("/tls-fingerprints/:fingerprint", s.handleFingerprintAllow)
+}
+
+func (s *Server) handleFingerprintDenylist(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"denylist": s.fingerprint.Denylist()})
+}
+
+// handleFingerprintDeny blocks clients with a JA3 or JA4 fingerprint, or
+// asks upstreams to step them up, on every replica.
+func (s *Server) handleFingerprintDeny(c echo.Context) error {
+	var entry middleware.DeniedFingerprint
+	if err := c.Bind(&entry); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid denylist entry"})
+	}
+	entry.Fingerprint = c.Param("fingerprint")
+	if entry.Action == "" {
+		entry.Action = middleware.FingerprintBlock
+	}
+	if !middleware.ValidFingerprint(entry.Fingerprint) || (entry.Action != middleware.FingerprintBlock && entry.Action != middleware.FingerprintStepUp) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A JA3 or JA4 fingerprint and an action of block or step_up are required"})
+	}
+	entry.SetBy = adminID(c)
+
+	entry, err := s.fingerprint.Deny(c.Request().Context(), entry)
+	if err != nil {
+		s.logger.Error("Failed to update TLS fingerprint denylist", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update denylist"})
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+func (s *Server) handleFingerprintAllow(c echo.Context) error {
+	found, err := s.fingerprint.Allow(c.Request().Context(), c.Param("fingerprint"), adminID(c))
+	if err != nil {
+		s.logger.Error("Failed to update TLS fingerprint denylist", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update denylist"})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Fingerprint is not on the denylist"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
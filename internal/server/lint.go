@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Route lint modes.
+const (
+	lintFail = "fail"
+	lintWarn = "warn"
+	lintOff  = "off"
+)
+
+// lintRoutes runs the route linter, failing startup in "fail" mode.
+func (s *Server) lintRoutes() error {
+	mode := s.cfg.Security.RouteLint.Mode
+	switch mode {
+	case lintOff:
+		return nil
+	case "", lintFail, lintWarn:
+	default:
+		return fmt.Errorf("security.route_lint.mode must be %q, %q or %q, got %q", lintFail, lintWarn, lintOff, mode)
+	}
+
+	issues := s.routeIssues()
+	for _, issue := range issues {
+		s.logger.Error("Route lint", zap.String("issue", issue))
+	}
+	if len(issues) > 0 && mode != lintWarn {
+		return fmt.Errorf("route lint found %d issue(s): %s", len(issues), strings.Join(issues, "; "))
+	}
+	return nil
+}
+
+// lintDynamicRoute holds a route published at runtime by Kubernetes, xDS or
+// the registry to the linter's checks. In "fail" mode a route with issues is
+// kept out of the routing table.
+func (s *Server) lintDynamicRoute(r routing.Route) error {
+	mode := s.cfg.Security.RouteLint.Mode
+	if mode == lintOff {
+		return nil
+	}
+	issues := s.planIssues(r.Prefix+"/*", r.Auth != routing.AuthPublic, r.RateLimit)
+	for _, issue := range issues {
+		s.logger.Error("Route lint", zap.String("issue", issue), zap.String("source", r.Source))
+	}
+	if len(issues) > 0 && mode != lintWarn {
+		return fmt.Errorf("route lint: %s", strings.Join(issues, "; "))
+	}
+	return nil
+}
+
+// planIssues checks one route's auth and rate limit policy.
+func (s *Server) planIssues(path string, auth bool, rateLimit string) []string {
+	var issues []string
+	if !auth && !slices.Contains(s.cfg.Security.RouteLint.PublicRoutes, path) {
+		issues = append(issues, fmt.Sprintf("%s: no auth middleware and not listed in security.route_lint.public_routes", path))
+	}
+	if rateLimit != "" && !slices.Contains(middleware.RateLimitPolicies(s.cfg), rateLimit) {
+		issues = append(issues, fmt.Sprintf("%s: rate limit policy %q is not defined", path, rateLimit))
+	}
+	return issues
+}
+
+// routeIssues walks the resolved routing table for proxied routes without
+// auth that are not declared public, rate limit policies that do not exist,
+// and routes shadowed by one that takes precedence.
+func (s *Server) routeIssues() []string {
+	var issues []string
+	for _, r := range s.ResolvedRoutes() {
+		if r.Source == "" {
+			issues = append(issues, fmt.Sprintf("%s: no route plan, auth cannot be verified", r.Path))
+			continue
+		}
+		issues = append(issues, s.planIssues(r.Path, r.Auth, r.RateLimit)...)
+		if r.ShadowedBy != "" {
+			kind := "route"
+			if r.Auth {
+				kind = "secured route"
+			}
+			issues = append(issues, fmt.Sprintf("%s shadows %s %s (from %s)", r.ShadowedBy, kind, r.Path, r.Source))
+		}
+	}
+	return issues
+}
+
+// handleRouteLint re-runs the route linter, e.g. after discovery syncs.
+func (s *Server) handleRouteLint(c echo.Context) error {
+	issues := s.routeIssues()
+	if issues == nil {
+		issues = []string{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"mode":   s.cfg.Security.RouteLint.Mode,
+		"issues": issues,
+	})
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/mesh"
+)
+
+// serveMesh serves a request from a peer gateway on the mesh port like any
+// other, marked with the identity the peer's certificate was verified for so
+// MeshGuard trusts its gateway path.
+func (s *Server) serveMesh(w http.ResponseWriter, r *http.Request) {
+	if peer, ok := mesh.PeerOf(r.TLS); ok {
+		r = r.WithContext(mesh.WithPeer(r.Context(), peer))
+	}
+	s.echo.ServeHTTP(w, r)
+}
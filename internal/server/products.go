@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (s *Server) setupProductRoutes(admin *echo.Group) {
+	admin.GET("/products", s.handleProducts)
+	admin.GET("/products/:name/usage", s.handleProductUsage)
+	admin.PUT("/products/:name/subscribers/:client", s.handleProductSubscribe)
+	admin.DELETE("/products/:name/subscribers/:client", s.handleProductUnsubscribe)
+}
+
+// handleProducts lists the API products with their current subscribers.
+func (s *Server) handleProducts(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.products.Catalog())
+}
+
+// handleProductUsage returns each subscriber's requests to a product in its
+// current quota period, for billing and quota reviews.
+func (s *Server) handleProductUsage(c echo.Context) error {
+	usage, err := s.products.Usage(c.Request().Context(), c.Param("name"))
+	switch {
+	case errors.Is(err, middleware.ErrUnknownProduct):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown product"})
+	case err != nil:
+		s.logger.Error("Failed to read product usage", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Product usage unavailable"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"product": c.Param("name"),
+		"usage":   usage,
+	})
+}
+
+// handleProductSubscribe subscribes a client, e.g. the client_id of an API
+// key, to a product.
+func (s *Server) handleProductSubscribe(c echo.Context) error {
+	return s.changeSubscription(c, s.products.Subscribe)
+}
+
+// handleProductUnsubscribe ends a client's subscription to a product,
+// including one from configuration.
+func (s *Server) handleProductUnsubscribe(c echo.Context) error {
+	return s.changeSubscription(c, s.products.Unsubscribe)
+}
+
+func (s *Server) changeSubscription(c echo.Context, change func(ctx context.Context, name, client, by string) error) error {
+	err := change(c.Request().Context(), c.Param("name"), c.Param("client"), adminID(c))
+	switch {
+	case errors.Is(err, middleware.ErrUnknownProduct):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown product"})
+	case err != nil:
+		s.logger.Error("Failed to change product subscription", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to change product subscription"})
+	}
+	return c.JSON(http.StatusOK, s.products.Catalog())
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Actions taken on the data of one store in a purge.
+const (
+	purgeDeleted    = "deleted"
+	purgeAnonymized = "anonymized"
+	purgeRetained   = "retained"
+	purgeNoneHeld   = "none_held"
+	purgeFailed     = "failed"
+)
+
+// PurgeReport records what a purge found and did with a user's data, store
+// by store, for the data protection officer.
+type PurgeReport struct {
+	ID          string        `json:"id"`
+	UserID      string        `json:"user_id"`
+	RequestedBy string        `json:"requested_by"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	Complete    bool          `json:"complete"`
+	Records     []PurgeRecord `json:"records"`
+}
+
+// PurgeRecord is the outcome of a purge in one store.
+type PurgeRecord struct {
+	Store  string `json:"store"`
+	Action string `json:"action"`
+	Count  int    `json:"count"`
+	Note   string `json:"note,omitempty"`
+}
+
+func (r *PurgeReport) add(store, action string, count int, note string) {
+	r.Records = append(r.Records, PurgeRecord{Store: store, Action: action, Count: count, Note: note})
+}
+
+// addResult records a deletion or anonymization, or its failure.
+func (r *PurgeReport) addResult(store, action string, count int, note string, err error) {
+	if err != nil {
+		r.add(store, purgeFailed, count, err.Error())
+		return
+	}
+	if count == 0 {
+		action = purgeNoneHeld
+	}
+	r.add(store, action, count, note)
+}
+
+// handleUserDataPurge erases the data the gateway holds on a user, given in
+// {"user_id": ...} rather than the path so the ID stays out of access logs,
+// and returns a report of every store it covered. Stores that fail are
+// reported and the purge can be repeated.
+func (s *Server) handleUserDataPurge(c echo.Context) error {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.Bind(&req); err != nil || req.UserID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user_id is required"})
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	report := &PurgeReport{
+		ID:          hex.EncodeToString(id),
+		UserID:      req.UserID,
+		RequestedBy: adminID(c),
+		StartedAt:   time.Now().UTC(),
+	}
+	s.purgeUserData(c.Request().Context(), req.UserID, report)
+	report.CompletedAt = time.Now().UTC()
+	report.Complete = !slices.ContainsFunc(report.Records, func(r PurgeRecord) bool { return r.Action == purgeFailed })
+
+	counts := make(map[string]int, len(report.Records))
+	for _, r := range report.Records {
+		counts[r.Store+"."+r.Action] = r.Count
+	}
+	s.logger.Named("audit").Info("User data purged",
+		zap.String("event", "user_data_purge"),
+		zap.String("purge_id", report.ID),
+		zap.String("subject", req.UserID),
+		zap.Bool("complete", report.Complete),
+		zap.Any("records", counts),
+		zap.String("by", report.RequestedBy),
+	)
+
+	status := http.StatusOK
+	if !report.Complete {
+		status = http.StatusInternalServerError
+	}
+	return c.JSON(status, report)
+}
+
+// purgeUserData deletes or anonymizes userID's data in every store and
+// records the outcome in report.
+func (s *Server) purgeUserData(ctx context.Context, userID string, report *PurgeReport) {
+	if s.store == nil {
+		report.add("sessions", purgeNoneHeld, 0, "store not configured")
+		report.add("consents", purgeNoneHeld, 0, "store not configured")
+	} else {
+		ids, err := s.store.Sessions.DeleteBySubject(ctx, userID)
+		report.addResult("sessions", purgeDeleted, len(ids), "", err)
+		ids, err = s.store.Consents.AnonymizeSubject(ctx, userID, time.Now())
+		report.addResult("consents", purgeAnonymized, len(ids), "kept without the subject as a record of what was granted, and revoked", err)
+	}
+
+	if s.pipeline == nil || s.pipeline.rateLimiter == nil {
+		report.add("rate_limits", purgeNoneHeld, 0, "rate limiting disabled")
+	} else {
+		cleared, err := s.pipeline.rateLimiter.Reset(ctx, middleware.ScopeUser, userID, s.rateLimitedRoutes("", ""))
+		report.addResult("rate_limits", purgeDeleted, cleared, "counters are keyed by an HMAC of the user ID; those from before the last key rotation expire with their window", err)
+	}
+	if s.redisClient != nil {
+		// The raw ID covers markers written before they were keyed by an HMAC
+		forgot, err := s.redisClient.ForgetFirstSeen(ctx, middleware.FirstSeenIdentity(s.cfg.RateLimits.KeySecret, userID), userID)
+		report.addResult("client_first_seen", purgeDeleted, forgot, "", err)
+	}
+
+	report.add("idempotency", purgeNoneHeld, 0, "the gateway keeps no idempotency records; services keep their own")
+
+	if s.responseCache != nil {
+		purged, err := s.responseCache.PurgeUser(ctx, userID)
+		note := "this replica's memory only; others' age out with their route's TTL"
+		if s.redisClient != nil {
+			note = "other replicas are told to drop theirs from memory"
+		}
+		report.addResult("cached_responses", purgeDeleted, purged, note, err)
+	}
+
+	s.purgeEvents(ctx, userID, report)
+
+	if s.inspector != nil {
+		report.addResult("inspection_samples", purgeDeleted, s.inspector.Forget(userID), "this replica only; samples on others age out of their buffers", nil)
+	}
+}
+
+// purgeEvents deletes the user's access events, which point from the user
+// to their requests, and the per-request logs they point to. Audit and error
+// events are kept as the security record and reported.
+func (s *Server) purgeEvents(ctx context.Context, userID string, report *PurgeReport) {
+	if s.events == nil {
+		report.add("access_events", purgeNoneHeld, 0, "event streams disabled")
+		return
+	}
+
+	access := s.cfg.Events.Enabled && slices.Contains(s.cfg.Events.Streams, infrastructure.StreamAccess)
+	var entryIDs, requestIDs []string
+	if access {
+		err := s.events.Scan(ctx, infrastructure.StreamAccess, func(e infrastructure.StreamEntry) {
+			if fmt.Sprint(e.Values["user_id"]) == userID {
+				entryIDs = append(entryIDs, e.ID)
+				requestIDs = append(requestIDs, fmt.Sprint(e.Values["request_id"]))
+			}
+		})
+		var deleted int64
+		if err == nil {
+			deleted, err = s.events.Delete(ctx, infrastructure.StreamAccess, entryIDs...)
+		}
+		report.addResult("access_events", purgeDeleted, int(deleted), "", err)
+	} else {
+		report.add("access_events", purgeNoneHeld, 0, "access event stream disabled")
+	}
+
+	if s.requestLog != nil {
+		note := "logs of the requests found in the access events"
+		if !access {
+			note = "access event stream disabled, so requests cannot be traced to the user; logs expire after " + s.cfg.RequestLog.Retention.String()
+		}
+		deleted, err := s.requestLog.Forget(ctx, requestIDs...)
+		report.addResult("request_logs", purgeDeleted, int(deleted), note, err)
+	}
+
+	for _, stream := range []string{infrastructure.StreamAudit, infrastructure.StreamError} {
+		if !s.cfg.Events.Enabled || !slices.Contains(s.cfg.Events.Streams, stream) {
+			continue
+		}
+		held := 0
+		err := s.events.Scan(ctx, stream, func(e infrastructure.StreamEntry) {
+			var fields map[string]interface{}
+			if json.Unmarshal([]byte(fmt.Sprint(e.Values["fields"])), &fields) == nil && fmt.Sprint(fields["user_id"]) == userID {
+				held++
+			}
+		})
+		if err != nil {
+			report.add(stream+"_events", purgeFailed, held, err.Error())
+			continue
+		}
+		if held == 0 {
+			report.add(stream+"_events", purgeNoneHeld, 0, "")
+			continue
+		}
+		report.add(stream+"_events", purgeRetained, held, "kept as the security record until the stream expires after "+s.cfg.Events.Retention.String())
+	}
+}
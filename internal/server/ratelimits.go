@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// maxRejectionScan bounds the access events searched for past rejections.
+const maxRejectionScan = 10000
+
+// Rejection is a past 429 answer to a client, from the access event stream.
+type Rejection struct {
+	Time      string `json:"time"`
+	Route     string `json:"route"`
+	Method    string `json:"method"`
+	URI       string `json:"uri"`
+	RequestID string `json:"request_id"`
+}
+
+func (s *Server) setupRateLimitRoutes(admin *echo.Group) {
+	admin.GET("/ratelimits", s.handleRateLimitState)
+	admin.DELETE("/ratelimits", s.handleRateLimitReset)
+}
+
+// rateLimitClient reads the client of a rate-limit request: ?user= or ?ip=.
+func rateLimitClient(c echo.Context) (scope, identity string, err error) {
+	user, ip := c.QueryParam("user"), c.QueryParam("ip")
+	switch {
+	case (user == "") == (ip == ""):
+		return "", "", fmt.Errorf("exactly one of user or ip is required")
+	case user != "":
+		return middleware.ScopeUser, user, nil
+	default:
+		return middleware.ScopeIP, ip, nil
+	}
+}
+
+// limitedRoutes maps the routes with a rate limit policy to it, narrowed by
+// ?route= and ?policy=.
+func (s *Server) limitedRoutes(c echo.Context) map[string]string {
+	return s.rateLimitedRoutes(c.QueryParam("route"), c.QueryParam("policy"))
+}
+
+// rateLimitedRoutes maps the routes with a rate limit policy to it, narrowed
+// to route and policy when they are set.
+func (s *Server) rateLimitedRoutes(route, policy string) map[string]string {
+	routes := make(map[string]string)
+	for _, r := range s.ResolvedRoutes() {
+		if r.RateLimit == "" || r.RateLimit == "none" ||
+			(route != "" && r.Path != route) || (policy != "" && r.RateLimit != policy) {
+			continue
+		}
+		routes[r.Path] = r.RateLimit
+	}
+	return routes
+}
+
+// handleRateLimitState returns a client's counters on every rate-limited
+// route (count or tokens in use, capacity, time to reset) and its 429s among
+// the last ?scan= access events, so support can tell why it is blocked.
+func (s *Server) handleRateLimitState(c echo.Context) error {
+	if s.pipeline == nil || s.pipeline.rateLimiter == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Rate limiting disabled"})
+	}
+	scope, identity, err := rateLimitClient(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	scan := int64(1000)
+	if raw := c.QueryParam("scan"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 || n > maxRejectionScan {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("scan must be between 1 and %d", maxRejectionScan)})
+		}
+		scan = n
+	}
+
+	ctx := c.Request().Context()
+	limits, err := s.pipeline.rateLimiter.Inspect(ctx, scope, identity, s.limitedRoutes(c))
+	if err != nil {
+		s.logger.Error("Failed to read rate limit state", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read rate limit state"})
+	}
+	resp := map[string]interface{}{
+		"scope":    scope,
+		"identity": identity,
+		"limits":   limits,
+	}
+
+	if s.events == nil || !s.cfg.Events.Enabled || !slices.Contains(s.cfg.Events.Streams, infrastructure.StreamAccess) {
+		resp["rejections_note"] = "access event stream disabled, rejection history unavailable"
+		return c.JSON(http.StatusOK, resp)
+	}
+	entries, err := s.events.Read(ctx, infrastructure.StreamAccess, scan)
+	if err != nil {
+		s.logger.Error("Failed to read event stream", zap.String("stream", infrastructure.StreamAccess), zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read event stream"})
+	}
+	field := "ip"
+	if scope == middleware.ScopeUser {
+		field = "user_id"
+	}
+	rejections := []Rejection{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		v := entries[i].Values
+		if fmt.Sprint(v["status"]) != strconv.Itoa(http.StatusTooManyRequests) || fmt.Sprint(v[field]) != identity {
+			continue
+		}
+		rejections = append(rejections, Rejection{
+			Time:      fmt.Sprint(v["time"]),
+			Route:     fmt.Sprint(v["route"]),
+			Method:    fmt.Sprint(v["method"]),
+			URI:       fmt.Sprint(v["uri"]),
+			RequestID: fmt.Sprint(v["request_id"]),
+		})
+	}
+	resp["rejections"] = rejections
+	resp["events_scanned"] = len(entries)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleRateLimitReset clears a client's counters, on all rate-limited routes
+// or those matching ?route= and ?policy=.
+func (s *Server) handleRateLimitReset(c echo.Context) error {
+	if s.pipeline == nil || s.pipeline.rateLimiter == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Rate limiting disabled"})
+	}
+	scope, identity, err := rateLimitClient(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	cleared, err := s.pipeline.rateLimiter.Reset(c.Request().Context(), scope, identity, s.limitedRoutes(c))
+	if err != nil {
+		s.logger.Error("Failed to reset rate limits", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset rate limits"})
+	}
+	s.logger.Named("audit").Info("Rate limits reset",
+		zap.String("scope", scope),
+		zap.String("identity", identity),
+		zap.String("route", c.QueryParam("route")),
+		zap.String("policy", c.QueryParam("policy")),
+		zap.Int("cleared", cleared),
+		zap.String("by", adminID(c)),
+	)
+	return c.JSON(http.StatusOK, map[string]interface{}{"scope": scope, "identity": identity, "cleared": cleared})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/discovery"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+// setupRegistry loads registered services and routes and builds the registry
+// admin API, served on its own port by Start. Callers authenticate and are
+// authorized like the admin API, and guarded operations need approval.
+func (s *Server) setupRegistry() {
+	s.registry = discovery.NewRegistry(s.cfg, s.routes, s.redisClient, s.logger)
+	s.registry.Start(s.background, switchRefreshInterval)
+
+	if s.adminAuth == nil {
+		// Load rejects the registry without admin credentials
+		return
+	}
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.JSONSerializer = jsonutil.Serializer{}
+	e.Use(echoMiddleware.Recover())
+	e.Use(echoMiddleware.RequestID())
+	e.Use(echoMiddleware.BodyLimit("64K"))
+
+	api := e.Group("/admin/registry")
+	api.Use(s.adminAuth.Handle)
+	if s.approvals != nil {
+		api.Use(s.approvals.Require)
+	}
+	api.GET("/services", s.handleRegistryServices)
+	api.PUT("/services/:name", s.handleRegistryServicePut)
+	api.DELETE("/services/:name", s.handleRegistryServiceDelete)
+	api.GET("/routes", s.handleRegistryRoutes)
+	api.PUT("/routes/*", s.handleRegistryRoutePut)
+	api.DELETE("/routes/*", s.handleRegistryRouteDelete)
+	s.registryAPI = e
+}
+
+// registryError answers a failed registry change.
+func (s *Server) registryError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, discovery.ErrNotRegistered):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Not registered"})
+	case errors.Is(err, discovery.ErrServiceInUse):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, discovery.ErrInvalidService), errors.Is(err, discovery.ErrInvalidRoute):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	s.logger.Error("Registry update failed", zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Registry update failed"})
+}
+
+func (s *Server) handleRegistryServices(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"services": s.registry.Services()})
+}
+
+// handleRegistryServicePut adds or updates the service named in the path.
+func (s *Server) handleRegistryServicePut(c echo.Context) error {
+	var svc discovery.RegisteredService
+	if err := c.Bind(&svc); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid service"})
+	}
+	svc.Name = c.Param("name")
+	svc, err := s.registry.PutService(c.Request().Context(), svc, adminID(c))
+	if err != nil {
+		return s.registryError(c, err)
+	}
+	return c.JSON(http.StatusOK, svc)
+}
+
+func (s *Server) handleRegistryServiceDelete(c echo.Context) error {
+	if err := s.registry.DeleteService(c.Request().Context(), c.Param("name"), adminID(c)); err != nil {
+		return s.registryError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) handleRegistryRoutes(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"routes": s.registry.Routes()})
+}
+
+// handleRegistryRoutePut adds or updates the route for the prefix in the
+// path, e.g. PUT /admin/registry/routes/api/payments. Prefixes under a
+// static route are refused: static routes always win, so it would never
+// match.
+func (s *Server) handleRegistryRoutePut(c echo.Context) error {
+	var route discovery.RegisteredRoute
+	if err := c.Bind(&route); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid route"})
+	}
+	route.Prefix = strings.TrimSuffix("/"+c.Param("*"), "/")
+	for path := range s.plans {
+		if p := strings.TrimSuffix(path, "/*"); route.Prefix == p || strings.HasPrefix(route.Prefix, p+"/") {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Prefix is served by static route " + path})
+		}
+	}
+
+	route, err := s.registry.PutRoute(c.Request().Context(), route, adminID(c))
+	if err != nil {
+		return s.registryError(c, err)
+	}
+	return c.JSON(http.StatusOK, route)
+}
+
+func (s *Server) handleRegistryRouteDelete(c echo.Context) error {
+	if err := s.registry.DeleteRoute(c.Request().Context(), "/"+c.Param("*"), adminID(c)); err != nil {
+		return s.registryError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
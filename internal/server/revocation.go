@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// maxRevocationBody bounds revocation webhook payloads.
+const maxRevocationBody = 64 << 10
+
+// handleRevocationWebhook accepts a logout/revocation event from the auth
+// service, signed with HMAC-SHA256 of the body in X-Signature-SHA256, and
+// blacklists the token or subject on every replica.
+func (s *Server) handleRevocationWebhook(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxRevocationBody))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unable to read request body"})
+	}
+
+	signature, err := hex.DecodeString(c.Request().Header.Get("X-Signature-SHA256"))
+	mac := hmac.New(sha256.New, []byte(s.cfg.Security.Revocation.WebhookSecret))
+	mac.Write(body)
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		s.logger.Warn("Rejected revocation webhook with invalid signature", zap.String("ip", c.RealIP()))
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid signature"})
+	}
+
+	var ev middleware.Revocation
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Malformed revocation event"})
+	}
+	if err := ev.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := s.revocations.Revoke(c.Request().Context(), ev, "webhook"); err != nil {
+		s.logger.Error("Failed to record revocation", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record revocation"})
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// handleRevocationList serves the exported revocation list to backends, with
+// its detached JWS in X-Signature-JWS, verifiable against
+// /.well-known/jwks.json.
+func (s *Server) handleRevocationList(c echo.Context) error {
+	if !s.revocations.MayFetch(c.Request().RemoteAddr) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+	}
+	list := s.revocations.List()
+	if list == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Revocation list not exported yet"})
+	}
+
+	h := c.Response().Header()
+	h.Set("ETag", list.ETag)
+	h.Set("Cache-Control", "max-age="+strconv.Itoa(int(s.cfg.Security.Revocation.Export.Interval.Seconds())))
+	h.Set("X-Signature-JWS", list.JWS)
+	if c.Request().Header.Get("If-None-Match") == list.ETag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, list.Body)
+}
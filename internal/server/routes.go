@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/labstack/echo/v4"
+)
+
+// dynamicRouting reports whether any source registers routes at runtime.
+func (s *Server) dynamicRouting() bool {
+	return s.cfg.Kubernetes.Controller || s.cfg.XDS.Enabled || s.registry != nil
+}
+
+// usesDiscovery reports whether any service resolves its instances with the
+// given discovery kind.
+func (s *Server) usesDiscovery(kind string) bool {
+	for _, svc := range s.cfg.Services {
+		if svc.Discovery == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// usesProtocol reports whether any service is reached over protocol.
+func (s *Server) usesProtocol(protocol string) bool {
+	for _, svc := range s.cfg.Services {
+		if svc.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// usesTranscoding reports whether any service has JSON routes transcoded to
+// gRPC.
+func (s *Server) usesTranscoding() bool {
+	for _, svc := range s.cfg.Services {
+		if len(svc.Transcode) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// usesHealthChecks reports whether any service has active health checks.
+func (s *Server) usesHealthChecks() bool {
+	for _, svc := range s.cfg.Services {
+		if svc.HealthCheck.Type != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// dynamicRouteHandler serves /api paths not matched by a static route from the
+// runtime route table, applying the auth and rate-limit policy each route was
+// registered with, and the API product policy of products listing its prefix.
+func (s *Server) dynamicRouteHandler(auth *middleware.AuthMiddleware, rateLimiter *middleware.RateLimiter, serviceMiddleware func(string) []echo.MiddlewareFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route, ok := s.routes.Lookup(c.Request().URL.Path)
+		if !ok {
+			return s.forwardToPeer(c)
+		}
+
+		// Rate limit keys, usage and traffic reports use the route pattern
+		c.SetPath(route.Prefix + "/*")
+
+		var chain []echo.MiddlewareFunc
+		if route.Auth != routing.AuthPublic {
+			chain = append(chain, auth.ValidateToken)
+			if s.concurrency != nil {
+				chain = append(chain, s.concurrency.PerClient)
+			}
+			if s.usage != nil {
+				chain = append(chain, middleware.UsageRecorder(s.usage))
+			}
+			chain = append(chain, s.products.Handle)
+		}
+		if rateLimiter != nil {
+			if limit := rateLimiter.ForPolicy(route.RateLimit); limit != nil {
+				chain = append(chain, limit)
+			}
+		}
+		chain = append(chain, serviceMiddleware(route.Service.Name)...)
+
+		h := s.proxy.Handle(route.Service.Name)
+		for i := len(chain) - 1; i >= 0; i-- {
+			h = chain[i](h)
+		}
+		return h(c)
+	}
+}
+
+// forwardToPeer sends a request matching no local route to the peer gateway
+// when federation is enabled.
+func (s *Server) forwardToPeer(c echo.Context) error {
+	if s.peer == nil || !s.peer.Accepts(c.Request().URL.Path) {
+		return echo.ErrNotFound
+	}
+	return s.peer.Forward(c)
+}
+
+// handleRoutes lists routes registered at runtime by discovery sources.
+func (s *Server) handleRoutes(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"routes": s.routes.Routes()})
+}
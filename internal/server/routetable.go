@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/labstack/echo/v4"
+)
+
+// sourceStatic labels routes wired in setupRoutes.
+const sourceStatic = "static"
+
+// ResolvedRoute is one proxied route of the effective routing table.
+type ResolvedRoute struct {
+	Path      string `json:"path"`
+	Source    string `json:"source"`
+	Service   string `json:"service"`
+	Auth      bool   `json:"auth"`
+	RateLimit string `json:"rate_limit,omitempty"`
+	Priority  int    `json:"priority,omitempty"`
+	// ShadowedBy is the route that takes every request this one would match.
+	ShadowedBy string `json:"shadowed_by,omitempty"`
+}
+
+// prefix is the path a wildcard route matches below.
+func (r ResolvedRoute) prefix() string {
+	return strings.TrimSuffix(strings.TrimSuffix(r.Path, "*"), "/")
+}
+
+// ResolvedRoutes returns the proxied routes in the order requests are
+// matched: static routes first, most specific first as Echo matches them,
+// then dynamic routes in table precedence (priority, then longest prefix).
+// Routes without a plan are returned with an empty Source.
+func (s *Server) ResolvedRoutes() []ResolvedRoute {
+	var static []ResolvedRoute
+	seen := make(map[string]bool)
+	for _, r := range s.echo.Routes() {
+		if r.Method == echo.RouteNotFound || !strings.HasPrefix(r.Path, "/api/") || seen[r.Path] {
+			continue
+		}
+		seen[r.Path] = true
+		if r.Path == "/api/*" && (s.dynamicRouting() || s.peer != nil) {
+			// Dispatcher for dynamic routes (listed below) and the peer gateway
+			continue
+		}
+		route := ResolvedRoute{Path: r.Path}
+		if plan, ok := s.plans[r.Path]; ok {
+			route.Source = sourceStatic
+			route.Service = plan.Service
+			route.Auth = plan.Auth
+			route.RateLimit = plan.RateLimit
+		}
+		static = append(static, route)
+	}
+	sort.Slice(static, func(i, j int) bool {
+		if len(static[i].Path) != len(static[j].Path) {
+			return len(static[i].Path) > len(static[j].Path)
+		}
+		return static[i].Path < static[j].Path
+	})
+
+	shadowed := make(map[string]string)
+	for _, c := range s.routes.Conflicts() {
+		shadowed[c.Source+" "+c.Prefix] = c.ShadowedBy + "/*"
+	}
+
+	routes := static
+	for _, dyn := range s.routes.Routes() {
+		route := ResolvedRoute{
+			Path:       dyn.Prefix + "/*",
+			Source:     dyn.Source,
+			Service:    dyn.Service.Name,
+			Auth:       dyn.Auth != routing.AuthPublic,
+			RateLimit:  dyn.RateLimit,
+			Priority:   dyn.Priority,
+			ShadowedBy: shadowed[dyn.Source+" "+dyn.Prefix],
+		}
+		// Static routes always win over dynamic ones
+		for _, st := range static {
+			if p := route.prefix(); strings.HasSuffix(st.Path, "/*") && (p == st.prefix() || strings.HasPrefix(p, st.prefix()+"/")) {
+				route.ShadowedBy = st.Path
+				break
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// handleResolvedRoutes lists the effective routing table in match order.
+func (s *Server) handleResolvedRoutes(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"routes": s.ResolvedRoutes()})
+}
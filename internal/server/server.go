@@ -2,16 +2,35 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/banking/api-gateway/internal/analytics"
+	"github.com/banking/api-gateway/internal/buildinfo"
+	"github.com/banking/api-gateway/internal/clock"
 	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/discovery"
+	"github.com/banking/api-gateway/internal/health"
 	"github.com/banking/api-gateway/internal/infrastructure"
+	"github.com/banking/api-gateway/internal/jsonutil"
+	"github.com/banking/api-gateway/internal/mesh"
+	"github.com/banking/api-gateway/internal/metrics"
 	"github.com/banking/api-gateway/internal/middleware"
 	"github.com/banking/api-gateway/internal/proxy"
+	"github.com/banking/api-gateway/internal/retention"
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/banking/api-gateway/internal/signing"
+	"github.com/banking/api-gateway/internal/status"
+	"github.com/banking/api-gateway/internal/store"
+	"github.com/banking/api-gateway/internal/traffic"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
@@ -20,111 +39,765 @@ type Server struct {
 	cfg         *config.Config
 	logger      *zap.Logger
 	redisClient *infrastructure.RedisClient
+	traffic     *traffic.Tracker
+	darkTraffic *traffic.DarkTraffic
+	usage       *analytics.UsageTracker
+	proxy       *proxy.ProxyHandler
+	peer        *proxy.PeerForwarder
+	mesh        *mesh.TLS
+	// meshServer serves peer gateways over mutual TLS on the mesh port
+	meshServer  *http.Server
+	migrations  *proxy.Migrations
+	weights     *proxy.Weights
+	products    *middleware.Products
+	status      *status.Monitor
+	readOnly    *middleware.ReadOnlyGuard
+	shield      *middleware.Shield
+	fingerprint *middleware.TLSFingerprints
+	healthCheck *health.Checker
+	approvals   *middleware.Approvals
+	adminAuth   *middleware.AdminAuth
+	explain     *middleware.ExplainMode
+	inspector   *middleware.Inspector
+	concurrency *middleware.ConcurrencyLimiter
+	revocations *middleware.Revocations
+	events      *infrastructure.EventStore
+	requestLog  *infrastructure.RequestLog
+	reloader    *config.Reloader
+	clock       *clock.Guard
+	routes      *routing.Table
+	registry    *discovery.Registry
+	// registryAPI serves the registry admin API on its own port
+	registryAPI *echo.Echo
+	plans       map[string]routePlan
+	pipeline    *pipeline
+	signer      *signing.Signer
+	auth        *middleware.AuthMiddleware
+	store       *store.Store
+
+	// responseCache is purged of a user's entries on erasure requests
+	responseCache *middleware.ResponseCache
+
+	// redirectServer sends plain HTTP requests to the HTTPS listener
+	redirectServer *http.Server
+
+	// draining is set on Stop so /health fails while load balancers catch up
+	draining atomic.Bool
+	// warmingUp holds /health at 503 until upstreams and key sets are primed
+	warmingUp atomic.Bool
+
+	// background is cancelled on Stop to end periodic jobs
+	background context.Context
+	cancel     context.CancelFunc
 }
 
+// switchRefreshInterval is how often replicas pick up read-only switches and
+// migration overrides set by other replicas.
+const switchRefreshInterval = 5 * time.Second
+
 func New(cfg *config.Config, logger *zap.Logger, redisClient *infrastructure.RedisClient) *Server {
 	e := echo.New()
 	e.HideBanner = true
+	// Exact decimals in bound bodies and canonical JSON responses
+	e.JSONSerializer = jsonutil.Serializer{}
+
+	// Access/audit/error event streams, plus per-request logs looked up by
+	// request ID from the admin API
+	var events *infrastructure.EventStore
+	var requestLog *infrastructure.RequestLog
+	if redisClient != nil && (cfg.Events.Enabled || cfg.RequestLog.Enabled) {
+		events = infrastructure.NewEventStore(redisClient, cfg.Events, logger)
+		if cfg.RequestLog.Enabled {
+			requestLog = infrastructure.NewRequestLog(events, cfg.RequestLog)
+		}
+		events.Start()
+		logger = events.Attach(logger)
+	}
+	// Wraps the event capture too, so no stream sees a card number either
+	if cfg.Security.PCIGuard.Enabled {
+		logger = middleware.MaskCardNumbersInLogs(logger)
+	}
 
 	// Standard Middleware
+	// gRPC clients get the gateway's own errors, panics included, as gRPC
+	// statuses
+	e.Use(middleware.GRPCErrors)
 	e.Use(echoMiddleware.Recover())
+
+	// Emergency global rate limit, shedding load before anything costly
+	shield := middleware.NewShield(cfg.Shield, redisClient, logger)
+	e.Use(shield.Handle)
+
 	e.Use(echoMiddleware.RequestID())
-	e.Use(echoMiddleware.CORSWithConfig(echoMiddleware.CORSConfig{
-		AllowOrigins: cfg.Cors.AllowOrigins,
-		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
-	}))
+
+	// Only dark traffic runs may mark requests as synthetic
+	e.Use(middleware.DarkTrafficGuard)
+
+	// Only peer gateways on the mesh port may claim a gateway path
+	if cfg.Federation.Mesh.Enabled {
+		e.Use(middleware.MeshGuard(cfg.Federation.Mesh.SPIFFEID, logger))
+	}
+
+	// A span per request, continuing the caller's W3C trace
+	if cfg.Tracing.Enabled {
+		e.Use(middleware.Tracing)
+	}
+
+	// Policy decision traces for trusted callers while explain mode is on
+	explain := middleware.NewExplainMode(cfg.Admin.ExplainKey, redisClient, logger)
+	e.Use(explain.Handle)
+
+	// Sampled deep inspection (sanitized headers, timings, decisions)
+	var inspector *middleware.Inspector
+	if cfg.Inspection.Enabled {
+		inspector = middleware.NewInspector(cfg.Inspection, logger)
+		e.Use(inspector.Handle)
+	}
+
+	e.Use(middleware.NewCORSMiddleware(cfg))
 
 	// Security Middleware
 	e.Use(echoMiddleware.Secure())
 	e.Use(echoMiddleware.BodyLimit("2M"))
 
+	// Traffic sketches for the admin top-talkers report
+	tracker := traffic.NewTracker(cfg.Traffic.Retention, cfg.Traffic.TopK)
+	e.Use(middleware.TrafficRecorder(tracker))
+
 	// Structured Logging
 	e.Use(echoMiddleware.RequestLoggerWithConfig(echoMiddleware.RequestLoggerConfig{
-		LogURI:     true,
-		LogStatus:  true,
-		LogMethod:  true,
-		LogLatency: true,
+		LogURI:       true,
+		LogStatus:    true,
+		LogMethod:    true,
+		LogLatency:   true,
+		LogRequestID: true,
 		LogValuesFunc: func(c echo.Context, v echoMiddleware.RequestLoggerValues) error {
-			logger.Info("request",
+			if cfg.Security.PCIGuard.Enabled {
+				v.URI, _ = middleware.MaskCardNumbers(v.URI)
+			}
+			fields := []zap.Field{
 				zap.String("URI", v.URI),
 				zap.Int("status", v.Status),
 				zap.String("method", v.Method),
 				zap.Duration("latency", v.Latency),
-			)
+				zap.String("route", c.Path()),
+				zap.String("request_id", v.RequestID),
+			}
+			ja3, _ := c.Get("tls_ja3").(string)
+			ja4, _ := c.Get("tls_ja4").(string)
+			if ja3 != "" || ja4 != "" {
+				fields = append(fields, zap.String("tls_ja3", ja3), zap.String("tls_ja4", ja4))
+			}
+			logger.Info("request", fields...)
+			var traceID string
+			if cfg.Tracing.Enabled {
+				traceID = middleware.SampledTraceID(c)
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			code := strconv.Itoa(v.Status)
+			metrics.RequestsTotal.WithLabelValues(route, v.Method, code).Inc()
+			metrics.ObserveWithTrace(metrics.RequestDuration.WithLabelValues(route, v.Method, code), v.Latency.Seconds(), traceID)
+			if events != nil {
+				userID, _ := c.Get("user_id").(string)
+				events.Publish(infrastructure.StreamAccess, map[string]interface{}{
+					"time":       v.StartTime.UTC().Format(time.RFC3339Nano),
+					"request_id": v.RequestID,
+					"method":     v.Method,
+					"uri":        v.URI,
+					"route":      c.Path(),
+					"status":     v.Status,
+					"latency_ms": v.Latency.Milliseconds(),
+					"ip":         c.RealIP(),
+					"user_id":    userID,
+					"tls_ja4":    ja4,
+				})
+			}
 			return nil
 		},
 	}))
 
-	return &Server{
+	// JA3/JA4 fingerprints of TLS clients, checked against the denylist
+	var fingerprints *middleware.TLSFingerprints
+	if cfg.TLSFingerprint.Enabled {
+		fingerprints = middleware.NewTLSFingerprints(cfg.TLSFingerprint, redisClient, logger)
+		e.Server.ConnContext = fingerprints.ConnContext
+		e.Server.ConnState = fingerprints.ConnState
+		e.Use(fingerprints.Handle)
+	}
+
+	info := buildinfo.Get()
+	metrics.BuildInfo.WithLabelValues(info.Version, info.Commit, info.BuildDate, info.GoVersion).Set(1)
+
+	// White-label brand of the request: response headers, then localized
+	// messages and support contacts on the gateway's own errors
+	if cfg.Branding.Enabled {
+		e.Use(middleware.NewBranding(cfg, logger).Handle)
+	}
+	if cfg.ErrorPages.Enabled {
+		e.Use(middleware.NewErrorPages(cfg, logger).Handle)
+	}
+
+	// End-to-end deadlines from trusted internal clients
+	if cfg.Deadline.Enabled {
+		e.Use(middleware.NewDeadlineGuard(cfg, logger).Handle)
+	}
+
+	// In-flight request caps per client IP (per client after auth)
+	var concurrency *middleware.ConcurrencyLimiter
+	if cfg.Concurrency.Enabled {
+		concurrency = middleware.NewConcurrencyLimiter(cfg, redisClient, logger)
+		e.Use(concurrency.PerIP)
+	}
+
+	background, cancel := context.WithCancel(context.Background())
+	s := &Server{
 		echo:        e,
 		cfg:         cfg,
 		logger:      logger,
 		redisClient: redisClient,
+		traffic:     tracker,
+		explain:     explain,
+		shield:      shield,
+		fingerprint: fingerprints,
+		inspector:   inspector,
+		concurrency: concurrency,
+		events:      events,
+		requestLog:  requestLog,
+		routes:      routing.NewTable(),
+		plans:       make(map[string]routePlan),
+		background:  background,
+		cancel:      cancel,
 	}
+	e.HTTPErrorHandler = s.httpErrorHandler
+
+	return s
 }
 
 func (s *Server) Start() error {
-	s.setupRoutes()
+	if err := s.setupRoutes(); err != nil {
+		return err
+	}
+
+	if s.cfg.Server.Warmup.Enabled {
+		s.warmingUp.Store(true)
+		go s.warmUp()
+	}
 
 	serverUrl := fmt.Sprintf(":%s", s.cfg.Server.Port)
-	s.logger.Info("Starting API Gateway", zap.String("url", serverUrl))
+	s.logger.Info("Starting API Gateway", zap.String("url", serverUrl), zap.Bool("tls", s.cfg.Server.TLS.Enabled))
 
 	// Configure Server Timeouts
 	s.echo.Server.ReadTimeout = s.cfg.Server.ReadTimeout
 	s.echo.Server.WriteTimeout = s.cfg.Server.WriteTimeout
 	s.echo.Server.IdleTimeout = 120 * time.Second
 	s.echo.Server.MaxHeaderBytes = 1 << 20 // 1MB
+	if s.cfg.Server.H2C {
+		// HTTP/2 with prior knowledge, for gRPC clients, next to HTTP/1.1
+		s.echo.Server.Protocols = new(http.Protocols)
+		s.echo.Server.Protocols.SetHTTP1(true)
+		s.echo.Server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	// HTTPS, with fingerprints of every ClientHello when those are on
+	if t := s.cfg.Server.TLS; t.Enabled {
+		tlsConfig, redirect, err := serverTLSConfig(s.cfg.Server, s.logger)
+		if err != nil {
+			return err
+		}
+		if s.fingerprint != nil {
+			tlsConfig = s.fingerprint.TLSConfig(tlsConfig)
+		}
+		s.echo.Server.TLSConfig = tlsConfig
 
-	return s.echo.Start(serverUrl)
+		if t.RedirectPort != "" {
+			redirectUrl := fmt.Sprintf(":%s", t.RedirectPort)
+			s.logger.Info("Starting HTTPS redirect listener", zap.String("url", redirectUrl))
+			s.redirectServer = &http.Server{
+				Addr:              redirectUrl,
+				Handler:           redirect,
+				ReadHeaderTimeout: s.cfg.Server.ReadTimeout,
+				IdleTimeout:       120 * time.Second,
+				MaxHeaderBytes:    1 << 20,
+			}
+			go func() {
+				if err := s.redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					s.logger.Error("HTTPS redirect listener stopped", zap.Error(err))
+				}
+			}()
+		}
+	}
+
+	if s.registryAPI != nil {
+		registryUrl := fmt.Sprintf(":%s", s.cfg.Registry.Port)
+		s.logger.Info("Starting registry API", zap.String("url", registryUrl))
+		go func() {
+			if err := s.registryAPI.Start(registryUrl); !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("Registry API stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	if s.mesh != nil {
+		meshUrl := fmt.Sprintf(":%s", s.cfg.Federation.Mesh.Port)
+		s.logger.Info("Starting mesh listener", zap.String("url", meshUrl), zap.String("spiffe_id", s.mesh.ID()))
+		s.meshServer = &http.Server{
+			Addr:           meshUrl,
+			Handler:        http.HandlerFunc(s.serveMesh),
+			TLSConfig:      s.mesh.ServerConfig(),
+			ReadTimeout:    s.cfg.Server.ReadTimeout,
+			WriteTimeout:   s.cfg.Server.WriteTimeout,
+			IdleTimeout:    120 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		}
+		go func() {
+			if err := s.meshServer.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("Mesh listener stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	s.echo.Server.Addr = serverUrl
+	if err := s.echo.StartServer(s.echo.Server); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
+// Handler sets up routes without listening, for serving requests in-process.
+func (s *Server) Handler() (http.Handler, error) {
+	if err := s.setupRoutes(); err != nil {
+		return nil, err
+	}
+	return s.echo, nil
+}
+
+// Routes returns every registered route.
+func (s *Server) Routes() []*echo.Route {
+	return s.echo.Routes()
+}
+
+// Drain marks the gateway as shutting down: /health fails so it is taken out
+// of load balancing, while requests are still served.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Stop closes the listeners and waits, until ctx is done, for in-flight
+// requests to complete before stopping background jobs.
 func (s *Server) Stop(ctx context.Context) error {
-	return s.echo.Shutdown(ctx)
+	s.Drain()
+	err := s.echo.Shutdown(ctx)
+	if s.registryAPI != nil {
+		if rerr := s.registryAPI.Shutdown(ctx); err == nil {
+			err = rerr
+		}
+	}
+	if s.meshServer != nil {
+		if merr := s.meshServer.Shutdown(ctx); err == nil {
+			err = merr
+		}
+	}
+	if s.redirectServer != nil {
+		if rerr := s.redirectServer.Shutdown(ctx); err == nil {
+			err = rerr
+		}
+	}
+	s.cancel()
+	if s.usage != nil {
+		s.usage.Stop()
+	}
+	if s.status != nil {
+		s.status.Stop()
+	}
+	if s.events != nil {
+		s.events.Stop()
+	}
+	if s.signer != nil {
+		s.signer.Close()
+	}
+	if s.store != nil {
+		s.store.Close()
+	}
+	return err
 }
 
-func (s *Server) setupRoutes() {
+func (s *Server) setupRoutes() error {
 	// Health Check
 	s.echo.GET("/health", func(c echo.Context) error {
+		if s.draining.Load() {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "DRAINING"})
+		}
+		if s.warmingUp.Load() {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "WARMING_UP"})
+		}
 		return c.JSON(http.StatusOK, map[string]string{"status": "UP"})
 	})
 
+	// Gateway signing key, held in an HSM or Cloud KMS
+	signer, err := signing.New(s.background, s.cfg.Signing)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		s.signer = signer
+		s.echo.GET("/.well-known/jwks.json", s.handleJWKS)
+	}
+
+	// API keys, consents, webhook subscriptions and sessions, kept in SQL
+	st, err := store.Open(s.background, s.cfg.Store, s.redisClient, s.logger)
+	if err != nil {
+		return err
+	}
+	s.store = st
+
+	// Build metadata (version, commit, build date, enabled features)
+	s.echo.GET("/version", s.handleVersion)
+
+	// Prometheus metrics
+	s.echo.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+
+	// Keyspace memory budget reporting
+	if s.redisClient != nil {
+		s.redisClient.StartKeyspaceScanner(s.background, s.cfg.Redis.ScanInterval, s.cfg.Redis.ScanMaxKeys)
+	}
+
+	// Retention and redaction of event streams, request logs and spill files
+	if s.cfg.Retention.Enabled {
+		retention.NewManager(s.cfg, s.redisClient, s.events, s.requestLog, s.logger).Start(s.background)
+	}
+
+	// Client/endpoint/scope usage analytics (requires Redis)
+	if s.cfg.Analytics.Enabled && s.redisClient != nil {
+		s.usage = analytics.NewUsageTracker(s.cfg, s.redisClient, s.logger)
+		s.usage.Start()
+	}
+
+	// Configuration reload with diff/audit records
+	s.reloader = config.NewReloader(s.cfg, s.logger)
+	if s.cfg.Admin.WatchConfig {
+		s.reloader.Watch()
+	}
+
+	// Incident read-only switches (global and per service)
+	s.readOnly = middleware.NewReadOnlyGuard(s.redisClient, s.logger)
+	s.readOnly.Start(s.background, switchRefreshInterval)
+	s.shield.Start(s.background, switchRefreshInterval)
+	if s.fingerprint != nil {
+		s.fingerprint.Start(s.background, switchRefreshInterval)
+	}
+
+	// NTP drift guard for clock-sensitive policies
+	if s.cfg.Clock.Enabled {
+		guard, err := clock.NewGuard(s.cfg.Clock, s.logger)
+		if err != nil {
+			return err
+		}
+		guard.Start(s.background)
+		s.clock = guard
+	}
+
+	// API products: subscriptions, scopes and quotas per route bundle
+	s.products = middleware.NewProducts(s.cfg, s.redisClient, s.logger)
+	s.products.Start(s.background, switchRefreshInterval)
+
+	if err := s.setupAdminRoutes(); err != nil {
+		return err
+	}
+
 	// Auth Middleware - Inject Redis Client
-	authMiddleware := middleware.NewAuthMiddleware(s.cfg, s.logger, s.redisClient)
+	authMiddleware, err := middleware.NewAuthMiddleware(s.cfg, s.logger, s.redisClient)
+	if err != nil {
+		return err
+	}
+	authMiddleware.Start(s.background)
+	s.auth = authMiddleware
+	if s.clock != nil {
+		authMiddleware.UseClock(s.clock)
+	}
+
+	// Logout/revocation events from auth-service (pub/sub and webhook)
+	if s.cfg.Security.Revocation.Enabled {
+		s.revocations = middleware.NewRevocations(s.cfg, s.redisClient, s.logger)
+		if s.signer != nil {
+			s.revocations.UseSigner(s.signer)
+		}
+		s.revocations.Start(s.background)
+		authMiddleware.UseRevocations(s.revocations)
+		if s.cfg.Security.Revocation.WebhookSecret != "" {
+			s.echo.POST("/webhooks/auth/revocations", s.handleRevocationWebhook)
+		}
+		// Signed revocation list for backends to enforce locally
+		if s.cfg.Security.Revocation.Export.Enabled {
+			s.echo.GET("/revocations", s.handleRevocationList)
+		}
+	}
 
 	// Rate Limiter (gracefully degrades if Redis is nil)
 	var rateLimiter *middleware.RateLimiter
 	if s.redisClient != nil {
-		rateLimiter = middleware.NewRateLimiter(s.redisClient, s.logger)
+		rateLimiter = middleware.NewRateLimiter(s.cfg, s.redisClient, s.logger)
 	}
 
 	// Proxy Handler with Circuit Breaker
 	proxyHandler := proxy.NewProxyHandler(s.cfg, s.logger)
+	proxyHandler.UseRoutes(s.routes)
+	s.proxy = proxyHandler
+
+	// Strangler migrations of legacy service paths to new backends
+	migrations, err := proxy.NewMigrations(s.cfg, s.redisClient, s.logger)
+	if err != nil {
+		return err
+	}
+	migrations.Start(s.background, switchRefreshInterval)
+	proxyHandler.UseMigrations(migrations)
+	s.migrations = migrations
+
+	// Instance weights of balanced services, e.g. for canary releases
+	weights := proxy.NewWeights(s.cfg, s.redisClient, proxyHandler.Instances, s.logger)
+	weights.Start(s.background, switchRefreshInterval)
+	proxyHandler.UseWeights(weights)
+	s.weights = weights
+
+	// Mutual TLS with peer gateways, both ways
+	if s.cfg.Federation.Mesh.Enabled {
+		meshTLS, err := mesh.NewTLS(s.cfg.Federation.Mesh)
+		if err != nil {
+			return err
+		}
+		s.mesh = meshTLS
+	}
+
+	// Peer gateway for requests matching no local route
+	if s.cfg.Federation.Enabled {
+		peer, err := proxy.NewPeerForwarder(s.cfg.Federation, s.mesh, s.logger)
+		if err != nil {
+			return err
+		}
+		s.peer = peer
+	}
+
+	// Active health checks of services with health_check, probing the
+	// instances the proxy would use; failing instances of balanced services
+	// are taken out of rotation
+	if s.usesHealthChecks() {
+		s.healthCheck = health.NewChecker(s.cfg, func(name string, svc config.Service) []string {
+			if len(svc.URLs) > 0 || svc.Discovery != "" {
+				return proxyHandler.Instances(name, svc)
+			}
+			return []string{svc.URL}
+		}, s.logger)
+		proxyHandler.UseHealthChecks(s.healthCheck)
+	}
+
+	// Public status feed and status-page webhook
+	s.setupStatus()
+
+	// Query Parameter Policies (per-route allowlist/strip/validation)
+	queryPolicy, err := middleware.NewQueryPolicyMiddleware(s.cfg, s.logger)
+	if err != nil {
+		return err
+	}
 
 	apiGroup := s.echo.Group("/api")
+	apiGroup.Use(queryPolicy.Enforce)
+	apiGroup.Use(middleware.HeadSynthesizer(s.cfg))
+
+	// Replayable request bodies for routes with buffer_body
+	apiGroup.Use(middleware.NewBodyBuffer(s.cfg, s.logger).Handle)
+
+	// JSON Structural Limits (depth, keys, array and string length)
+	jsonLimits := middleware.NewJSONLimitMiddleware(s.cfg, s.logger)
+	apiGroup.Use(jsonLimits.Enforce)
+
+	// Amounts between decimal strings and minor units (per route)
+	money, err := middleware.NewMoneyConverter(s.cfg, s.logger)
+	if err != nil {
+		return err
+	}
+	apiGroup.Use(money.Handle)
+
+	// Deprecation/Sunset headers and brown-outs (per route)
+	deprecation, err := middleware.NewDeprecationMiddleware(s.cfg, s.logger)
+	if err != nil {
+		return err
+	}
+	if s.clock != nil {
+		deprecation.UseClock(s.clock)
+	}
+	apiGroup.Use(deprecation.Handle)
+
+	// Minimum app versions and per-version request counts
+	var clientPolicy *middleware.ClientPolicy
+	if s.cfg.ClientPolicy.Enabled {
+		clientPolicy = middleware.NewClientPolicy(s.cfg, s.logger)
+		apiGroup.Use(clientPolicy.Handle)
+	}
+
+	// Dangerous JSON key filtering (configured per service)
+	sanitizer := middleware.NewBodySanitizer(s.cfg, s.logger)
+	fieldEncryption, err := middleware.NewFieldEncryption(s.cfg, s.logger)
+	if err != nil {
+		return err
+	}
+	pciGuard := middleware.NewPCIGuard(s.cfg, s.logger)
+
+	// Response cache (per-route policy), kept next to the proxy
+	responseCache, err := middleware.NewResponseCache(s.cfg, s.redisClient, s.logger)
+	if err != nil {
+		return err
+	}
+	responseCache.Start(s.background)
+	s.responseCache = responseCache
+
+	// Download digest verification, inside the cache so only verified
+	// responses are stored
+	integrity := middleware.NewIntegrityVerifier(s.cfg, s.logger)
+
+	// Route-level middleware shared by every proxied service
+	serviceMiddleware := func(serviceName string) []echo.MiddlewareFunc {
+		return []echo.MiddlewareFunc{
+			s.readOnly.ForService(serviceName),
+			sanitizer.ForService(serviceName),
+			fieldEncryption.ForService(serviceName),
+			pciGuard.ForService(serviceName),
+			responseCache.Handle,
+			integrity.Handle,
+		}
+	}
+
+	// Kept for dry-run evaluation by the admin trace endpoint
+	s.pipeline = &pipeline{
+		auth:         authMiddleware,
+		rateLimiter:  rateLimiter,
+		queryPolicy:  queryPolicy,
+		jsonLimits:   jsonLimits,
+		deprecation:  deprecation,
+		clientPolicy: clientPolicy,
+		sanitizer:    sanitizer,
+	}
 
 	// Auth Service Routes (Public, with IP-based rate limiting)
 	authRoutes := apiGroup.Group("/auth")
 	if rateLimiter != nil {
 		authRoutes.Use(rateLimiter.AuthRateLimiter())
 	}
-	authRoutes.Any("/*", proxyHandler.Handle("auth-service"))
+	authRoutes.Any("/*", proxyHandler.Handle("auth-service"), serviceMiddleware("auth-service")...)
+	s.plans["/api/auth/*"] = routePlan{Service: "auth-service", RateLimit: "auth"}
 
 	// Protected Routes
 	protected := apiGroup.Group("")
 	protected.Use(authMiddleware.ValidateToken)
+	if s.concurrency != nil {
+		protected.Use(s.concurrency.PerClient)
+	}
+	if s.usage != nil {
+		protected.Use(middleware.UsageRecorder(s.usage))
+	}
+	protected.Use(s.products.Handle)
+	protected.Use(middleware.NewWebSockets(s.cfg, s.redisClient, s.logger).Handle)
 
 	// Transfer routes with stricter rate limiting
 	transferRoutes := protected.Group("/transfers")
 	if rateLimiter != nil {
 		transferRoutes.Use(rateLimiter.TransferRateLimiter())
 	}
-	transferRoutes.Any("/*", proxyHandler.Handle("transaction-service"))
+	transferRoutes.Any("/*", proxyHandler.Handle("transaction-service"), serviceMiddleware("transaction-service")...)
+	s.plans["/api/transfers/*"] = routePlan{Service: "transaction-service", Auth: true, RateLimit: "transfer"}
 
 	// Other protected routes with default rate limiting
 	if rateLimiter != nil {
 		protected.Use(rateLimiter.DefaultRateLimiter())
 	}
-	protected.Any("/users/*", proxyHandler.Handle("user-service"))
-	protected.Any("/reporting/*", proxyHandler.Handle("reporting-service"))
-	protected.Any("/aml/*", proxyHandler.Handle("aml-service"))
+	protected.Any("/users/*", proxyHandler.Handle("user-service"), serviceMiddleware("user-service")...)
+	protected.Any("/reporting/*", proxyHandler.Handle("reporting-service"), serviceMiddleware("reporting-service")...)
+	protected.Any("/aml/*", proxyHandler.Handle("aml-service"), serviceMiddleware("aml-service")...)
+	protected.Any("/notifications/*", proxyHandler.Handle("notification-service"), serviceMiddleware("notification-service")...)
+	for path, service := range map[string]string{"/api/users/*": "user-service", "/api/reporting/*": "reporting-service", "/api/aml/*": "aml-service", "/api/notifications/*": "notification-service"} {
+		s.plans[path] = routePlan{Service: service, Auth: true, RateLimit: "default"}
+	}
+
+	// gRPC calls, routed by the gRPC service named first in their path
+	if s.usesProtocol("grpc") {
+		s.echo.Pre(middleware.GRPCPaths)
+	}
+	for name, svc := range s.cfg.Services {
+		for _, grpcService := range svc.GRPCServices {
+			protected.POST("/"+grpcService+"/*", proxyHandler.Handle(name), serviceMiddleware(name)...)
+			s.plans["/api/"+grpcService+"/*"] = routePlan{Service: name, Auth: true, RateLimit: "default"}
+		}
+	}
+
+	// JSON routes transcoded to unary gRPC methods
+	if s.usesTranscoding() {
+		transcoder, err := proxy.NewTranscoder(s.cfg, proxyHandler, s.logger)
+		if err != nil {
+			return err
+		}
+		for name, svc := range s.cfg.Services {
+			for _, rule := range svc.Transcode {
+				protected.Add(rule.Method, strings.TrimPrefix(rule.Path, "/api"), transcoder.Handle(name, rule), serviceMiddleware(name)...)
+				s.plans[rule.Path] = routePlan{Service: name, Auth: true, RateLimit: "default"}
+			}
+		}
+	}
+
+	// Routes published at runtime are linted as they arrive
+	s.routes.SetCheck(s.lintDynamicRoute)
+
+	// Services registered at runtime from Kubernetes annotations
+	if s.cfg.Kubernetes.Controller {
+		controller, err := discovery.NewServiceController(s.cfg, s.routes, s.logger)
+		if err != nil {
+			return err
+		}
+		controller.Start(s.background)
+	}
+
+	// Instances of services with kubernetes or consul discovery
+	if s.usesDiscovery("kubernetes") {
+		resolver, err := discovery.NewEndpointResolver(s.cfg, s.logger)
+		if err != nil {
+			return err
+		}
+		resolver.Start(s.background)
+		proxyHandler.UseResolver("kubernetes", resolver)
+	}
+	if s.usesDiscovery("consul") {
+		resolver, err := discovery.NewConsulResolver(s.cfg, s.logger)
+		if err != nil {
+			return err
+		}
+		resolver.Start(s.background)
+		proxyHandler.UseResolver("consul", resolver)
+	}
+	if s.healthCheck != nil {
+		s.healthCheck.Start(s.background)
+	}
+
+	// Routes and upstreams from an xDS control plane
+	if s.cfg.XDS.Enabled {
+		xds, err := discovery.NewXDSClient(s.cfg, s.routes, s.logger)
+		if err != nil {
+			return err
+		}
+		xds.Start(s.background)
+	}
+
+	// Services and routes registered through the registry admin API
+	if s.cfg.Registry.Enabled {
+		s.setupRegistry()
+	}
+
+	if s.dynamicRouting() {
+		apiGroup.Any("/*", s.dynamicRouteHandler(authMiddleware, rateLimiter, serviceMiddleware))
+	} else if s.peer != nil {
+		// Unmatched routes skip local auth; the peer applies its own
+		apiGroup.Any("/*", s.forwardToPeer)
+	}
+
+	// Refuse to serve a routing table with auth bypasses
+	return s.lintRoutes()
 }
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/proxy"
+	"github.com/labstack/echo/v4"
+)
+
+// maxSimulatedRequests bounds the work one what-if request may ask for.
+const maxSimulatedRequests = 1_000_000
+
+type simulateRequest struct {
+	Service            string  `json:"service"`
+	RPS                float64 `json:"rps"`
+	FailureRate        float64 `json:"failure_rate"`
+	DurationSeconds    int     `json:"duration_seconds"`
+	FailureForSeconds  int     `json:"failure_for_seconds"`
+	Clients            int     `json:"clients"`
+	Policy             string  `json:"policy"`
+	EstablishedClients bool    `json:"established_clients"`
+	Seed               uint64  `json:"seed"`
+}
+
+type simulateResult struct {
+	Breaker    *proxy.BreakerSimulation         `json:"breaker,omitempty"`
+	RateLimits []middleware.RateLimitSimulation `json:"rate_limits"`
+	Notes      []string                         `json:"notes,omitempty"`
+}
+
+// handleSimulate answers "what if" questions: how the current circuit breaker
+// and rate limit settings would respond to hypothetical traffic. Nothing is
+// sent upstream and no live breaker or quota is touched.
+func (s *Server) handleSimulate(c echo.Context) error {
+	var req simulateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid simulation request"})
+	}
+	if req.DurationSeconds == 0 {
+		req.DurationSeconds = 60
+	}
+	if req.Clients == 0 {
+		req.Clients = 1
+	}
+	switch {
+	case req.RPS <= 0:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "rps must be positive"})
+	case req.FailureRate < 0 || req.FailureRate > 1:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failure_rate must be between 0 and 1"})
+	case req.DurationSeconds < 0 || req.FailureForSeconds < 0 || req.Clients < 0:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "duration_seconds, failure_for_seconds and clients must not be negative"})
+	case req.RPS*float64(req.DurationSeconds) > maxSimulatedRequests:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "rps * duration_seconds must not exceed 1000000"})
+	case req.Policy != "" && (req.Policy == "none" || !slices.Contains(middleware.RateLimitPolicies(s.cfg), req.Policy)):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown rate limit policy"})
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	result := simulateResult{RateLimits: []middleware.RateLimitSimulation{}}
+
+	if req.Service != "" {
+		svc, ok := s.cfg.Services[req.Service]
+		switch {
+		case !ok:
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown service"})
+		case !svc.CircuitBreaker:
+			result.Notes = append(result.Notes, "service "+req.Service+" has no circuit breaker; every request is forwarded")
+		default:
+			sim := proxy.SimulateBreaker(proxy.BreakerScenario{
+				RPS:         req.RPS,
+				FailureRate: req.FailureRate,
+				Duration:    duration,
+				FailureFor:  time.Duration(req.FailureForSeconds) * time.Second,
+				Seed:        req.Seed,
+			})
+			result.Breaker = &sim
+		}
+	}
+
+	if s.redisClient == nil {
+		result.Notes = append(result.Notes, "Redis is unavailable, so rate limiting is currently disabled; results show the configured policies")
+	}
+	result.RateLimits = append(result.RateLimits, middleware.SimulateRateLimits(s.cfg, middleware.RateLimitScenario{
+		RPS:         req.RPS,
+		Clients:     req.Clients,
+		Duration:    duration,
+		Policy:      req.Policy,
+		Established: req.EstablishedClients,
+	})...)
+
+	return c.JSON(http.StatusOK, result)
+}
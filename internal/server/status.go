@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/status"
+	"github.com/labstack/echo/v4"
+	"github.com/sony/gobreaker"
+)
+
+func (s *Server) setupStatus() {
+	if !s.cfg.Status.Enabled {
+		return
+	}
+
+	s.status = status.NewMonitor(s.cfg.Status, s.logger)
+	if s.cfg.Branding.Enabled {
+		for _, brand := range s.cfg.Branding.Brands {
+			for _, hook := range brand.Webhooks {
+				s.status.AddWebhook(status.Webhook{URL: hook.URL, Secret: hook.Secret, Public: true})
+			}
+		}
+	}
+
+	s.status.AddSource(func(ctx context.Context) []status.Component {
+		comp := status.Component{Name: "rate-limiting", Status: status.Operational}
+		if s.redisClient == nil {
+			comp.Status = status.Degraded
+			comp.Detail = "redis not connected"
+		} else if err := s.redisClient.HealthCheck(ctx); err != nil {
+			comp.Status = status.Degraded
+			comp.Detail = err.Error()
+		}
+		return []status.Component{comp}
+	})
+
+	s.status.AddSource(func(ctx context.Context) []status.Component {
+		return s.upstreamComponents()
+	})
+
+	s.status.Start()
+
+	s.echo.GET("/status", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, s.status.Feed())
+	})
+}
+
+// upstreamComponents reports each service at its worst signal: its circuit
+// breaker, the breakers of its instances and active health checks. Instance
+// addresses only appear in Detail, which the public feed leaves out.
+func (s *Server) upstreamComponents() []status.Component {
+	byService := make(map[string]*status.Component)
+	worsen := func(name, level, detail string) {
+		comp, ok := byService[name]
+		if !ok {
+			comp = &status.Component{Name: name, Status: status.Operational}
+			byService[name] = comp
+		}
+		if level == status.Operational {
+			return
+		}
+		comp.Status = status.Worst(comp.Status, level)
+		if comp.Detail != "" {
+			comp.Detail += "; "
+		}
+		comp.Detail += detail
+	}
+
+	for name, state := range s.proxy.BreakerStates() {
+		switch state {
+		case gobreaker.StateOpen:
+			worsen(name, status.MajorOutage, "circuit breaker open")
+		case gobreaker.StateHalfOpen:
+			worsen(name, status.Degraded, "circuit breaker half-open")
+		default:
+			worsen(name, status.Operational, "")
+		}
+	}
+	for name, instances := range s.proxy.InstanceBreakerStates() {
+		open := 0
+		var tripped []string
+		for instance, state := range instances {
+			if state == gobreaker.StateOpen {
+				open++
+			}
+			if state != gobreaker.StateClosed {
+				tripped = append(tripped, instance+" "+state.String())
+			}
+		}
+		level := partialOutage(open, len(instances))
+		if len(tripped) > 0 {
+			level = status.Worst(level, status.Degraded)
+		}
+		sort.Strings(tripped)
+		worsen(name, level, "circuit breakers: "+strings.Join(tripped, ", "))
+	}
+	if s.healthCheck != nil {
+		total := make(map[string]int)
+		unhealthy := make(map[string][]string)
+		for _, st := range s.healthCheck.Statuses() {
+			total[st.Service]++
+			if !st.Healthy {
+				unhealthy[st.Service] = append(unhealthy[st.Service], st.Instance)
+			}
+		}
+		for name, n := range total {
+			down := unhealthy[name]
+			worsen(name, partialOutage(len(down), n), fmt.Sprintf("%d of %d instances failing health checks: %s", len(down), n, strings.Join(down, ", ")))
+		}
+	}
+
+	components := make([]status.Component, 0, len(byService))
+	for _, comp := range byService {
+		components = append(components, *comp)
+	}
+	return components
+}
+
+// partialOutage is a major outage when all of total instances are down and
+// degraded service when only some are.
+func partialOutage(down, total int) string {
+	switch {
+	case down == 0:
+		return status.Operational
+	case down == total:
+		return status.MajorOutage
+	}
+	return status.Degraded
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/banking/api-gateway/internal/store"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// apiKeyPrefix marks gateway-issued API keys, so leaked ones are easy to spot.
+const apiKeyPrefix = "gwk_"
+
+// hashAPIKey returns the stored form of an API key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) setupStoreRoutes(admin *echo.Group) {
+	admin.GET("/api-keys", s.handleAPIKeys)
+	admin.POST("/api-keys", s.handleAPIKeyCreate)
+	admin.DELETE("/api-keys/:id", s.handleAPIKeyRevoke)
+
+	admin.GET("/consents", s.handleConsents)
+	admin.POST("/consents", s.handleConsentCreate)
+	admin.DELETE("/consents/:id", s.handleConsentRevoke)
+
+	admin.GET("/webhooks", s.handleWebhooks)
+	admin.POST("/webhooks", s.handleWebhookCreate)
+	admin.DELETE("/webhooks/:id", s.handleWebhookDelete)
+
+	admin.GET("/sessions", s.handleSessions)
+	admin.DELETE("/sessions/:id", s.handleSessionRevoke)
+}
+
+// storeError answers a failed repository call.
+func (s *Server) storeError(c echo.Context, op string, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+	s.logger.Error("Store operation failed", zap.String("op", op), zap.Error(err))
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Store operation failed"})
+}
+
+// expiry turns an optional lifetime in days into an expiry time.
+func expiry(days int) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	return &t
+}
+
+// handleAPIKeys lists API keys, optionally for one ?client_id=.
+func (s *Server) handleAPIKeys(c echo.Context) error {
+	keys, err := s.store.APIKeys.List(c.Request().Context(), c.QueryParam("client_id"))
+	if err != nil {
+		return s.storeError(c, "list_api_keys", err)
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// handleAPIKeyCreate issues an API key. The key is returned only in this
+// response; the store keeps its hash.
+func (s *Server) handleAPIKeyCreate(c echo.Context) error {
+	var req struct {
+		ClientID      string   `json:"client_id"`
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.Bind(&req); err != nil || req.ClientID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_id is required"})
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return s.storeError(c, "create_api_key", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	k := &store.APIKey{
+		ClientID:  req.ClientID,
+		Name:      req.Name,
+		Hash:      hashAPIKey(key),
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		Scopes:    req.Scopes,
+		ExpiresAt: expiry(req.ExpiresInDays),
+	}
+	if err := s.store.APIKeys.Create(c.Request().Context(), k); err != nil {
+		return s.storeError(c, "create_api_key", err)
+	}
+	s.logger.Info("API key issued",
+		zap.String("id", k.ID),
+		zap.String("client_id", k.ClientID),
+		zap.String("by", adminID(c)),
+	)
+	return c.JSON(http.StatusCreated, map[string]interface{}{"api_key": k, "key": key})
+}
+
+func (s *Server) handleAPIKeyRevoke(c echo.Context) error {
+	if err := s.store.APIKeys.Revoke(c.Request().Context(), c.Param("id"), time.Now()); err != nil {
+		return s.storeError(c, "revoke_api_key", err)
+	}
+	s.logger.Info("API key revoked", zap.String("id", c.Param("id")), zap.String("by", adminID(c)))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleConsents lists the consent records of ?subject=.
+func (s *Server) handleConsents(c echo.Context) error {
+	subject := c.QueryParam("subject")
+	if subject == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "subject is required"})
+	}
+	consents, err := s.store.Consents.ListBySubject(c.Request().Context(), subject)
+	if err != nil {
+		return s.storeError(c, "list_consents", err)
+	}
+	return c.JSON(http.StatusOK, consents)
+}
+
+func (s *Server) handleConsentCreate(c echo.Context) error {
+	var req struct {
+		Subject       string   `json:"subject"`
+		ClientID      string   `json:"client_id"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.Bind(&req); err != nil || req.Subject == "" || req.ClientID == "" || len(req.Scopes) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "subject, client_id and scopes are required"})
+	}
+
+	consent := &store.Consent{
+		Subject:   req.Subject,
+		ClientID:  req.ClientID,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiry(req.ExpiresInDays),
+	}
+	if err := s.store.Consents.Create(c.Request().Context(), consent); err != nil {
+		return s.storeError(c, "create_consent", err)
+	}
+	s.logger.Info("Consent recorded",
+		zap.String("id", consent.ID),
+		zap.String("client_id", consent.ClientID),
+		zap.String("by", adminID(c)),
+	)
+	return c.JSON(http.StatusCreated, consent)
+}
+
+func (s *Server) handleConsentRevoke(c echo.Context) error {
+	if err := s.store.Consents.Revoke(c.Request().Context(), c.Param("id"), time.Now()); err != nil {
+		return s.storeError(c, "revoke_consent", err)
+	}
+	s.logger.Info("Consent revoked", zap.String("id", c.Param("id")), zap.String("by", adminID(c)))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleWebhooks lists webhook subscriptions, optionally for one ?client_id=.
+func (s *Server) handleWebhooks(c echo.Context) error {
+	subs, err := s.store.Webhooks.List(c.Request().Context(), c.QueryParam("client_id"))
+	if err != nil {
+		return s.storeError(c, "list_webhooks", err)
+	}
+	return c.JSON(http.StatusOK, subs)
+}
+
+func (s *Server) handleWebhookCreate(c echo.Context) error {
+	var req struct {
+		ClientID string   `json:"client_id"`
+		URL      string   `json:"url"`
+		Events   []string `json:"events"`
+		Secret   string   `json:"secret"`
+	}
+	if err := c.Bind(&req); err != nil || req.ClientID == "" || len(req.Events) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_id, url and events are required"})
+	}
+	if u, err := url.Parse(req.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "url must be an absolute https URL"})
+	}
+
+	sub := &store.WebhookSubscription{
+		ClientID: req.ClientID,
+		URL:      req.URL,
+		Events:   req.Events,
+		Secret:   req.Secret,
+	}
+	if err := s.store.Webhooks.Create(c.Request().Context(), sub); err != nil {
+		return s.storeError(c, "create_webhook", err)
+	}
+	s.logger.Info("Webhook subscription created",
+		zap.String("id", sub.ID),
+		zap.String("client_id", sub.ClientID),
+		zap.String("by", adminID(c)),
+	)
+	return c.JSON(http.StatusCreated, sub)
+}
+
+func (s *Server) handleWebhookDelete(c echo.Context) error {
+	if err := s.store.Webhooks.Delete(c.Request().Context(), c.Param("id")); err != nil {
+		return s.storeError(c, "delete_webhook", err)
+	}
+	s.logger.Info("Webhook subscription deleted", zap.String("id", c.Param("id")), zap.String("by", adminID(c)))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleSessions lists the sessions of ?subject=.
+func (s *Server) handleSessions(c echo.Context) error {
+	subject := c.QueryParam("subject")
+	if subject == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "subject is required"})
+	}
+	sessions, err := s.store.Sessions.ListBySubject(c.Request().Context(), subject)
+	if err != nil {
+		return s.storeError(c, "list_sessions", err)
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+func (s *Server) handleSessionRevoke(c echo.Context) error {
+	if err := s.store.Sessions.Revoke(c.Request().Context(), c.Param("id"), time.Now()); err != nil {
+		return s.storeError(c, "revoke_session", err)
+	}
+	s.logger.Info("Session revoked", zap.String("id", c.Param("id")), zap.String("by", adminID(c)))
+	return c.NoContent(http.StatusNoContent)
+}
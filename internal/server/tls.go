@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/fips"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most, while handshakes need them.
+const certCheckInterval = time.Minute
+
+// serverTLSConfig returns the TLS configuration of the gateway's listener,
+// and the handler of its redirect listener.
+func serverTLSConfig(cfg config.ServerConfig, logger *zap.Logger) (*tls.Config, http.Handler, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if cfg.TLS.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	for _, name := range cfg.TLS.CipherSuites {
+		// Names were checked when the configuration was loaded
+		i := slices.IndexFunc(tls.CipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name })
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, tls.CipherSuites()[i].ID)
+	}
+
+	var redirect http.Handler = redirectToHTTPS(cfg.Port)
+	if cfg.ACME.Enabled {
+		manager := acmeManager(cfg.ACME)
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		certs := &certFiles{certFile: cfg.TLS.CertFile, keyFile: cfg.TLS.KeyFile, logger: logger}
+		if err := certs.load(); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = certs.get
+	}
+	return fips.TLSConfig(tlsConfig), redirect, nil
+}
+
+// certFiles serves a certificate from PEM files, reloading it when the files
+// change, as when a renewed certificate is written over the old one.
+type certFiles struct {
+	certFile, keyFile string
+	logger            *zap.Logger
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+// load reads the key pair.
+func (c *certFiles) load() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return fmt.Errorf("server.tls: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("server.tls: load certificate: %w", err)
+	}
+	c.cert, c.modified, c.checked = &cert, modified, time.Now()
+	return nil
+}
+
+func (c *certFiles) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// get returns the certificate, reloaded if the files changed. A pair that
+// fails to load, e.g. while only one file has been replaced, keeps the
+// current certificate in use until the next check.
+func (c *certFiles) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	if modified, err := c.lastModified(); err != nil || !modified.After(c.modified) {
+		return c.cert, nil
+	}
+	current := c.cert
+	if err := c.load(); err != nil {
+		c.logger.Error("Failed to reload TLS certificate", zap.Error(err))
+		c.cert = current
+		return c.cert, nil
+	}
+	c.logger.Info("TLS certificate reloaded", zap.String("cert_file", c.certFile))
+	return c.cert, nil
+}
+
+// redirectToHTTPS answers plain HTTP requests with a permanent redirect to
+// the same URL over HTTPS on port, keeping the method and body (308).
+func redirectToHTTPS(port string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
+
+// acmeManager obtains certificates for the configured domains on their
+// first handshake, keeps them in the cache directory and renews them in the
+// background.
+func acmeManager(cfg config.ACMEConfig) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cfg.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(cfg.Domains...),
+		RenewBefore: cfg.RenewBefore,
+		Email:       cfg.Email,
+		Client:      &acme.Client{DirectoryURL: cfg.DirectoryURL},
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/banking/api-gateway/internal/middleware"
+	"github.com/banking/api-gateway/internal/routing"
+	"github.com/labstack/echo/v4"
+)
+
+// routePlan records how a proxied route is wired, for dry-run evaluation.
+type routePlan struct {
+	Service   string
+	Auth      bool
+	RateLimit string
+}
+
+// pipeline holds the request middleware built in setupRoutes.
+type pipeline struct {
+	auth        *middleware.AuthMiddleware
+	rateLimiter *middleware.RateLimiter
+	queryPolicy *middleware.QueryPolicyMiddleware
+	jsonLimits  *middleware.JSONLimitMiddleware
+	deprecation *middleware.DeprecationMiddleware
+	sanitizer   *middleware.BodySanitizer
+	// clientPolicy is nil unless client_policy is enabled
+	clientPolicy *middleware.ClientPolicy
+}
+
+// Context values later stages depend on.
+var tracedValues = []string{"user_id", "user_claims", "actor_id", "actor_chain"}
+
+const (
+	stageAllow = "allow"
+	stageDeny  = "deny"
+	stageSkip  = "skip"
+)
+
+type traceRequest struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	Body     json.RawMessage   `json:"body,omitempty"`
+	ClientIP string            `json:"client_ip"`
+}
+
+type traceStage struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type traceUpstream struct {
+	Service string `json:"service"`
+	URL     string `json:"url,omitempty"`
+	// Endpoints are the instances of a service with urls or discovery
+	Endpoints []string `json:"endpoints,omitempty"`
+	Path      string   `json:"path"`
+	Breaker   string   `json:"breaker,omitempty"`
+}
+
+type traceResult struct {
+	Matched  bool           `json:"matched"`
+	Route    string         `json:"route,omitempty"`
+	Source   string         `json:"source,omitempty"`
+	Decision string         `json:"decision"`
+	Status   int            `json:"status,omitempty"`
+	Stages   []traceStage   `json:"stages"`
+	Upstream *traceUpstream `json:"upstream,omitempty"`
+}
+
+// tracer evaluates one request descriptor stage by stage against fresh
+// synthetic contexts, carrying auth results forward.
+type tracer struct {
+	s      *Server
+	req    traceRequest
+	route  string
+	values map[string]interface{}
+}
+
+// handleTrace dry-runs a synthetic request: which route matches, which
+// middleware would allow or deny it and which upstream would receive it.
+// Nothing is proxied and no rate-limit quota is consumed.
+func (s *Server) handleTrace(c echo.Context) error {
+	var req traceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid trace request"})
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	req.Method = strings.ToUpper(req.Method)
+	if !strings.HasPrefix(req.Path, "/") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "path must start with /"})
+	}
+	if req.ClientIP != "" && net.ParseIP(req.ClientIP) == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_ip must be an IP address"})
+	}
+	if s.pipeline == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Routes not initialised"})
+	}
+
+	return c.JSON(http.StatusOK, s.trace(req))
+}
+
+func (s *Server) trace(req traceRequest) traceResult {
+	t := &tracer{s: s, req: req, values: make(map[string]interface{})}
+	result := traceResult{Decision: stageAllow, Stages: []traceStage{}}
+
+	path := t.request().URL.Path
+	route, plan, source, ok := s.matchRoute(req.Method, path)
+	t.route = route
+	if !ok {
+		result.Decision = stageDeny
+		result.Status = http.StatusNotFound
+		return result
+	}
+	result.Matched = true
+	result.Route = t.route
+	result.Source = source
+
+	p := s.pipeline
+	if req.Headers["Origin"] != "" {
+		result.Stages = append(result.Stages, t.run("cors", middleware.NewCORSMiddleware(s.cfg)))
+	}
+	result.Stages = append(result.Stages,
+		t.run("query_policy", p.queryPolicy.Enforce),
+		t.run("json_limits", p.jsonLimits.Enforce),
+		t.run("deprecation", p.deprecation.Handle),
+	)
+	if p.clientPolicy != nil {
+		result.Stages = append(result.Stages, t.run("client_policy", p.clientPolicy.Enforce))
+	}
+	if plan.Auth {
+		result.Stages = append(result.Stages, t.run("auth", p.auth.ValidateToken))
+	} else {
+		result.Stages = append(result.Stages, traceStage{Name: "auth", Result: stageSkip, Detail: "public route"})
+	}
+	result.Stages = append(result.Stages,
+		t.rateLimit(plan.RateLimit),
+		t.run("read_only", s.readOnly.ForService(plan.Service)),
+		t.run("body_sanitization", p.sanitizer.ForService(plan.Service)),
+	)
+
+	for _, st := range result.Stages {
+		if st.Result == stageDeny {
+			result.Decision = stageDeny
+			result.Status = st.Status
+			break
+		}
+	}
+
+	upstream := &traceUpstream{Service: plan.Service, Path: strings.TrimPrefix(path, "/api")}
+	if svc, found := s.cfg.Services[plan.Service]; found {
+		upstream.URL = svc.URL
+		upstream.Endpoints = s.proxy.Instances(plan.Service, svc)
+	} else if svc, found := s.routes.Service(plan.Service); found {
+		upstream.URL = svc.URL
+	}
+	if state, found := s.proxy.BreakerStates()[plan.Service]; found {
+		upstream.Breaker = state.String()
+		if result.Decision == stageAllow && state.String() == "open" {
+			result.Decision = stageDeny
+			result.Status = http.StatusServiceUnavailable
+		}
+	}
+	result.Upstream = upstream
+
+	return result
+}
+
+// matchRoute finds the route a request takes, static routes first, with its
+// plan and the source that configured it.
+func (s *Server) matchRoute(method, path string) (string, routePlan, string, bool) {
+	match := s.echo.NewContext(nil, nil)
+	s.echo.Router().Find(method, path, match)
+	route := match.Path()
+	if plan, ok := s.plans[route]; ok {
+		return route, plan, "static", true
+	}
+	if dyn, found := s.routes.Lookup(path); found && route == "/api/*" {
+		plan := routePlan{Service: dyn.Service.Name, Auth: dyn.Auth != routing.AuthPublic, RateLimit: dyn.RateLimit}
+		return dyn.Prefix + "/*", plan, dyn.Source, true
+	}
+	return route, routePlan{}, "", false
+}
+
+// request builds a fresh request from the descriptor; every stage gets its own
+// copy because stages consume and rewrite the body and query.
+func (t *tracer) request() *http.Request {
+	var body io.Reader = http.NoBody
+	if len(t.req.Body) > 0 {
+		body = bytes.NewReader(t.req.Body)
+	}
+	r := httptest.NewRequest(t.req.Method, t.req.Path, body)
+	for k, v := range t.req.Headers {
+		r.Header.Set(k, v)
+	}
+	if len(t.req.Body) > 0 && r.Header.Get(echo.HeaderContentType) == "" {
+		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if t.req.ClientIP != "" {
+		r.RemoteAddr = net.JoinHostPort(t.req.ClientIP, "0")
+	}
+	return r
+}
+
+func (t *tracer) context() (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := t.s.echo.NewContext(t.request(), rec)
+	c.SetPath(t.route)
+	for k, v := range t.values {
+		c.Set(k, v)
+	}
+	return c, rec
+}
+
+// run evaluates a single middleware with a terminal handler that only records
+// that the request got through.
+func (t *tracer) run(name string, mw echo.MiddlewareFunc) traceStage {
+	c, rec := t.context()
+
+	reached := false
+	err := mw(func(c echo.Context) error {
+		reached = true
+		for _, k := range tracedValues {
+			if v := c.Get(k); v != nil {
+				t.values[k] = v
+			}
+		}
+		return nil
+	})(c)
+
+	if reached {
+		return traceStage{Name: name, Result: stageAllow}
+	}
+
+	stage := traceStage{Name: name, Result: stageDeny, Status: rec.Code}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		stage.Status = he.Code
+		stage.Detail = fmt.Sprint(he.Message)
+	} else if err != nil {
+		stage.Detail = err.Error()
+	} else {
+		var body map[string]interface{}
+		if json.Unmarshal(rec.Body.Bytes(), &body) == nil {
+			if msg, ok := body["error"].(string); ok {
+				stage.Detail = msg
+			}
+		}
+	}
+	if stage.Status == http.StatusNoContent || stage.Status == http.StatusOK {
+		// Answered by the gateway itself, e.g. a CORS preflight
+		stage.Result = stageAllow
+		stage.Detail = "answered by the gateway"
+	}
+	return stage
+}
+
+func (t *tracer) rateLimit(policy string) traceStage {
+	stage := traceStage{Name: "rate_limit"}
+	if policy == "" || policy == "none" {
+		stage.Result = stageSkip
+		stage.Detail = "no rate limit policy"
+		return stage
+	}
+	if t.s.pipeline.rateLimiter == nil {
+		stage.Result = stageSkip
+		stage.Detail = "Redis unavailable, rate limiting disabled"
+		return stage
+	}
+
+	c, _ := t.context()
+	limit, count, err := t.s.pipeline.rateLimiter.Peek(c, policy)
+	if err != nil {
+		stage.Result = stageSkip
+		stage.Detail = err.Error()
+		return stage
+	}
+
+	stage.Detail = fmt.Sprintf("policy %s: %d of %d used in current window", limit.Name, count, limit.Limit)
+	if limit.Strategy == middleware.StrategyTokenBucket {
+		stage.Detail = fmt.Sprintf("policy %s: %d of %d burst tokens in use", limit.Name, count, limit.Burst)
+	}
+	if count >= limit.Capacity() {
+		stage.Result = stageDeny
+		stage.Status = http.StatusTooManyRequests
+		return stage
+	}
+	stage.Result = stageAllow
+	return stage
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/banking/api-gateway/internal/buildinfo"
+	"github.com/banking/api-gateway/internal/fips"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// features lists the optional subsystems enabled by the running configuration.
+func (s *Server) features() []string {
+	var out []string
+	add := func(name string, on bool) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	add("acme", s.cfg.Server.ACME.Enabled)
+	add("admin", s.adminEnabled())
+	add("admin_approvals", s.cfg.Admin.Approvals.Enabled)
+	add("admin_sso", s.cfg.Admin.SSO.Enabled)
+	add("analytics", s.cfg.Analytics.Enabled && s.redisClient != nil)
+	add("api_products", len(s.cfg.Products) > 0)
+	add("branding", s.cfg.Branding.Enabled)
+	add("client_policy", s.cfg.ClientPolicy.Enabled)
+	add("clock_guard", s.cfg.Clock.Enabled)
+	add("consul_discovery", s.usesDiscovery("consul"))
+	add("dark_traffic", s.cfg.DarkTraffic.Enabled && s.adminEnabled())
+	add("deadline", s.cfg.Deadline.Enabled)
+	add("error_pages", s.cfg.ErrorPages.Enabled)
+	add("events", s.cfg.Events.Enabled && s.redisClient != nil)
+	add("explain", s.cfg.Admin.ExplainKey != "")
+	add("federation_mesh", s.cfg.Federation.Mesh.Enabled)
+	add("field_encryption", len(s.cfg.FieldEncryption.Fields) > 0)
+	add("fips", fips.Enabled())
+	add("grpc", s.usesProtocol("grpc"))
+	add("grpc_transcoding", s.usesTranscoding())
+	add("h2c", s.cfg.Server.H2C)
+	add("health_checks", s.usesHealthChecks())
+	add("impersonation", s.cfg.Security.Impersonation.Enabled)
+	add("kubernetes", s.cfg.Kubernetes.Controller)
+	add("kubernetes_discovery", s.usesDiscovery("kubernetes"))
+	add("multi_issuer", len(s.cfg.Security.Issuers) > 0)
+	add("pci_guard", s.cfg.Security.PCIGuard.Enabled)
+	add("rate_limiting", s.redisClient != nil)
+	add("registry", s.cfg.Registry.Enabled)
+	add("request_log", s.cfg.RequestLog.Enabled && s.redisClient != nil)
+	add("retention", s.cfg.Retention.Enabled)
+	add("signing", s.signer != nil)
+	add("status", s.cfg.Status.Enabled)
+	add("store", s.store != nil)
+	add("tls", s.cfg.Server.TLS.Enabled)
+	add("tls_fingerprint", s.cfg.TLSFingerprint.Enabled)
+	add("tracing", s.cfg.Tracing.Enabled)
+	add("xds", s.cfg.XDS.Enabled)
+	return out
+}
+
+// handleVersion returns build metadata, signed with HMAC-SHA256 over the body
+// when server.version_signing_secret is set, and with a detached JWS from the
+// gateway signing key when one is configured.
+func (s *Server) handleVersion(c echo.Context) error {
+	info := buildinfo.Get()
+	info.Features = s.features()
+
+	body, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if secret := s.cfg.Server.VersionSigningSecret; secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		c.Response().Header().Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+	if s.signer != nil {
+		jws, err := s.signer.Detached(c.Request().Context(), body)
+		if err != nil {
+			s.logger.Error("Failed to sign version response", zap.Error(err))
+		} else {
+			c.Response().Header().Set("X-Signature-JWS", jws)
+		}
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// handleJWKS publishes the gateway signing key so clients can verify
+// gateway-issued signatures.
+func (s *Server) handleJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"keys": []map[string]string{s.signer.JWK()}})
+}
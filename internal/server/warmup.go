@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// warmUp primes everything the first requests would otherwise wait for:
+// upstream DNS and pooled connections, JWKS key sets and Redis connections.
+// The gateway reports ready when all steps finish or the timeout passes;
+// failures are logged but never keep it out of rotation.
+func (s *Server) warmUp() {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(s.background, s.cfg.Server.Warmup.Timeout)
+	defer cancel()
+	defer s.warmingUp.Store(false)
+
+	services := make(map[string]config.Service, len(s.cfg.Services))
+	for name, svc := range s.cfg.Services {
+		services[name] = svc
+	}
+	for _, r := range s.routes.Routes() {
+		if _, ok := services[r.Service.Name]; !ok {
+			services[r.Service.Name] = r.Service
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	step := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				s.logger.Warn("Warm-up step failed", zap.String("step", name), zap.Error(err))
+			}
+		}()
+	}
+
+	for name, svc := range services {
+		step("upstream:"+name, func() error {
+			return s.proxy.Warm(ctx, name, svc, s.cfg.Server.Warmup.Connections)
+		})
+	}
+	step("jwks", func() error { return s.auth.WarmUp(ctx) })
+	if s.redisClient != nil {
+		step("redis", func() error { return s.redisClient.HealthCheck(ctx) })
+	}
+	wg.Wait()
+
+	s.logger.Info("Warm-up complete, reporting ready",
+		zap.Int("services", len(services)),
+		zap.Int("failed_steps", failed),
+		zap.Duration("took", time.Since(start)),
+	)
+}
@@ -0,0 +1,182 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// kmsAlgorithms maps Cloud KMS key algorithms to the JWS algorithm they
+// produce.
+var kmsAlgorithms = map[string]string{
+	"EC_SIGN_P256_SHA256":        "ES256",
+	"EC_SIGN_P384_SHA384":        "ES384",
+	"RSA_SIGN_PKCS1_2048_SHA256": "RS256",
+	"RSA_SIGN_PKCS1_3072_SHA256": "RS256",
+	"RSA_SIGN_PKCS1_4096_SHA256": "RS256",
+	"RSA_SIGN_PSS_2048_SHA256":   "PS256",
+	"RSA_SIGN_PSS_3072_SHA256":   "PS256",
+	"RSA_SIGN_PSS_4096_SHA256":   "PS256",
+}
+
+// kmsKey signs through the Cloud KMS REST API (asymmetricSign).
+type kmsKey struct {
+	cfg    config.KMSConfig
+	alg    algorithm
+	client *http.Client
+	public crypto.PublicKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func openKMS(ctx context.Context, cfg config.KMSConfig, alg algorithm) (key, error) {
+	if cfg.KeyName == "" {
+		return nil, errors.New("kms.key_name must be set")
+	}
+	k := &kmsKey{
+		cfg:    cfg,
+		alg:    alg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+
+	var res struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call(ctx, http.MethodGet, "/publicKey", nil, &res); err != nil {
+		return nil, fmt.Errorf("fetch public key: %w", err)
+	}
+	if kmsAlgorithms[res.Algorithm] != alg.name {
+		return nil, fmt.Errorf("key algorithm %s does not produce %s signatures", res.Algorithm, alg.name)
+	}
+	block, _ := pem.Decode([]byte(res.PEM))
+	if block == nil {
+		return nil, errors.New("public key is not PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPublic(pub, alg); err != nil {
+		return nil, err
+	}
+	k.public = pub
+	return k, nil
+}
+
+func (k *kmsKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *kmsKey) sign(ctx context.Context, digest []byte) ([]byte, error) {
+	field := strings.ToLower(strings.ReplaceAll(k.alg.hash.String(), "-", ""))
+	req := map[string]interface{}{
+		"digest": map[string]string{field: base64.StdEncoding.EncodeToString(digest)},
+	}
+	var res struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := k.call(ctx, http.MethodPost, ":asymmetricSign", req, &res); err != nil {
+		return nil, err
+	}
+	if k.alg.curve != nil {
+		return rawECDSA(res.Signature, k.alg.keySize())
+	}
+	return res.Signature, nil
+}
+
+func (k *kmsKey) Close() error {
+	k.client.CloseIdleConnections()
+	return nil
+}
+
+// call sends a request for the key version; suffix is appended to its name.
+func (k *kmsKey) call(ctx context.Context, method, suffix string, body, out interface{}) error {
+	token, err := k.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	url := strings.TrimSuffix(k.cfg.Endpoint, "/") + "/v1/" + k.cfg.KeyName + suffix
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kms returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken returns the token from TokenEnv, or a cached metadata server
+// token refreshed a minute before it expires.
+func (k *kmsKey) accessToken(ctx context.Context) (string, error) {
+	if k.cfg.TokenEnv != "" {
+		if token := os.Getenv(k.cfg.TokenEnv); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("%s is empty", k.cfg.TokenEnv)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Now().Before(k.expires) {
+		return k.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("metadata token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata token: status %d", resp.StatusCode)
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("metadata token: %w", err)
+	}
+	k.token = res.AccessToken
+	k.expires = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+	return k.token, nil
+}
@@ -0,0 +1,187 @@
+//go:build pkcs11 && cgo
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/miekg/pkcs11"
+)
+
+// sha256DigestInfo is the DER DigestInfo prefix CKM_RSA_PKCS expects in front
+// of a SHA-256 digest for PKCS #1 v1.5 signatures.
+var sha256DigestInfo = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+
+// pkcs11Key signs on an HSM token through the vendor's PKCS#11 module. A
+// session serves one operation at a time, so signing is serialized.
+type pkcs11Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	alg     algorithm
+	public  crypto.PublicKey
+
+	mu sync.Mutex
+}
+
+func openPKCS11(cfg config.PKCS11Config, alg algorithm) (key, error) {
+	if cfg.Module == "" || cfg.TokenLabel == "" || cfg.KeyLabel == "" {
+		return nil, errors.New("pkcs11.module, token_label and key_label must be set")
+	}
+	p := pkcs11.New(cfg.Module)
+	if p == nil {
+		return nil, fmt.Errorf("cannot load module %s", cfg.Module)
+	}
+	if err := p.Initialize(); err != nil {
+		p.Destroy()
+		return nil, err
+	}
+
+	k := &pkcs11Key{ctx: p, alg: alg}
+	if err := k.open(cfg); err != nil {
+		k.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *pkcs11Key) open(cfg config.PKCS11Config) error {
+	slots, err := k.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	slot, found := uint(0), false
+	for _, s := range slots {
+		info, err := k.ctx.GetTokenInfo(s)
+		if err == nil && strings.TrimRight(info.Label, " \x00") == cfg.TokenLabel {
+			slot, found = s, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no token labelled %q", cfg.TokenLabel)
+	}
+
+	if k.session, err = k.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		return err
+	}
+	if err := k.ctx.Login(k.session, pkcs11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	if k.handle, err = k.find(pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel); err != nil {
+		return err
+	}
+	pubHandle, err := k.find(pkcs11.CKO_PUBLIC_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	if k.public, err = k.readPublic(pubHandle); err != nil {
+		return err
+	}
+	return checkPublic(k.public, k.alg)
+}
+
+func (k *pkcs11Key) find(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := k.ctx.FindObjectsInit(k.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := k.ctx.FindObjects(k.session, 2)
+	k.ctx.FindObjectsFinal(k.session)
+	switch {
+	case err != nil:
+		return 0, err
+	case len(objects) == 0:
+		return 0, fmt.Errorf("no key labelled %q", label)
+	case len(objects) > 1:
+		return 0, fmt.Errorf("more than one key labelled %q", label)
+	}
+	return objects[0], nil
+}
+
+// readPublic reads the public half from the token: the EC point for ECDSA
+// keys, modulus and exponent for RSA.
+func (k *pkcs11Key) readPublic(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	if k.alg.curve != nil {
+		attrs, err := k.ctx.GetAttributeValue(k.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+		if err != nil {
+			return nil, err
+		}
+		// CKA_EC_POINT is a DER OCTET STRING holding the uncompressed point
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[0].Value, &point); err != nil {
+			point = attrs[0].Value
+		}
+		size := k.alg.keySize()
+		if len(point) != 1+2*size || point[0] != 4 {
+			return nil, errors.New("public key does not match the algorithm's curve")
+		}
+		return &ecdsa.PublicKey{
+			Curve: k.alg.curve,
+			X:     new(big.Int).SetBytes(point[1 : 1+size]),
+			Y:     new(big.Int).SetBytes(point[1+size:]),
+		}, nil
+	}
+
+	attrs, err := k.ctx.GetAttributeValue(k.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attrs[0].Value),
+		E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+	}, nil
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *pkcs11Key) sign(_ context.Context, digest []byte) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	input := digest
+	switch {
+	case k.alg.curve != nil:
+		// CKM_ECDSA returns r||s, already the JWS encoding
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case k.alg.pss:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, uint(len(digest))))
+	default:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		input = append(append([]byte{}, sha256DigestInfo...), digest...)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mech}, k.handle); err != nil {
+		return nil, err
+	}
+	return k.ctx.Sign(k.session, input)
+}
+
+func (k *pkcs11Key) Close() error {
+	if k.session != 0 {
+		k.ctx.Logout(k.session)
+		k.ctx.CloseSession(k.session)
+	}
+	err := k.ctx.Finalize()
+	k.ctx.Destroy()
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !pkcs11 || !cgo
+
+package signing
+
+import (
+	"errors"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+func openPKCS11(config.PKCS11Config, algorithm) (key, error) {
+	return nil, errors.New("this binary was built without PKCS#11 support (build with -tags pkcs11 and CGO_ENABLED=1)")
+}
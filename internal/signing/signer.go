@@ -0,0 +1,221 @@
+// Package signing produces the gateway's own signatures with keys that stay
+// in an HSM (PKCS#11) or Cloud KMS. The process only holds a handle to the
+// private key; every signature is a round trip to the device or service.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/banking/api-gateway/internal/config"
+)
+
+// Backends.
+const (
+	BackendPKCS11 = "pkcs11"
+	BackendKMS    = "kms"
+)
+
+// algorithm describes a supported JWS algorithm.
+type algorithm struct {
+	name  string
+	hash  crypto.Hash
+	curve elliptic.Curve // nil for RSA
+	pss   bool
+}
+
+var algorithms = map[string]algorithm{
+	"ES256": {name: "ES256", hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {name: "ES384", hash: crypto.SHA384, curve: elliptic.P384()},
+	"RS256": {name: "RS256", hash: crypto.SHA256},
+	"PS256": {name: "PS256", hash: crypto.SHA256, pss: true},
+}
+
+// keySize is the byte length of r and s in an ECDSA JWS signature.
+func (a algorithm) keySize() int {
+	return (a.curve.Params().BitSize + 7) / 8
+}
+
+// key is a private key held outside the process.
+type key interface {
+	Public() crypto.PublicKey
+	// sign signs a digest made with the algorithm's hash and returns the
+	// signature in JWS encoding (r||s for ECDSA).
+	sign(ctx context.Context, digest []byte) ([]byte, error)
+	Close() error
+}
+
+// Signer signs with the configured HSM or KMS key.
+type Signer struct {
+	alg   algorithm
+	keyID string
+	key   key
+}
+
+// New opens the configured key and checks it with a probe signature. It
+// returns nil when no backend is configured.
+func New(ctx context.Context, cfg config.SigningConfig) (*Signer, error) {
+	if cfg.Backend == "" {
+		return nil, nil
+	}
+	alg, ok := algorithms[cfg.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("signing: unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	var k key
+	var err error
+	switch cfg.Backend {
+	case BackendPKCS11:
+		k, err = openPKCS11(cfg.PKCS11, alg)
+	case BackendKMS:
+		k, err = openKMS(ctx, cfg.KMS, alg)
+	default:
+		return nil, fmt.Errorf("signing: unknown backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing (%s): %w", cfg.Backend, err)
+	}
+
+	s := &Signer{alg: alg, keyID: cfg.KeyID, key: k}
+	if err := s.probe(ctx); err != nil {
+		k.Close()
+		return nil, fmt.Errorf("signing (%s): %w", cfg.Backend, err)
+	}
+	return s, nil
+}
+
+// probe signs random bytes and verifies the result against the public key,
+// catching a key that does not match the configured algorithm.
+func (s *Signer) probe(ctx context.Context) error {
+	data := make([]byte, 32)
+	rand.Read(data)
+	sig, err := s.Sign(ctx, data)
+	if err != nil {
+		return err
+	}
+	if !verify(s.key.Public(), s.alg, s.digest(data), sig) {
+		return fmt.Errorf("probe signature does not verify as %s", s.alg.name)
+	}
+	return nil
+}
+
+func (s *Signer) digest(data []byte) []byte {
+	h := s.alg.hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// Algorithm returns the JWS algorithm name.
+func (s *Signer) Algorithm() string {
+	return s.alg.name
+}
+
+// KeyID returns the key's "kid".
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the JWS signature of data.
+func (s *Signer) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	return s.key.sign(ctx, s.digest(data))
+}
+
+// Detached returns a compact JWS over payload with the payload left out
+// (RFC 7515 Appendix F): header..signature.
+func (s *Signer) Detached(ctx context.Context, payload []byte) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": s.alg.name, "kid": s.keyID})
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	signingInput := protected + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sig, err := s.Sign(ctx, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return protected + ".." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// JWK returns the public key as a JSON Web Key.
+func (s *Signer) JWK() map[string]string {
+	jwk := map[string]string{"use": "sig", "alg": s.alg.name, "kid": s.keyID}
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := s.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		size := s.alg.keySize()
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk["y"] = b64(pub.Y.FillBytes(make([]byte, size)))
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = b64(pub.N.Bytes())
+		jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return jwk
+}
+
+// Close releases the HSM session or KMS client.
+func (s *Signer) Close() error {
+	return s.key.Close()
+}
+
+// checkPublic rejects a public key that cannot produce alg signatures.
+func checkPublic(pub crypto.PublicKey, alg algorithm) error {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if alg.curve == nil || k.Curve != alg.curve {
+			return fmt.Errorf("%s key does not match algorithm %s", k.Curve.Params().Name, alg.name)
+		}
+	case *rsa.PublicKey:
+		if alg.curve != nil {
+			return fmt.Errorf("RSA key does not match algorithm %s", alg.name)
+		}
+		if k.N.BitLen() < 2048 {
+			return errors.New("RSA keys must be at least 2048 bits")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+func verify(pub crypto.PublicKey, alg algorithm, digest, sig []byte) bool {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := alg.keySize()
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case *rsa.PublicKey:
+		if alg.pss {
+			return rsa.VerifyPSS(k, alg.hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(k, alg.hash, digest, sig) == nil
+	}
+	return false
+}
+
+// rawECDSA converts an ASN.1 DER ECDSA signature to JWS r||s.
+func rawECDSA(der []byte, size int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) > 0 || sig.R.BitLen() > 8*size || sig.S.BitLen() > 8*size {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
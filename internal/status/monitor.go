@@ -0,0 +1,246 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// Component health levels, ordered from best to worst.
+const (
+	Operational = "operational"
+	Degraded    = "degraded"
+	MajorOutage = "major_outage"
+)
+
+var severity = map[string]int{Operational: 0, Degraded: 1, MajorOutage: 2}
+
+// Worst returns the worse of two health levels.
+func Worst(a, b string) string {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+// Component is the health of a single dependency.
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Detail is kept for logs and webhooks but never exposed on the public feed.
+	Detail string `json:"-"`
+}
+
+// Source reports the health of one or more components.
+type Source func(ctx context.Context) []Component
+
+// Feed is the sanitized public status document.
+type Feed struct {
+	Status     string      `json:"status"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Components []Component `json:"components"`
+}
+
+// Incident is pushed to the status-page webhook when a component leaves or
+// returns to the operational state.
+type Incident struct {
+	Event     string    `json:"event"`
+	Component string    `json:"component"`
+	Status    string    `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitempty"`
+}
+
+// Webhook is an endpoint notified of incidents, signed with Secret when set.
+// Public webhooks belong to third parties and never receive Detail.
+type Webhook struct {
+	URL    string
+	Secret string
+	Public bool
+}
+
+// Monitor periodically evaluates health sources, serves the aggregate as a
+// feed and notifies the status-page provider of incidents.
+type Monitor struct {
+	cfg    config.StatusConfig
+	logger *zap.Logger
+	client *http.Client
+
+	mu        sync.RWMutex
+	sources   []Source
+	webhooks  []Webhook
+	feed      Feed
+	incidents map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMonitor(cfg config.StatusConfig, logger *zap.Logger) *Monitor {
+	m := &Monitor{
+		cfg:       cfg,
+		logger:    logger,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		feed:      Feed{Status: Operational},
+		incidents: make(map[string]time.Time),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if cfg.WebhookURL != "" {
+		m.webhooks = append(m.webhooks, Webhook{URL: cfg.WebhookURL, Secret: cfg.WebhookSecret})
+	}
+	return m
+}
+
+// AddSource registers a health source. Sources must be added before Start.
+func (m *Monitor) AddSource(src Source) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = append(m.sources, src)
+}
+
+// AddWebhook registers another endpoint for incidents, such as a brand's.
+// Webhooks must be added before Start.
+func (m *Monitor) AddWebhook(w Webhook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhooks = append(m.webhooks, w)
+}
+
+// Feed returns the latest evaluated status.
+func (m *Monitor) Feed() Feed {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.feed
+}
+
+func (m *Monitor) Start() {
+	m.evaluate()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.evaluate()
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *Monitor) evaluate() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Interval)
+	defer cancel()
+
+	m.mu.RLock()
+	sources, webhooks := m.sources, m.webhooks
+	m.mu.RUnlock()
+
+	var components []Component
+	for _, src := range sources {
+		components = append(components, src(ctx)...)
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+
+	overall := Operational
+	for _, comp := range components {
+		if severity[comp.Status] > severity[overall] {
+			overall = comp.Status
+		}
+	}
+
+	now := time.Now().UTC()
+	var events []Incident
+
+	m.mu.Lock()
+	m.feed = Feed{Status: overall, UpdatedAt: now, Components: components}
+	for _, comp := range components {
+		startedAt, open := m.incidents[comp.Name]
+		switch {
+		case comp.Status != Operational && !open:
+			m.incidents[comp.Name] = now
+			events = append(events, Incident{Event: "incident.started", Component: comp.Name, Status: comp.Status, Detail: comp.Detail, StartedAt: now})
+		case comp.Status == Operational && open:
+			delete(m.incidents, comp.Name)
+			events = append(events, Incident{Event: "incident.resolved", Component: comp.Name, Status: comp.Status, StartedAt: startedAt, EndedAt: now})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ev := range events {
+		m.logger.Warn("Status incident",
+			zap.String("event", ev.Event),
+			zap.String("component", ev.Component),
+			zap.String("status", ev.Status),
+			zap.String("detail", ev.Detail),
+		)
+		for _, hook := range webhooks {
+			go m.notify(hook, ev)
+		}
+	}
+}
+
+// notify posts an incident to a webhook, signing the body with
+// HMAC-SHA256 when it has a secret, and retries a few times.
+func (m *Monitor) notify(hook Webhook, ev Incident) {
+	if hook.Public {
+		ev.Detail = ""
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = m.post(hook, body)
+		if err == nil {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	m.logger.Error("Failed to deliver status webhook", zap.String("url", hook.URL), zap.String("component", ev.Component), zap.Error(err))
+}
+
+func (m *Monitor) post(hook Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
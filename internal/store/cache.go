@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"go.uber.org/zap"
+)
+
+// cache keeps entities in Redis as JSON. Redis errors only cost a database
+// round trip; writes go to the database first and then invalidate.
+type cache struct {
+	redis  *infrastructure.RedisClient
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+// cached returns the entity at key, loading and storing it on a miss.
+// Entities that do not exist are not cached.
+func cached[T any](ctx context.Context, c *cache, key string, load func() (*T, error)) (*T, error) {
+	key = "store:" + key
+	if raw, err := c.redis.GetValue(ctx, key); err != nil {
+		c.logger.Warn("Store cache read failed", zap.String("key", key), zap.Error(err))
+	} else if raw != "" {
+		var v T
+		if err := decodeEntry([]byte(raw), &v); err == nil {
+			return &v, nil
+		}
+	}
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if raw, err := encodeEntry(v); err == nil {
+		if err := c.redis.SetValue(ctx, key, string(raw), c.ttl); err != nil {
+			c.logger.Warn("Store cache write failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+	return v, nil
+}
+
+func (c *cache) invalidate(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := c.redis.DeleteKey(ctx, "store:"+key); err != nil {
+			c.logger.Warn("Store cache invalidation failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// apiKeyEntry is the cached form of an APIKey, keeping the hash its JSON
+// form hides.
+type apiKeyEntry struct {
+	*APIKey
+	KeyHash string `json:"key_hash"`
+}
+
+func encodeEntry(v interface{}) ([]byte, error) {
+	if k, ok := v.(*APIKey); ok {
+		return json.Marshal(apiKeyEntry{APIKey: k, KeyHash: k.Hash})
+	}
+	return json.Marshal(v)
+}
+
+func decodeEntry(raw []byte, v interface{}) error {
+	if k, ok := v.(*APIKey); ok {
+		e := apiKeyEntry{APIKey: k}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return err
+		}
+		k.Hash = e.KeyHash
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+type cachedAPIKeys struct {
+	APIKeys
+	cache *cache
+}
+
+func (r *cachedAPIKeys) Get(ctx context.Context, id string) (*APIKey, error) {
+	return cached(ctx, r.cache, "apikey:"+id, func() (*APIKey, error) { return r.APIKeys.Get(ctx, id) })
+}
+
+func (r *cachedAPIKeys) GetByHash(ctx context.Context, hash string) (*APIKey, error) {
+	return cached(ctx, r.cache, "apikey:hash:"+hash, func() (*APIKey, error) { return r.APIKeys.GetByHash(ctx, hash) })
+}
+
+func (r *cachedAPIKeys) Revoke(ctx context.Context, id string, at time.Time) error {
+	k, err := r.APIKeys.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.APIKeys.Revoke(ctx, id, at); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, "apikey:"+id, "apikey:hash:"+k.Hash)
+	return nil
+}
+
+type cachedConsents struct {
+	Consents
+	cache *cache
+}
+
+func (r *cachedConsents) Get(ctx context.Context, id string) (*Consent, error) {
+	return cached(ctx, r.cache, "consent:"+id, func() (*Consent, error) { return r.Consents.Get(ctx, id) })
+}
+
+func (r *cachedConsents) Revoke(ctx context.Context, id string, at time.Time) error {
+	if err := r.Consents.Revoke(ctx, id, at); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, "consent:"+id)
+	return nil
+}
+
+func (r *cachedConsents) AnonymizeSubject(ctx context.Context, subject string, at time.Time) ([]string, error) {
+	ids, err := r.Consents.AnonymizeSubject(ctx, subject, at)
+	for _, id := range ids {
+		r.cache.invalidate(ctx, "consent:"+id)
+	}
+	return ids, err
+}
+
+type cachedSessions struct {
+	Sessions
+	cache *cache
+}
+
+func (r *cachedSessions) Get(ctx context.Context, id string) (*Session, error) {
+	return cached(ctx, r.cache, "session:"+id, func() (*Session, error) { return r.Sessions.Get(ctx, id) })
+}
+
+func (r *cachedSessions) Touch(ctx context.Context, id string, at time.Time) error {
+	if err := r.Sessions.Touch(ctx, id, at); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, "session:"+id)
+	return nil
+}
+
+func (r *cachedSessions) Revoke(ctx context.Context, id string, at time.Time) error {
+	if err := r.Sessions.Revoke(ctx, id, at); err != nil {
+		return err
+	}
+	r.cache.invalidate(ctx, "session:"+id)
+	return nil
+}
+
+func (r *cachedSessions) DeleteBySubject(ctx context.Context, subject string) ([]string, error) {
+	ids, err := r.Sessions.DeleteBySubject(ctx, subject)
+	for _, id := range ids {
+		r.cache.invalidate(ctx, "session:"+id)
+	}
+	return ids, err
+}
@@ -0,0 +1,46 @@
+CREATE TABLE api_keys (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id   text NOT NULL,
+    name        text NOT NULL DEFAULT '',
+    key_hash    text NOT NULL UNIQUE,
+    prefix      text NOT NULL,
+    scopes      text[] NOT NULL DEFAULT '{}',
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at  timestamptz,
+    revoked_at  timestamptz
+);
+CREATE INDEX api_keys_client_id ON api_keys (client_id);
+
+CREATE TABLE consents (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    subject     text NOT NULL,
+    client_id   text NOT NULL,
+    scopes      text[] NOT NULL DEFAULT '{}',
+    granted_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at  timestamptz,
+    revoked_at  timestamptz
+);
+CREATE INDEX consents_subject ON consents (subject);
+
+CREATE TABLE webhook_subscriptions (
+    id          uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id   text NOT NULL,
+    url         text NOT NULL,
+    events      text[] NOT NULL DEFAULT '{}',
+    secret      text NOT NULL DEFAULT '',
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_subscriptions_client_id ON webhook_subscriptions (client_id);
+
+CREATE TABLE sessions (
+    id            uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    subject       text NOT NULL,
+    client_id     text NOT NULL DEFAULT '',
+    ip            text NOT NULL DEFAULT '',
+    user_agent    text NOT NULL DEFAULT '',
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    last_seen_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at    timestamptz NOT NULL,
+    revoked_at    timestamptz
+);
+CREATE INDEX sessions_subject ON sessions (subject);
@@ -0,0 +1,402 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockID serialises migrations across replicas starting together.
+const migrationLockID = 0x67617465776179 // "gateway"
+
+func openPostgres(ctx context.Context, cfg config.StoreConfig, logger *zap.Logger) (*Store, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("store: invalid DSN: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(connectCtx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	if err := pool.Ping(connectCtx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("store: %w", err)
+	}
+
+	if cfg.Migrate {
+		applied, err := migrate(ctx, pool)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("store: migration failed: %w", err)
+		}
+		for _, name := range applied {
+			logger.Info("Applied store migration", zap.String("migration", name))
+		}
+	}
+
+	logger.Info("Store connection established", zap.String("driver", DriverPostgres), zap.String("host", poolCfg.ConnConfig.Host))
+	return &Store{
+		APIKeys:  &pgAPIKeys{pool: pool},
+		Consents: &pgConsents{pool: pool},
+		Webhooks: &pgWebhooks{pool: pool},
+		Sessions: &pgSessions{pool: pool},
+		ping:     pool.Ping,
+		close:    pool.Close,
+	}, nil
+}
+
+// migrate applies the embedded migrations not yet recorded in
+// schema_migrations, each in its own transaction, and returns their names.
+// Files are named NNNN_description.sql and applied in order.
+func migrate(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return nil, err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return nil, err
+	}
+	var current int
+	if err := conn.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var applied []string
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: name must start with a version number", file)
+		}
+		if version <= current {
+			continue
+		}
+		sql, err := migrations.ReadFile(file)
+		if err != nil {
+			return applied, err
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, string(sql)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, version, name)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("%s: %w", name, err)
+		}
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+// one maps a missing row to ErrNotFound.
+func one[T any](row T, err error) (T, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// affected maps an update or delete that matched no row to ErrNotFound.
+func affected(tag interface{ RowsAffected() int64 }, err error) error {
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type pgAPIKeys struct {
+	pool *pgxpool.Pool
+}
+
+const apiKeyColumns = `id, client_id, name, key_hash, prefix, scopes, created_at, expires_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (*APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.ClientID, &k.Name, &k.Hash, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *pgAPIKeys) Create(ctx context.Context, k *APIKey) error {
+	return r.pool.QueryRow(ctx,
+		`INSERT INTO api_keys (client_id, name, key_hash, prefix, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		k.ClientID, k.Name, k.Hash, k.Prefix, nonNil(k.Scopes), k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+}
+
+func (r *pgAPIKeys) Get(ctx context.Context, id string) (*APIKey, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return one(scanAPIKey(r.pool.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id)))
+}
+
+func (r *pgAPIKeys) GetByHash(ctx context.Context, hash string) (*APIKey, error) {
+	return one(scanAPIKey(r.pool.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash)))
+}
+
+func (r *pgAPIKeys) List(ctx context.Context, clientID string) ([]APIKey, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE $1 = '' OR client_id = $1 ORDER BY created_at`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows, scanAPIKey)
+}
+
+func (r *pgAPIKeys) Revoke(ctx context.Context, id string, at time.Time) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	return affected(r.pool.Exec(ctx, `UPDATE api_keys SET revoked_at = coalesce(revoked_at, $2) WHERE id = $1`, id, at))
+}
+
+type pgConsents struct {
+	pool *pgxpool.Pool
+}
+
+const consentColumns = `id, subject, client_id, scopes, granted_at, expires_at, revoked_at`
+
+func scanConsent(row pgx.Row) (*Consent, error) {
+	var c Consent
+	err := row.Scan(&c.ID, &c.Subject, &c.ClientID, &c.Scopes, &c.GrantedAt, &c.ExpiresAt, &c.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *pgConsents) Create(ctx context.Context, c *Consent) error {
+	return r.pool.QueryRow(ctx,
+		`INSERT INTO consents (subject, client_id, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4) RETURNING id, granted_at`,
+		c.Subject, c.ClientID, nonNil(c.Scopes), c.ExpiresAt,
+	).Scan(&c.ID, &c.GrantedAt)
+}
+
+func (r *pgConsents) Get(ctx context.Context, id string) (*Consent, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return one(scanConsent(r.pool.QueryRow(ctx, `SELECT `+consentColumns+` FROM consents WHERE id = $1`, id)))
+}
+
+func (r *pgConsents) ListBySubject(ctx context.Context, subject string) ([]Consent, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+consentColumns+` FROM consents WHERE subject = $1 ORDER BY granted_at`, subject)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows, scanConsent)
+}
+
+func (r *pgConsents) Revoke(ctx context.Context, id string, at time.Time) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	return affected(r.pool.Exec(ctx, `UPDATE consents SET revoked_at = coalesce(revoked_at, $2) WHERE id = $1`, id, at))
+}
+
+func (r *pgConsents) AnonymizeSubject(ctx context.Context, subject string, at time.Time) ([]string, error) {
+	rows, err := r.pool.Query(ctx,
+		`UPDATE consents SET subject = $2, revoked_at = coalesce(revoked_at, $3)
+		 WHERE subject = $1 RETURNING id`,
+		subject, ErasedSubject, at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+type pgWebhooks struct {
+	pool *pgxpool.Pool
+}
+
+const webhookColumns = `id, client_id, url, events, secret, created_at`
+
+func scanWebhook(row pgx.Row) (*WebhookSubscription, error) {
+	var w WebhookSubscription
+	err := row.Scan(&w.ID, &w.ClientID, &w.URL, &w.Events, &w.Secret, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *pgWebhooks) Create(ctx context.Context, w *WebhookSubscription) error {
+	return r.pool.QueryRow(ctx,
+		`INSERT INTO webhook_subscriptions (client_id, url, events, secret)
+		 VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		w.ClientID, w.URL, nonNil(w.Events), w.Secret,
+	).Scan(&w.ID, &w.CreatedAt)
+}
+
+func (r *pgWebhooks) Get(ctx context.Context, id string) (*WebhookSubscription, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return one(scanWebhook(r.pool.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1`, id)))
+}
+
+func (r *pgWebhooks) List(ctx context.Context, clientID string) ([]WebhookSubscription, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE $1 = '' OR client_id = $1 ORDER BY created_at`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows, scanWebhook)
+}
+
+func (r *pgWebhooks) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	return affected(r.pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id))
+}
+
+type pgSessions struct {
+	pool *pgxpool.Pool
+}
+
+const sessionColumns = `id, subject, client_id, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at`
+
+func scanSession(row pgx.Row) (*Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.Subject, &s.ClientID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *pgSessions) Create(ctx context.Context, s *Session) error {
+	return r.pool.QueryRow(ctx,
+		`INSERT INTO sessions (subject, client_id, ip, user_agent, expires_at)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, last_seen_at`,
+		s.Subject, s.ClientID, s.IP, s.UserAgent, s.ExpiresAt,
+	).Scan(&s.ID, &s.CreatedAt, &s.LastSeenAt)
+}
+
+func (r *pgSessions) Get(ctx context.Context, id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return one(scanSession(r.pool.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, id)))
+}
+
+func (r *pgSessions) ListBySubject(ctx context.Context, subject string) ([]Session, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE subject = $1 ORDER BY created_at`, subject)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows, scanSession)
+}
+
+func (r *pgSessions) Touch(ctx context.Context, id string, at time.Time) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	return affected(r.pool.Exec(ctx, `UPDATE sessions SET last_seen_at = greatest(last_seen_at, $2) WHERE id = $1`, id, at))
+}
+
+func (r *pgSessions) Revoke(ctx context.Context, id string, at time.Time) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	return affected(r.pool.Exec(ctx, `UPDATE sessions SET revoked_at = coalesce(revoked_at, $2) WHERE id = $1`, id, at))
+}
+
+func (r *pgSessions) DeleteBySubject(ctx context.Context, subject string) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `DELETE FROM sessions WHERE subject = $1 RETURNING id`, subject)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// validID reports whether id is a UUID; nothing else can match a row, and
+// Postgres would reject it as a parameter.
+func validID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, r := range id {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// collect scans every row into a slice.
+func collect[T any](rows pgx.Rows, scan func(pgx.Row) (*T, error)) ([]T, error) {
+	defer rows.Close()
+	out := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *v)
+	}
+	return out, rows.Err()
+}
+
+// nonNil stores an empty array rather than NULL.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
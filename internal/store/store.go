@@ -0,0 +1,174 @@
+// Package store persists entities that must outlive Redis: API keys, consent
+// records, webhook subscriptions and session metadata. They live in a SQL
+// database, with Redis as a read-through cache for lookups on the request path.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/infrastructure"
+	"go.uber.org/zap"
+)
+
+// Drivers.
+const (
+	DriverPostgres = "postgres"
+)
+
+// ErrNotFound is returned when no entity has the requested ID.
+var ErrNotFound = errors.New("not found")
+
+// ErasedSubject replaces the subject of records kept after the subject's
+// data was erased.
+const ErasedSubject = "erased"
+
+// APIKey is a credential issued to a client. Only a SHA-256 hash of the key
+// is stored; Prefix identifies it to humans.
+type APIKey struct {
+	ID        string     `json:"id"`
+	ClientID  string     `json:"client_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key may be used at t.
+func (k *APIKey) Active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || t.Before(*k.ExpiresAt))
+}
+
+// Consent records the scopes a subject granted a client.
+type Consent struct {
+	ID        string     `json:"id"`
+	Subject   string     `json:"subject"`
+	ClientID  string     `json:"client_id"`
+	Scopes    []string   `json:"scopes"`
+	GrantedAt time.Time  `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the consent is in force at t.
+func (c *Consent) Active(t time.Time) bool {
+	return c.RevokedAt == nil && (c.ExpiresAt == nil || t.Before(*c.ExpiresAt))
+}
+
+// WebhookSubscription is a client's request to be notified of events.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	ClientID  string    `json:"client_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is the metadata of a signed-in user's session.
+type Session struct {
+	ID         string     `json:"id"`
+	Subject    string     `json:"subject"`
+	ClientID   string     `json:"client_id,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeys stores API keys. Create fills in ID and CreatedAt.
+type APIKeys interface {
+	Create(ctx context.Context, k *APIKey) error
+	Get(ctx context.Context, id string) (*APIKey, error)
+	GetByHash(ctx context.Context, hash string) (*APIKey, error)
+	List(ctx context.Context, clientID string) ([]APIKey, error)
+	Revoke(ctx context.Context, id string, at time.Time) error
+}
+
+// Consents stores consent records. Create fills in ID and GrantedAt.
+type Consents interface {
+	Create(ctx context.Context, c *Consent) error
+	Get(ctx context.Context, id string) (*Consent, error)
+	ListBySubject(ctx context.Context, subject string) ([]Consent, error)
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// AnonymizeSubject replaces the subject of its consents with
+	// ErasedSubject, revoking them, and returns their IDs.
+	AnonymizeSubject(ctx context.Context, subject string, at time.Time) ([]string, error)
+}
+
+// WebhookSubscriptions stores webhook subscriptions. Create fills in ID and
+// CreatedAt.
+type WebhookSubscriptions interface {
+	Create(ctx context.Context, w *WebhookSubscription) error
+	Get(ctx context.Context, id string) (*WebhookSubscription, error)
+	List(ctx context.Context, clientID string) ([]WebhookSubscription, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Sessions stores session metadata. Create fills in ID, CreatedAt and
+// LastSeenAt.
+type Sessions interface {
+	Create(ctx context.Context, s *Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	ListBySubject(ctx context.Context, subject string) ([]Session, error)
+	Touch(ctx context.Context, id string, at time.Time) error
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// DeleteBySubject deletes the sessions of subject and returns their IDs.
+	DeleteBySubject(ctx context.Context, subject string) ([]string, error)
+}
+
+// Store groups the repositories of one database.
+type Store struct {
+	APIKeys  APIKeys
+	Consents Consents
+	Webhooks WebhookSubscriptions
+	Sessions Sessions
+
+	ping  func(context.Context) error
+	close func()
+}
+
+// Open connects to the configured database, applies pending migrations when
+// cfg.Migrate is set and, given Redis, caches lookups for cfg.CacheTTL. It
+// returns nil without a driver.
+func Open(ctx context.Context, cfg config.StoreConfig, redis *infrastructure.RedisClient, logger *zap.Logger) (*Store, error) {
+	var s *Store
+	var err error
+	switch cfg.Driver {
+	case "":
+		return nil, nil
+	case DriverPostgres:
+		s, err = openPostgres(ctx, cfg, logger)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", cfg.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if redis != nil && cfg.CacheTTL > 0 {
+		c := &cache{redis: redis, ttl: cfg.CacheTTL, logger: logger}
+		s.APIKeys = &cachedAPIKeys{APIKeys: s.APIKeys, cache: c}
+		s.Consents = &cachedConsents{Consents: s.Consents, cache: c}
+		s.Sessions = &cachedSessions{Sessions: s.Sessions, cache: c}
+	}
+	return s, nil
+}
+
+// HealthCheck pings the database.
+func (s *Store) HealthCheck(ctx context.Context) error {
+	return s.ping(ctx)
+}
+
+// Close releases the database connections.
+func (s *Store) Close() {
+	s.close()
+}
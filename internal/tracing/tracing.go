@@ -0,0 +1,63 @@
+// Package tracing wires OpenTelemetry: a span per inbound request, a child
+// span per upstream call, W3C trace context propagation and OTLP/HTTP export.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/banking/api-gateway/internal/buildinfo"
+	"github.com/banking/api-gateway/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the gateway's tracer.
+const instrumentation = "github.com/banking/api-gateway"
+
+// Setup installs the global tracer provider and propagator. With tracing
+// disabled it leaves OpenTelemetry's no-op defaults, so spans cost nothing
+// and traceparent headers pass through untouched. The returned function
+// flushes buffered spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	if u, err := url.Parse(cfg.Endpoint); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("tracing.endpoint must be an absolute URL, got %q", cfg.Endpoint)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("tracing exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(buildinfo.Get().Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's sampling decision; sample new traces by ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the gateway's tracer from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
@@ -0,0 +1,348 @@
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/banking/api-gateway/internal/config"
+	"github.com/banking/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DarkHeader carries the run ID on every dark traffic request, so upstreams
+// can tell synthetic requests from customer traffic and skip side effects.
+const DarkHeader = "X-Dark-Traffic"
+
+const (
+	// maxDarkRuns finished runs are kept for their results.
+	maxDarkRuns = 20
+	// maxDarkFailures failed requests are kept per run.
+	maxDarkFailures = 20
+	// darkBodyExcerpt is how much of a failed response body is kept.
+	darkBodyExcerpt = 256
+)
+
+// Run states.
+const (
+	DarkRunning   = "running"
+	DarkCompleted = "completed"
+	DarkCancelled = "cancelled"
+)
+
+var (
+	ErrDarkRunning     = errors.New("a dark traffic run is already sending to this route")
+	ErrDarkNoRequests  = errors.New("no requests to send")
+	ErrDarkRunNotFound = errors.New("dark traffic run not found")
+)
+
+// darkKey marks the context of requests sent by a run.
+type darkKey struct{}
+
+// IsDark reports whether a request was sent by a dark traffic run.
+func IsDark(r *http.Request) bool {
+	return r.Context().Value(darkKey{}) != nil
+}
+
+// DarkRequest is one request a run sends, in turn with the others.
+type DarkRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// DarkSpec describes a run: Count requests to Route at RPS, cycling through
+// Requests.
+type DarkSpec struct {
+	Route string
+	// Source is "generated" or "replay", for the record.
+	Source   string
+	RPS      float64
+	Count    int
+	Requests []DarkRequest
+}
+
+// DarkFailure is a request of a run that got no 2xx or 3xx response.
+type DarkFailure struct {
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Body      string `json:"body,omitempty"`
+}
+
+// DarkRun is a run and its results so far.
+type DarkRun struct {
+	ID         string         `json:"id"`
+	Route      string         `json:"route"`
+	Source     string         `json:"source"`
+	RPS        float64        `json:"rps"`
+	Count      int            `json:"count"`
+	State      string         `json:"state"`
+	StartedBy  string         `json:"started_by"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Sent       int            `json:"sent"`
+	Statuses   map[string]int `json:"statuses"`
+	// Latencies are in milliseconds, measured through the whole gateway.
+	LatencyP50 float64       `json:"latency_p50_ms"`
+	LatencyP95 float64       `json:"latency_p95_ms"`
+	LatencyMax float64       `json:"latency_max_ms"`
+	Failures   []DarkFailure `json:"failures,omitempty"`
+}
+
+type darkRun struct {
+	run       DarkRun
+	latencies []time.Duration
+	cancel    context.CancelFunc
+}
+
+// DarkTraffic sends synthetic requests to soft-launched routes through the
+// gateway's own handler, so routing, policies and the upstream are checked
+// end to end before customers are switched over. Each request carries
+// DarkHeader; runs and their results are local to the replica.
+type DarkTraffic struct {
+	cfg     config.DarkTrafficConfig
+	handler http.Handler
+	logger  *zap.Logger
+	audit   *zap.Logger
+
+	mu   sync.Mutex
+	runs []*darkRun // oldest first
+}
+
+func NewDarkTraffic(cfg config.DarkTrafficConfig, handler http.Handler, logger *zap.Logger) *DarkTraffic {
+	return &DarkTraffic{
+		cfg:     cfg,
+		handler: handler,
+		logger:  logger,
+		audit:   logger.Named("audit"),
+	}
+}
+
+// Start begins a run in the background, stopped early when ctx is cancelled.
+// Rates and counts are capped by the configuration; an unset count sends
+// every request once.
+func (d *DarkTraffic) Start(ctx context.Context, spec DarkSpec, by string) (DarkRun, error) {
+	if len(spec.Requests) == 0 {
+		return DarkRun{}, ErrDarkNoRequests
+	}
+	if spec.RPS <= 0 {
+		spec.RPS = 1
+	}
+	spec.RPS = min(spec.RPS, d.cfg.MaxRPS)
+	if spec.Count <= 0 {
+		spec.Count = len(spec.Requests)
+	}
+	spec.Count = min(spec.Count, d.cfg.MaxRequests)
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	ctx, cancel := context.WithCancel(ctx)
+	r := &darkRun{
+		run: DarkRun{
+			ID:        hex.EncodeToString(id),
+			Route:     spec.Route,
+			Source:    spec.Source,
+			RPS:       spec.RPS,
+			Count:     spec.Count,
+			State:     DarkRunning,
+			StartedBy: by,
+			StartedAt: time.Now().UTC(),
+			Statuses:  make(map[string]int),
+		},
+		cancel: cancel,
+	}
+
+	d.mu.Lock()
+	for _, other := range d.runs {
+		if other.run.Route == spec.Route && other.run.State == DarkRunning {
+			d.mu.Unlock()
+			cancel()
+			return DarkRun{}, ErrDarkRunning
+		}
+	}
+	d.runs = append(d.runs, r)
+	d.evict()
+	snapshot := d.snapshot(r)
+	d.mu.Unlock()
+
+	d.audit.Info("Dark traffic run started",
+		zap.String("event", "dark_traffic_started"),
+		zap.String("run", r.run.ID),
+		zap.String("route", spec.Route),
+		zap.String("source", spec.Source),
+		zap.Float64("rps", spec.RPS),
+		zap.Int("count", spec.Count),
+		zap.String("started_by", by),
+	)
+	go d.send(ctx, r, spec)
+	return snapshot, nil
+}
+
+// evict drops the oldest finished runs beyond maxDarkRuns. Callers hold mu.
+func (d *DarkTraffic) evict() {
+	for i := 0; len(d.runs) > maxDarkRuns && i < len(d.runs); {
+		if d.runs[i].run.State == DarkRunning {
+			i++
+			continue
+		}
+		d.runs = slices.Delete(d.runs, i, i+1)
+	}
+}
+
+// send paces the requests of a run and records their results.
+func (d *DarkTraffic) send(ctx context.Context, r *darkRun, spec DarkSpec) {
+	defer r.cancel()
+	limiter := rate.NewLimiter(rate.Limit(spec.RPS), 1)
+	state := DarkCompleted
+	for i := 0; i < spec.Count; i++ {
+		if err := limiter.Wait(ctx); err != nil {
+			state = DarkCancelled
+			break
+		}
+		d.sendOne(ctx, r, i, spec.Requests[i%len(spec.Requests)])
+	}
+
+	d.mu.Lock()
+	now := time.Now().UTC()
+	r.run.State = state
+	r.run.FinishedAt = &now
+	sent, failures := r.run.Sent, len(r.run.Failures)
+	d.mu.Unlock()
+
+	d.logger.Info("Dark traffic run finished",
+		zap.String("run", r.run.ID),
+		zap.String("route", spec.Route),
+		zap.String("state", state),
+		zap.Int("sent", sent),
+		zap.Int("failures_kept", failures),
+	)
+}
+
+func (d *DarkTraffic) sendOne(ctx context.Context, r *darkRun, n int, dr DarkRequest) {
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, darkKey{}, r.run.ID), dr.Method, dr.Path, bytes.NewReader(dr.Body))
+	if err != nil {
+		d.record(r, dr, "", 0, nil, 0)
+		return
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	for k, v := range dr.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(dr.Body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(DarkHeader, r.run.ID)
+	req.Header.Set("X-Request-ID", "dark-"+r.run.ID+"-"+strconv.Itoa(n))
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	d.handler.ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+	d.record(r, dr, rec.Header().Get("X-Request-ID"), rec.Code, rec.Body, elapsed)
+}
+
+// record adds one response to the run. A status of 0 means the request could
+// not be built.
+func (d *DarkTraffic) record(r *darkRun, dr DarkRequest, requestID string, status int, body *bytes.Buffer, elapsed time.Duration) {
+	code := strconv.Itoa(status)
+	if status == 0 {
+		code = "invalid"
+	}
+	metrics.DarkTrafficRequests.WithLabelValues(r.run.Route, code).Inc()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r.run.Sent++
+	r.run.Statuses[code]++
+	if status != 0 {
+		r.latencies = append(r.latencies, elapsed)
+	}
+	if (status == 0 || status >= http.StatusBadRequest) && len(r.run.Failures) < maxDarkFailures {
+		f := DarkFailure{RequestID: requestID, Method: dr.Method, Path: dr.Path, Status: status}
+		if body != nil {
+			excerpt, _ := io.ReadAll(io.LimitReader(body, darkBodyExcerpt))
+			f.Body = string(excerpt)
+		}
+		r.run.Failures = append(r.run.Failures, f)
+	}
+}
+
+// snapshot copies a run with its latency percentiles. Callers hold mu.
+func (d *DarkTraffic) snapshot(r *darkRun) DarkRun {
+	run := r.run
+	run.Statuses = make(map[string]int, len(r.run.Statuses))
+	for k, v := range r.run.Statuses {
+		run.Statuses[k] = v
+	}
+	run.Failures = slices.Clone(r.run.Failures)
+	if n := len(r.latencies); n > 0 {
+		sorted := slices.Clone(r.latencies)
+		slices.Sort(sorted)
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		run.LatencyP50 = ms(sorted[n/2])
+		run.LatencyP95 = ms(sorted[min(n*95/100, n-1)])
+		run.LatencyMax = ms(sorted[n-1])
+	}
+	return run
+}
+
+// Runs returns the kept runs, most recent first.
+func (d *DarkTraffic) Runs() []DarkRun {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	runs := make([]DarkRun, 0, len(d.runs))
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		runs = append(runs, d.snapshot(d.runs[i]))
+	}
+	return runs
+}
+
+// Run returns one run.
+func (d *DarkTraffic) Run(id string) (DarkRun, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range d.runs {
+		if r.run.ID == id {
+			return d.snapshot(r), true
+		}
+	}
+	return DarkRun{}, false
+}
+
+// Cancel stops a run; requests already sent are kept in its results.
+func (d *DarkTraffic) Cancel(id, by string) error {
+	d.mu.Lock()
+	var found *darkRun
+	for _, r := range d.runs {
+		if r.run.ID == id {
+			found = r
+			break
+		}
+	}
+	d.mu.Unlock()
+	if found == nil {
+		return ErrDarkRunNotFound
+	}
+	found.cancel()
+
+	d.audit.Info("Dark traffic run cancelled",
+		zap.String("event", "dark_traffic_cancelled"),
+		zap.String("run", id),
+		zap.String("cancelled_by", by),
+	)
+	return nil
+}
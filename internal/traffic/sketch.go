@@ -0,0 +1,105 @@
+package traffic
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// CountMin is a count-min sketch estimating per-key frequencies in fixed
+// memory. Estimates never undercount and overcount by at most e/width * total
+// with probability 1 - e^-depth.
+type CountMin struct {
+	seed  maphash.Seed
+	width uint64
+	table [][]uint64
+}
+
+func NewCountMin(seed maphash.Seed, width, depth int) *CountMin {
+	table := make([][]uint64, depth)
+	for i := range table {
+		table[i] = make([]uint64, width)
+	}
+	return &CountMin{seed: seed, width: uint64(width), table: table}
+}
+
+func (c *CountMin) Add(key string, n uint64) uint64 {
+	h1, h2 := splitHash(maphash.String(c.seed, key))
+	est := uint64(math.MaxUint64)
+	for i, row := range c.table {
+		idx := (h1 + uint64(i)*h2) % c.width
+		row[idx] += n
+		est = min(est, row[idx])
+	}
+	return est
+}
+
+func (c *CountMin) Estimate(key string) uint64 {
+	h1, h2 := splitHash(maphash.String(c.seed, key))
+	est := uint64(math.MaxUint64)
+	for i, row := range c.table {
+		est = min(est, row[(h1+uint64(i)*h2)%c.width])
+	}
+	return est
+}
+
+// Merge adds the counters of other into c. Both sketches must share the same
+// seed and dimensions.
+func (c *CountMin) Merge(other *CountMin) {
+	for i, row := range other.table {
+		for j, v := range row {
+			c.table[i][j] += v
+		}
+	}
+}
+
+func splitHash(h uint64) (uint64, uint64) {
+	return h & 0xffffffff, (h >> 32) | 1
+}
+
+const hllPrecision = 12
+
+// HyperLogLog estimates the number of distinct keys using 2^12 registers
+// (about 1.6% standard error).
+type HyperLogLog struct {
+	seed      maphash.Seed
+	registers [1 << hllPrecision]uint8
+}
+
+func NewHyperLogLog(seed maphash.Seed) *HyperLogLog {
+	return &HyperLogLog{seed: seed}
+}
+
+func (h *HyperLogLog) Add(key string) {
+	x := maphash.String(h.seed, key)
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, v := range other.registers {
+		if v > h.registers[i] {
+			h.registers[i] = v
+		}
+	}
+}
+
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, v := range h.registers {
+		sum += 1 / float64(uint64(1)<<v)
+		if v == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
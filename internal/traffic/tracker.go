@@ -0,0 +1,288 @@
+package traffic
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	bucketSize  = time.Minute
+	sketchWidth = 2048
+	sketchDepth = 4
+)
+
+// Observation is a single completed request as seen by the tracker.
+type Observation struct {
+	ClientID     string
+	IP           string
+	Route        string
+	Status       int
+	Bytes        int64
+	RateLimitKey string
+	At           time.Time
+}
+
+// Entry is a ranked key in a report.
+type Entry struct {
+	Key           string  `json:"key"`
+	Count         uint64  `json:"count"`
+	RatePerSecond float64 `json:"rate_per_second"`
+}
+
+// Payload describes one of the largest request bodies seen.
+type Payload struct {
+	Route    string    `json:"route"`
+	ClientID string    `json:"client_id,omitempty"`
+	IP       string    `json:"ip"`
+	Bytes    int64     `json:"bytes"`
+	At       time.Time `json:"at"`
+}
+
+// Report is a point-in-time view of traffic over a trailing window.
+type Report struct {
+	GeneratedAt      time.Time `json:"generated_at"`
+	WindowSeconds    int       `json:"window_seconds"`
+	TotalRequests    uint64    `json:"total_requests"`
+	TotalErrors      uint64    `json:"total_errors"`
+	UniqueClients    uint64    `json:"unique_clients"`
+	UniqueIPs        uint64    `json:"unique_ips"`
+	TopClients       []Entry   `json:"top_clients"`
+	TopIPs           []Entry   `json:"top_ips"`
+	TopErrorRoutes   []Entry   `json:"top_error_routes"`
+	HotRateLimitKeys []Entry   `json:"hot_rate_limit_keys"`
+	LargestPayloads  []Payload `json:"largest_payloads"`
+}
+
+type dimension struct {
+	sketch     *CountMin
+	candidates map[string]uint64
+	capacity   int
+}
+
+func newDimension(seed maphash.Seed, capacity int) *dimension {
+	return &dimension{
+		sketch:     NewCountMin(seed, sketchWidth, sketchDepth),
+		candidates: make(map[string]uint64, capacity),
+		capacity:   capacity,
+	}
+}
+
+// add counts key and keeps it as a top-k candidate if its estimate is among
+// the largest seen in this bucket.
+func (d *dimension) add(key string) {
+	est := d.sketch.Add(key, 1)
+	if _, ok := d.candidates[key]; ok || len(d.candidates) < d.capacity {
+		d.candidates[key] = est
+		return
+	}
+
+	minKey, minEst := "", uint64(0)
+	for k, v := range d.candidates {
+		if minKey == "" || v < minEst {
+			minKey, minEst = k, v
+		}
+	}
+	if est > minEst {
+		delete(d.candidates, minKey)
+		d.candidates[key] = est
+	}
+}
+
+type bucket struct {
+	slot      int64
+	requests  uint64
+	errors    uint64
+	clients   *dimension
+	ips       *dimension
+	errRoutes *dimension
+	rlKeys    *dimension
+	uniqClies *HyperLogLog
+	uniqIPs   *HyperLogLog
+	payloads  []Payload
+}
+
+// Tracker aggregates request observations into per-minute sketches and
+// answers top-talker queries over the retained window. Buckets are numbered
+// by monotonic time elapsed since the tracker was created, so wall-clock
+// steps (NTP corrections) neither merge nor skip minutes.
+type Tracker struct {
+	mu      sync.Mutex
+	seed    maphash.Seed
+	topK    int
+	origin  time.Time
+	buckets []*bucket
+}
+
+func NewTracker(retention time.Duration, topK int) *Tracker {
+	n := int(retention / bucketSize)
+	if n < 1 {
+		n = 1
+	}
+	if topK < 1 {
+		topK = 10
+	}
+	return &Tracker{
+		seed:    maphash.MakeSeed(),
+		topK:    topK,
+		origin:  time.Now(),
+		buckets: make([]*bucket, n),
+	}
+}
+
+func (t *Tracker) newBucket(slot int64) *bucket {
+	capacity := t.topK * 4
+	return &bucket{
+		slot:      slot,
+		clients:   newDimension(t.seed, capacity),
+		ips:       newDimension(t.seed, capacity),
+		errRoutes: newDimension(t.seed, capacity),
+		rlKeys:    newDimension(t.seed, capacity),
+		uniqClies: NewHyperLogLog(t.seed),
+		uniqIPs:   NewHyperLogLog(t.seed),
+	}
+}
+
+// slot returns the bucket number for at. Times taken from time.Now carry a
+// monotonic reading, which Sub uses in preference to the wall clock.
+func (t *Tracker) slot(at time.Time) int64 {
+	d := at.Sub(t.origin)
+	if d < 0 {
+		return -1 - int64(-d/bucketSize)
+	}
+	return int64(d / bucketSize)
+}
+
+func (t *Tracker) bucketFor(at time.Time) *bucket {
+	slot := t.slot(at)
+	n := int64(len(t.buckets))
+	idx := (slot%n + n) % n
+	b := t.buckets[idx]
+	if b == nil || b.slot != slot {
+		b = t.newBucket(slot)
+		t.buckets[idx] = b
+	}
+	return b
+}
+
+// Record adds a completed request to the current bucket.
+func (t *Tracker) Record(o Observation) {
+	if o.At.IsZero() {
+		o.At = time.Now()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.bucketFor(o.At)
+	b.requests++
+	if o.ClientID != "" {
+		b.clients.add(o.ClientID)
+		b.uniqClies.Add(o.ClientID)
+	}
+	if o.IP != "" {
+		b.ips.add(o.IP)
+		b.uniqIPs.Add(o.IP)
+	}
+	if o.Status >= 400 {
+		b.errors++
+		b.errRoutes.add(o.Route)
+	}
+	if o.RateLimitKey != "" {
+		b.rlKeys.add(o.RateLimitKey)
+	}
+	if o.Bytes > 0 {
+		b.payloads = insertPayload(b.payloads, Payload{
+			Route:    o.Route,
+			ClientID: o.ClientID,
+			IP:       o.IP,
+			Bytes:    o.Bytes,
+			At:       o.At,
+		}, t.topK)
+	}
+}
+
+// Report merges the buckets covering the trailing window and returns the top
+// limit entries per dimension.
+func (t *Tracker) Report(window time.Duration, limit int) Report {
+	now := time.Now()
+	if limit < 1 || limit > t.topK {
+		limit = t.topK
+	}
+	maxWindow := time.Duration(len(t.buckets)) * bucketSize
+	if window <= 0 || window > maxWindow {
+		window = maxWindow
+	}
+	cutoff := t.slot(now.Add(-window))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	merged := t.newBucket(cutoff)
+	for _, b := range t.buckets {
+		if b == nil || b.slot < cutoff {
+			continue
+		}
+		merged.requests += b.requests
+		merged.errors += b.errors
+		mergeDimension(merged.clients, b.clients)
+		mergeDimension(merged.ips, b.ips)
+		mergeDimension(merged.errRoutes, b.errRoutes)
+		mergeDimension(merged.rlKeys, b.rlKeys)
+		merged.uniqClies.Merge(b.uniqClies)
+		merged.uniqIPs.Merge(b.uniqIPs)
+		for _, p := range b.payloads {
+			merged.payloads = insertPayload(merged.payloads, p, limit)
+		}
+	}
+
+	seconds := now.Sub(t.origin.Add(time.Duration(cutoff) * bucketSize)).Seconds()
+	return Report{
+		GeneratedAt:      now,
+		WindowSeconds:    int(seconds),
+		TotalRequests:    merged.requests,
+		TotalErrors:      merged.errors,
+		UniqueClients:    merged.uniqClies.Count(),
+		UniqueIPs:        merged.uniqIPs.Count(),
+		TopClients:       topEntries(merged.clients, limit, seconds),
+		TopIPs:           topEntries(merged.ips, limit, seconds),
+		TopErrorRoutes:   topEntries(merged.errRoutes, limit, seconds),
+		HotRateLimitKeys: topEntries(merged.rlKeys, limit, seconds),
+		LargestPayloads:  merged.payloads,
+	}
+}
+
+func mergeDimension(dst, src *dimension) {
+	dst.sketch.Merge(src.sketch)
+	for k := range src.candidates {
+		dst.candidates[k] = 0
+	}
+}
+
+func topEntries(d *dimension, limit int, seconds float64) []Entry {
+	entries := make([]Entry, 0, len(d.candidates))
+	for k := range d.candidates {
+		count := d.sketch.Estimate(k)
+		entries = append(entries, Entry{Key: k, Count: count, RatePerSecond: float64(count) / seconds})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+func insertPayload(payloads []Payload, p Payload, limit int) []Payload {
+	idx := sort.Search(len(payloads), func(i int) bool { return payloads[i].Bytes < p.Bytes })
+	if idx >= limit {
+		return payloads
+	}
+	payloads = append(payloads, Payload{})
+	copy(payloads[idx+1:], payloads[idx:])
+	payloads[idx] = p
+	if len(payloads) > limit {
+		payloads = payloads[:limit]
+	}
+	return payloads
+}